@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// CmdConfig represents the configuration of the command.
+type CmdConfig struct {
+	Debug               bool
+	ListenAddr          string
+	IssuerURL           string
+	ClientID            string
+	ClientSecret        string
+	RedirectURL         string
+	Scopes              []string
+	Upstreams           []string
+	UpstreamCAFile      string
+	UpstreamInsecure    bool
+	SkipAuthRoutes      []string
+	AuthOnly            bool
+	PassAccessToken     bool
+	CookieSecret        string
+	CookieName          string
+	CookieDomain        string
+	CookieSecure        bool
+	CookieSameSite      string
+	CookieExpire        time.Duration
+	CookieRefresh       time.Duration
+	AllowedGroups       []string
+	AllowedEmails       []string
+	AllowedEmailDomains []string
+	RequiredClaims      map[string]string
+}
+
+// NewCmdConfig returns a new command configuration.
+func NewCmdConfig() (*CmdConfig, error) {
+	c := &CmdConfig{RequiredClaims: map[string]string{}}
+	app := kingpin.New("bilrost-proxy", "An OIDC auth proxy for the apps secured by Bilrost.")
+
+	app.Flag("debug", "Enable debug mode.").BoolVar(&c.Debug)
+	app.Flag("listen-address", "the address where the HTTP server will be listening.").Default(":4180").StringVar(&c.ListenAddr)
+	app.Flag("oidc-issuer-url", "the URL of the OIDC provider.").Required().StringVar(&c.IssuerURL)
+	app.Flag("client-id", "the OIDC client ID.").Envar("OIDC_CLIENT_ID").Required().StringVar(&c.ClientID)
+	app.Flag("client-secret", "the OIDC client secret.").Envar("OIDC_CLIENT_SECRET").Required().StringVar(&c.ClientSecret)
+	app.Flag("redirect-url", "the OIDC callback URL, if only the path is set, the request host will be used.").Default("/oauth2/callback").StringVar(&c.RedirectURL)
+	app.Flag("scope", "the OIDC scopes requested on the sign in (repeatable).").Default("openid", "email", "profile").StringsVar(&c.Scopes)
+	app.Flag("upstream", "the upstream URL (http, https or h2c), the URL path is the path prefix of the proxied requests (repeatable).").StringsVar(&c.Upstreams)
+	app.Flag("upstream-ca-file", "the PEM encoded CA bundle used to verify the https upstreams certificates, added to the system CAs.").StringVar(&c.UpstreamCAFile)
+	app.Flag("upstream-insecure-skip-verify", "don't verify the https upstreams certificates.").BoolVar(&c.UpstreamInsecure)
+	app.Flag("skip-auth-route", "a route proxied without authentication in `[METHOD=]path-regex` format (repeatable).").StringsVar(&c.SkipAuthRoutes)
+	app.Flag("auth-only", "only authenticate the ingress controller auth requests on the /oauth2/auth path, without proxying.").BoolVar(&c.AuthOnly)
+	app.Flag("pass-access-token", "pass the OIDC access token to the upstream on the X-Auth-Request-Access-Token header.").BoolVar(&c.PassAccessToken)
+	app.Flag("cookie-secret", "the secret used to encrypt the cookies.").Envar("PROXY_COOKIE_SECRET").Required().StringVar(&c.CookieSecret)
+	app.Flag("cookie-name", "the name of the session cookie.").Default("_bilrost_proxy").StringVar(&c.CookieName)
+	app.Flag("cookie-domain", "the domain of the cookies, by default the request host.").StringVar(&c.CookieDomain)
+	app.Flag("cookie-secure", "set the secure flag on the cookies.").Default("true").BoolVar(&c.CookieSecure)
+	app.Flag("cookie-samesite", "the SameSite attribute of the cookies.").Default("lax").EnumVar(&c.CookieSameSite, "lax", "strict", "none")
+	app.Flag("cookie-expire", "the max age of the sessions.").Default("168h").DurationVar(&c.CookieExpire)
+	app.Flag("cookie-refresh", "the duration after the session tokens are refreshed, if 0, they are only refreshed when they expire.").DurationVar(&c.CookieRefresh)
+	app.Flag("allowed-group", "a group allowed to access, if set, the user needs to be in any of the allowed groups (repeatable).").StringsVar(&c.AllowedGroups)
+	app.Flag("allowed-email", "an email allowed to access, if set, the user needs an allowed email or an email of an allowed domain (repeatable).").StringsVar(&c.AllowedEmails)
+	app.Flag("allowed-email-domain", "an email domain allowed to access, if set, the user needs an allowed email or an email of an allowed domain (repeatable).").StringsVar(&c.AllowedEmailDomains)
+	app.Flag("required-claim", "an ID token claim and the value required to access in `claim=value` format (repeatable).").StringMapVar(&c.RequiredClaims)
+
+	_, err := app.Parse(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
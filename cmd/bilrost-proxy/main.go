@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oklog/run"
+	"github.com/sirupsen/logrus"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/oidcproxy"
+)
+
+// Run runs the main application.
+func Run(ctx context.Context) error {
+	// Load command flags and arguments.
+	cmdCfg, err := NewCmdConfig()
+	if err != nil {
+		return fmt.Errorf("could not load command configuration: %w", err)
+	}
+
+	// Set up logger.
+	logrusLog := logrus.New()
+	logrusLogEntry := logrus.NewEntry(logrusLog).WithField("app", "bilrost-proxy")
+	logger := log.NewLogrus(logrusLogEntry)
+	if cmdCfg.Debug {
+		logrusLog.SetLevel(logrus.DebugLevel)
+	}
+
+	upstreams, err := parseUpstreams(cmdCfg.Upstreams)
+	if err != nil {
+		return fmt.Errorf("invalid upstreams: %w", err)
+	}
+
+	skipAuthRoutes, err := parseSkipAuthRoutes(cmdCfg.SkipAuthRoutes)
+	if err != nil {
+		return fmt.Errorf("invalid skip auth routes: %w", err)
+	}
+
+	upstreamTLSConfig, err := getUpstreamTLSConfig(cmdCfg.UpstreamCAFile, cmdCfg.UpstreamInsecure)
+	if err != nil {
+		return fmt.Errorf("invalid upstream TLS configuration: %w", err)
+	}
+
+	handler, err := oidcproxy.NewHandler(oidcproxy.Config{
+		IssuerURL:           cmdCfg.IssuerURL,
+		ClientID:            cmdCfg.ClientID,
+		ClientSecret:        cmdCfg.ClientSecret,
+		RedirectURL:         cmdCfg.RedirectURL,
+		Scopes:              cmdCfg.Scopes,
+		CookieSecret:        cmdCfg.CookieSecret,
+		CookieName:          cmdCfg.CookieName,
+		CookieDomain:        cmdCfg.CookieDomain,
+		CookieSecure:        cmdCfg.CookieSecure,
+		CookieSameSite:      cookieSameSites[cmdCfg.CookieSameSite],
+		CookieExpire:        cmdCfg.CookieExpire,
+		CookieRefresh:       cmdCfg.CookieRefresh,
+		Upstreams:           upstreams,
+		UpstreamTLSConfig:   upstreamTLSConfig,
+		SkipAuthRoutes:      skipAuthRoutes,
+		AuthOnly:            cmdCfg.AuthOnly,
+		PassAccessToken:     cmdCfg.PassAccessToken,
+		AllowedGroups:       cmdCfg.AllowedGroups,
+		AllowedEmails:       cmdCfg.AllowedEmails,
+		AllowedEmailDomains: cmdCfg.AllowedEmailDomains,
+		RequiredClaims:      cmdCfg.RequiredClaims,
+		Logger:              logger,
+	})
+	if err != nil {
+		return fmt.Errorf("could not create proxy handler: %w", err)
+	}
+
+	// Prepare our run entrypoints.
+	var g run.Group
+
+	// Serving HTTP server.
+	{
+		mux := http.NewServeMux()
+		mux.HandleFunc("/ping", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte("pong")) })
+		mux.Handle("/", handler)
+
+		server := &http.Server{
+			Addr:    cmdCfg.ListenAddr,
+			Handler: mux,
+		}
+
+		g.Add(
+			func() error {
+				logger.WithKV(log.KV{"addr": cmdCfg.ListenAddr}).Infof("http server listening for requests")
+				return server.ListenAndServe()
+			},
+			func(_ error) {
+				ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				defer cancel()
+				err := server.Shutdown(ctx)
+				if err != nil {
+					logger.Errorf("error shutting down server: %w", err)
+				}
+			},
+		)
+	}
+
+	// OS signals.
+	{
+		sigC := make(chan os.Signal, 1)
+		exitC := make(chan struct{})
+		signal.Notify(sigC, syscall.SIGTERM, syscall.SIGINT)
+
+		g.Add(
+			func() error {
+				select {
+				case s := <-sigC:
+					logger.Infof("signal %s received", s)
+					return nil
+				case <-exitC:
+					return nil
+				}
+			},
+			func(_ error) {
+				close(exitC)
+			},
+		)
+	}
+
+	return g.Run()
+}
+
+var cookieSameSites = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// parseUpstreams parses the upstream URLs, the path of the URL is used as the path prefix
+// of the proxied requests.
+func parseUpstreams(rawUpstreams []string) ([]oidcproxy.Upstream, error) {
+	upstreams := []oidcproxy.Upstream{}
+	for _, raw := range rawUpstreams {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q upstream: %w", raw, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "h2c" {
+			return nil, fmt.Errorf("invalid %q upstream scheme", raw)
+		}
+
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		if !strings.HasSuffix(path, "/") {
+			path += "/"
+		}
+		upstreams = append(upstreams, oidcproxy.Upstream{Path: path, URL: u})
+	}
+
+	return upstreams, nil
+}
+
+var skipAuthMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// parseSkipAuthRoutes parses the routes in `[METHOD=]path-regex` format, the prefix is only
+// used as the method if it's a valid HTTP method, so the regexes can have `=`.
+func parseSkipAuthRoutes(rawRoutes []string) ([]oidcproxy.SkipAuthRoute, error) {
+	routes := []oidcproxy.SkipAuthRoute{}
+	for _, raw := range rawRoutes {
+		method, pathRegex := "", raw
+		if i := strings.Index(raw, "="); i >= 0 && skipAuthMethods[raw[:i]] {
+			method, pathRegex = raw[:i], raw[i+1:]
+		}
+
+		re, err := regexp.Compile(pathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %q route path regex: %w", raw, err)
+		}
+		routes = append(routes, oidcproxy.SkipAuthRoute{Method: method, PathRegex: re})
+	}
+
+	return routes, nil
+}
+
+// getUpstreamTLSConfig returns the TLS configuration used to connect to the upstreams, the
+// CA bundle is added to the system CAs.
+func getUpstreamTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile == "" {
+		return cfg, nil
+	}
+
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA file: %w", err)
+	}
+
+	cfg.RootCAs, err = x509.SystemCertPool()
+	if err != nil {
+		cfg.RootCAs = x509.NewCertPool()
+	}
+	if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid CA file, missing PEM certificates")
+	}
+
+	return cfg, nil
+}
+
+func main() {
+	ctx := context.Background()
+	err := Run(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error running application: %s", err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
@@ -20,6 +20,23 @@ type CmdConfig struct {
 	ListenAddr       string
 	MetricsPath      string
 	ResyncInterval   time.Duration
+
+	LeaderElectionDisable        bool
+	LeaderElectionLeaseName      string
+	LeaderElectionLeaseNamespace string
+	LeaderElectionLeaseDuration  time.Duration
+	LeaderElectionRenewDeadline  time.Duration
+	LeaderElectionRetryPeriod    time.Duration
+
+	DexClientJanitorDisable  bool
+	DexClientJanitorInterval time.Duration
+	DexClientJanitorDryRun   bool
+
+	ProxyJanitorDisable  bool
+	ProxyJanitorInterval time.Duration
+	ProxyJanitorDryRun   bool
+
+	BilrostProxyImage string
 }
 
 // NewCmdConfig returns a new command configuration.
@@ -38,11 +55,28 @@ func NewCmdConfig() (*CmdConfig, error) {
 	app.Flag("resync-interval", "the duration between resync all ingress resources.").Default("5m").DurationVar(&c.ResyncInterval)
 	app.Flag("listen-address", "the address where the HTTP server will be listening.").Default(":8081").StringVar(&c.ListenAddr)
 	app.Flag("metrics-path", "the path where Prometehus metrics will be served.").Default("/metrics").StringVar(&c.MetricsPath)
+	app.Flag("leader-election-disable", "disables the leader election, only disable it when running a single instance.").BoolVar(&c.LeaderElectionDisable)
+	app.Flag("leader-election-lease-name", "the name of the lease used for the leader election.").Default("bilrost-controller").StringVar(&c.LeaderElectionLeaseName)
+	app.Flag("leader-election-lease-namespace", "the namespace of the lease used for the leader election, by default the namespace where the controller is running.").StringVar(&c.LeaderElectionLeaseNamespace)
+	app.Flag("leader-election-lease-duration", "the duration that the standby instances will wait until forcing the acquisition of the leadership.").Default("15s").DurationVar(&c.LeaderElectionLeaseDuration)
+	app.Flag("leader-election-renew-deadline", "the duration that the leader will retry refreshing the leadership before giving up.").Default("10s").DurationVar(&c.LeaderElectionRenewDeadline)
+	app.Flag("leader-election-retry-period", "the duration the instances will wait between leader election actions.").Default("2s").DurationVar(&c.LeaderElectionRetryPeriod)
+	app.Flag("dex-client-janitor-disable", "disables the janitor that cleans the orphaned Dex clients.").BoolVar(&c.DexClientJanitorDisable)
+	app.Flag("dex-client-janitor-interval", "the duration between the orphaned Dex clients cleaning passes.").Default("1h").DurationVar(&c.DexClientJanitorInterval)
+	app.Flag("dex-client-janitor-dry-run", "the janitor will only report the orphaned Dex clients without cleaning them.").BoolVar(&c.DexClientJanitorDryRun)
+	app.Flag("proxy-janitor-disable", "disables the janitor that cleans the orphaned proxy deployments, services and secrets.").BoolVar(&c.ProxyJanitorDisable)
+	app.Flag("proxy-janitor-interval", "the duration between the orphaned proxy resources cleaning passes.").Default("1h").DurationVar(&c.ProxyJanitorInterval)
+	app.Flag("proxy-janitor-dry-run", "the janitor will only report the orphaned proxy resources without cleaning them.").BoolVar(&c.ProxyJanitorDryRun)
+	app.Flag("bilrost-proxy-image", "the default image used by the Bilrost OIDC proxy deployments, it needs the bilrost-proxy binary, by default the image of the controller version.").Default("slok/bilrost:" + Version).StringVar(&c.BilrostProxyImage)
 
 	_, err := app.Parse(os.Args[1:])
 	if err != nil {
 		return nil, err
 	}
 
+	if c.LeaderElectionLeaseNamespace == "" {
+		c.LeaderElectionLeaseNamespace = c.NamespaceRunning
+	}
+
 	return c, nil
 }
@@ -16,6 +16,7 @@ import (
 	"github.com/sirupsen/logrus"
 	koopercontroller "github.com/spotahome/kooper/v2/controller"
 	kooperlog "github.com/spotahome/kooper/v2/log/logrus"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -23,15 +24,24 @@ import (
 	authbackendfactory "github.com/slok/bilrost/internal/authbackend/factory"
 	"github.com/slok/bilrost/internal/backup"
 	"github.com/slok/bilrost/internal/controller"
+	"github.com/slok/bilrost/internal/janitor"
 	"github.com/slok/bilrost/internal/kubernetes"
 	kubernetesclient "github.com/slok/bilrost/internal/kubernetes/client"
+	"github.com/slok/bilrost/internal/leaderelection"
 	"github.com/slok/bilrost/internal/log"
 	bilrostprometheus "github.com/slok/bilrost/internal/metrics/prometheus"
 	"github.com/slok/bilrost/internal/proxy"
+	"github.com/slok/bilrost/internal/proxy/bilrostproxy"
+	"github.com/slok/bilrost/internal/proxy/nginx"
 	"github.com/slok/bilrost/internal/proxy/oauth2proxy"
+	"github.com/slok/bilrost/internal/proxy/skipper"
+	"github.com/slok/bilrost/internal/proxy/traefik"
 	"github.com/slok/bilrost/internal/security"
 )
 
+// Version is the version of the app, set at build time.
+var Version = "dev"
+
 // Run runs the main application.
 func Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
@@ -66,14 +76,31 @@ func Run(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("could not create K8S core client: %w", err)
 	}
+	kubeDynamicCli, err := kubernetesclient.BaseFactory.NewDynamicClient(context.TODO(), kcfg)
+	if err != nil {
+		return fmt.Errorf("could not create K8S dynamic client: %w", err)
+	}
 
 	// Create main dependencies.
 	metricsRecorder := bilrostprometheus.NewRecorder(prometheus.DefaultRegisterer)
-	kubeSvc := kubernetes.NewMeasuredService(metricsRecorder, kubernetes.NewService(kubeCoreCli, kubeBilrostCli, logger))
-	proxyProvisioner := proxy.NewMeasuredOIDCProvisioner(
+	baseKubeSvc, err := kubernetes.NewService(kubeCoreCli, kubeBilrostCli, kubeDynamicCli, logger)
+	if err != nil {
+		return fmt.Errorf("could not create K8S service: %w", err)
+	}
+	kubeSvc := kubernetes.NewMeasuredService(metricsRecorder, baseKubeSvc)
+	oauth2proxyProvisioner := proxy.NewMeasuredOIDCProvisioner(
 		"oauth2proxy",
 		metricsRecorder,
 		oauth2proxy.NewOIDCProvisioner(kubeSvc, logger))
+	// The apps using the Bilrost proxy or the ingress controller auth (nginx external auth, Traefik
+	// forward auth or Skipper filters) are provisioned by their provisioners, the rest by oauth2-proxy.
+	proxyProvisioner := proxy.NewMeasuredOIDCProvisioner(
+		"bilrostproxy",
+		metricsRecorder,
+		bilrostproxy.NewOIDCProvisioner(kubeSvc, oauth2proxyProvisioner, cmdCfg.BilrostProxyImage, logger))
+	proxyProvisioner = nginx.NewOIDCProvisioner(kubeSvc, proxyProvisioner, logger)
+	proxyProvisioner = traefik.NewOIDCProvisioner(kubeSvc, proxyProvisioner, logger)
+	proxyProvisioner = skipper.NewOIDCProvisioner(kubeSvc, proxyProvisioner, logger)
 	backupSvc := backup.NewMeasuredbackupper("ingress", metricsRecorder, backup.NewIngressBackupper(kubeSvc, logger))
 	authBackFactory := authbackendfactory.NewFactory(cmdCfg.NamespaceRunning, metricsRecorder, kubeSvc, logger)
 	secSvc, err := security.NewService(security.ServiceConfig{
@@ -87,6 +114,11 @@ func Run(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("could not create security service: %w", err)
 	}
+	eventRecorder, err := kubernetes.NewEventRecorder(kubeCoreCli, "bilrost", logger)
+	if err != nil {
+		return fmt.Errorf("could not create Kubernetes event recorder: %w", err)
+	}
+	defer eventRecorder.Stop()
 
 	// Prepare our run entrypoints.
 	var g run.Group
@@ -149,23 +181,41 @@ func Run(ctx context.Context) error {
 	}
 
 	// Controllers.
-	// We create and run 2 controllers that have the same handler.
+	// We create and run 4 controllers that have the same handler.
 	//
-	// The primary controller is based on Ingresses and the secondary controller is based on
-	// IngressAuth CR.
+	// The primary controller is based on Ingresses and the secondary controllers are based on
+	// IngressAuth CR, AuthBackend CR and the Secrets referenced by them.
 	//
-	// Both controllers end executing the same reconciliation loop but if anything changes in any of
+	// All controllers end executing the same reconciliation loop but if anything changes in any of
 	// the resources we will reconcile again.
+	//
+	// Only the leader will run the controllers (and the janitors), the standby instances will
+	// keep serving the HTTP server until they acquire the leadership.
 	{
 		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
 		const retries = 2
-		handler, err := controller.NewHandler(controller.HandlerConfig{
+		// The ingresses of the changed auth backends (and their secrets) are enqueued to be
+		// reconciled again.
+		ingEnqueuer, err := controller.NewIngressEnqueuer(controller.IngressEnqueuerConfig{
 			KubernetesRepo: kubeSvc,
-			SecuritySvc:    secSvc,
+			Workers:        cmdCfg.Workers,
+			Retries:        retries,
 			Logger:         logger,
 		})
+		if err != nil {
+			return fmt.Errorf("could not create ingress enqueuer: %w", err)
+		}
+		handler, err := controller.NewHandler(controller.HandlerConfig{
+			KubernetesRepo:         kubeSvc,
+			SecuritySvc:            secSvc,
+			EventRecorder:          eventRecorder,
+			AuthBackendInvalidator: authBackFactory,
+			IngressEnqueuer:        ingEnqueuer,
+			NamespaceFilter:        cmdCfg.NamespaceFilter,
+			Logger:                 logger,
+		})
 		if err != nil {
 			return fmt.Errorf("could not create controller handler: %w", err)
 		}
@@ -184,15 +234,6 @@ func Run(ctx context.Context) error {
 			return fmt.Errorf("could not create backend auth kubernetes controller: %w", err)
 		}
 
-		g.Add(
-			func() error {
-				return ctrlIng.Run(ctx)
-			},
-			func(_ error) {
-				cancel()
-			},
-		)
-
 		ctrlIngAuth, err := koopercontroller.New(&koopercontroller.Config{
 			Handler:              handler,
 			Retriever:            controller.NewIngressAuthRetriever(cmdCfg.NamespaceFilter, kubeSvc),
@@ -209,14 +250,170 @@ func Run(ctx context.Context) error {
 			return fmt.Errorf("could not create backend auth kubernetes controller: %w", err)
 		}
 
-		g.Add(
-			func() error {
-				return ctrlIngAuth.Run(ctx)
-			},
-			func(_ error) {
-				cancel()
-			},
-		)
+		ctrlAuthBackend, err := koopercontroller.New(&koopercontroller.Config{
+			Handler:              handler,
+			Retriever:            controller.NewAuthBackendRetriever(kubeSvc),
+			MetricsRecorder:      metricsRecorder,
+			Logger:               kooperLogger,
+			Name:                 "bilrost-controller-authbackend",
+			ConcurrentWorkers:    cmdCfg.Workers,
+			ProcessingJobRetries: retries,
+			// Same as the IngressAuth controller, we only want the updates.
+			DisableResync: true,
+		})
+		if err != nil {
+			return fmt.Errorf("could not create backend auth kubernetes controller: %w", err)
+		}
+
+		ctrlSecret, err := koopercontroller.New(&koopercontroller.Config{
+			Handler:              handler,
+			Retriever:            controller.NewSecretRetriever(kubeSvc),
+			MetricsRecorder:      metricsRecorder,
+			Logger:               kooperLogger,
+			Name:                 "bilrost-controller-secret",
+			ConcurrentWorkers:    cmdCfg.Workers,
+			ProcessingJobRetries: retries,
+			// Same as the IngressAuth controller, we only want the updates.
+			DisableResync: true,
+		})
+		if err != nil {
+			return fmt.Errorf("could not create secret kubernetes controller: %w", err)
+		}
+
+		dexClientJanitor, err := janitor.NewDexClientJanitor(janitor.DexClientJanitorConfig{
+			RunningNamespace:      cmdCfg.NamespaceRunning,
+			Interval:              cmdCfg.DexClientJanitorInterval,
+			DryRun:                cmdCfg.DexClientJanitorDryRun,
+			KubernetesRepository:  kubeSvc,
+			AuthBackendRegFactory: authBackFactory,
+			MetricsRecorder:       metricsRecorder,
+			Logger:                logger,
+		})
+		if err != nil {
+			return fmt.Errorf("could not create Dex client janitor: %w", err)
+		}
+
+		proxyJanitor, err := janitor.NewProxyJanitor(janitor.ProxyJanitorConfig{
+			Namespace:            cmdCfg.NamespaceFilter,
+			Interval:             cmdCfg.ProxyJanitorInterval,
+			DryRun:               cmdCfg.ProxyJanitorDryRun,
+			KubernetesRepository: kubeSvc,
+			MetricsRecorder:      metricsRecorder,
+			Logger:               logger,
+		})
+		if err != nil {
+			return fmt.Errorf("could not create proxy janitor: %w", err)
+		}
+
+		runControllers := func(ctx context.Context) error {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			var g run.Group
+			g.Add(
+				func() error {
+					return ctrlIng.Run(ctx)
+				},
+				func(_ error) {
+					cancel()
+				},
+			)
+			g.Add(
+				func() error {
+					return ctrlIngAuth.Run(ctx)
+				},
+				func(_ error) {
+					cancel()
+				},
+			)
+			g.Add(
+				func() error {
+					return ctrlAuthBackend.Run(ctx)
+				},
+				func(_ error) {
+					cancel()
+				},
+			)
+			g.Add(
+				func() error {
+					return ctrlSecret.Run(ctx)
+				},
+				func(_ error) {
+					cancel()
+				},
+			)
+			g.Add(
+				func() error {
+					return ingEnqueuer.Run(ctx, handler)
+				},
+				func(_ error) {
+					cancel()
+				},
+			)
+			if !cmdCfg.DexClientJanitorDisable {
+				g.Add(
+					func() error {
+						return dexClientJanitor.Run(ctx)
+					},
+					func(_ error) {
+						cancel()
+					},
+				)
+			}
+			if !cmdCfg.ProxyJanitorDisable {
+				g.Add(
+					func() error {
+						return proxyJanitor.Run(ctx)
+					},
+					func(_ error) {
+						cancel()
+					},
+				)
+			}
+
+			return g.Run()
+		}
+
+		if cmdCfg.LeaderElectionDisable {
+			logger.Warningf("leader election disabled")
+			g.Add(
+				func() error {
+					return runControllers(ctx)
+				},
+				func(_ error) {
+					cancel()
+				},
+			)
+		} else {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("could not get hostname: %w", err)
+			}
+
+			leRunner, err := leaderelection.NewRunner(leaderelection.Config{
+				LeaseClient:     kubeCoreCli.CoordinationV1(),
+				LeaseName:       cmdCfg.LeaderElectionLeaseName,
+				LeaseNamespace:  cmdCfg.LeaderElectionLeaseNamespace,
+				Identity:        fmt.Sprintf("%s_%s", hostname, uuid.NewUUID()),
+				LeaseDuration:   cmdCfg.LeaderElectionLeaseDuration,
+				RenewDeadline:   cmdCfg.LeaderElectionRenewDeadline,
+				RetryPeriod:     cmdCfg.LeaderElectionRetryPeriod,
+				MetricsRecorder: metricsRecorder,
+				Logger:          logger,
+			})
+			if err != nil {
+				return fmt.Errorf("could not create leader election runner: %w", err)
+			}
+
+			g.Add(
+				func() error {
+					return leRunner.Run(ctx, runControllers)
+				},
+				func(_ error) {
+					cancel()
+				},
+			)
+		}
 	}
 
 	err = g.Run()
@@ -0,0 +1,549 @@
+package auth0
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/metrics"
+)
+
+// KubernetesRepository is the service used by the registerer to interact with k8s.
+type KubernetesRepository interface {
+	EnsureSecret(ctx context.Context, sec *corev1.Secret) error
+	GetSecret(ctx context.Context, ns, name string) (*corev1.Secret, error)
+	DeleteSecret(ctx context.Context, ns, name string) error
+}
+
+//go:generate mockery -case underscore -output auth0mock -outpkg auth0mock -name KubernetesRepository
+
+// AppRegistererConfig is the configuration for the app registerer.
+type AppRegistererConfig struct {
+	// AuthBackendID is the ID of the auth backend, it's used to not share the apps
+	// between different auth backends.
+	AuthBackendID    string
+	RunningNamespace string
+	// Domain is the Auth0 tenant domain.
+	Domain string
+	// ClientID and ClientSecret are the credentials of the machine to machine application
+	// used to access the Management API.
+	ClientID     string
+	ClientSecret string
+	// BaseURL is the URL used to access Auth0, by default `https://{Domain}`.
+	BaseURL              string
+	HTTPClient           *http.Client
+	KubernetesRepository KubernetesRepository
+	// SecretMaxAge is the max age of the client secrets, once expired they will be rotated
+	// on Auth0. If 0 the secrets will not be rotated. The apps can override it.
+	SecretMaxAge    time.Duration
+	TimeNow         func() time.Time
+	MetricsRecorder metrics.Recorder
+	Logger          log.Logger
+}
+
+func (c *AppRegistererConfig) defaults() error {
+	if c.AuthBackendID == "" {
+		return fmt.Errorf("the auth backend ID is required")
+	}
+
+	if c.RunningNamespace == "" {
+		return fmt.Errorf("the namespace where the app is running is required")
+	}
+
+	if c.Domain == "" {
+		return fmt.Errorf("the Auth0 domain is required")
+	}
+
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return fmt.Errorf("the Auth0 management API credentials are required")
+	}
+
+	if c.KubernetesRepository == nil {
+		return fmt.Errorf("a Kubernetes repository required")
+	}
+
+	if c.BaseURL == "" {
+		c.BaseURL = "https://" + c.Domain
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if c.TimeNow == nil {
+		c.TimeNow = time.Now
+	}
+
+	if c.MetricsRecorder == nil {
+		c.MetricsRecorder = metrics.Dummy
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Dummy
+	}
+	c.Logger = c.Logger.WithKV(log.KV{"service": "authbackend.auth0.AppRegisterer"})
+
+	return nil
+}
+
+type appRegisterer struct {
+	authBackendID    string
+	runningNamespace string
+	baseURL          string
+	secretMaxAge     time.Duration
+	timeNow          func() time.Time
+	cli              *http.Client
+	kuberepo         KubernetesRepository
+	metricsRec       metrics.Recorder
+	logger           log.Logger
+}
+
+// NewAppRegisterer returns a new application registerer for Auth0.
+//
+// The apps are registered as Auth0 regular web applications using the Management API, the
+// Management API access token is obtained (and refreshed) with the client credentials flow.
+func NewAppRegisterer(config AppRegistererConfig) (authbackend.AppRegisterer, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("could not create app registerer: %w", err)
+	}
+
+	cc := clientcredentials.Config{
+		ClientID:       config.ClientID,
+		ClientSecret:   config.ClientSecret,
+		TokenURL:       config.BaseURL + "/oauth/token",
+		EndpointParams: url.Values{"audience": {fmt.Sprintf("https://%s/api/v2/", config.Domain)}},
+		AuthStyle:      oauth2.AuthStyleInParams,
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, config.HTTPClient)
+
+	return appRegisterer{
+		authBackendID:    config.AuthBackendID,
+		runningNamespace: config.RunningNamespace,
+		baseURL:          config.BaseURL,
+		secretMaxAge:     config.SecretMaxAge,
+		timeNow:          config.TimeNow,
+		cli:              cc.Client(ctx),
+		kuberepo:         config.KubernetesRepository,
+		metricsRec:       config.MetricsRecorder,
+		logger:           config.Logger,
+	}, nil
+}
+
+const (
+	metadataAppIDKey       = "bilrost_app_id"
+	metadataAuthBackendKey = "bilrost_auth_backend"
+)
+
+// client is the Auth0 client (application) representation.
+type client struct {
+	ClientID                string            `json:"client_id,omitempty"`
+	ClientSecret            string            `json:"client_secret,omitempty"`
+	Name                    string            `json:"name,omitempty"`
+	AppType                 string            `json:"app_type,omitempty"`
+	Callbacks               []string          `json:"callbacks,omitempty"`
+	AllowedLogoutURLs       []string          `json:"allowed_logout_urls,omitempty"`
+	OIDCConformant          bool              `json:"oidc_conformant,omitempty"`
+	GrantTypes              []string          `json:"grant_types,omitempty"`
+	TokenEndpointAuthMethod string            `json:"token_endpoint_auth_method,omitempty"`
+	ClientMetadata          map[string]string `json:"client_metadata,omitempty"`
+
+	// createdAt is the creation time of the client secret, only tracked on the stored data.
+	createdAt time.Time
+}
+
+func (a appRegisterer) RegisterApp(ctx context.Context, app authbackend.OIDCApp) (*authbackend.OIDCAppRegistryData, error) {
+	logger := a.logger.WithKV(log.KV{"app": app.Name, "callbackURLs": app.CallBackURLs})
+
+	stored, err := a.getStoredClient(ctx, app.ID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get '%s' app stored client data: %w", app.ID, err)
+	}
+
+	// Get the Auth0 client ID of the app, if we don't have it stored, maybe was registered previously
+	// and we lost the stored data.
+	clientID := ""
+	if stored != nil {
+		clientID = stored.ClientID
+	} else {
+		clientID, err = a.findClientID(ctx, app.ID)
+		if err != nil {
+			return nil, fmt.Errorf("could not search '%s' app client: %w", app.ID, err)
+		}
+	}
+
+	appClient := a.newClient(app)
+	updated := false
+	if clientID != "" {
+		updated, err = a.updateClient(ctx, clientID, appClient)
+		if err != nil {
+			return nil, fmt.Errorf("could not update '%s' app client: %w", app.ID, err)
+		}
+		if !updated {
+			logger.Warningf("client missing on Auth0, registering again")
+		}
+	}
+
+	hasSecret := stored != nil && stored.ClientSecret != ""
+	expired := hasSecret && a.secretExpired(ctx, app, stored.createdAt)
+
+	var res *client
+	switch {
+	// Registered and we have a valid secret, nothing else to do.
+	case updated && hasSecret && !expired:
+		res = stored
+
+	// Registered but the secret expired, rotate the secret.
+	case updated && expired:
+		logger.Infof("client secret expired, rotating Auth0 client secret")
+		res, err = a.rotateClientSecret(ctx, clientID)
+		if err != nil {
+			return nil, fmt.Errorf("could not rotate '%s' app client secret: %w", app.ID, err)
+		}
+
+	// Registered but we don't have the secret, rotate the secret so we know it.
+	case updated:
+		logger.Infof("client secret missing, rotating Auth0 client secret")
+		res, err = a.rotateClientSecret(ctx, clientID)
+		if err != nil {
+			return nil, fmt.Errorf("could not rotate '%s' app client secret: %w", app.ID, err)
+		}
+
+	// Not registered.
+	default:
+		res, err = a.createClient(ctx, appClient)
+		if err != nil {
+			return nil, fmt.Errorf("could not create '%s' app client: %w", app.ID, err)
+		}
+	}
+
+	// New secrets, or stored before tracking the creation, start tracking them from now.
+	if res.createdAt.IsZero() {
+		res.createdAt = a.timeNow()
+		a.metricsRec.SetClientSecretAge(ctx, a.authBackendID, app.ID, 0)
+	}
+
+	err = a.storeClient(ctx, app.ID, *res)
+	if err != nil {
+		return nil, fmt.Errorf("could not store '%s' app client data: %w", app.ID, err)
+	}
+
+	logger.Infof("app registered as a client on Auth0")
+
+	return &authbackend.OIDCAppRegistryData{
+		ClientID:     res.ClientID,
+		ClientSecret: res.ClientSecret,
+	}, nil
+}
+
+// secretExpired returns true if the client secret is older than the max age, the secrets without
+// creation time are never expired.
+func (a appRegisterer) secretExpired(ctx context.Context, app authbackend.OIDCApp, createdAt time.Time) bool {
+	if createdAt.IsZero() {
+		return false
+	}
+
+	age := a.timeNow().Sub(createdAt)
+	maxAge := a.secretMaxAge
+	if app.SecretMaxAge != 0 {
+		maxAge = app.SecretMaxAge
+	}
+	if maxAge == 0 || age < maxAge {
+		a.metricsRec.SetClientSecretAge(ctx, a.authBackendID, app.ID, age)
+		return false
+	}
+
+	a.logger.WithKV(log.KV{"age": age, "maxAge": maxAge}).Infof("secret for app '%s' expired", app.ID)
+	return true
+}
+
+func (a appRegisterer) UnregisterApp(ctx context.Context, appID string) error {
+	stored, err := a.getStoredClient(ctx, appID)
+	if err != nil {
+		return fmt.Errorf("could not get '%s' app stored client data: %w", appID, err)
+	}
+
+	clientID := ""
+	if stored != nil {
+		clientID = stored.ClientID
+	} else {
+		clientID, err = a.findClientID(ctx, appID)
+		if err != nil {
+			return fmt.Errorf("could not search '%s' app client: %w", appID, err)
+		}
+	}
+
+	if clientID != "" {
+		err = a.deleteClient(ctx, clientID)
+		if err != nil {
+			return fmt.Errorf("could not delete '%s' app client: %w", appID, err)
+		}
+	}
+
+	err = a.kuberepo.DeleteSecret(ctx, a.runningNamespace, a.secretName(appID))
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete '%s' app client data: %w", appID, err)
+	}
+
+	return nil
+}
+
+func (a appRegisterer) newClient(app authbackend.OIDCApp) client {
+	return client{
+		Name:                    app.Name,
+		AppType:                 "regular_web",
+		Callbacks:               app.CallBackURLs,
+		AllowedLogoutURLs:       getOrigins(app.CallBackURLs),
+		OIDCConformant:          true,
+		GrantTypes:              []string{"authorization_code", "refresh_token"},
+		TokenEndpointAuthMethod: "client_secret_basic",
+		ClientMetadata: map[string]string{
+			metadataAppIDKey:       app.ID,
+			metadataAuthBackendKey: a.authBackendID,
+		},
+	}
+}
+
+// getOrigins returns the origins (scheme and host) of the URLs.
+func getOrigins(urls []string) []string {
+	origins := []string{}
+	seen := map[string]bool{}
+	for _, u := range urls {
+		pu, err := url.Parse(u)
+		if err != nil || pu.Host == "" {
+			continue
+		}
+
+		origin := fmt.Sprintf("%s://%s", pu.Scheme, pu.Host)
+		if seen[origin] {
+			continue
+		}
+		seen[origin] = true
+		origins = append(origins, origin)
+	}
+
+	return origins
+}
+
+func (a appRegisterer) createClient(ctx context.Context, c client) (*client, error) {
+	res := &client{}
+	statusCode, err := a.do(ctx, http.MethodPost, "/api/v2/clients", c, res)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusCreated {
+		return nil, fmt.Errorf("unexpected %d status code", statusCode)
+	}
+
+	return res, nil
+}
+
+// updateClient updates the client, returns false if the client is missing.
+func (a appRegisterer) updateClient(ctx context.Context, clientID string, c client) (bool, error) {
+	// App type can't be changed.
+	c.AppType = ""
+	statusCode, err := a.do(ctx, http.MethodPatch, "/api/v2/clients/"+url.PathEscape(clientID), c, nil)
+	if err != nil {
+		return false, err
+	}
+
+	switch statusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+
+	return false, fmt.Errorf("unexpected %d status code", statusCode)
+}
+
+func (a appRegisterer) rotateClientSecret(ctx context.Context, clientID string) (*client, error) {
+	res := &client{}
+	statusCode, err := a.do(ctx, http.MethodPost, "/api/v2/clients/"+url.PathEscape(clientID)+"/rotate-secret", nil, res)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected %d status code", statusCode)
+	}
+
+	return res, nil
+}
+
+// deleteClient deletes the client, is safe to delete missing clients.
+func (a appRegisterer) deleteClient(ctx context.Context, clientID string) error {
+	statusCode, err := a.do(ctx, http.MethodDelete, "/api/v2/clients/"+url.PathEscape(clientID), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if statusCode == http.StatusNoContent || statusCode == http.StatusOK || statusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return fmt.Errorf("unexpected %d status code", statusCode)
+}
+
+const clientsPageSize = 100
+
+// findClientID searches the client of an app using the client metadata set by bilrost,
+// returns an empty ID if missing.
+func (a appRegisterer) findClientID(ctx context.Context, appID string) (string, error) {
+	for page := 0; ; page++ {
+		q := url.Values{
+			"fields":         {"client_id,client_metadata"},
+			"include_fields": {"true"},
+			"app_type":       {"regular_web"},
+			"page":           {strconv.Itoa(page)},
+			"per_page":       {strconv.Itoa(clientsPageSize)},
+		}
+		clients := []client{}
+		statusCode, err := a.do(ctx, http.MethodGet, "/api/v2/clients?"+q.Encode(), nil, &clients)
+		if err != nil {
+			return "", err
+		}
+		if statusCode != http.StatusOK {
+			return "", fmt.Errorf("unexpected %d status code", statusCode)
+		}
+
+		for _, c := range clients {
+			if c.ClientMetadata[metadataAppIDKey] == appID && c.ClientMetadata[metadataAuthBackendKey] == a.authBackendID {
+				return c.ClientID, nil
+			}
+		}
+
+		if len(clients) < clientsPageSize {
+			return "", nil
+		}
+	}
+}
+
+// do makes a request to the Management API, the response is decoded on resp if the request
+// succeeds. Returns the response status code, the status codes are handled by the caller except
+// the server errors.
+func (a appRegisterer) do(ctx context.Context, method, path string, body, resp interface{}) (int, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("could not marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, a.baseURL+path, reqBody)
+	if err != nil {
+		return 0, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	r, err := a.cli.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= 300 {
+		// Try getting the error from the response.
+		errResp := struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}{}
+		_ = json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&errResp)
+		if r.StatusCode >= 500 || r.StatusCode == http.StatusUnauthorized || r.StatusCode == http.StatusForbidden {
+			return r.StatusCode, fmt.Errorf("unexpected %d status code: %s: %s", r.StatusCode, errResp.Error, errResp.Message)
+		}
+		return r.StatusCode, nil
+	}
+
+	if resp != nil {
+		err = json.NewDecoder(r.Body).Decode(resp)
+		if err != nil {
+			return r.StatusCode, fmt.Errorf("could not decode response: %w", err)
+		}
+	}
+
+	return r.StatusCode, nil
+}
+
+const (
+	clientIDKey               = "clientID"
+	clientSecretKey           = "clientSecret"
+	secretCreatedAtAnnotation = "bilrost.slok.dev/secret-created-at"
+)
+
+// getStoredClient returns the client data, if missing it will return nil.
+func (a appRegisterer) getStoredClient(ctx context.Context, appID string) (*client, error) {
+	sec, err := a.kuberepo.GetSecret(ctx, a.runningNamespace, a.secretName(appID))
+	if err != nil {
+		if kubeerrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// An invalid creation time is handled as missing.
+	createdAt, _ := time.Parse(time.RFC3339, sec.Annotations[secretCreatedAtAnnotation])
+
+	c := &client{
+		ClientID:     string(sec.Data[clientIDKey]),
+		ClientSecret: string(sec.Data[clientSecretKey]),
+		createdAt:    createdAt,
+	}
+	if c.ClientID == "" {
+		return nil, nil
+	}
+
+	return c, nil
+}
+
+func (a appRegisterer) storeClient(ctx context.Context, appID string, c client) error {
+	name := a.secretName(appID)
+	return a.kuberepo.EnsureSecret(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: a.runningNamespace,
+			Annotations: map[string]string{
+				"bilrost.slok.dev/auth0-app-id": appID,
+				"bilrost.slok.dev/auth-backend": a.authBackendID,
+				secretCreatedAtAnnotation:       c.createdAt.UTC().Format(time.RFC3339),
+			},
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "bilrost",
+				"app.kubernetes.io/name":       "bilrost",
+				"app.kubernetes.io/component":  "auth0-client-data",
+				"app.kubernetes.io/instance":   name,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			clientIDKey:     []byte(c.ClientID),
+			clientSecretKey: []byte(c.ClientSecret),
+		},
+	})
+}
+
+func (a appRegisterer) secretName(appID string) string {
+	checksum := md5.Sum([]byte(a.authBackendID + "/" + appID))
+	return fmt.Sprintf("bilrost-auth0-cli-%x", checksum)
+}
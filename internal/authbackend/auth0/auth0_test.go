@@ -0,0 +1,459 @@
+package auth0_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/authbackend/auth0"
+	"github.com/slok/bilrost/internal/authbackend/auth0/auth0mock"
+)
+
+// fakeAuth0 is a stand-in Auth0 Management API.
+type fakeAuth0 struct {
+	t        *testing.T
+	clients  map[string]map[string]interface{}
+	requests []string
+}
+
+func (f *fakeAuth0) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/oauth/token" {
+		require.NoError(f.t, r.ParseForm())
+		assert.Equal(f.t, "https://test.auth0.com/api/v2/", r.Form.Get("audience"))
+		if r.Form.Get("client_id") != "m4n4g3r" || r.Form.Get("client_secret") != "m4n4g3r-53cr37" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "t0k3n", "token_type": "Bearer", "expires_in": 3600})
+		return
+	}
+
+	f.requests = append(f.requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+	if r.Header.Get("Authorization") != "Bearer t0k3n" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body := map[string]interface{}{}
+	if r.Method == http.MethodPost || r.Method == http.MethodPatch {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v2/clients":
+		res := []map[string]interface{}{}
+		for _, c := range f.clients {
+			res = append(res, map[string]interface{}{"client_id": c["client_id"], "client_metadata": c["client_metadata"]})
+		}
+		_ = json.NewEncoder(w).Encode(res)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v2/clients":
+		assert.Equal(f.t, "regular_web", body["app_type"])
+		assert.Equal(f.t, []interface{}{"https://whatever.dev/oauth2/callback"}, body["callbacks"])
+		assert.Equal(f.t, []interface{}{"https://whatever.dev"}, body["allowed_logout_urls"])
+		body["client_id"] = "new-client-id"
+		body["client_secret"] = "new-53cr37"
+		f.clients["new-client-id"] = body
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(body)
+
+	case r.Method == http.MethodPatch && r.URL.Path == "/api/v2/clients/client-id":
+		c, ok := f.clients["client-id"]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		assert.Equal(f.t, []interface{}{"https://whatever.dev/oauth2/callback"}, body["callbacks"])
+		_ = json.NewEncoder(w).Encode(c)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v2/clients/client-id/rotate-secret":
+		c := f.clients["client-id"]
+		c["client_secret"] = "r0t4t3d-53cr37"
+		_ = json.NewEncoder(w).Encode(c)
+
+	case r.Method == http.MethodDelete && r.URL.Path == "/api/v2/clients/client-id":
+		if _, ok := f.clients["client-id"]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.clients, "client-id")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+const secretName = "bilrost-auth0-cli-541f1075a5e61f5da55d0f217c4f9b90"
+
+func getBaseApp() authbackend.OIDCApp {
+	return authbackend.OIDCApp{
+		ID:           "test-id",
+		Name:         "test",
+		CallBackURLs: []string{"https://whatever.dev/oauth2/callback"},
+	}
+}
+
+func getRegisteredClient() map[string]interface{} {
+	return map[string]interface{}{
+		"client_id":       "client-id",
+		"client_secret":   "53cr37",
+		"client_metadata": map[string]interface{}{"bilrost_app_id": "test-id", "bilrost_auth_backend": "test-backend"},
+	}
+}
+
+var (
+	t0 = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 = t0.Add(24 * time.Hour)
+)
+
+func getSecret(clientID, clientSecret string, createdAt time.Time) *corev1.Secret {
+	annotations := map[string]string{
+		"bilrost.slok.dev/auth0-app-id": "test-id",
+		"bilrost.slok.dev/auth-backend": "test-backend",
+	}
+	if !createdAt.IsZero() {
+		annotations["bilrost.slok.dev/secret-created-at"] = createdAt.Format(time.RFC3339)
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        secretName,
+			Namespace:   "test-ns",
+			Annotations: annotations,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "bilrost",
+				"app.kubernetes.io/name":       "bilrost",
+				"app.kubernetes.io/component":  "auth0-client-data",
+				"app.kubernetes.io/instance":   secretName,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"clientID":     []byte(clientID),
+			"clientSecret": []byte(clientSecret),
+		},
+	}
+}
+
+var errNotFound = kubeerrors.NewNotFound(corev1.Resource("secret"), secretName)
+
+func TestAppRegistererRegisterApp(t *testing.T) {
+	tests := map[string]struct {
+		clients      map[string]map[string]interface{}
+		mgmtSecret   string
+		secretMaxAge time.Duration
+		mock         func(m *auth0mock.KubernetesRepository)
+		expData      *authbackend.OIDCAppRegistryData
+		expRequests  []string
+		expClientIDs []string
+		expErr       bool
+	}{
+		"A new app should be created as a client.": {
+			clients: map[string]map[string]interface{}{},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+				m.On("EnsureSecret", mock.Anything, getSecret("new-client-id", "new-53cr37", t1)).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "new-client-id", ClientSecret: "new-53cr37"},
+			expRequests: []string{
+				"GET /api/v2/clients",
+				"POST /api/v2/clients",
+			},
+			expClientIDs: []string{"new-client-id"},
+		},
+
+		"An already registered app should update the client and reuse the stored secret.": {
+			clients: map[string]map[string]interface{}{"client-id": getRegisteredClient()},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(getSecret("client-id", "53cr37", t0), nil)
+				m.On("EnsureSecret", mock.Anything, getSecret("client-id", "53cr37", t0)).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "client-id", ClientSecret: "53cr37"},
+			expRequests: []string{
+				"PATCH /api/v2/clients/client-id",
+			},
+			expClientIDs: []string{"client-id"},
+		},
+
+		"An already registered app with an expired secret should rotate the client secret.": {
+			clients:      map[string]map[string]interface{}{"client-id": getRegisteredClient()},
+			secretMaxAge: 12 * time.Hour,
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(getSecret("client-id", "53cr37", t0), nil)
+				m.On("EnsureSecret", mock.Anything, getSecret("client-id", "r0t4t3d-53cr37", t1)).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "client-id", ClientSecret: "r0t4t3d-53cr37"},
+			expRequests: []string{
+				"PATCH /api/v2/clients/client-id",
+				"POST /api/v2/clients/client-id/rotate-secret",
+			},
+			expClientIDs: []string{"client-id"},
+		},
+
+		"An already registered app with a not expired secret should reuse the stored secret.": {
+			clients:      map[string]map[string]interface{}{"client-id": getRegisteredClient()},
+			secretMaxAge: 48 * time.Hour,
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(getSecret("client-id", "53cr37", t0), nil)
+				m.On("EnsureSecret", mock.Anything, getSecret("client-id", "53cr37", t0)).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "client-id", ClientSecret: "53cr37"},
+			expRequests: []string{
+				"PATCH /api/v2/clients/client-id",
+			},
+			expClientIDs: []string{"client-id"},
+		},
+
+		"An already registered app with a secret without creation time should start tracking it instead of rotating.": {
+			clients:      map[string]map[string]interface{}{"client-id": getRegisteredClient()},
+			secretMaxAge: 12 * time.Hour,
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(getSecret("client-id", "53cr37", time.Time{}), nil)
+				m.On("EnsureSecret", mock.Anything, getSecret("client-id", "53cr37", t1)).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "client-id", ClientSecret: "53cr37"},
+			expRequests: []string{
+				"PATCH /api/v2/clients/client-id",
+			},
+			expClientIDs: []string{"client-id"},
+		},
+
+		"An already registered app without stored secret should rotate the client secret.": {
+			clients: map[string]map[string]interface{}{"client-id": getRegisteredClient()},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(getSecret("client-id", "", t0), nil)
+				m.On("EnsureSecret", mock.Anything, getSecret("client-id", "r0t4t3d-53cr37", t1)).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "client-id", ClientSecret: "r0t4t3d-53cr37"},
+			expRequests: []string{
+				"PATCH /api/v2/clients/client-id",
+				"POST /api/v2/clients/client-id/rotate-secret",
+			},
+			expClientIDs: []string{"client-id"},
+		},
+
+		"An already registered app without stored data should adopt the client and rotate the secret.": {
+			clients: map[string]map[string]interface{}{"client-id": getRegisteredClient()},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+				m.On("EnsureSecret", mock.Anything, getSecret("client-id", "r0t4t3d-53cr37", t1)).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "client-id", ClientSecret: "r0t4t3d-53cr37"},
+			expRequests: []string{
+				"GET /api/v2/clients",
+				"PATCH /api/v2/clients/client-id",
+				"POST /api/v2/clients/client-id/rotate-secret",
+			},
+			expClientIDs: []string{"client-id"},
+		},
+
+		"A stored app missing on Auth0 should be created again.": {
+			clients: map[string]map[string]interface{}{},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(getSecret("client-id", "53cr37", t0), nil)
+				m.On("EnsureSecret", mock.Anything, getSecret("new-client-id", "new-53cr37", t1)).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "new-client-id", ClientSecret: "new-53cr37"},
+			expRequests: []string{
+				"PATCH /api/v2/clients/client-id",
+				"POST /api/v2/clients",
+			},
+			expClientIDs: []string{"new-client-id"},
+		},
+
+		"Having invalid management credentials should fail.": {
+			clients:    map[string]map[string]interface{}{},
+			mgmtSecret: "wrong",
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+			},
+			expClientIDs: []string{},
+			expErr:       true,
+		},
+
+		"Having an error while getting the stored data should fail.": {
+			clients: map[string]map[string]interface{}{},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, fmt.Errorf("whatever"))
+			},
+			expClientIDs: []string{},
+			expErr:       true,
+		},
+
+		"Having an error while storing the client data should fail.": {
+			clients: map[string]map[string]interface{}{},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expRequests: []string{
+				"GET /api/v2/clients",
+				"POST /api/v2/clients",
+			},
+			expClientIDs: []string{"new-client-id"},
+			expErr:       true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			fa := &fakeAuth0{t: t, clients: test.clients}
+			srv := httptest.NewServer(fa)
+			defer srv.Close()
+
+			mkr := &auth0mock.KubernetesRepository{}
+			test.mock(mkr)
+
+			// Prepare.
+			mgmtSecret := "m4n4g3r-53cr37"
+			if test.mgmtSecret != "" {
+				mgmtSecret = test.mgmtSecret
+			}
+			ar, err := auth0.NewAppRegisterer(auth0.AppRegistererConfig{
+				AuthBackendID:        "test-backend",
+				RunningNamespace:     "test-ns",
+				Domain:               "test.auth0.com",
+				ClientID:             "m4n4g3r",
+				ClientSecret:         mgmtSecret,
+				BaseURL:              srv.URL,
+				HTTPClient:           srv.Client(),
+				KubernetesRepository: mkr,
+				SecretMaxAge:         test.secretMaxAge,
+				TimeNow:              func() time.Time { return t1 },
+			})
+			require.NoError(err)
+
+			// Execute.
+			gotData, err := ar.RegisterApp(context.TODO(), getBaseApp())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expData, gotData)
+			}
+			assert.Equal(test.expRequests, fa.requests)
+			gotClientIDs := []string{}
+			for id := range fa.clients {
+				gotClientIDs = append(gotClientIDs, id)
+			}
+			assert.Equal(test.expClientIDs, gotClientIDs)
+			mkr.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAppRegistererUnregisterApp(t *testing.T) {
+	tests := map[string]struct {
+		clients     map[string]map[string]interface{}
+		mock        func(m *auth0mock.KubernetesRepository)
+		expRequests []string
+		expClients  int
+		expErr      bool
+	}{
+		"A registered app should delete the client and the stored data.": {
+			clients: map[string]map[string]interface{}{"client-id": getRegisteredClient()},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(getSecret("client-id", "53cr37", t0), nil)
+				m.On("DeleteSecret", mock.Anything, "test-ns", secretName).Once().Return(nil)
+			},
+			expRequests: []string{
+				"DELETE /api/v2/clients/client-id",
+			},
+		},
+
+		"A registered app without stored data should search the client and delete it.": {
+			clients: map[string]map[string]interface{}{"client-id": getRegisteredClient()},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+				m.On("DeleteSecret", mock.Anything, "test-ns", secretName).Once().Return(errNotFound)
+			},
+			expRequests: []string{
+				"GET /api/v2/clients",
+				"DELETE /api/v2/clients/client-id",
+			},
+		},
+
+		"A stored app missing on Auth0 should delete the stored data.": {
+			clients: map[string]map[string]interface{}{},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(getSecret("client-id", "53cr37", t0), nil)
+				m.On("DeleteSecret", mock.Anything, "test-ns", secretName).Once().Return(nil)
+			},
+			expRequests: []string{
+				"DELETE /api/v2/clients/client-id",
+			},
+		},
+
+		"Having an error while deleting the stored data should fail.": {
+			clients: map[string]map[string]interface{}{"client-id": getRegisteredClient()},
+			mock: func(m *auth0mock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(getSecret("client-id", "53cr37", t0), nil)
+				m.On("DeleteSecret", mock.Anything, "test-ns", secretName).Once().Return(fmt.Errorf("whatever"))
+			},
+			expRequests: []string{
+				"DELETE /api/v2/clients/client-id",
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			fa := &fakeAuth0{t: t, clients: test.clients}
+			srv := httptest.NewServer(fa)
+			defer srv.Close()
+
+			mkr := &auth0mock.KubernetesRepository{}
+			test.mock(mkr)
+
+			// Prepare.
+			ar, err := auth0.NewAppRegisterer(auth0.AppRegistererConfig{
+				AuthBackendID:        "test-backend",
+				RunningNamespace:     "test-ns",
+				Domain:               "test.auth0.com",
+				ClientID:             "m4n4g3r",
+				ClientSecret:         "m4n4g3r-53cr37",
+				BaseURL:              srv.URL,
+				HTTPClient:           srv.Client(),
+				KubernetesRepository: mkr,
+			})
+			require.NoError(err)
+
+			// Execute.
+			err = ar.UnregisterApp(context.TODO(), "test-id")
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(test.expRequests, fa.requests)
+			assert.Len(fa.clients, test.expClients)
+			mkr.AssertExpectations(t)
+		})
+	}
+}
@@ -2,15 +2,27 @@ package authbackend
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/slok/bilrost/internal/model"
 )
 
+// ErrTLSHandshake is returned (wrapped) by the app registerers when the TLS handshake with
+// the auth backend fails (e.g: invalid CA or client certificate).
+var ErrTLSHandshake = errors.New("auth backend TLS handshake failed")
+
 // OIDCApp is an app that can be registered on different OIDC auth backends.
 type OIDCApp struct {
-	ID          string
-	Name        string
-	CallBackURL string
+	ID           string
+	Name         string
+	CallBackURLs []string
+	// ClientCredentialsKey is the key of the pre-provisioned client credentials, only used
+	// by the auth backends that don't register apps (optional).
+	ClientCredentialsKey string
+	// SecretMaxAge is the max age of the app client secret, overrides the app registerer
+	// one, only used by the auth backends that generate the client secrets (optional).
+	SecretMaxAge time.Duration
 }
 
 // OIDCAppRegistryData is extra information that the user can use to communicate with the
@@ -31,6 +43,9 @@ type AppRegisterer interface {
 // AppRegistererFactory gets an app registerer based on an auth backend.
 type AppRegistererFactory interface {
 	GetAppRegisterer(ab model.AuthBackend) (AppRegisterer, error)
+	// InvalidateAppRegisterer removes the cached app registerer of the auth backend (if any),
+	// releasing its resources (e.g connections).
+	InvalidateAppRegisterer(authBackendID string) error
 }
 
 //go:generate mockery -case underscore -output authbackendmock -outpkg authbackendmock -name AppRegistererFactory
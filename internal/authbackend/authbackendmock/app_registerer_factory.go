@@ -36,3 +36,17 @@ func (_m *AppRegistererFactory) GetAppRegisterer(ab model.AuthBackend) (authback
 
 	return r0, r1
 }
+
+// InvalidateAppRegisterer provides a mock function with given fields: authBackendID
+func (_m *AppRegistererFactory) InvalidateAppRegisterer(authBackendID string) error {
+	ret := _m.Called(authBackendID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(authBackendID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
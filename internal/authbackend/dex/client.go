@@ -0,0 +1,81 @@
+package dex
+
+import (
+	"context"
+	"fmt"
+
+	dexapi "github.com/dexidp/dex/api/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetClientReq is the Dex API `GetClient` request.
+//
+// The Dex API module we depend on doesn't have the `GetClient` method (added on Dex v2.37),
+// so we have the same messages, these have the same wire format as the Dex API ones.
+type GetClientReq struct {
+	ID string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+// Reset satisfies proto.Message interface.
+func (m *GetClientReq) Reset() { *m = GetClientReq{} }
+
+// String satisfies proto.Message interface.
+func (m *GetClientReq) String() string { return fmt.Sprintf("%+v", *m) }
+
+// ProtoMessage satisfies proto.Message interface.
+func (*GetClientReq) ProtoMessage() {}
+
+// GetClientResp is the Dex API `GetClient` response.
+type GetClientResp struct {
+	Client *dexapi.Client `protobuf:"bytes,1,opt,name=client,proto3" json:"client,omitempty"`
+}
+
+// Reset satisfies proto.Message interface.
+func (m *GetClientResp) Reset() { *m = GetClientResp{} }
+
+// String satisfies proto.Message interface.
+func (m *GetClientResp) String() string { return fmt.Sprintf("%+v", *m) }
+
+// ProtoMessage satisfies proto.Message interface.
+func (*GetClientResp) ProtoMessage() {}
+
+type client struct {
+	dexapi.DexClient
+	conn *grpc.ClientConn
+}
+
+// NewClient returns a new Dex API client using the gRPC connection.
+func NewClient(conn *grpc.ClientConn) Client {
+	return client{
+		DexClient: dexapi.NewDexClient(conn),
+		conn:      conn,
+	}
+}
+
+func (c client) GetClient(ctx context.Context, in *GetClientReq, opts ...grpc.CallOption) (*GetClientResp, error) {
+	out := &GetClientResp{}
+	err := c.conn.Invoke(ctx, "/api.Dex/GetClient", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// isDexClientNotFound returns true if the error is a missing client error. Dex doesn't use
+// the gRPC not found code, returns the storage not found error as an unknown error.
+func isDexClientNotFound(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+
+	return st.Code() == codes.NotFound || (st.Code() == codes.Unknown && st.Message() == "not found")
+}
+
+// isDexUnimplemented returns true if the Dex API doesn't have the called method (e.g: old Dex versions).
+func isDexUnimplemented(err error) bool {
+	return status.Code(err) == codes.Unimplemented
+}
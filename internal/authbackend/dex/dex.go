@@ -6,21 +6,28 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"strings"
+	"time"
 
 	dexapi "github.com/dexidp/dex/api/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	corev1 "k8s.io/api/core/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/slok/bilrost/internal/authbackend"
 	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/metrics"
 )
 
 // Client is the dex client interface.
 type Client interface {
 	CreateClient(ctx context.Context, in *dexapi.CreateClientReq, opts ...grpc.CallOption) (*dexapi.CreateClientResp, error)
+	UpdateClient(ctx context.Context, in *dexapi.UpdateClientReq, opts ...grpc.CallOption) (*dexapi.UpdateClientResp, error)
 	DeleteClient(ctx context.Context, in *dexapi.DeleteClientReq, opts ...grpc.CallOption) (*dexapi.DeleteClientResp, error)
+	GetClient(ctx context.Context, in *GetClientReq, opts ...grpc.CallOption) (*GetClientResp, error)
 }
 
 //go:generate mockery -case underscore -output dexmock -outpkg dexmock -name Client
@@ -36,11 +43,20 @@ type KubernetesRepository interface {
 
 // AppRegistererConfig is the configuration for the app registerer.
 type AppRegistererConfig struct {
+	// AuthBackendID is the ID of the auth backend, it's used to not share the apps data between
+	// different Dex auth backends (e.g: migrating an app between backends). If empty, the apps
+	// data will be shared between all the Dex auth backends.
+	AuthBackendID        string
 	RunningNamespace     string
 	Client               Client
 	KubernetesRepository KubernetesRepository
 	SecretGenerator      func(app authbackend.OIDCApp) (string, error)
-	Logger               log.Logger
+	// SecretMaxAge is the max age of the client secrets, once expired they will be rotated.
+	// If 0 the secrets will not be rotated. The apps can override it.
+	SecretMaxAge    time.Duration
+	TimeNow         func() time.Time
+	MetricsRecorder metrics.Recorder
+	Logger          log.Logger
 }
 
 func (c *AppRegistererConfig) defaults() error {
@@ -68,6 +84,14 @@ func (c *AppRegistererConfig) defaults() error {
 		}
 	}
 
+	if c.TimeNow == nil {
+		c.TimeNow = time.Now
+	}
+
+	if c.MetricsRecorder == nil {
+		c.MetricsRecorder = metrics.Dummy
+	}
+
 	if c.Logger == nil {
 		c.Logger = log.Dummy
 	}
@@ -77,10 +101,14 @@ func (c *AppRegistererConfig) defaults() error {
 }
 
 type appRegisterer struct {
+	authBackendID    string
 	secretGenerator  func(app authbackend.OIDCApp) (string, error)
+	secretMaxAge     time.Duration
+	timeNow          func() time.Time
 	runningNamespace string
 	cli              Client
 	kuberepo         KubernetesRepository
+	metricsRec       metrics.Recorder
 	logger           log.Logger
 }
 
@@ -92,10 +120,14 @@ func NewAppRegisterer(config AppRegistererConfig) (authbackend.AppRegisterer, er
 	}
 
 	return appRegisterer{
+		authBackendID:    config.AuthBackendID,
 		secretGenerator:  config.SecretGenerator,
+		secretMaxAge:     config.SecretMaxAge,
+		timeNow:          config.TimeNow,
 		runningNamespace: config.RunningNamespace,
 		cli:              config.Client,
 		kuberepo:         config.KubernetesRepository,
+		metricsRec:       config.MetricsRecorder,
 		logger:           config.Logger,
 	}, nil
 }
@@ -106,13 +138,13 @@ func (a appRegisterer) RegisterApp(ctx context.Context, app authbackend.OIDCApp)
 		return nil, fmt.Errorf("could not get '%s' app OIDC Dex secret: %w", app.ID, err)
 	}
 
-	// changed means that we have a new secret and we need to recreate the client on Dex.
+	// changed means that we have a new secret and we can't update the client on Dex.
 	err = a.registerOnDex(ctx, app, secret, changed)
 	if err != nil {
 		return nil, fmt.Errorf("could not register app on dex: %w", err)
 	}
 
-	a.logger.WithKV(log.KV{"app": app.Name, "callbackURL": app.CallBackURL}).
+	a.logger.WithKV(log.KV{"app": app.Name, "callbackURLs": app.CallBackURLs}).
 		Infof("app registered as a client on Dex backend")
 
 	return &authbackend.OIDCAppRegistryData{
@@ -122,26 +154,61 @@ func (a appRegisterer) RegisterApp(ctx context.Context, app authbackend.OIDCApp)
 }
 
 // getAndCreateSecret will try getting the OIDC app client secret from a kubernetes secret
-// if the secret does not exists or is empty it will generate a new one.
+// if the secret does not exists, is empty or has expired it will generate a new one.
 // in case we generated a new secret it will return true on the `changed` flag.
 func (a appRegisterer) getAndCreateSecret(ctx context.Context, app authbackend.OIDCApp) (secret string, changed bool, err error) {
-	const clientSecretKey = "clientSecret"
-
 	// Check if we already have a secret.
-	name := getSecretName(app.ID)
-	kubeSecret, err := a.kuberepo.GetSecret(ctx, a.runningNamespace, name)
-	if err == nil {
-		secret := string(kubeSecret.Data[clientSecretKey])
-		// If we have the secret, then we don't need to create a new one.
+	name := getSecretName(a.authBackendID, app.ID)
+	secret, createdAt, err := a.getStoredSecret(ctx, name)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Secrets stored before having the auth backend on the name are adopted, this way
+	// we don't need to recreate the client on Dex.
+	if secret == "" && a.authBackendID != "" {
+		legacyName := getSecretName("", app.ID)
+		secret, createdAt, err = a.getStoredSecret(ctx, legacyName)
+		if err != nil {
+			return "", false, err
+		}
 		if secret != "" {
-			return secret, false, nil
+			if createdAt.IsZero() {
+				createdAt = a.timeNow()
+			}
+			err = a.kuberepo.EnsureSecret(ctx, a.newKubeSecret(name, app.ID, secret, createdAt))
+			if err != nil {
+				return "", false, err
+			}
+			err = a.deleteStoredSecret(ctx, legacyName)
+			if err != nil {
+				return "", false, err
+			}
+			a.logger.Debugf("legacy secret adopted for client '%s'", app.ID)
 		}
-		// Continue because we have the secret but is empty.
-	} else {
-		if !kubeerrors.IsNotFound(err) {
-			return "", false, err
+	}
+
+	if secret != "" {
+		// Secrets stored before tracking the creation, start tracking them from now.
+		if createdAt.IsZero() {
+			createdAt = a.timeNow()
+			err = a.kuberepo.EnsureSecret(ctx, a.newKubeSecret(name, app.ID, secret, createdAt))
+			if err != nil {
+				return "", false, err
+			}
+		}
+
+		age := a.timeNow().Sub(createdAt)
+		maxAge := a.secretMaxAge
+		if app.SecretMaxAge != 0 {
+			maxAge = app.SecretMaxAge
 		}
-		// Continue because the secret is not present on Kubernetes.
+		if maxAge == 0 || age < maxAge {
+			a.metricsRec.SetClientSecretAge(ctx, a.authBackendID, app.ID, age)
+			return secret, false, nil
+		}
+
+		a.logger.WithKV(log.KV{"age": age, "maxAge": maxAge}).Infof("secret for client '%s' expired, rotating", app.ID)
 	}
 
 	// If we reached here means that we need a new secret.
@@ -152,86 +219,288 @@ func (a appRegisterer) getAndCreateSecret(ctx context.Context, app authbackend.O
 	a.logger.Debugf("new secret generated for client '%s'", app.ID)
 
 	// Ensure secret (Create or update).
-	newSecret := &corev1.Secret{
+	err = a.kuberepo.EnsureSecret(ctx, a.newKubeSecret(name, app.ID, generatedSecret, a.timeNow()))
+	if err != nil {
+		return "", false, err
+	}
+	a.metricsRec.SetClientSecretAge(ctx, a.authBackendID, app.ID, 0)
+
+	return generatedSecret, true, nil
+}
+
+// getStoredSecret returns the OIDC app client secret stored on the Kubernetes secret and its
+// creation time, if missing it will return an empty secret and a zero creation time.
+func (a appRegisterer) getStoredSecret(ctx context.Context, name string) (string, time.Time, error) {
+	kubeSecret, err := a.kuberepo.GetSecret(ctx, a.runningNamespace, name)
+	if err != nil {
+		if kubeerrors.IsNotFound(err) {
+			return "", time.Time{}, nil
+		}
+		return "", time.Time{}, err
+	}
+
+	// An invalid creation time is handled as missing.
+	createdAt, _ := time.Parse(time.RFC3339, kubeSecret.Annotations[secretCreatedAtAnnotation])
+
+	return string(kubeSecret.Data[clientSecretKey]), createdAt, nil
+}
+
+// deleteStoredSecret deletes the Kubernetes secret, is safe to delete a missing secret.
+func (a appRegisterer) deleteStoredSecret(ctx context.Context, name string) error {
+	err := a.kuberepo.DeleteSecret(ctx, a.runningNamespace, name)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (a appRegisterer) newKubeSecret(name, appID, secret string, createdAt time.Time) *corev1.Secret {
+	annotations := map[string]string{
+		ClientIDAnnotation:        appID,
+		secretCreatedAtAnnotation: createdAt.UTC().Format(time.RFC3339),
+	}
+	if a.authBackendID != "" {
+		annotations[AuthBackendAnnotation] = a.authBackendID
+	}
+
+	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: a.runningNamespace,
-			Annotations: map[string]string{
-				"bilrost.slok.dev/dex-client-id": app.ID,
-			},
+			Name:        name,
+			Namespace:   a.runningNamespace,
+			Annotations: annotations,
 			Labels: map[string]string{
 				"app.kubernetes.io/managed-by": "bilrost",
 				"app.kubernetes.io/name":       "bilrost",
-				"app.kubernetes.io/component":  "dex-client-data",
+				"app.kubernetes.io/component":  ClientDataComponent,
 				"app.kubernetes.io/instance":   name,
 			},
 		},
 		Type: corev1.SecretTypeOpaque,
 		Data: map[string][]byte{
-			clientSecretKey: []byte(generatedSecret),
+			clientSecretKey: []byte(secret),
 		},
 	}
+}
 
-	err = a.kuberepo.EnsureSecret(ctx, newSecret)
-	if err != nil {
-		return "", false, err
+// registerOnDex will register the application on Dex converging the client in place, it gets
+// the client to decide what to do:
+//
+//   - If the client is missing, we create it.
+//   - If the client has the same secret, we update the client (name and redirect URIs), only
+//     when these changed.
+//   - If the client has a different secret, we recreate it. The Dex API doesn't allow updating
+//     the secret of a client, we can't do anything better to not end with inconsistencies (with
+//     Dex having an old secret for the client).
+//
+// Dex versions without the `GetClient` API will fallback to update or create the client.
+func (a appRegisterer) registerOnDex(ctx context.Context, app authbackend.OIDCApp, secret string, secretChanged bool) error {
+	logger := a.logger.WithKV(log.KV{"app": app.Name})
+
+	resp, err := a.cli.GetClient(ctx, &GetClientReq{ID: app.ID})
+	switch {
+	case isDexUnimplemented(err):
+		logger.Debugf("Dex API without get client support, falling back to update or create")
+		return a.updateOrCreateOnDex(ctx, app, secret, secretChanged)
+	case isDexClientNotFound(err):
+		resp = &GetClientResp{}
+	case err != nil:
+		return fmt.Errorf("could not get client on Dex: %w", wrapDexErr(err))
 	}
 
-	return generatedSecret, true, nil
+	current := resp.Client
+	switch {
+	case current == nil:
+		created, err := a.createOnDex(ctx, app, secret)
+		if err != nil {
+			return err
+		}
+		if !created {
+			return fmt.Errorf("could not create client on Dex: client already exists")
+		}
+		logger.Debugf("client created on Dex")
+
+	case current.Secret != secret:
+		err := a.recreateOnDex(ctx, app, secret)
+		if err != nil {
+			return err
+		}
+		logger.Debugf("client recreated on Dex")
+
+	case current.Name == app.Name && equalStrings(current.RedirectUris, app.CallBackURLs):
+		logger.Debugf("client already up to date on Dex")
+
+	default:
+		req := &dexapi.UpdateClientReq{
+			Id:           app.ID,
+			Name:         app.Name,
+			RedirectUris: app.CallBackURLs,
+		}
+		resp, err := a.cli.UpdateClient(ctx, req)
+		if err != nil {
+			return fmt.Errorf("could not update client on Dex: %w", wrapDexErr(err))
+		}
+		if resp.NotFound {
+			return fmt.Errorf("could not update client on Dex: client not found")
+		}
+		logger.Debugf("client updated on Dex")
+	}
+
+	return nil
 }
 
-// registerOnDex will register the application on Dex.
-// We have an optional flag called recreate, this is used to delete the client
-// prior registering. this is because:
+// updateOrCreateOnDex will register the application on Dex without knowing the state of the client:
 //
-// Dex doesn't update the client secret if the app already exists so if we create
-// always the client doesn't matter if we had created a new secret, this will be
-// ignored by dex and we will end with inconsistencies (with Dex having an old secret
-// for the client). So this flag will be used when we want recreate the client.
-func (a appRegisterer) registerOnDex(ctx context.Context, app authbackend.OIDCApp, secret string, recreate bool) error {
-	if recreate {
-		req := &dexapi.DeleteClientReq{Id: app.ID}
-		_, err := a.cli.DeleteClient(ctx, req)
+//   - If the secret didn't change, we update the client (name and redirect URIs), and only
+//     create it when missing.
+//   - If the secret changed, we create the client, and only if it was already present we
+//     recreate it.
+func (a appRegisterer) updateOrCreateOnDex(ctx context.Context, app authbackend.OIDCApp, secret string, secretChanged bool) error {
+	logger := a.logger.WithKV(log.KV{"app": app.Name})
+
+	if !secretChanged {
+		req := &dexapi.UpdateClientReq{
+			Id:           app.ID,
+			Name:         app.Name,
+			RedirectUris: app.CallBackURLs,
+		}
+		resp, err := a.cli.UpdateClient(ctx, req)
 		if err != nil {
-			return fmt.Errorf("could not delete client on Dex: %w", err)
+			return fmt.Errorf("could not update client on Dex: %w", wrapDexErr(err))
+		}
+		if !resp.NotFound {
+			logger.Debugf("client updated on Dex")
+			return nil
 		}
 	}
 
+	created, err := a.createOnDex(ctx, app, secret)
+	if err != nil {
+		return err
+	}
+	if created {
+		logger.Debugf("client created on Dex")
+		return nil
+	}
+
+	// The client was present with the old secret, recreate.
+	err = a.recreateOnDex(ctx, app, secret)
+	if err != nil {
+		return err
+	}
+	logger.Debugf("client recreated on Dex")
+
+	return nil
+}
+
+// recreateOnDex deletes and creates the client on Dex.
+func (a appRegisterer) recreateOnDex(ctx context.Context, app authbackend.OIDCApp, secret string) error {
+	_, err := a.cli.DeleteClient(ctx, &dexapi.DeleteClientReq{Id: app.ID})
+	if err != nil {
+		return fmt.Errorf("could not delete client on Dex: %w", wrapDexErr(err))
+	}
+	created, err := a.createOnDex(ctx, app, secret)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return fmt.Errorf("could not recreate client on Dex: client already exists")
+	}
+
+	return nil
+}
+
+// createOnDex creates the client on Dex, if the client already exists it will return false.
+func (a appRegisterer) createOnDex(ctx context.Context, app authbackend.OIDCApp, secret string) (created bool, err error) {
 	req := &dexapi.CreateClientReq{
 		Client: &dexapi.Client{
-			Id:     app.ID,
-			Name:   app.Name,
-			Secret: secret,
-			RedirectUris: []string{
-				app.CallBackURL,
-			},
+			Id:           app.ID,
+			Name:         app.Name,
+			Secret:       secret,
+			RedirectUris: app.CallBackURLs,
 		},
 	}
-	_, err := a.cli.CreateClient(ctx, req)
+	resp, err := a.cli.CreateClient(ctx, req)
 	if err != nil {
-		return fmt.Errorf("could not create client on Dex: %w", err)
+		return false, fmt.Errorf("could not create client on Dex: %w", wrapDexErr(err))
 	}
 
-	return nil
+	return !resp.AlreadyExists, nil
 }
 
 func (a appRegisterer) UnregisterApp(ctx context.Context, appID string) error {
 	req := &dexapi.DeleteClientReq{Id: appID}
 	_, err := a.cli.DeleteClient(ctx, req)
 	if err != nil {
-		return fmt.Errorf("could not unregister application on Dex: %w", err)
+		return fmt.Errorf("could not unregister application on Dex: %w", wrapDexErr(err))
 	}
 
-	name := getSecretName(appID)
-	err = a.kuberepo.DeleteSecret(ctx, a.runningNamespace, name)
+	name := getSecretName(a.authBackendID, appID)
+	err = a.deleteStoredSecret(ctx, name)
 	if err != nil {
 		return fmt.Errorf("could not delete '%s' client dex data: %w", appID, err)
 	}
+	a.metricsRec.DeleteClientSecretAge(ctx, a.authBackendID, appID)
+
+	// The app could have not been registered again since the secrets have the auth backend on the name.
+	if a.authBackendID != "" {
+		err = a.deleteStoredSecret(ctx, getSecretName("", appID))
+		if err != nil {
+			return fmt.Errorf("could not delete '%s' client dex legacy data: %w", appID, err)
+		}
+	}
 
 	return nil
 }
 
-func getSecretName(id string) string {
+const (
+	clientSecretKey           = "clientSecret"
+	secretCreatedAtAnnotation = "bilrost.slok.dev/secret-created-at"
+)
+
+// The client data secrets identification, used to find the clients of the apps (e.g: janitor).
+const (
+	// ClientDataComponent is the component label of the client data secrets.
+	ClientDataComponent = "dex-client-data"
+	// ClientIDAnnotation is the client data secret annotation with the app ID.
+	ClientIDAnnotation = "bilrost.slok.dev/dex-client-id"
+	// AuthBackendAnnotation is the client data secret annotation with the auth backend ID, only
+	// present if the registerer has an auth backend ID.
+	AuthBackendAnnotation = "bilrost.slok.dev/auth-backend"
+)
+
+// getSecretName returns the name of the Kubernetes secret that stores the app data, the auth backend
+// is optional.
+func getSecretName(authBackendID, appID string) string {
+	id := appID
+	if authBackendID != "" {
+		id = authBackendID + "/" + appID
+	}
+
 	checksum := md5.Sum([]byte(id))
 	return fmt.Sprintf("bilrost-dex-cli-%x", checksum)
 }
+
+// equalStrings returns true if both string slices have the same values in the same order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// wrapDexErr wraps the Dex API errors with the known auth backend errors.
+func wrapDexErr(err error) error {
+	st, ok := status.FromError(err)
+	if ok && st.Code() == codes.Unavailable && strings.Contains(st.Message(), "handshake") {
+		return fmt.Errorf("%w: %s", authbackend.ErrTLSHandshake, err)
+	}
+
+	return err
+}
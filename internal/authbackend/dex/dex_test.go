@@ -3,12 +3,20 @@ package dex_test
 import (
 	"context"
 	"fmt"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
 	dexapi "github.com/dexidp/dex/api/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 	corev1 "k8s.io/api/core/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -20,26 +28,44 @@ import (
 
 func getBaseApp() authbackend.OIDCApp {
 	return authbackend.OIDCApp{
-		ID:          "test-id",
-		Name:        "test",
-		CallBackURL: "https://whatever.dev/oauth2/callback",
+		ID:           "test-id",
+		Name:         "test",
+		CallBackURLs: []string{"https://whatever.dev/oauth2/callback"},
 	}
 }
 
+var testNow = time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
 func getBaseConfig() dex.AppRegistererConfig {
 	return dex.AppRegistererConfig{
 		RunningNamespace: "test-ns",
 		SecretGenerator:  func(_ authbackend.OIDCApp) (string, error) { return "53cr37", nil },
+		TimeNow:          func() time.Time { return testNow },
 	}
 }
 
+func getBackendConfig() dex.AppRegistererConfig {
+	cfg := getBaseConfig()
+	cfg.AuthBackendID = "test-backend"
+	return cfg
+}
+
+func getBackendSecret() *corev1.Secret {
+	s := getBaseSecret()
+	s.Name = "bilrost-dex-cli-541f1075a5e61f5da55d0f217c4f9b90"
+	s.Labels["app.kubernetes.io/instance"] = "bilrost-dex-cli-541f1075a5e61f5da55d0f217c4f9b90"
+	s.Annotations["bilrost.slok.dev/auth-backend"] = "test-backend"
+	return s
+}
+
 func getBaseSecret() *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b",
 			Namespace: "test-ns",
 			Annotations: map[string]string{
-				"bilrost.slok.dev/dex-client-id": "test-id",
+				"bilrost.slok.dev/dex-client-id":     "test-id",
+				"bilrost.slok.dev/secret-created-at": "2021-03-01T12:00:00Z",
 			},
 			Labels: map[string]string{
 				"app.kubernetes.io/managed-by": "bilrost",
@@ -66,6 +92,25 @@ func getBaseDexCreateRequest() *dexapi.CreateClientReq {
 	}
 }
 
+func getBaseDexUpdateRequest() *dexapi.UpdateClientReq {
+	return &dexapi.UpdateClientReq{
+		Id:           "test-id",
+		Name:         "test",
+		RedirectUris: []string{"https://whatever.dev/oauth2/callback"},
+	}
+}
+
+func getBaseDexGetResponse() *dex.GetClientResp {
+	return &dex.GetClientResp{
+		Client: &dexapi.Client{
+			Id:           "test-id",
+			Name:         "test",
+			Secret:       "53cr37",
+			RedirectUris: []string{"https://whatever.dev/oauth2/callback"},
+		},
+	}
+}
+
 func getBaseResultData() authbackend.OIDCAppRegistryData {
 	return authbackend.OIDCAppRegistryData{
 		ClientID:     "test-id",
@@ -75,11 +120,12 @@ func getBaseResultData() authbackend.OIDCAppRegistryData {
 
 func TestRegisterApp(t *testing.T) {
 	tests := map[string]struct {
-		config  func() dex.AppRegistererConfig
-		oidcApp func() authbackend.OIDCApp
-		mock    func(c *dexmock.Client, k *dexmock.KubernetesRepository)
-		expRes  func() authbackend.OIDCAppRegistryData
-		expErr  bool
+		config   func() dex.AppRegistererConfig
+		oidcApp  func() authbackend.OIDCApp
+		mock     func(c *dexmock.Client, k *dexmock.KubernetesRepository)
+		expRes   func() authbackend.OIDCAppRegistryData
+		expErr   bool
+		expErrIs error
 	}{
 
 		"An error getting the secret should be propagated.": {
@@ -98,11 +144,25 @@ func TestRegisterApp(t *testing.T) {
 				expSecret := getBaseSecret()
 				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(expSecret, nil)
 
-				c.On("CreateClient", mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("wanted error"))
+				c.On("GetClient", mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("wanted error"))
 			},
 			expErr: true,
 		},
 
+		"A TLS handshake error registering the oidc app should be propagated as a TLS handshake error.": {
+			config:  getBaseConfig,
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				expSecret := getBaseSecret()
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(expSecret, nil)
+
+				err := status.Error(codes.Unavailable, `connection error: desc = "transport: authentication handshake failed: x509: certificate signed by unknown authority"`)
+				c.On("GetClient", mock.Anything, mock.Anything).Once().Return(nil, err)
+			},
+			expErr:   true,
+			expErrIs: authbackend.ErrTLSHandshake,
+		},
+
 		"An error setting a new secret should be propagated.": {
 			config:  getBaseConfig,
 			oidcApp: getBaseApp,
@@ -129,10 +189,10 @@ func TestRegisterApp(t *testing.T) {
 				expSecret := getBaseSecret()
 				k.On("EnsureSecret", mock.Anything, expSecret).Once().Return(nil)
 
-				expDelReq := &dexapi.DeleteClientReq{Id: "test-id"}
-				c.On("DeleteClient", mock.Anything, expDelReq).Once().Return(nil, nil)
+				// New client, should be created without deleting.
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(nil, status.Error(codes.Unknown, "not found"))
 				expCreReq := getBaseDexCreateRequest()
-				c.On("CreateClient", mock.Anything, expCreReq).Once().Return(nil, nil)
+				c.On("CreateClient", mock.Anything, expCreReq).Once().Return(&dexapi.CreateClientResp{}, nil)
 			},
 			expRes: getBaseResultData,
 		},
@@ -146,9 +206,12 @@ func TestRegisterApp(t *testing.T) {
 				expSecret.Data["clientSecret"] = []byte("old-secret")
 				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(expSecret, nil)
 
-				expReq := getBaseDexCreateRequest()
-				expReq.Client.Secret = "old-secret"
-				c.On("CreateClient", mock.Anything, expReq).Once().Return(nil, nil)
+				// The client secret didn't change, the client should be updated in place.
+				current := getBaseDexGetResponse()
+				current.Client.Secret = "old-secret"
+				current.Client.RedirectUris = []string{"https://old.dev/oauth2/callback"}
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(current, nil)
+				c.On("UpdateClient", mock.Anything, getBaseDexUpdateRequest()).Once().Return(&dexapi.UpdateClientResp{}, nil)
 			},
 			expRes: func() authbackend.OIDCAppRegistryData {
 				r := getBaseResultData()
@@ -170,10 +233,231 @@ func TestRegisterApp(t *testing.T) {
 				expSecret := getBaseSecret()
 				k.On("EnsureSecret", mock.Anything, expSecret).Once().Return(nil)
 
+				// The client is present with the old secret, should be recreated.
+				current := getBaseDexGetResponse()
+				current.Client.Secret = "old-secret"
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(current, nil)
+				expCreReq := getBaseDexCreateRequest()
 				expDelReq := &dexapi.DeleteClientReq{Id: "test-id"}
-				c.On("DeleteClient", mock.Anything, expDelReq).Once().Return(nil, nil)
+				c.On("DeleteClient", mock.Anything, expDelReq).Once().Return(&dexapi.DeleteClientResp{}, nil)
+				c.On("CreateClient", mock.Anything, expCreReq).Once().Return(&dexapi.CreateClientResp{}, nil)
+			},
+			expRes: getBaseResultData,
+		},
+
+		"Registering a present app with a new secret that can't be recreated should fail.": {
+			config:  getBaseConfig,
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				storedSecret := getBaseSecret()
+				storedSecret.Data["clientSecret"] = []byte("") // Force creation.
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(storedSecret, nil)
+				k.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+
+				current := getBaseDexGetResponse()
+				current.Client.Secret = "old-secret"
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(current, nil)
+				c.On("CreateClient", mock.Anything, mock.Anything).Once().Return(&dexapi.CreateClientResp{AlreadyExists: true}, nil)
+				c.On("DeleteClient", mock.Anything, mock.Anything).Once().Return(&dexapi.DeleteClientResp{}, nil)
+			},
+			expErr: true,
+		},
+
+		"Registering an app with a stored secret missing on Dex should create the client.": {
+			config:  getBaseConfig,
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(getBaseSecret(), nil)
+
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(nil, status.Error(codes.Unknown, "not found"))
+				c.On("CreateClient", mock.Anything, getBaseDexCreateRequest()).Once().Return(&dexapi.CreateClientResp{}, nil)
+			},
+			expRes: getBaseResultData,
+		},
+
+		"Registering a present app with an expired secret should rotate the secret and recreate the client on Dex.": {
+			config: func() dex.AppRegistererConfig {
+				c := getBaseConfig()
+				c.SecretMaxAge = 24 * time.Hour
+				return c
+			},
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				storedSecret := getBaseSecret()
+				storedSecret.Annotations["bilrost.slok.dev/secret-created-at"] = "2021-02-28T11:00:00Z"
+				storedSecret.Data["clientSecret"] = []byte("old-secret")
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(storedSecret, nil)
+
+				// Rotate the secret.
+				k.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+
+				current := getBaseDexGetResponse()
+				current.Client.Secret = "old-secret"
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(current, nil)
+				expCreReq := getBaseDexCreateRequest()
+				c.On("DeleteClient", mock.Anything, &dexapi.DeleteClientReq{Id: "test-id"}).Once().Return(&dexapi.DeleteClientResp{}, nil)
+				c.On("CreateClient", mock.Anything, expCreReq).Once().Return(&dexapi.CreateClientResp{}, nil)
+			},
+			expRes: getBaseResultData,
+		},
+
+		"Registering a present app with a not expired secret should not rotate the secret.": {
+			config: func() dex.AppRegistererConfig {
+				c := getBaseConfig()
+				c.SecretMaxAge = 24 * time.Hour
+				return c
+			},
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				storedSecret := getBaseSecret()
+				storedSecret.Annotations["bilrost.slok.dev/secret-created-at"] = "2021-02-28T13:00:00Z"
+				storedSecret.Data["clientSecret"] = []byte("old-secret")
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(storedSecret, nil)
+
+				// The client is up to date, nothing to change on Dex.
+				current := getBaseDexGetResponse()
+				current.Client.Secret = "old-secret"
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(current, nil)
+			},
+			expRes: func() authbackend.OIDCAppRegistryData {
+				r := getBaseResultData()
+				r.ClientSecret = "old-secret"
+				return r
+			},
+		},
+
+		"Registering a present app with a secret expired by the app max age should rotate the secret.": {
+			config: getBaseConfig,
+			oidcApp: func() authbackend.OIDCApp {
+				a := getBaseApp()
+				a.SecretMaxAge = time.Hour
+				return a
+			},
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				storedSecret := getBaseSecret()
+				storedSecret.Annotations["bilrost.slok.dev/secret-created-at"] = "2021-03-01T10:00:00Z"
+				storedSecret.Data["clientSecret"] = []byte("old-secret")
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(storedSecret, nil)
+
+				// Rotate the secret.
+				k.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(nil, status.Error(codes.Unknown, "not found"))
+				c.On("CreateClient", mock.Anything, getBaseDexCreateRequest()).Once().Return(&dexapi.CreateClientResp{}, nil)
+			},
+			expRes: getBaseResultData,
+		},
+
+		"Registering a present app with a secret without creation time should start tracking it without rotating.": {
+			config: func() dex.AppRegistererConfig {
+				c := getBaseConfig()
+				c.SecretMaxAge = 24 * time.Hour
+				return c
+			},
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				storedSecret := getBaseSecret()
+				delete(storedSecret.Annotations, "bilrost.slok.dev/secret-created-at")
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(storedSecret, nil)
+
+				// Set the creation time.
+				k.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(getBaseDexGetResponse(), nil)
+			},
+			expRes: getBaseResultData,
+		},
+
+		"Registering an app with an auth backend should use the auth backend secret.": {
+			config:  getBackendConfig,
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				expSecret := getBackendSecret()
+				expSecret.Data["clientSecret"] = []byte("old-secret")
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-541f1075a5e61f5da55d0f217c4f9b90").Once().Return(expSecret, nil)
+
+				// The client is up to date, nothing to change on Dex.
+				current := getBaseDexGetResponse()
+				current.Client.Secret = "old-secret"
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(current, nil)
+			},
+			expRes: func() authbackend.OIDCAppRegistryData {
+				r := getBaseResultData()
+				r.ClientSecret = "old-secret"
+				return r
+			},
+		},
+
+		"Registering an app with an auth backend without secret should adopt the legacy secret.": {
+			config:  getBackendConfig,
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-541f1075a5e61f5da55d0f217c4f9b90").Once().Return(nil, notFoundErr)
+				legacySecret := getBaseSecret()
+				legacySecret.Data["clientSecret"] = []byte("old-secret")
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(legacySecret, nil)
+
+				// Adopt the legacy secret.
+				expSecret := getBackendSecret()
+				expSecret.Data["clientSecret"] = []byte("old-secret")
+				k.On("EnsureSecret", mock.Anything, expSecret).Once().Return(nil)
+				k.On("DeleteSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(nil)
+
+				// The client secret didn't change, we don't need to recreate.
+				current := getBaseDexGetResponse()
+				current.Client.Secret = "old-secret"
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(current, nil)
+			},
+			expRes: func() authbackend.OIDCAppRegistryData {
+				r := getBaseResultData()
+				r.ClientSecret = "old-secret"
+				return r
+			},
+		},
+
+		"Registering a new app with an auth backend should create a secret and register on Dex.": {
+			config:  getBackendConfig,
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-541f1075a5e61f5da55d0f217c4f9b90").Once().Return(nil, notFoundErr)
+				k.On("GetSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(nil, notFoundErr)
+
+				k.On("EnsureSecret", mock.Anything, getBackendSecret()).Once().Return(nil)
+
+				c.On("GetClient", mock.Anything, &dex.GetClientReq{ID: "test-id"}).Once().Return(nil, status.Error(codes.Unknown, "not found"))
+				c.On("CreateClient", mock.Anything, getBaseDexCreateRequest()).Once().Return(&dexapi.CreateClientResp{}, nil)
+			},
+			expRes: getBaseResultData,
+		},
+
+		"Registering a present app on a Dex without the get client API should update the client in place.": {
+			config:  getBaseConfig,
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(getBaseSecret(), nil)
+
+				c.On("GetClient", mock.Anything, mock.Anything).Once().Return(nil, status.Error(codes.Unimplemented, "unknown method GetClient"))
+				c.On("UpdateClient", mock.Anything, getBaseDexUpdateRequest()).Once().Return(&dexapi.UpdateClientResp{}, nil)
+			},
+			expRes: getBaseResultData,
+		},
+
+		"Registering a present app with a new secret on a Dex without the get client API should recreate the client.": {
+			config:  getBaseConfig,
+			oidcApp: getBaseApp,
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				storedSecret := getBaseSecret()
+				storedSecret.Data["clientSecret"] = []byte("") // Force creation.
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(storedSecret, nil)
+				k.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+
+				c.On("GetClient", mock.Anything, mock.Anything).Once().Return(nil, status.Error(codes.Unimplemented, "unknown method GetClient"))
 				expCreReq := getBaseDexCreateRequest()
-				c.On("CreateClient", mock.Anything, expCreReq).Once().Return(nil, nil)
+				c.On("CreateClient", mock.Anything, expCreReq).Once().Return(&dexapi.CreateClientResp{AlreadyExists: true}, nil)
+				c.On("DeleteClient", mock.Anything, &dexapi.DeleteClientReq{Id: "test-id"}).Once().Return(&dexapi.DeleteClientResp{}, nil)
+				c.On("CreateClient", mock.Anything, expCreReq).Once().Return(&dexapi.CreateClientResp{}, nil)
 			},
 			expRes: getBaseResultData,
 		},
@@ -197,6 +481,9 @@ func TestRegisterApp(t *testing.T) {
 
 			if test.expErr {
 				assert.Error(err)
+				if test.expErrIs != nil {
+					assert.ErrorIs(err, test.expErrIs)
+				}
 			} else if assert.NoError(err) {
 				assert.Equal(test.expRes(), *res)
 				mdex.AssertExpectations(t)
@@ -205,6 +492,239 @@ func TestRegisterApp(t *testing.T) {
 	}
 }
 
+// fakeDex is an in memory Dex API server that has the same client semantics as Dex.
+type fakeDex struct {
+	dexapi.UnimplementedDexServer
+
+	// noGetClient disables the get client API, like Dex versions before v2.37.
+	noGetClient bool
+
+	mu      sync.Mutex
+	clients map[string]*dexapi.Client
+	updates int
+	deletes int
+}
+
+// handleUnknown handles the Dex API methods that are not on the Dex API module we depend on.
+func (f *fakeDex) handleUnknown(_ interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	if method != "/api.Dex/GetClient" || f.noGetClient {
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+
+	req := &dex.GetClientReq{}
+	err := stream.RecvMsg(req)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.clients[req.ID]
+	if !ok {
+		// Dex returns the storage error as is.
+		return fmt.Errorf("not found")
+	}
+
+	return stream.SendMsg(&dex.GetClientResp{Client: c})
+}
+
+func (f *fakeDex) CreateClient(_ context.Context, req *dexapi.CreateClientReq) (*dexapi.CreateClientResp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.clients[req.Client.Id]; ok {
+		return &dexapi.CreateClientResp{AlreadyExists: true}, nil
+	}
+	f.clients[req.Client.Id] = req.Client
+
+	return &dexapi.CreateClientResp{Client: req.Client}, nil
+}
+
+func (f *fakeDex) UpdateClient(_ context.Context, req *dexapi.UpdateClientReq) (*dexapi.UpdateClientResp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.clients[req.Id]
+	if !ok {
+		return &dexapi.UpdateClientResp{NotFound: true}, nil
+	}
+	if req.Name != "" {
+		c.Name = req.Name
+	}
+	if len(req.RedirectUris) > 0 {
+		c.RedirectUris = req.RedirectUris
+	}
+	f.updates++
+
+	return &dexapi.UpdateClientResp{}, nil
+}
+
+func (f *fakeDex) DeleteClient(_ context.Context, req *dexapi.DeleteClientReq) (*dexapi.DeleteClientResp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.clients[req.Id]; !ok {
+		return &dexapi.DeleteClientResp{NotFound: true}, nil
+	}
+	delete(f.clients, req.Id)
+	f.deletes++
+
+	return &dexapi.DeleteClientResp{}, nil
+}
+
+// newFakeDexClient runs the fake Dex gRPC server and returns a client connected to it.
+func newFakeDexClient(t *testing.T, fd *fakeDex) dex.Client {
+	l := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(fd.handleUnknown))
+	dexapi.RegisterDexServer(srv, fd)
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return dex.NewClient(conn)
+}
+
+func TestRegisterAppDexServer(t *testing.T) {
+	notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+
+	tests := map[string]struct {
+		noGetClient bool
+		dexClients  map[string]*dexapi.Client
+		mock        func(k *dexmock.KubernetesRepository)
+		expClient   *dexapi.Client
+		expUpdates  int
+		expDeletes  int
+	}{
+		"A new app should be created on Dex.": {
+			dexClients: map[string]*dexapi.Client{},
+			mock: func(k *dexmock.KubernetesRepository) {
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, notFoundErr)
+				k.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expClient: &dexapi.Client{
+				Id:           "test-id",
+				Name:         "test",
+				Secret:       "53cr37",
+				RedirectUris: []string{"https://whatever.dev/oauth2/callback"},
+			},
+		},
+
+		"A present app with the same secret should have its name and redirect URIs updated in place.": {
+			dexClients: map[string]*dexapi.Client{
+				"test-id": {Id: "test-id", Name: "old", Secret: "53cr37", RedirectUris: []string{"https://old.dev/oauth2/callback"}},
+			},
+			mock: func(k *dexmock.KubernetesRepository) {
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(getBaseSecret(), nil)
+			},
+			expClient: &dexapi.Client{
+				Id:           "test-id",
+				Name:         "test",
+				Secret:       "53cr37",
+				RedirectUris: []string{"https://whatever.dev/oauth2/callback"},
+			},
+			expUpdates: 1,
+		},
+
+		"A present app up to date should not be changed on Dex.": {
+			dexClients: map[string]*dexapi.Client{
+				"test-id": {Id: "test-id", Name: "test", Secret: "53cr37", RedirectUris: []string{"https://whatever.dev/oauth2/callback"}},
+			},
+			mock: func(k *dexmock.KubernetesRepository) {
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(getBaseSecret(), nil)
+			},
+			expClient: &dexapi.Client{
+				Id:           "test-id",
+				Name:         "test",
+				Secret:       "53cr37",
+				RedirectUris: []string{"https://whatever.dev/oauth2/callback"},
+			},
+		},
+
+		"A present app on a Dex without the get client API should be updated in place.": {
+			noGetClient: true,
+			dexClients: map[string]*dexapi.Client{
+				"test-id": {Id: "test-id", Name: "old", Secret: "53cr37", RedirectUris: []string{"https://old.dev/oauth2/callback"}},
+			},
+			mock: func(k *dexmock.KubernetesRepository) {
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(getBaseSecret(), nil)
+			},
+			expClient: &dexapi.Client{
+				Id:           "test-id",
+				Name:         "test",
+				Secret:       "53cr37",
+				RedirectUris: []string{"https://whatever.dev/oauth2/callback"},
+			},
+			expUpdates: 1,
+		},
+
+		"A present app with a stored secret missing on Dex should be created on Dex.": {
+			dexClients: map[string]*dexapi.Client{},
+			mock: func(k *dexmock.KubernetesRepository) {
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(getBaseSecret(), nil)
+			},
+			expClient: &dexapi.Client{
+				Id:           "test-id",
+				Name:         "test",
+				Secret:       "53cr37",
+				RedirectUris: []string{"https://whatever.dev/oauth2/callback"},
+			},
+		},
+
+		"A present app with a new secret should be recreated on Dex.": {
+			dexClients: map[string]*dexapi.Client{
+				"test-id": {Id: "test-id", Name: "test", Secret: "old-secret", RedirectUris: []string{"https://whatever.dev/oauth2/callback"}},
+			},
+			mock: func(k *dexmock.KubernetesRepository) {
+				k.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, notFoundErr)
+				k.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expClient: &dexapi.Client{
+				Id:           "test-id",
+				Name:         "test",
+				Secret:       "53cr37",
+				RedirectUris: []string{"https://whatever.dev/oauth2/callback"},
+			},
+			expDeletes: 1,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			fd := &fakeDex{clients: test.dexClients, noGetClient: test.noGetClient}
+			mkr := &dexmock.KubernetesRepository{}
+			test.mock(mkr)
+
+			cfg := getBaseConfig()
+			cfg.Client = newFakeDexClient(t, fd)
+			cfg.KubernetesRepository = mkr
+			ar, err := dex.NewAppRegisterer(cfg)
+			require.NoError(err)
+
+			_, err = ar.RegisterApp(context.TODO(), getBaseApp())
+			require.NoError(err)
+
+			gotClient := fd.clients["test-id"]
+			require.NotNil(gotClient)
+			assert.Equal(test.expClient.Id, gotClient.Id)
+			assert.Equal(test.expClient.Name, gotClient.Name)
+			assert.Equal(test.expClient.Secret, gotClient.Secret)
+			assert.Equal(test.expClient.RedirectUris, gotClient.RedirectUris)
+			assert.Equal(test.expUpdates, fd.updates)
+			assert.Equal(test.expDeletes, fd.deletes)
+			mkr.AssertExpectations(t)
+		})
+	}
+}
+
 func TestUnregisterApp(t *testing.T) {
 	tests := map[string]struct {
 		config func() dex.AppRegistererConfig
@@ -241,6 +761,18 @@ func TestUnregisterApp(t *testing.T) {
 				k.On("DeleteSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(nil)
 			},
 		},
+
+		"Unregistering an app with an auth backend should delete the auth backend app data and the legacy data.": {
+			config: getBackendConfig,
+			id:     "test-id",
+			mock: func(c *dexmock.Client, k *dexmock.KubernetesRepository) {
+				expReq := &dexapi.DeleteClientReq{Id: "test-id"}
+				c.On("DeleteClient", mock.Anything, expReq).Once().Return(nil, nil)
+				notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+				k.On("DeleteSecret", mock.Anything, "test-ns", "bilrost-dex-cli-541f1075a5e61f5da55d0f217c4f9b90").Once().Return(nil)
+				k.On("DeleteSecret", mock.Anything, "test-ns", "bilrost-dex-cli-361dc45aacd2d2a1961554d12a2d666b").Once().Return(notFoundErr)
+			},
+		},
 	}
 
 	for name, test := range tests {
@@ -264,6 +796,7 @@ func TestUnregisterApp(t *testing.T) {
 			} else if assert.NoError(err) {
 				assert.NoError(err)
 				mdex.AssertExpectations(t)
+				mkr.AssertExpectations(t)
 			}
 		})
 	}
@@ -7,6 +7,8 @@ import (
 
 	api "github.com/dexidp/dex/api/v2"
 
+	dex "github.com/slok/bilrost/internal/authbackend/dex"
+
 	grpc "google.golang.org/grpc"
 
 	mock "github.com/stretchr/testify/mock"
@@ -76,3 +78,63 @@ func (_m *Client) DeleteClient(ctx context.Context, in *api.DeleteClientReq, opt
 
 	return r0, r1
 }
+
+// GetClient provides a mock function with given fields: ctx, in, opts
+func (_m *Client) GetClient(ctx context.Context, in *dex.GetClientReq, opts ...grpc.CallOption) (*dex.GetClientResp, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dex.GetClientResp
+	if rf, ok := ret.Get(0).(func(context.Context, *dex.GetClientReq, ...grpc.CallOption) *dex.GetClientResp); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dex.GetClientResp)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *dex.GetClientReq, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateClient provides a mock function with given fields: ctx, in, opts
+func (_m *Client) UpdateClient(ctx context.Context, in *api.UpdateClientReq, opts ...grpc.CallOption) (*api.UpdateClientResp, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *api.UpdateClientResp
+	if rf, ok := ret.Get(0).(func(context.Context, *api.UpdateClientReq, ...grpc.CallOption) *api.UpdateClientResp); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*api.UpdateClientResp)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *api.UpdateClientReq, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
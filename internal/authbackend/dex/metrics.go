@@ -35,3 +35,19 @@ func (m measuredClient) DeleteClient(ctx context.Context, in *dexapi.DeleteClien
 
 	return m.next.DeleteClient(ctx, in, opts...)
 }
+
+func (m measuredClient) GetClient(ctx context.Context, in *GetClientReq, opts ...grpc.CallOption) (r *GetClientResp, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveDexAuthBackendDexClientOp(ctx, "GetClient", err == nil, t0)
+	}(time.Now())
+
+	return m.next.GetClient(ctx, in, opts...)
+}
+
+func (m measuredClient) UpdateClient(ctx context.Context, in *dexapi.UpdateClientReq, opts ...grpc.CallOption) (r *dexapi.UpdateClientResp, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveDexAuthBackendDexClientOp(ctx, "UpdateClient", err == nil, t0)
+	}(time.Now())
+
+	return m.next.UpdateClient(ctx, in, opts...)
+}
@@ -1,37 +1,66 @@
 package factory
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"net/http"
+	"reflect"
 	"sync"
+	"time"
 
-	dexapi "github.com/dexidp/dex/api/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/authbackend/auth0"
 	"github.com/slok/bilrost/internal/authbackend/dex"
+	"github.com/slok/bilrost/internal/authbackend/keycloak"
+	"github.com/slok/bilrost/internal/authbackend/oidcregistration"
+	"github.com/slok/bilrost/internal/authbackend/staticoidc"
 	"github.com/slok/bilrost/internal/log"
 	"github.com/slok/bilrost/internal/metrics"
 	"github.com/slok/bilrost/internal/model"
 )
 
+// KubernetesRepository is the repository used by all the app registerers created by the factory.
+type KubernetesRepository interface {
+	dex.KubernetesRepository
+	oidcregistration.KubernetesRepository
+	auth0.KubernetesRepository
+	staticoidc.KubernetesRepository
+}
+
+// poolEntry is a cached app registerer with the auth backend configuration used to
+// create it, and the resources that need to be released when invalidated.
+type poolEntry struct {
+	ab     model.AuthBackend
+	ar     authbackend.AppRegisterer
+	closer io.Closer
+}
+
 type factory struct {
 	runningNamespace   string
 	metricsRecorder    metrics.Recorder
-	dexKubeRepo        dex.KubernetesRepository
-	appRegisterersPool map[string]authbackend.AppRegisterer
+	kubeRepo           KubernetesRepository
+	appRegisterersPool map[string]poolEntry
 	mu                 sync.Mutex
 	logger             log.Logger
 }
 
 // NewFactory returns a new authbackend factory.
-func NewFactory(runningNamespace string, metricsRecorder metrics.Recorder, dexKubeRepo dex.KubernetesRepository, logger log.Logger) authbackend.AppRegistererFactory {
+//
+// The app registerers are cached by auth backend, if the auth backend configuration changes,
+// the cached registerer will be replaced with a new one.
+func NewFactory(runningNamespace string, metricsRecorder metrics.Recorder, kubeRepo KubernetesRepository, logger log.Logger) authbackend.AppRegistererFactory {
 	return &factory{
 		runningNamespace:   runningNamespace,
 		metricsRecorder:    metricsRecorder,
-		dexKubeRepo:        dexKubeRepo,
-		appRegisterersPool: map[string]authbackend.AppRegisterer{},
-		logger:             logger,
+		kubeRepo:           kubeRepo,
+		appRegisterersPool: map[string]poolEntry{},
+		logger:             logger.WithKV(log.KV{"service": "authbackend.Factory"}),
 	}
 }
 
@@ -39,45 +68,230 @@ func (f *factory) GetAppRegisterer(ab model.AuthBackend) (authbackend.AppRegiste
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	// If cached and the configuration is the same, reuse it.
+	entry, ok := f.appRegisterersPool[ab.ID]
+	if ok && reflect.DeepEqual(entry.ab, ab) {
+		return entry.ar, nil
+	}
+
+	// Stale registerer, the auth backend configuration changed.
+	if ok {
+		err := f.invalidate(ab.ID)
+		if err != nil {
+			f.logger.Warningf("could not invalidate stale app registerer: %s", err)
+		}
+	}
+
+	var err error
 	switch {
 	// Dex client.
 	case ab.Dex != nil:
-		poolKey := fmt.Sprintf("dex-%s", ab.Dex.APIURL)
-		ar, ok := f.appRegisterersPool[poolKey]
-		if ok {
-			return ar, nil
+		entry, err = f.newDexAppRegisterer(ab)
+		if err != nil {
+			return nil, err
 		}
-
-		// New registerer, store in cache.
-		ar, err := f.newDexAppRegisterer(ab)
+	// OIDC dynamic client registration.
+	case ab.OIDCDynamicRegistration != nil:
+		entry, err = f.newOIDCRegistrationAppRegisterer(ab)
+		if err != nil {
+			return nil, err
+		}
+	// Auth0.
+	case ab.Auth0 != nil:
+		entry, err = f.newAuth0AppRegisterer(ab)
+		if err != nil {
+			return nil, err
+		}
+	// Keycloak.
+	case ab.Keycloak != nil:
+		entry, err = f.newKeycloakAppRegisterer(ab)
+		if err != nil {
+			return nil, err
+		}
+	// Static OIDC.
+	case ab.StaticOIDC != nil:
+		entry, err = f.newStaticOIDCAppRegisterer(ab)
 		if err != nil {
 			return nil, err
 		}
-		f.appRegisterersPool[poolKey] = ar
+	default:
+		return nil, fmt.Errorf("unknown auth backend type")
+	}
+
+	// New registerer, store in cache.
+	f.appRegisterersPool[ab.ID] = entry
+
+	return entry.ar, nil
+}
+
+func (f *factory) InvalidateAppRegisterer(authBackendID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.invalidate(authBackendID)
+}
+
+// invalidate requires the lock to be acquired.
+func (f *factory) invalidate(authBackendID string) error {
+	entry, ok := f.appRegisterersPool[authBackendID]
+	if !ok {
+		return nil
+	}
+	delete(f.appRegisterersPool, authBackendID)
+	f.logger.WithKV(log.KV{"auth-backend": authBackendID}).Debugf("app registerer invalidated")
+
+	if entry.closer == nil {
+		return nil
+	}
 
-		return ar, nil
+	err := entry.closer.Close()
+	if err != nil {
+		return fmt.Errorf("could not release app registerer resources: %w", err)
 	}
 
-	return nil, fmt.Errorf("unknown auth backend type")
+	return nil
 }
 
-func (f *factory) newDexAppRegisterer(ab model.AuthBackend) (authbackend.AppRegisterer, error) {
-	conn, err := grpc.Dial(ab.Dex.APIURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func (f *factory) newDexAppRegisterer(ab model.AuthBackend) (poolEntry, error) {
+	creds, err := newDexTransportCredentials(ab.Dex.TLS)
 	if err != nil {
-		return nil, fmt.Errorf("could not create GRPC Dex API client: %w", err)
+		return poolEntry{}, fmt.Errorf("could not create Dex API TLS credentials: %w", err)
+	}
+
+	conn, err := grpc.Dial(ab.Dex.APIURL, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return poolEntry{}, fmt.Errorf("could not create GRPC Dex API client: %w", err)
 	}
 
 	cfg := dex.AppRegistererConfig{
+		AuthBackendID:        ab.ID,
 		RunningNamespace:     f.runningNamespace,
-		KubernetesRepository: f.dexKubeRepo,
-		Client:               dex.NewMeasuredClient(f.metricsRecorder, dexapi.NewDexClient(conn)),
+		KubernetesRepository: f.kubeRepo,
+		Client:               dex.NewMeasuredClient(f.metricsRecorder, dex.NewClient(conn)),
+		SecretMaxAge:         ab.SecretMaxAge,
+		MetricsRecorder:      f.metricsRecorder,
 		Logger:               f.logger,
 	}
 	ar, err := dex.NewAppRegisterer(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("could not create Dex app registerer: %w", err)
+		_ = conn.Close()
+		return poolEntry{}, fmt.Errorf("could not create Dex app registerer: %w", err)
 	}
 	ar = authbackend.NewMeasuredAppRegisterer("dex", f.metricsRecorder, ar)
 
-	return ar, nil
+	return poolEntry{ab: ab, ar: ar, closer: conn}, nil
+}
+
+// newDexTransportCredentials returns the Dex API connection credentials, without TLS configuration
+// the connection will be insecure.
+func newDexTransportCredentials(cfg *model.AuthBackendDexTLS) (credentials.TransportCredentials, error) {
+	if cfg == nil {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if len(cfg.CA) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(cfg.CA) {
+			return nil, fmt.Errorf("invalid CA bundle, no PEM certificates found")
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if len(cfg.ClientCert) > 0 || len(cfg.ClientKey) > 0 {
+		cert, err := tls.X509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+func (f *factory) newOIDCRegistrationAppRegisterer(ab model.AuthBackend) (poolEntry, error) {
+	cfg := oidcregistration.AppRegistererConfig{
+		AuthBackendID:        ab.ID,
+		RunningNamespace:     f.runningNamespace,
+		IssuerURL:            ab.OIDCDynamicRegistration.IssuerURL,
+		RegistrationEndpoint: ab.OIDCDynamicRegistration.RegistrationEndpoint,
+		InitialAccessToken:   ab.OIDCDynamicRegistration.InitialAccessToken,
+		HTTPClient:           &http.Client{Timeout: 10 * time.Second},
+		KubernetesRepository: f.kubeRepo,
+		Logger:               f.logger,
+	}
+	ar, err := oidcregistration.NewAppRegisterer(cfg)
+	if err != nil {
+		return poolEntry{}, fmt.Errorf("could not create OIDC dynamic registration app registerer: %w", err)
+	}
+	ar = authbackend.NewMeasuredAppRegisterer("oidc-dynamic-registration", f.metricsRecorder, ar)
+
+	return poolEntry{ab: ab, ar: ar}, nil
+}
+
+func (f *factory) newAuth0AppRegisterer(ab model.AuthBackend) (poolEntry, error) {
+	cfg := auth0.AppRegistererConfig{
+		AuthBackendID:        ab.ID,
+		RunningNamespace:     f.runningNamespace,
+		Domain:               ab.Auth0.Domain,
+		ClientID:             ab.Auth0.ClientID,
+		ClientSecret:         ab.Auth0.ClientSecret,
+		HTTPClient:           &http.Client{Timeout: 10 * time.Second},
+		KubernetesRepository: f.kubeRepo,
+		SecretMaxAge:         ab.SecretMaxAge,
+		MetricsRecorder:      f.metricsRecorder,
+		Logger:               f.logger,
+	}
+	ar, err := auth0.NewAppRegisterer(cfg)
+	if err != nil {
+		return poolEntry{}, fmt.Errorf("could not create Auth0 app registerer: %w", err)
+	}
+	ar = authbackend.NewMeasuredAppRegisterer("auth0", f.metricsRecorder, ar)
+
+	return poolEntry{ab: ab, ar: ar}, nil
+}
+
+func (f *factory) newKeycloakAppRegisterer(ab model.AuthBackend) (poolEntry, error) {
+	cfg := keycloak.AppRegistererConfig{
+		AuthBackendID:   ab.ID,
+		URL:             ab.Keycloak.URL,
+		Realm:           ab.Keycloak.Realm,
+		AdminRealm:      ab.Keycloak.AdminRealm,
+		Username:        ab.Keycloak.Username,
+		Password:        ab.Keycloak.Password,
+		ClientID:        ab.Keycloak.ClientID,
+		ClientSecret:    ab.Keycloak.ClientSecret,
+		GroupsMapper:    ab.Keycloak.GroupsMapper,
+		SecretMaxAge:    ab.SecretMaxAge,
+		HTTPClient:      &http.Client{Timeout: 10 * time.Second},
+		MetricsRecorder: f.metricsRecorder,
+		Logger:          f.logger,
+	}
+	ar, err := keycloak.NewAppRegisterer(cfg)
+	if err != nil {
+		return poolEntry{}, fmt.Errorf("could not create Keycloak app registerer: %w", err)
+	}
+	ar = authbackend.NewMeasuredAppRegisterer("keycloak", f.metricsRecorder, ar)
+
+	return poolEntry{ab: ab, ar: ar}, nil
+}
+
+func (f *factory) newStaticOIDCAppRegisterer(ab model.AuthBackend) (poolEntry, error) {
+	cfg := staticoidc.AppRegistererConfig{
+		ClientsSecretName:      ab.StaticOIDC.ClientsSecretName,
+		ClientsSecretNamespace: ab.StaticOIDC.ClientsSecretNamespace,
+		KubernetesRepository:   f.kubeRepo,
+		Logger:                 f.logger,
+	}
+	ar, err := staticoidc.NewAppRegisterer(cfg)
+	if err != nil {
+		return poolEntry{}, fmt.Errorf("could not create static OIDC app registerer: %w", err)
+	}
+	ar = authbackend.NewMeasuredAppRegisterer("static-oidc", f.metricsRecorder, ar)
+
+	return poolEntry{ab: ab, ar: ar}, nil
 }
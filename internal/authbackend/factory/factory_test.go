@@ -0,0 +1,216 @@
+package factory_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	dexapi "github.com/dexidp/dex/api/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/authbackend/dex/dexmock"
+	"github.com/slok/bilrost/internal/authbackend/factory"
+	"github.com/slok/bilrost/internal/log"
+	bilrostprometheus "github.com/slok/bilrost/internal/metrics/prometheus"
+	"github.com/slok/bilrost/internal/model"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate, if parent is nil the certificate will be a self signed CA.
+func newTestCert(t *testing.T, cn string, parent *testCert) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signerCert, signerKey := tpl, key
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signerCert, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+type fakeDex struct {
+	dexapi.UnimplementedDexServer
+}
+
+func (*fakeDex) CreateClient(context.Context, *dexapi.CreateClientReq) (*dexapi.CreateClientResp, error) {
+	return &dexapi.CreateClientResp{}, nil
+}
+
+func (*fakeDex) UpdateClient(context.Context, *dexapi.UpdateClientReq) (*dexapi.UpdateClientResp, error) {
+	return &dexapi.UpdateClientResp{}, nil
+}
+
+// runFakeDex runs a Dex API server with TLS and returns its address.
+func runFakeDex(t *testing.T, serverCert testCert, clientCA *testCert) string {
+	cert, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	require.NoError(t, err)
+
+	tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCA != nil {
+		pool := x509.NewCertPool()
+		pool.AddCert(clientCA.cert)
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsCfg)))
+	dexapi.RegisterDexServer(srv, &fakeDex{})
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	return l.Addr().String()
+}
+
+func TestFactoryDexTLS(t *testing.T) {
+	ca := newTestCert(t, "test-ca", nil)
+	otherCA := newTestCert(t, "other-ca", nil)
+	serverCert := newTestCert(t, "dex.test", &ca)
+	clientCert := newTestCert(t, "bilrost", &ca)
+
+	tests := map[string]struct {
+		tls            *model.AuthBackendDexTLS
+		mtls           bool
+		expFactoryErr  bool
+		expRegisterErr error
+	}{
+		"A valid CA should connect to Dex using TLS.": {
+			tls: &model.AuthBackendDexTLS{CA: ca.certPEM, ServerName: "dex.test"},
+		},
+
+		"A valid CA and client certificate should connect to Dex using mutual TLS.": {
+			tls: &model.AuthBackendDexTLS{
+				CA:         ca.certPEM,
+				ClientCert: clientCert.certPEM,
+				ClientKey:  clientCert.keyPEM,
+				ServerName: "dex.test",
+			},
+			mtls: true,
+		},
+
+		"A wrong CA should fail with a TLS handshake error.": {
+			tls:            &model.AuthBackendDexTLS{CA: otherCA.certPEM, ServerName: "dex.test"},
+			expRegisterErr: authbackend.ErrTLSHandshake,
+		},
+
+		"A wrong server name should fail with a TLS handshake error.": {
+			tls:            &model.AuthBackendDexTLS{CA: ca.certPEM, ServerName: "wrong.test"},
+			expRegisterErr: authbackend.ErrTLSHandshake,
+		},
+
+		"An invalid CA bundle should fail.": {
+			tls:           &model.AuthBackendDexTLS{CA: []byte("wrong")},
+			expFactoryErr: true,
+		},
+
+		"An invalid client certificate should fail.": {
+			tls:           &model.AuthBackendDexTLS{CA: ca.certPEM, ClientCert: clientCert.certPEM},
+			expFactoryErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			var clientCA *testCert
+			if test.mtls {
+				clientCA = &ca
+			}
+			addr := runFakeDex(t, serverCert, clientCA)
+
+			mkr := &dexmock.KubernetesRepository{}
+			sec := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{"bilrost.slok.dev/secret-created-at": time.Now().UTC().Format(time.RFC3339)},
+				},
+				Data: map[string][]byte{"clientSecret": []byte("53cr37")},
+			}
+			mkr.On("GetSecret", mock.Anything, mock.Anything, mock.Anything).Return(sec, nil)
+
+			// Prepare.
+			f := factory.NewFactory("test-ns", bilrostprometheus.NewRecorder(prometheus.NewRegistry()), mkr, log.Dummy)
+			ab := model.AuthBackend{
+				ID: "test-backend",
+				Dex: &model.AuthBackendDex{
+					APIURL: addr,
+					TLS:    test.tls,
+				},
+			}
+
+			// Execute.
+			ar, err := f.GetAppRegisterer(ab)
+			if test.expFactoryErr {
+				assert.Error(err)
+				return
+			}
+			require.NoError(err)
+			defer func() { _ = f.InvalidateAppRegisterer("test-backend") }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err = ar.RegisterApp(ctx, authbackend.OIDCApp{ID: "test-id", Name: "test"})
+
+			// Check.
+			if test.expRegisterErr != nil {
+				assert.ErrorIs(err, test.expRegisterErr)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
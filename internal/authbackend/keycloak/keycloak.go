@@ -0,0 +1,476 @@
+package keycloak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/metrics"
+)
+
+// AppRegistererConfig is the configuration for the app registerer.
+type AppRegistererConfig struct {
+	// AuthBackendID is the ID of the auth backend, used on the metrics.
+	AuthBackendID string
+	// URL is the Keycloak base URL.
+	URL string
+	// Realm is the realm where the apps will be registered.
+	Realm string
+	// AdminRealm is the realm used to authenticate on the admin API, by default Realm.
+	AdminRealm string
+	// Username and Password are the credentials of a Keycloak user with permissions
+	// to manage the clients of the realm.
+	Username string
+	Password string
+	// ClientID and ClientSecret are the credentials of a service account client with permissions
+	// to manage the clients of the realm. If Username is set, ClientID will be used as the
+	// client of the user login (by default `admin-cli`).
+	ClientID     string
+	ClientSecret string
+	// GroupsMapper will add a groups mapper to the clients.
+	GroupsMapper bool
+	// SecretMaxAge is the max age of the client secrets, once expired they will be regenerated
+	// on Keycloak. If 0 the secrets will not be rotated. The apps can override it.
+	SecretMaxAge    time.Duration
+	TimeNow         func() time.Time
+	HTTPClient      *http.Client
+	MetricsRecorder metrics.Recorder
+	Logger          log.Logger
+}
+
+func (c *AppRegistererConfig) defaults() error {
+	if c.URL == "" {
+		return fmt.Errorf("the Keycloak URL is required")
+	}
+	c.URL = strings.TrimSuffix(c.URL, "/")
+
+	if c.Realm == "" {
+		return fmt.Errorf("the Keycloak realm is required")
+	}
+
+	if c.AdminRealm == "" {
+		c.AdminRealm = c.Realm
+	}
+
+	if c.Username != "" {
+		if c.Password == "" {
+			return fmt.Errorf("the Keycloak admin password is required")
+		}
+		if c.ClientID == "" {
+			c.ClientID = "admin-cli"
+		}
+	} else if c.ClientID == "" || c.ClientSecret == "" {
+		return fmt.Errorf("the Keycloak admin credentials are required")
+	}
+
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if c.TimeNow == nil {
+		c.TimeNow = time.Now
+	}
+
+	if c.MetricsRecorder == nil {
+		c.MetricsRecorder = metrics.Dummy
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Dummy
+	}
+	c.Logger = c.Logger.WithKV(log.KV{"service": "authbackend.keycloak.AppRegisterer"})
+
+	return nil
+}
+
+type appRegisterer struct {
+	authBackendID string
+	clientsURL    string
+	groupsMapper  bool
+	secretMaxAge  time.Duration
+	timeNow       func() time.Time
+	cli           *http.Client
+	metricsRec    metrics.Recorder
+	logger        log.Logger
+}
+
+// NewAppRegisterer returns a new application registerer for Keycloak.
+//
+// The apps are registered as confidential clients using the admin REST API, the secret of the
+// client is generated by Keycloak so the registerer doesn't need to store anything. The creation
+// time of the secret is tracked on a client attribute to rotate it.
+func NewAppRegisterer(config AppRegistererConfig) (authbackend.AppRegisterer, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("could not create app registerer: %w", err)
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, config.HTTPClient)
+	cli := oauth2.NewClient(ctx, newTokenSource(ctx, config))
+
+	return appRegisterer{
+		authBackendID: config.AuthBackendID,
+		clientsURL:    fmt.Sprintf("%s/admin/realms/%s/clients", config.URL, url.PathEscape(config.Realm)),
+		groupsMapper:  config.GroupsMapper,
+		secretMaxAge:  config.SecretMaxAge,
+		timeNow:       config.TimeNow,
+		cli:           cli,
+		metricsRec:    config.MetricsRecorder,
+		logger:        config.Logger,
+	}, nil
+}
+
+// newTokenSource returns the admin API token source, the tokens will be obtained again
+// when expired.
+func newTokenSource(ctx context.Context, config AppRegistererConfig) oauth2.TokenSource {
+	tokenURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token", config.URL, url.PathEscape(config.AdminRealm))
+
+	if config.Username != "" {
+		return oauth2.ReuseTokenSource(nil, passwordTokenSource{
+			ctx: ctx,
+			config: oauth2.Config{
+				ClientID:     config.ClientID,
+				ClientSecret: config.ClientSecret,
+				Endpoint:     oauth2.Endpoint{TokenURL: tokenURL, AuthStyle: oauth2.AuthStyleInParams},
+			},
+			username: config.Username,
+			password: config.Password,
+		})
+	}
+
+	cc := clientcredentials.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		TokenURL:     tokenURL,
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}
+	return cc.TokenSource(ctx)
+}
+
+// passwordTokenSource gets tokens using the resource owner password credentials grant.
+type passwordTokenSource struct {
+	ctx      context.Context
+	config   oauth2.Config
+	username string
+	password string
+}
+
+func (p passwordTokenSource) Token() (*oauth2.Token, error) {
+	return p.config.PasswordCredentialsToken(p.ctx, p.username, p.password)
+}
+
+// client is the Keycloak client representation.
+type client struct {
+	ID                        string   `json:"id,omitempty"`
+	ClientID                  string   `json:"clientId"`
+	Name                      string   `json:"name"`
+	Enabled                   bool     `json:"enabled"`
+	Protocol                  string   `json:"protocol"`
+	PublicClient              bool     `json:"publicClient"`
+	ClientAuthenticatorType   string   `json:"clientAuthenticatorType"`
+	StandardFlowEnabled       bool     `json:"standardFlowEnabled"`
+	DirectAccessGrantsEnabled bool     `json:"directAccessGrantsEnabled"`
+	RedirectURIs              []string `json:"redirectUris"`
+	// Attributes are merged on the updates, the missing ones are not removed.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// secretCreatedAtAttribute is the client attribute with the creation time of the client secret.
+const secretCreatedAtAttribute = "bilrost.slok.dev/secret-created-at"
+
+// clientSecret is the Keycloak client secret credential representation.
+type clientSecret struct {
+	Value string `json:"value"`
+}
+
+// protocolMapper is the Keycloak protocol mapper representation.
+type protocolMapper struct {
+	Name           string            `json:"name"`
+	Protocol       string            `json:"protocol"`
+	ProtocolMapper string            `json:"protocolMapper"`
+	Config         map[string]string `json:"config"`
+}
+
+var groupsMapper = protocolMapper{
+	Name:           "groups",
+	Protocol:       "openid-connect",
+	ProtocolMapper: "oidc-group-membership-mapper",
+	Config: map[string]string{
+		"claim.name":           "groups",
+		"full.path":            "false",
+		"id.token.claim":       "true",
+		"access.token.claim":   "true",
+		"userinfo.token.claim": "true",
+	},
+}
+
+func (a appRegisterer) RegisterApp(ctx context.Context, app authbackend.OIDCApp) (*authbackend.OIDCAppRegistryData, error) {
+	logger := a.logger.WithKV(log.KV{"app": app.Name, "callbackURLs": app.CallBackURLs})
+
+	c := client{
+		ClientID:                app.ID,
+		Name:                    app.Name,
+		Enabled:                 true,
+		Protocol:                "openid-connect",
+		ClientAuthenticatorType: "client-secret",
+		StandardFlowEnabled:     true,
+		RedirectURIs:            app.CallBackURLs,
+	}
+
+	existing, err := a.getClient(ctx, app.ID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get '%s' app client: %w", app.ID, err)
+	}
+
+	id := ""
+	secret := ""
+	createdAt := a.timeNow()
+	if existing != nil {
+		id = existing.ID
+
+		// Secrets created before tracking the creation (or with an invalid creation time), start
+		// tracking them from now.
+		storedCreatedAt, parseErr := time.Parse(time.RFC3339, existing.Attributes[secretCreatedAtAttribute])
+		tracked := parseErr == nil
+		if tracked {
+			createdAt = storedCreatedAt
+		}
+
+		// Regenerate before storing the new creation time, if we fail storing it the secret
+		// will be regenerated again on the next registration.
+		if tracked && a.secretExpired(app, createdAt) {
+			logger.Infof("client secret expired, regenerating Keycloak client secret")
+			secret, err = a.regenerateClientSecret(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("could not regenerate '%s' app client secret: %w", app.ID, err)
+			}
+			createdAt = a.timeNow()
+		}
+	}
+	c.Attributes = map[string]string{secretCreatedAtAttribute: createdAt.UTC().Format(time.RFC3339)}
+
+	if id != "" {
+		c.ID = id
+		err := a.do(ctx, http.MethodPut, a.clientsURL+"/"+url.PathEscape(id), c, nil, http.StatusNoContent)
+		if err != nil {
+			return nil, fmt.Errorf("could not update '%s' app client: %w", app.ID, err)
+		}
+	} else {
+		id, err = a.createClient(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("could not create '%s' app client: %w", app.ID, err)
+		}
+	}
+
+	if a.groupsMapper {
+		err := a.ensureProtocolMapper(ctx, id, groupsMapper)
+		if err != nil {
+			return nil, fmt.Errorf("could not ensure '%s' app client groups mapper: %w", app.ID, err)
+		}
+	}
+
+	if secret == "" {
+		cs := clientSecret{}
+		err = a.do(ctx, http.MethodGet, a.clientsURL+"/"+url.PathEscape(id)+"/client-secret", nil, &cs, http.StatusOK)
+		if err != nil {
+			return nil, fmt.Errorf("could not get '%s' app client secret: %w", app.ID, err)
+		}
+		secret = cs.Value
+	}
+	a.metricsRec.SetClientSecretAge(ctx, a.authBackendID, app.ID, a.timeNow().Sub(createdAt))
+
+	logger.Infof("app registered as a client on Keycloak")
+
+	return &authbackend.OIDCAppRegistryData{
+		ClientID:     app.ID,
+		ClientSecret: secret,
+	}, nil
+}
+
+// secretExpired returns true if the client secret is older than the max age.
+func (a appRegisterer) secretExpired(app authbackend.OIDCApp, createdAt time.Time) bool {
+	maxAge := a.secretMaxAge
+	if app.SecretMaxAge != 0 {
+		maxAge = app.SecretMaxAge
+	}
+
+	return maxAge != 0 && a.timeNow().Sub(createdAt) >= maxAge
+}
+
+// regenerateClientSecret generates a new secret for the client, the old one stops being valid.
+func (a appRegisterer) regenerateClientSecret(ctx context.Context, id string) (string, error) {
+	cs := clientSecret{}
+	err := a.do(ctx, http.MethodPost, a.clientsURL+"/"+url.PathEscape(id)+"/client-secret", nil, &cs, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	if cs.Value == "" {
+		return "", fmt.Errorf("missing regenerated client secret")
+	}
+
+	return cs.Value, nil
+}
+
+func (a appRegisterer) UnregisterApp(ctx context.Context, appID string) error {
+	c, err := a.getClient(ctx, appID)
+	if err != nil {
+		return fmt.Errorf("could not get '%s' app client: %w", appID, err)
+	}
+
+	// Already missing.
+	if c == nil {
+		return nil
+	}
+
+	err = a.do(ctx, http.MethodDelete, a.clientsURL+"/"+url.PathEscape(c.ID), nil, nil, http.StatusNoContent, http.StatusNotFound)
+	if err != nil {
+		return fmt.Errorf("could not delete '%s' app client: %w", appID, err)
+	}
+
+	return nil
+}
+
+// getClient returns the Keycloak client, if missing it will return nil.
+func (a appRegisterer) getClient(ctx context.Context, clientID string) (*client, error) {
+	clients := []client{}
+	err := a.do(ctx, http.MethodGet, a.clientsURL+"?"+url.Values{"clientId": {clientID}}.Encode(), nil, &clients, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range clients {
+		if clients[i].ClientID == clientID {
+			return &clients[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (a appRegisterer) createClient(ctx context.Context, c client) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal client: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.clientsURL, bytes.NewReader(b))
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.cli.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", newResponseError(resp)
+	}
+
+	// The ID of the created client is on the location.
+	id := path.Base(resp.Header.Get("Location"))
+	if id == "" || id == "." || id == "/" {
+		return "", fmt.Errorf("missing created client location")
+	}
+
+	return id, nil
+}
+
+func (a appRegisterer) ensureProtocolMapper(ctx context.Context, id string, pm protocolMapper) error {
+	modelsURL := a.clientsURL + "/" + url.PathEscape(id) + "/protocol-mappers/models"
+
+	pms := []protocolMapper{}
+	err := a.do(ctx, http.MethodGet, modelsURL, nil, &pms, http.StatusOK)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pms {
+		if p.Name == pm.Name {
+			return nil
+		}
+	}
+
+	return a.do(ctx, http.MethodPost, modelsURL, pm, nil, http.StatusCreated)
+}
+
+// do makes a request to the admin API, the response will be decoded on resp if not nil. If the status
+// code of the response is not one of the expected ones, it will fail.
+func (a appRegisterer) do(ctx context.Context, method, reqURL string, body, resp interface{}, expStatusCodes ...int) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reqBody)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	r, err := a.cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer r.Body.Close()
+
+	expected := false
+	for _, sc := range expStatusCodes {
+		if r.StatusCode == sc {
+			expected = true
+			break
+		}
+	}
+	if !expected {
+		return newResponseError(r)
+	}
+
+	if resp != nil && r.StatusCode != http.StatusNoContent {
+		err = json.NewDecoder(r.Body).Decode(resp)
+		if err != nil {
+			return fmt.Errorf("could not decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func newResponseError(resp *http.Response) error {
+	// Try getting the error from the response.
+	errResp := struct {
+		Error        string `json:"error"`
+		ErrorMessage string `json:"errorMessage"`
+	}{}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&errResp)
+
+	msg := errResp.ErrorMessage
+	if msg == "" {
+		msg = errResp.Error
+	}
+	if msg != "" {
+		return fmt.Errorf("unexpected %d status code: %s", resp.StatusCode, msg)
+	}
+
+	return fmt.Errorf("unexpected %d status code", resp.StatusCode)
+}
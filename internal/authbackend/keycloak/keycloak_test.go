@@ -0,0 +1,421 @@
+package keycloak_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/authbackend/keycloak"
+)
+
+// fakeKeycloak is a stand-in Keycloak admin REST API.
+type fakeKeycloak struct {
+	t              *testing.T
+	tokenExpiresIn int
+	tokens         int
+	clients        map[string]map[string]interface{}
+	mappers        map[string][]string
+	requests       []string
+}
+
+func (f *fakeKeycloak) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/realms/master/protocol/openid-connect/token" {
+		require.NoError(f.t, r.ParseForm())
+		validUser := r.Form.Get("grant_type") == "password" && r.Form.Get("client_id") == "admin-cli" &&
+			r.Form.Get("username") == "admin" && r.Form.Get("password") == "4dm1n"
+		validSA := r.Form.Get("grant_type") == "client_credentials" && r.Form.Get("client_id") == "bilrost" &&
+			r.Form.Get("client_secret") == "b1lr0st"
+		if !validUser && !validSA {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		f.tokens++
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("t0k3n-%d", f.tokens),
+			"token_type":   "Bearer",
+			"expires_in":   f.tokenExpiresIn,
+		})
+		return
+	}
+
+	f.requests = append(f.requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+	if r.Header.Get("Authorization") != fmt.Sprintf("Bearer t0k3n-%d", f.tokens) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	const clientsPath = "/admin/realms/test/clients"
+	subpath := strings.TrimPrefix(r.URL.Path, clientsPath)
+	parts := strings.Split(strings.TrimPrefix(subpath, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && subpath == "":
+		res := []map[string]interface{}{}
+		for _, c := range f.clients {
+			if c["clientId"] == r.URL.Query().Get("clientId") {
+				res = append(res, c)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(res)
+
+	case r.Method == http.MethodPost && subpath == "":
+		c := map[string]interface{}{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&c))
+		assert.Equal(f.t, false, c["publicClient"])
+		assert.Equal(f.t, []interface{}{"https://whatever.dev/oauth2/callback"}, c["redirectUris"])
+		c["id"] = "new-uuid"
+		f.clients["new-uuid"] = c
+		w.Header().Set("Location", "http://"+r.Host+clientsPath+"/new-uuid")
+		w.WriteHeader(http.StatusCreated)
+
+	case len(parts) == 1 && f.clients[parts[0]] == nil:
+		w.WriteHeader(http.StatusNotFound)
+
+	case r.Method == http.MethodPut && len(parts) == 1:
+		c := map[string]interface{}{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&c))
+		assert.Equal(f.t, []interface{}{"https://whatever.dev/oauth2/callback"}, c["redirectUris"])
+		f.clients[parts[0]] = c
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodDelete && len(parts) == 1:
+		delete(f.clients, parts[0])
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "client-secret":
+		_ = json.NewEncoder(w).Encode(map[string]string{"type": "secret", "value": "53cr37-" + parts[0]})
+
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "client-secret":
+		_ = json.NewEncoder(w).Encode(map[string]string{"type": "secret", "value": "r3g3n3r4t3d-53cr37-" + parts[0]})
+
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "protocol-mappers":
+		res := []map[string]interface{}{}
+		for _, m := range f.mappers[parts[0]] {
+			res = append(res, map[string]interface{}{"name": m})
+		}
+		_ = json.NewEncoder(w).Encode(res)
+
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[1] == "protocol-mappers":
+		m := map[string]interface{}{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&m))
+		assert.Equal(f.t, "oidc-group-membership-mapper", m["protocolMapper"])
+		f.mappers[parts[0]] = append(f.mappers[parts[0]], m["name"].(string))
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func getBaseApp() authbackend.OIDCApp {
+	return authbackend.OIDCApp{
+		ID:           "test-id",
+		Name:         "test",
+		CallBackURLs: []string{"https://whatever.dev/oauth2/callback"},
+	}
+}
+
+func getRegisteredClients() map[string]map[string]interface{} {
+	return map[string]map[string]interface{}{
+		"test-uuid": {"id": "test-uuid", "clientId": "test-id", "redirectUris": []string{"https://old.dev/oauth2/callback"}},
+	}
+}
+
+var (
+	t0 = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 = t0.Add(24 * time.Hour)
+)
+
+func getRegisteredClientsWithSecretCreatedAt(createdAt time.Time) map[string]map[string]interface{} {
+	clients := getRegisteredClients()
+	clients["test-uuid"]["attributes"] = map[string]interface{}{"bilrost.slok.dev/secret-created-at": createdAt.Format(time.RFC3339)}
+	return clients
+}
+
+func getUserConfig() keycloak.AppRegistererConfig {
+	return keycloak.AppRegistererConfig{
+		Realm:      "test",
+		AdminRealm: "master",
+		Username:   "admin",
+		Password:   "4dm1n",
+	}
+}
+
+func TestAppRegistererRegisterApp(t *testing.T) {
+	tests := map[string]struct {
+		config         keycloak.AppRegistererConfig
+		clients        map[string]map[string]interface{}
+		mappers        map[string][]string
+		tokenExpiresIn int
+		expData        *authbackend.OIDCAppRegistryData
+		expRequests    []string
+		expTokens      int
+		expMappers     map[string][]string
+		expCreatedAt   string
+		expErr         bool
+	}{
+		"A new app should be created as a confidential client.": {
+			config:  getUserConfig(),
+			clients: map[string]map[string]interface{}{},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "test-id", ClientSecret: "53cr37-new-uuid"},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"POST /admin/realms/test/clients",
+				"GET /admin/realms/test/clients/new-uuid/client-secret",
+			},
+			expTokens:  1,
+			expMappers: map[string][]string{},
+		},
+
+		"A new app should be created as a confidential client with a groups mapper.": {
+			config: func() keycloak.AppRegistererConfig {
+				c := getUserConfig()
+				c.GroupsMapper = true
+				return c
+			}(),
+			clients: map[string]map[string]interface{}{},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "test-id", ClientSecret: "53cr37-new-uuid"},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"POST /admin/realms/test/clients",
+				"GET /admin/realms/test/clients/new-uuid/protocol-mappers/models",
+				"POST /admin/realms/test/clients/new-uuid/protocol-mappers/models",
+				"GET /admin/realms/test/clients/new-uuid/client-secret",
+			},
+			expTokens:  1,
+			expMappers: map[string][]string{"new-uuid": {"groups"}},
+		},
+
+		"An already registered app should update the client.": {
+			config:  getUserConfig(),
+			clients: getRegisteredClients(),
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "test-id", ClientSecret: "53cr37-test-uuid"},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"PUT /admin/realms/test/clients/test-uuid",
+				"GET /admin/realms/test/clients/test-uuid/client-secret",
+			},
+			expTokens:  1,
+			expMappers: map[string][]string{},
+		},
+
+		"An already registered app with an expired secret should regenerate the client secret.": {
+			config: func() keycloak.AppRegistererConfig {
+				c := getUserConfig()
+				c.SecretMaxAge = 12 * time.Hour
+				return c
+			}(),
+			clients: getRegisteredClientsWithSecretCreatedAt(t0),
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "test-id", ClientSecret: "r3g3n3r4t3d-53cr37-test-uuid"},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"POST /admin/realms/test/clients/test-uuid/client-secret",
+				"PUT /admin/realms/test/clients/test-uuid",
+			},
+			expTokens:    1,
+			expMappers:   map[string][]string{},
+			expCreatedAt: t1.Format(time.RFC3339),
+		},
+
+		"An already registered app with a not expired secret should reuse the client secret.": {
+			config: func() keycloak.AppRegistererConfig {
+				c := getUserConfig()
+				c.SecretMaxAge = 48 * time.Hour
+				return c
+			}(),
+			clients: getRegisteredClientsWithSecretCreatedAt(t0),
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "test-id", ClientSecret: "53cr37-test-uuid"},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"PUT /admin/realms/test/clients/test-uuid",
+				"GET /admin/realms/test/clients/test-uuid/client-secret",
+			},
+			expTokens:    1,
+			expMappers:   map[string][]string{},
+			expCreatedAt: t0.Format(time.RFC3339),
+		},
+
+		"An already registered app with a secret without creation time should start tracking it instead of regenerating.": {
+			config: func() keycloak.AppRegistererConfig {
+				c := getUserConfig()
+				c.SecretMaxAge = 12 * time.Hour
+				return c
+			}(),
+			clients: getRegisteredClients(),
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "test-id", ClientSecret: "53cr37-test-uuid"},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"PUT /admin/realms/test/clients/test-uuid",
+				"GET /admin/realms/test/clients/test-uuid/client-secret",
+			},
+			expTokens:    1,
+			expMappers:   map[string][]string{},
+			expCreatedAt: t1.Format(time.RFC3339),
+		},
+
+		"An already registered app with the groups mapper should not add the mapper again.": {
+			config: func() keycloak.AppRegistererConfig {
+				c := getUserConfig()
+				c.GroupsMapper = true
+				return c
+			}(),
+			clients: getRegisteredClients(),
+			mappers: map[string][]string{"test-uuid": {"groups"}},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "test-id", ClientSecret: "53cr37-test-uuid"},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"PUT /admin/realms/test/clients/test-uuid",
+				"GET /admin/realms/test/clients/test-uuid/protocol-mappers/models",
+				"GET /admin/realms/test/clients/test-uuid/client-secret",
+			},
+			expTokens:  1,
+			expMappers: map[string][]string{"test-uuid": {"groups"}},
+		},
+
+		"Using a service account should register the app.": {
+			config: keycloak.AppRegistererConfig{
+				Realm:        "test",
+				AdminRealm:   "master",
+				ClientID:     "bilrost",
+				ClientSecret: "b1lr0st",
+			},
+			clients: getRegisteredClients(),
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "test-id", ClientSecret: "53cr37-test-uuid"},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"PUT /admin/realms/test/clients/test-uuid",
+				"GET /admin/realms/test/clients/test-uuid/client-secret",
+			},
+			expTokens:  1,
+			expMappers: map[string][]string{},
+		},
+
+		"Expired tokens should be refreshed.": {
+			config:         getUserConfig(),
+			clients:        getRegisteredClients(),
+			tokenExpiresIn: 1,
+			expData:        &authbackend.OIDCAppRegistryData{ClientID: "test-id", ClientSecret: "53cr37-test-uuid"},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"PUT /admin/realms/test/clients/test-uuid",
+				"GET /admin/realms/test/clients/test-uuid/client-secret",
+			},
+			expTokens:  3,
+			expMappers: map[string][]string{},
+		},
+
+		"Having invalid credentials should fail.": {
+			config: func() keycloak.AppRegistererConfig {
+				c := getUserConfig()
+				c.Password = "wrong"
+				return c
+			}(),
+			clients:    map[string]map[string]interface{}{},
+			expMappers: map[string][]string{},
+			expErr:     true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			if test.mappers == nil {
+				test.mappers = map[string][]string{}
+			}
+			if test.tokenExpiresIn == 0 {
+				test.tokenExpiresIn = 3600
+			}
+			fk := &fakeKeycloak{t: t, clients: test.clients, mappers: test.mappers, tokenExpiresIn: test.tokenExpiresIn}
+			srv := httptest.NewServer(fk)
+			defer srv.Close()
+
+			// Prepare.
+			cfg := test.config
+			cfg.URL = srv.URL
+			cfg.HTTPClient = srv.Client()
+			cfg.TimeNow = func() time.Time { return t1 }
+			ar, err := keycloak.NewAppRegisterer(cfg)
+			require.NoError(err)
+
+			// Execute.
+			gotData, err := ar.RegisterApp(context.TODO(), getBaseApp())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expData, gotData)
+			}
+			assert.Equal(test.expRequests, fk.requests)
+			assert.Equal(test.expTokens, fk.tokens)
+			assert.Equal(test.expMappers, fk.mappers)
+			if test.expCreatedAt != "" {
+				attrs, _ := fk.clients["test-uuid"]["attributes"].(map[string]interface{})
+				assert.Equal(test.expCreatedAt, attrs["bilrost.slok.dev/secret-created-at"])
+			}
+		})
+	}
+}
+
+func TestAppRegistererUnregisterApp(t *testing.T) {
+	tests := map[string]struct {
+		clients     map[string]map[string]interface{}
+		expRequests []string
+	}{
+		"A registered app should delete the client.": {
+			clients: getRegisteredClients(),
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+				"DELETE /admin/realms/test/clients/test-uuid",
+			},
+		},
+
+		"A missing app should not delete anything.": {
+			clients: map[string]map[string]interface{}{},
+			expRequests: []string{
+				"GET /admin/realms/test/clients",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			fk := &fakeKeycloak{t: t, clients: test.clients, mappers: map[string][]string{}, tokenExpiresIn: 3600}
+			srv := httptest.NewServer(fk)
+			defer srv.Close()
+
+			// Prepare.
+			cfg := getUserConfig()
+			cfg.URL = srv.URL
+			cfg.HTTPClient = srv.Client()
+			ar, err := keycloak.NewAppRegisterer(cfg)
+			require.NoError(err)
+
+			// Execute.
+			err = ar.UnregisterApp(context.TODO(), "test-id")
+
+			// Check.
+			if assert.NoError(err) {
+				assert.Empty(fk.clients)
+			}
+			assert.Equal(test.expRequests, fk.requests)
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package oidcregistration
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/log"
+)
+
+// KubernetesRepository is the service used by the registerer to interact with k8s.
+type KubernetesRepository interface {
+	EnsureSecret(ctx context.Context, sec *corev1.Secret) error
+	GetSecret(ctx context.Context, ns, name string) (*corev1.Secret, error)
+	DeleteSecret(ctx context.Context, ns, name string) error
+}
+
+//go:generate mockery -case underscore -output oidcregistrationmock -outpkg oidcregistrationmock -name KubernetesRepository
+
+// AppRegistererConfig is the configuration for the app registerer.
+type AppRegistererConfig struct {
+	// AuthBackendID is the ID of the auth backend, it's used to not share the apps
+	// registration data between different auth backends.
+	AuthBackendID    string
+	RunningNamespace string
+	// IssuerURL is the OIDC issuer, used to discover the registration endpoint.
+	IssuerURL string
+	// RegistrationEndpoint is the client registration endpoint, if empty it will be discovered.
+	RegistrationEndpoint string
+	// InitialAccessToken is the token used to register new clients, optional.
+	InitialAccessToken   string
+	HTTPClient           *http.Client
+	KubernetesRepository KubernetesRepository
+	Logger               log.Logger
+}
+
+func (c *AppRegistererConfig) defaults() error {
+	if c.AuthBackendID == "" {
+		return fmt.Errorf("the auth backend ID is required")
+	}
+
+	if c.RunningNamespace == "" {
+		return fmt.Errorf("the namespace where the app is running is required")
+	}
+
+	if c.IssuerURL == "" && c.RegistrationEndpoint == "" {
+		return fmt.Errorf("the issuer URL or the registration endpoint is required")
+	}
+
+	if c.KubernetesRepository == nil {
+		return fmt.Errorf("a Kubernetes repository required")
+	}
+
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Dummy
+	}
+	c.Logger = c.Logger.WithKV(log.KV{"service": "authbackend.oidcregistration.AppRegisterer"})
+
+	return nil
+}
+
+type appRegisterer struct {
+	authBackendID        string
+	runningNamespace     string
+	issuerURL            string
+	registrationEndpoint string
+	initialAccessToken   string
+	cli                  *http.Client
+	kuberepo             KubernetesRepository
+	logger               log.Logger
+}
+
+// NewAppRegisterer returns a new application registerer for OIDC backends that support
+// dynamic client registration (RFC 7591) and dynamic client registration management (RFC 7592).
+//
+// The client registration data returned by the auth backend (e.g: the registration access token)
+// is stored on a Kubernetes secret so the registerer can update and delete the client afterwards.
+func NewAppRegisterer(config AppRegistererConfig) (authbackend.AppRegisterer, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("could not create app registerer: %w", err)
+	}
+
+	return appRegisterer{
+		authBackendID:        config.AuthBackendID,
+		runningNamespace:     config.RunningNamespace,
+		issuerURL:            strings.TrimSuffix(config.IssuerURL, "/"),
+		registrationEndpoint: config.RegistrationEndpoint,
+		initialAccessToken:   config.InitialAccessToken,
+		cli:                  config.HTTPClient,
+		kuberepo:             config.KubernetesRepository,
+		logger:               config.Logger,
+	}, nil
+}
+
+// clientMetadata is the client metadata sent to the auth backend.
+type clientMetadata struct {
+	ClientID                string   `json:"client_id,omitempty"`
+	ClientName              string   `json:"client_name"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+}
+
+// clientInformation is the client information returned by the auth backend.
+type clientInformation struct {
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret"`
+	RegistrationAccessToken string `json:"registration_access_token"`
+	RegistrationClientURI   string `json:"registration_client_uri"`
+}
+
+func (a appRegisterer) RegisterApp(ctx context.Context, app authbackend.OIDCApp) (*authbackend.OIDCAppRegistryData, error) {
+	logger := a.logger.WithKV(log.KV{"app": app.Name, "callbackURLs": app.CallBackURLs})
+
+	stored, err := a.getStoredClient(ctx, app.ID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get '%s' app stored registration data: %w", app.ID, err)
+	}
+
+	metadata := clientMetadata{
+		ClientName:              app.Name,
+		RedirectURIs:            app.CallBackURLs,
+		GrantTypes:              []string{"authorization_code", "refresh_token"},
+		ResponseTypes:           []string{"code"},
+		TokenEndpointAuthMethod: "client_secret_basic",
+	}
+
+	// If already registered, update the client, the auth backend could have
+	// deleted the client, in that case register again.
+	var client *clientInformation
+	if stored != nil {
+		client, err = a.updateClient(ctx, *stored, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("could not update '%s' app client: %w", app.ID, err)
+		}
+		if client == nil {
+			logger.Warningf("client missing on the auth backend, registering again")
+		}
+	}
+
+	if client == nil {
+		client, err = a.registerClient(ctx, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("could not register '%s' app client: %w", app.ID, err)
+		}
+	}
+
+	// Don't lose the data that the auth backend doesn't return on the updates.
+	if stored != nil {
+		if client.ClientSecret == "" && client.ClientID == stored.ClientID {
+			client.ClientSecret = stored.ClientSecret
+		}
+		if client.RegistrationAccessToken == "" {
+			client.RegistrationAccessToken = stored.RegistrationAccessToken
+		}
+		if client.RegistrationClientURI == "" {
+			client.RegistrationClientURI = stored.RegistrationClientURI
+		}
+	}
+
+	err = a.storeClient(ctx, app.ID, *client)
+	if err != nil {
+		return nil, fmt.Errorf("could not store '%s' app registration data: %w", app.ID, err)
+	}
+
+	logger.Infof("app registered as a client on OIDC backend")
+
+	return &authbackend.OIDCAppRegistryData{
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+	}, nil
+}
+
+func (a appRegisterer) UnregisterApp(ctx context.Context, appID string) error {
+	stored, err := a.getStoredClient(ctx, appID)
+	if err != nil {
+		return fmt.Errorf("could not get '%s' app stored registration data: %w", appID, err)
+	}
+
+	// Not registered by us, nothing to unregister.
+	if stored == nil {
+		return nil
+	}
+
+	err = a.deleteClient(ctx, *stored)
+	if err != nil {
+		return fmt.Errorf("could not delete '%s' app client: %w", appID, err)
+	}
+
+	err = a.kuberepo.DeleteSecret(ctx, a.runningNamespace, a.secretName(appID))
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete '%s' app registration data: %w", appID, err)
+	}
+
+	return nil
+}
+
+// registerClient registers a new client (RFC 7591).
+func (a appRegisterer) registerClient(ctx context.Context, metadata clientMetadata) (*clientInformation, error) {
+	endpoint, err := a.getRegistrationEndpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.do(ctx, http.MethodPost, endpoint, a.initialAccessToken, metadata)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, newResponseError(resp)
+	}
+
+	client := &clientInformation{}
+	err = json.NewDecoder(resp.Body).Decode(client)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode registration response: %w", err)
+	}
+
+	if client.ClientID == "" {
+		return nil, fmt.Errorf("registration response without client ID")
+	}
+
+	return client, nil
+}
+
+// updateClient updates a registered client (RFC 7592), if the client is missing on the auth
+// backend it will return a nil client.
+func (a appRegisterer) updateClient(ctx context.Context, stored clientInformation, metadata clientMetadata) (*clientInformation, error) {
+	if stored.RegistrationClientURI == "" || stored.RegistrationAccessToken == "" {
+		return nil, nil
+	}
+
+	metadata.ClientID = stored.ClientID
+	resp, err := a.do(ctx, http.MethodPut, stored.RegistrationClientURI, stored.RegistrationAccessToken, metadata)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// RFC 7592: If the client does not exist or the token is invalid, the server must respond with 401.
+	if clientMissing(resp.StatusCode) {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newResponseError(resp)
+	}
+
+	client := &clientInformation{}
+	err = json.NewDecoder(resp.Body).Decode(client)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode update response: %w", err)
+	}
+
+	if client.ClientID == "" {
+		client.ClientID = stored.ClientID
+	}
+
+	return client, nil
+}
+
+// deleteClient deletes a registered client (RFC 7592), is safe to delete missing clients.
+func (a appRegisterer) deleteClient(ctx context.Context, stored clientInformation) error {
+	if stored.RegistrationClientURI == "" || stored.RegistrationAccessToken == "" {
+		return nil
+	}
+
+	resp, err := a.do(ctx, http.MethodDelete, stored.RegistrationClientURI, stored.RegistrationAccessToken, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusOK || clientMissing(resp.StatusCode) {
+		return nil
+	}
+
+	return newResponseError(resp)
+}
+
+func clientMissing(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusNotFound || statusCode == http.StatusGone
+}
+
+// getRegistrationEndpoint returns the registration endpoint, if not configured it will use
+// the OIDC discovery to get it.
+func (a appRegisterer) getRegistrationEndpoint(ctx context.Context) (string, error) {
+	if a.registrationEndpoint != "" {
+		return a.registrationEndpoint, nil
+	}
+
+	resp, err := a.do(ctx, http.MethodGet, a.issuerURL+"/.well-known/openid-configuration", "", nil)
+	if err != nil {
+		return "", fmt.Errorf("could not discover the registration endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not discover the registration endpoint: %w", newResponseError(resp))
+	}
+
+	discovery := struct {
+		RegistrationEndpoint string `json:"registration_endpoint"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&discovery)
+	if err != nil {
+		return "", fmt.Errorf("could not decode discovery response: %w", err)
+	}
+
+	if discovery.RegistrationEndpoint == "" {
+		return "", fmt.Errorf("the issuer doesn't support dynamic client registration")
+	}
+
+	return discovery.RegistrationEndpoint, nil
+}
+
+func (a appRegisterer) do(ctx context.Context, method, url, token string, body interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := a.cli.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	return resp, nil
+}
+
+func newResponseError(resp *http.Response) error {
+	// Try getting the error from the response (RFC 7591 error response).
+	errResp := struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&errResp)
+
+	if errResp.Error != "" {
+		return fmt.Errorf("unexpected %d status code: %s: %s", resp.StatusCode, errResp.Error, errResp.ErrorDescription)
+	}
+
+	return fmt.Errorf("unexpected %d status code", resp.StatusCode)
+}
+
+const (
+	clientIDKey                = "clientID"
+	clientSecretKey            = "clientSecret"
+	registrationAccessTokenKey = "registrationAccessToken"
+	registrationClientURIKey   = "registrationClientURI"
+)
+
+// getStoredClient returns the client registration data, if missing it will return nil.
+func (a appRegisterer) getStoredClient(ctx context.Context, appID string) (*clientInformation, error) {
+	sec, err := a.kuberepo.GetSecret(ctx, a.runningNamespace, a.secretName(appID))
+	if err != nil {
+		if kubeerrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	client := &clientInformation{
+		ClientID:                string(sec.Data[clientIDKey]),
+		ClientSecret:            string(sec.Data[clientSecretKey]),
+		RegistrationAccessToken: string(sec.Data[registrationAccessTokenKey]),
+		RegistrationClientURI:   string(sec.Data[registrationClientURIKey]),
+	}
+	if client.ClientID == "" {
+		return nil, nil
+	}
+
+	return client, nil
+}
+
+func (a appRegisterer) storeClient(ctx context.Context, appID string, client clientInformation) error {
+	name := a.secretName(appID)
+	return a.kuberepo.EnsureSecret(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: a.runningNamespace,
+			Annotations: map[string]string{
+				"bilrost.slok.dev/oidc-app-id":  appID,
+				"bilrost.slok.dev/auth-backend": a.authBackendID,
+			},
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "bilrost",
+				"app.kubernetes.io/name":       "bilrost",
+				"app.kubernetes.io/component":  "oidc-registration-client-data",
+				"app.kubernetes.io/instance":   name,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			clientIDKey:                []byte(client.ClientID),
+			clientSecretKey:            []byte(client.ClientSecret),
+			registrationAccessTokenKey: []byte(client.RegistrationAccessToken),
+			registrationClientURIKey:   []byte(client.RegistrationClientURI),
+		},
+	})
+}
+
+func (a appRegisterer) secretName(appID string) string {
+	checksum := md5.Sum([]byte(a.authBackendID + "/" + appID))
+	return fmt.Sprintf("bilrost-oidcreg-cli-%x", checksum)
+}
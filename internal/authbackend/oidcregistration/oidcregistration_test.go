@@ -0,0 +1,391 @@
+package oidcregistration_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/authbackend/oidcregistration"
+	"github.com/slok/bilrost/internal/authbackend/oidcregistration/oidcregistrationmock"
+)
+
+// fakeIdP is a stand-in OIDC provider that supports dynamic client registration.
+type fakeIdP struct {
+	t                  *testing.T
+	url                string
+	discovery          bool
+	registerStatusCode int
+	updateStatusCode   int
+	deleteStatusCode   int
+	requests           []string
+}
+
+func (f *fakeIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, r.Header.Get("Authorization")))
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/.well-known/openid-configuration":
+		if !f.discovery {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"registration_endpoint": f.url + "/register"})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/register":
+		if f.registerStatusCode != http.StatusCreated {
+			w.WriteHeader(f.registerStatusCode)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client_metadata", "error_description": "wrong"})
+			return
+		}
+		body := map[string]interface{}{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(f.t, "test", body["client_name"])
+		assert.Equal(f.t, []interface{}{"https://whatever.dev/oauth2/callback"}, body["redirect_uris"])
+
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"client_id":                 "new-client-id",
+			"client_secret":             "new-53cr37",
+			"registration_access_token": "new-r3g-t0k3n",
+			"registration_client_uri":   f.url + "/register/new-client-id",
+		})
+
+	case r.Method == http.MethodPut && r.URL.Path == "/register/client-id":
+		if f.updateStatusCode != http.StatusOK {
+			w.WriteHeader(f.updateStatusCode)
+			return
+		}
+		body := map[string]interface{}{}
+		require.NoError(f.t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(f.t, "client-id", body["client_id"])
+
+		// Some providers don't return the secret nor the token on updates.
+		_ = json.NewEncoder(w).Encode(map[string]string{"client_id": "client-id"})
+
+	case r.Method == http.MethodDelete && r.URL.Path == "/register/client-id":
+		w.WriteHeader(f.deleteStatusCode)
+
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+const secretName = "bilrost-oidcreg-cli-541f1075a5e61f5da55d0f217c4f9b90"
+
+func getBaseApp() authbackend.OIDCApp {
+	return authbackend.OIDCApp{
+		ID:           "test-id",
+		Name:         "test",
+		CallBackURLs: []string{"https://whatever.dev/oauth2/callback"},
+	}
+}
+
+func getSecret(clientID, clientSecret, token, uri string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: "test-ns",
+			Annotations: map[string]string{
+				"bilrost.slok.dev/oidc-app-id":  "test-id",
+				"bilrost.slok.dev/auth-backend": "test-backend",
+			},
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "bilrost",
+				"app.kubernetes.io/name":       "bilrost",
+				"app.kubernetes.io/component":  "oidc-registration-client-data",
+				"app.kubernetes.io/instance":   secretName,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"clientID":                []byte(clientID),
+			"clientSecret":            []byte(clientSecret),
+			"registrationAccessToken": []byte(token),
+			"registrationClientURI":   []byte(uri),
+		},
+	}
+}
+
+var errNotFound = kubeerrors.NewNotFound(corev1.Resource("secret"), secretName)
+
+func TestAppRegistererRegisterApp(t *testing.T) {
+	tests := map[string]struct {
+		idp         fakeIdP
+		regEndpoint bool
+		mock        func(url string, m *oidcregistrationmock.KubernetesRepository)
+		expData     *authbackend.OIDCAppRegistryData
+		expRequests []string
+		expErr      bool
+	}{
+		"A new app should discover the registration endpoint and register the client.": {
+			idp: fakeIdP{discovery: true, registerStatusCode: http.StatusCreated},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+				expSec := getSecret("new-client-id", "new-53cr37", "new-r3g-t0k3n", url+"/register/new-client-id")
+				m.On("EnsureSecret", mock.Anything, expSec).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "new-client-id", ClientSecret: "new-53cr37"},
+			expRequests: []string{
+				"GET /.well-known/openid-configuration ",
+				"POST /register Bearer 1n1t14l",
+			},
+		},
+
+		"A new app with a configured registration endpoint should register the client without discovery.": {
+			idp:         fakeIdP{registerStatusCode: http.StatusCreated},
+			regEndpoint: true,
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "new-client-id", ClientSecret: "new-53cr37"},
+			expRequests: []string{
+				"POST /register Bearer 1n1t14l",
+			},
+		},
+
+		"An already registered app should update the client keeping the stored data.": {
+			idp: fakeIdP{updateStatusCode: http.StatusOK},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				sec := getSecret("client-id", "53cr37", "r3g-t0k3n", url+"/register/client-id")
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(sec, nil)
+				m.On("EnsureSecret", mock.Anything, sec).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "client-id", ClientSecret: "53cr37"},
+			expRequests: []string{
+				"PUT /register/client-id Bearer r3g-t0k3n",
+			},
+		},
+
+		"An already registered app missing on the provider should register the client again.": {
+			idp: fakeIdP{discovery: true, updateStatusCode: http.StatusUnauthorized, registerStatusCode: http.StatusCreated},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				sec := getSecret("client-id", "53cr37", "r3g-t0k3n", url+"/register/client-id")
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(sec, nil)
+				expSec := getSecret("new-client-id", "new-53cr37", "new-r3g-t0k3n", url+"/register/new-client-id")
+				m.On("EnsureSecret", mock.Anything, expSec).Once().Return(nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "new-client-id", ClientSecret: "new-53cr37"},
+			expRequests: []string{
+				"PUT /register/client-id Bearer r3g-t0k3n",
+				"GET /.well-known/openid-configuration ",
+				"POST /register Bearer 1n1t14l",
+			},
+		},
+
+		"Having an error while updating the client should fail.": {
+			idp: fakeIdP{updateStatusCode: http.StatusInternalServerError},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				sec := getSecret("client-id", "53cr37", "r3g-t0k3n", url+"/register/client-id")
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(sec, nil)
+			},
+			expRequests: []string{
+				"PUT /register/client-id Bearer r3g-t0k3n",
+			},
+			expErr: true,
+		},
+
+		"Having an error while registering the client should fail.": {
+			idp: fakeIdP{discovery: true, registerStatusCode: http.StatusBadRequest},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+			},
+			expRequests: []string{
+				"GET /.well-known/openid-configuration ",
+				"POST /register Bearer 1n1t14l",
+			},
+			expErr: true,
+		},
+
+		"A provider without registration support should fail.": {
+			idp: fakeIdP{},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+			},
+			expRequests: []string{
+				"GET /.well-known/openid-configuration ",
+			},
+			expErr: true,
+		},
+
+		"Having an error while getting the stored data should fail.": {
+			idp: fakeIdP{},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"Having an error while storing the registration data should fail.": {
+			idp: fakeIdP{discovery: true, registerStatusCode: http.StatusCreated},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expRequests: []string{
+				"GET /.well-known/openid-configuration ",
+				"POST /register Bearer 1n1t14l",
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			idp := test.idp
+			idp.t = t
+			srv := httptest.NewServer(&idp)
+			defer srv.Close()
+			idp.url = srv.URL
+
+			mkr := &oidcregistrationmock.KubernetesRepository{}
+			test.mock(srv.URL, mkr)
+
+			// Prepare.
+			cfg := oidcregistration.AppRegistererConfig{
+				AuthBackendID:        "test-backend",
+				RunningNamespace:     "test-ns",
+				IssuerURL:            srv.URL,
+				InitialAccessToken:   "1n1t14l",
+				HTTPClient:           srv.Client(),
+				KubernetesRepository: mkr,
+			}
+			if test.regEndpoint {
+				cfg.RegistrationEndpoint = srv.URL + "/register"
+			}
+			ar, err := oidcregistration.NewAppRegisterer(cfg)
+			require.NoError(err)
+
+			// Execute.
+			gotData, err := ar.RegisterApp(context.TODO(), getBaseApp())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expData, gotData)
+			}
+			assert.Equal(test.expRequests, idp.requests)
+			mkr.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAppRegistererUnregisterApp(t *testing.T) {
+	tests := map[string]struct {
+		idp         fakeIdP
+		mock        func(url string, m *oidcregistrationmock.KubernetesRepository)
+		expRequests []string
+		expErr      bool
+	}{
+		"A registered app should delete the client and the stored data.": {
+			idp: fakeIdP{deleteStatusCode: http.StatusNoContent},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				sec := getSecret("client-id", "53cr37", "r3g-t0k3n", url+"/register/client-id")
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(sec, nil)
+				m.On("DeleteSecret", mock.Anything, "test-ns", secretName).Once().Return(nil)
+			},
+			expRequests: []string{
+				"DELETE /register/client-id Bearer r3g-t0k3n",
+			},
+		},
+
+		"A registered app missing on the provider should delete the stored data.": {
+			idp: fakeIdP{deleteStatusCode: http.StatusUnauthorized},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				sec := getSecret("client-id", "53cr37", "r3g-t0k3n", url+"/register/client-id")
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(sec, nil)
+				m.On("DeleteSecret", mock.Anything, "test-ns", secretName).Once().Return(nil)
+			},
+			expRequests: []string{
+				"DELETE /register/client-id Bearer r3g-t0k3n",
+			},
+		},
+
+		"A not registered app should not call the provider.": {
+			idp: fakeIdP{},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(nil, errNotFound)
+			},
+		},
+
+		"Having an error while deleting the client should fail and keep the stored data.": {
+			idp: fakeIdP{deleteStatusCode: http.StatusInternalServerError},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				sec := getSecret("client-id", "53cr37", "r3g-t0k3n", url+"/register/client-id")
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(sec, nil)
+			},
+			expRequests: []string{
+				"DELETE /register/client-id Bearer r3g-t0k3n",
+			},
+			expErr: true,
+		},
+
+		"Having an error while deleting the stored data should fail.": {
+			idp: fakeIdP{deleteStatusCode: http.StatusNoContent},
+			mock: func(url string, m *oidcregistrationmock.KubernetesRepository) {
+				sec := getSecret("client-id", "53cr37", "r3g-t0k3n", url+"/register/client-id")
+				m.On("GetSecret", mock.Anything, "test-ns", secretName).Once().Return(sec, nil)
+				m.On("DeleteSecret", mock.Anything, "test-ns", secretName).Once().Return(fmt.Errorf("whatever"))
+			},
+			expRequests: []string{
+				"DELETE /register/client-id Bearer r3g-t0k3n",
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			idp := test.idp
+			idp.t = t
+			srv := httptest.NewServer(&idp)
+			defer srv.Close()
+			idp.url = srv.URL
+
+			mkr := &oidcregistrationmock.KubernetesRepository{}
+			test.mock(srv.URL, mkr)
+
+			// Prepare.
+			ar, err := oidcregistration.NewAppRegisterer(oidcregistration.AppRegistererConfig{
+				AuthBackendID:        "test-backend",
+				RunningNamespace:     "test-ns",
+				IssuerURL:            srv.URL,
+				HTTPClient:           srv.Client(),
+				KubernetesRepository: mkr,
+			})
+			require.NoError(err)
+
+			// Execute.
+			err = ar.UnregisterApp(context.TODO(), "test-id")
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(test.expRequests, idp.requests)
+			mkr.AssertExpectations(t)
+		})
+	}
+}
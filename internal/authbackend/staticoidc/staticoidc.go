@@ -0,0 +1,130 @@
+package staticoidc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/log"
+)
+
+// KubernetesRepository is the service used by the registerer to interact with k8s.
+type KubernetesRepository interface {
+	GetSecret(ctx context.Context, ns, name string) (*corev1.Secret, error)
+}
+
+//go:generate mockery -case underscore -output staticoidcmock -outpkg staticoidcmock -name KubernetesRepository
+
+// AppRegistererConfig is the configuration for the app registerer.
+type AppRegistererConfig struct {
+	// ClientsSecretName and ClientsSecretNamespace are the name and namespace of the secret
+	// that has the pre-provisioned clients credentials.
+	ClientsSecretName      string
+	ClientsSecretNamespace string
+	KubernetesRepository   KubernetesRepository
+	Logger                 log.Logger
+}
+
+func (c *AppRegistererConfig) defaults() error {
+	if c.ClientsSecretName == "" || c.ClientsSecretNamespace == "" {
+		return fmt.Errorf("the clients secret is required")
+	}
+
+	if c.KubernetesRepository == nil {
+		return fmt.Errorf("a Kubernetes repository required")
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Dummy
+	}
+	c.Logger = c.Logger.WithKV(log.KV{"service": "authbackend.staticoidc.AppRegisterer"})
+
+	return nil
+}
+
+type appRegisterer struct {
+	secretName      string
+	secretNamespace string
+	kuberepo        KubernetesRepository
+	logger          log.Logger
+}
+
+// NewAppRegisterer returns a new application registerer for OIDC auth backends that don't
+// allow registering clients programmatically.
+//
+// The clients are pre-provisioned on the auth backend and their credentials are stored on a
+// Kubernetes secret, the registerer doesn't call any API, it only gets the app credentials
+// from the secret and checks that the app callback URLs are allowed by the client.
+func NewAppRegisterer(config AppRegistererConfig) (authbackend.AppRegisterer, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("could not create app registerer: %w", err)
+	}
+
+	return appRegisterer{
+		secretName:      config.ClientsSecretName,
+		secretNamespace: config.ClientsSecretNamespace,
+		kuberepo:        config.KubernetesRepository,
+		logger:          config.Logger,
+	}, nil
+}
+
+const (
+	clientIDKeySuffix     = ".clientID"
+	clientSecretKeySuffix = ".clientSecret"
+	redirectURLsKeySuffix = ".redirectURLs"
+)
+
+func (a appRegisterer) RegisterApp(ctx context.Context, app authbackend.OIDCApp) (*authbackend.OIDCAppRegistryData, error) {
+	key := app.ClientCredentialsKey
+	if key == "" {
+		key = defaultKey(app.ID)
+	}
+	logger := a.logger.WithKV(log.KV{"app": app.Name, "key": key})
+
+	sec, err := a.kuberepo.GetSecret(ctx, a.secretNamespace, a.secretName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get clients secret: %w", err)
+	}
+
+	clientID := string(sec.Data[key+clientIDKeySuffix])
+	clientSecret := string(sec.Data[key+clientSecretKeySuffix])
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("missing '%s' app client credentials on %s/%s secret (%q key)", app.ID, a.secretNamespace, a.secretName, key)
+	}
+
+	// Check the app callbacks are registered on the auth backend client.
+	allowedURLs := map[string]bool{}
+	for _, u := range strings.Split(string(sec.Data[key+redirectURLsKeySuffix]), ",") {
+		u = strings.TrimSpace(u)
+		if u != "" {
+			allowedURLs[u] = true
+		}
+	}
+	for _, u := range app.CallBackURLs {
+		if !allowedURLs[u] {
+			return nil, fmt.Errorf("'%s' app callback URL %q is not on the pre-provisioned client redirect URLs", app.ID, u)
+		}
+	}
+
+	logger.Debugf("app pre-provisioned client credentials loaded")
+
+	return &authbackend.OIDCAppRegistryData{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	}, nil
+}
+
+// UnregisterApp doesn't do anything, the clients are not managed by Bilrost.
+func (a appRegisterer) UnregisterApp(ctx context.Context, appID string) error {
+	return nil
+}
+
+// defaultKey returns the default credentials key of an app, the app IDs are `{namespace}/{name}`,
+// `/` is not valid on secret keys and `_` is not valid on Kubernetes names.
+func defaultKey(appID string) string {
+	return strings.ReplaceAll(appID, "/", "_")
+}
@@ -0,0 +1,152 @@
+package staticoidc_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/authbackend/staticoidc"
+	"github.com/slok/bilrost/internal/authbackend/staticoidc/staticoidcmock"
+)
+
+func getBaseApp() authbackend.OIDCApp {
+	return authbackend.OIDCApp{
+		ID:   "test-ns/test",
+		Name: "test-ns/test",
+		CallBackURLs: []string{
+			"https://whatever.dev/oauth2/callback",
+			"https://whatever2.dev/oauth2/callback",
+		},
+	}
+}
+
+func getSecret() *corev1.Secret {
+	return &corev1.Secret{
+		Data: map[string][]byte{
+			"test-ns_test.clientID":     []byte("test-client-id"),
+			"test-ns_test.clientSecret": []byte("53cr37"),
+			"test-ns_test.redirectURLs": []byte("https://whatever.dev/oauth2/callback, https://whatever2.dev/oauth2/callback"),
+			"custom.clientID":           []byte("custom-client-id"),
+			"custom.clientSecret":       []byte("cu570m-53cr37"),
+			"custom.redirectURLs":       []byte("https://whatever.dev/oauth2/callback,https://whatever2.dev/oauth2/callback"),
+			"other.clientID":            []byte("other-client-id"),
+			"other.clientSecret":        []byte("07h3r-53cr37"),
+			"other.redirectURLs":        []byte("https://other.dev/oauth2/callback"),
+		},
+	}
+}
+
+func TestAppRegistererRegisterApp(t *testing.T) {
+	tests := map[string]struct {
+		app     func() authbackend.OIDCApp
+		mock    func(m *staticoidcmock.KubernetesRepository)
+		expData *authbackend.OIDCAppRegistryData
+		expErr  bool
+	}{
+		"An app should get the client credentials using the app ID.": {
+			app: getBaseApp,
+			mock: func(m *staticoidcmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "auth", "clients").Once().Return(getSecret(), nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "test-client-id", ClientSecret: "53cr37"},
+		},
+
+		"An app with a custom key should get the client credentials using the custom key.": {
+			app: func() authbackend.OIDCApp {
+				a := getBaseApp()
+				a.ClientCredentialsKey = "custom"
+				return a
+			},
+			mock: func(m *staticoidcmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "auth", "clients").Once().Return(getSecret(), nil)
+			},
+			expData: &authbackend.OIDCAppRegistryData{ClientID: "custom-client-id", ClientSecret: "cu570m-53cr37"},
+		},
+
+		"An app without client credentials should fail.": {
+			app: func() authbackend.OIDCApp {
+				a := getBaseApp()
+				a.ClientCredentialsKey = "missing"
+				return a
+			},
+			mock: func(m *staticoidcmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "auth", "clients").Once().Return(getSecret(), nil)
+			},
+			expErr: true,
+		},
+
+		"An app with callback URLs not allowed by the client should fail.": {
+			app: func() authbackend.OIDCApp {
+				a := getBaseApp()
+				a.ClientCredentialsKey = "other"
+				return a
+			},
+			mock: func(m *staticoidcmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "auth", "clients").Once().Return(getSecret(), nil)
+			},
+			expErr: true,
+		},
+
+		"Having an error while getting the clients secret should fail.": {
+			app: getBaseApp,
+			mock: func(m *staticoidcmock.KubernetesRepository) {
+				m.On("GetSecret", mock.Anything, "auth", "clients").Once().Return(nil, fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			mkr := &staticoidcmock.KubernetesRepository{}
+			test.mock(mkr)
+
+			// Prepare.
+			ar, err := staticoidc.NewAppRegisterer(staticoidc.AppRegistererConfig{
+				ClientsSecretName:      "clients",
+				ClientsSecretNamespace: "auth",
+				KubernetesRepository:   mkr,
+			})
+			require.NoError(err)
+
+			// Execute.
+			gotData, err := ar.RegisterApp(context.TODO(), test.app())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				assert.Equal(test.expData, gotData)
+			}
+			mkr.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAppRegistererUnregisterApp(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// The clients are not managed by Bilrost, no calls should be made.
+	mkr := &staticoidcmock.KubernetesRepository{}
+	ar, err := staticoidc.NewAppRegisterer(staticoidc.AppRegistererConfig{
+		ClientsSecretName:      "clients",
+		ClientsSecretNamespace: "auth",
+		KubernetesRepository:   mkr,
+	})
+	require.NoError(err)
+
+	err = ar.UnregisterApp(context.TODO(), "test-ns/test")
+	assert.NoError(err)
+	mkr.AssertExpectations(t)
+}
@@ -8,7 +8,24 @@ import (
 
 // Data is the data that needs to be backuped.
 type Data struct {
-	AuthBackendID         string `json:"authBackendID"`
+	AuthBackendID string      `json:"authBackendID"`
+	Routes        []RouteData `json:"routes,omitempty"`
+	// Annotations are the original values of the ingress annotations that could be
+	// overridden while securing the app.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// ServiceName and ServicePortOrNamePort are the original single backend
+	// of the backups made before supporting multiple routes, the backuppers
+	// will convert them to Routes.
+	ServiceName           string `json:"serviceName,omitempty"`
+	ServicePortOrNamePort string `json:"servicePortOrNamePort,omitempty"`
+}
+
+// RouteData is the original backend data of an app route, the route
+// is identified by its host and path.
+type RouteData struct {
+	Host                  string `json:"host,omitempty"`
+	Path                  string `json:"path,omitempty"`
 	ServiceName           string `json:"serviceName"`
 	ServicePortOrNamePort string `json:"servicePortOrNamePort"`
 }
@@ -18,6 +35,8 @@ type Backupper interface {
 	// BackupOrGet will backup if the backup is not yet stored, otherwise
 	// it will not backup and get the current backup data instead.
 	BackupOrGet(ctx context.Context, app model.App, data Data) (*Data, error)
+	// UpdateBackup replaces the stored backup data, the backup must be already stored.
+	UpdateBackup(ctx context.Context, app model.App, data Data) error
 	// GetBackup gets an app Backup data.
 	GetBackup(ctx context.Context, app model.App) (*Data, error)
 	// DeleteBackup gets the backup.
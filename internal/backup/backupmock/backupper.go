@@ -76,3 +76,17 @@ func (_m *Backupper) GetBackup(ctx context.Context, app model.App) (*backup.Data
 
 	return r0, r1
 }
+
+// UpdateBackup provides a mock function with given fields: ctx, app, data
+func (_m *Backupper) UpdateBackup(ctx context.Context, app model.App, data backup.Data) error {
+	ret := _m.Called(ctx, app, data)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.App, backup.Data) error); ok {
+		r0 = rf(ctx, app, data)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
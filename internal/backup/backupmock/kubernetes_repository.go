@@ -6,7 +6,7 @@ import (
 	context "context"
 
 	mock "github.com/stretchr/testify/mock"
-	v1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 )
 
 // KubernetesRepository is an autogenerated mock type for the KubernetesRepository type
@@ -15,15 +15,15 @@ type KubernetesRepository struct {
 }
 
 // GetIngress provides a mock function with given fields: ctx, ns, name
-func (_m *KubernetesRepository) GetIngress(ctx context.Context, ns string, name string) (*v1beta1.Ingress, error) {
+func (_m *KubernetesRepository) GetIngress(ctx context.Context, ns string, name string) (*networkingv1.Ingress, error) {
 	ret := _m.Called(ctx, ns, name)
 
-	var r0 *v1beta1.Ingress
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) *v1beta1.Ingress); ok {
+	var r0 *networkingv1.Ingress
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *networkingv1.Ingress); ok {
 		r0 = rf(ctx, ns, name)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*v1beta1.Ingress)
+			r0 = ret.Get(0).(*networkingv1.Ingress)
 		}
 	}
 
@@ -38,11 +38,11 @@ func (_m *KubernetesRepository) GetIngress(ctx context.Context, ns string, name
 }
 
 // UpdateIngress provides a mock function with given fields: ctx, ingress
-func (_m *KubernetesRepository) UpdateIngress(ctx context.Context, ingress *v1beta1.Ingress) error {
+func (_m *KubernetesRepository) UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error {
 	ret := _m.Called(ctx, ingress)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, *v1beta1.Ingress) error); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *networkingv1.Ingress) error); ok {
 		r0 = rf(ctx, ingress)
 	} else {
 		r0 = ret.Error(0)
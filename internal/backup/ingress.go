@@ -5,20 +5,19 @@ import (
 	"encoding/json"
 	"fmt"
 
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 
 	"github.com/slok/bilrost/internal/log"
 	"github.com/slok/bilrost/internal/model"
 )
 
-const (
-	ingressBackupAnnotation = "auth.bilrost.slok.dev/backup"
-)
+// IngressBackupAnnotation is the ingress annotation where the ingress backupper stores the backup.
+const IngressBackupAnnotation = "auth.bilrost.slok.dev/backup"
 
 // KubernetesRepository is the proxy kubernetes service used to communicate with Kubernetes.
 type KubernetesRepository interface {
-	GetIngress(ctx context.Context, ns, name string) (*networkingv1beta1.Ingress, error)
-	UpdateIngress(ctx context.Context, ingress *networkingv1beta1.Ingress) error
+	GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error)
+	UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error
 }
 
 //go:generate mockery -case underscore -output backupmock -outpkg backupmock -name KubernetesRepository
@@ -53,19 +52,16 @@ func (i ingressBackupper) BackupOrGet(ctx context.Context, app model.App, data D
 	}
 
 	// If backup already stored return the backup.
-	storedData, ok := ing.Annotations[ingressBackupAnnotation]
+	storedData, ok, err := DecodeIngressBackup(ing)
+	if err != nil {
+		return nil, err
+	}
 	if ok {
-		data := &Data{}
-		err := json.Unmarshal([]byte(storedData), data)
-		if err != nil {
-			return nil, fmt.Errorf("could not unmarshall from JSON stored backup: %w", err)
-		}
-
-		return data, nil
+		return storedData, nil
 	}
 
 	// Store backup.
-	ing.Annotations[ingressBackupAnnotation] = string(jsonData)
+	ing.Annotations[IngressBackupAnnotation] = string(jsonData)
 	err = i.kuberepo.UpdateIngress(ctx, ing)
 	if err != nil {
 		return nil, fmt.Errorf("could not update ingress for backup: %w", err)
@@ -74,25 +70,49 @@ func (i ingressBackupper) BackupOrGet(ctx context.Context, app model.App, data D
 	return &data, nil
 }
 
-func (i ingressBackupper) GetBackup(ctx context.Context, app model.App) (*Data, error) {
-	ing, err := i.kuberepo.GetIngress(ctx, app.Ingress.Namespace, app.Ingress.Name)
+func (i ingressBackupper) UpdateBackup(ctx context.Context, app model.App, data Data) error {
+	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return nil, fmt.Errorf("could not get ingress for backup: %w", err)
+		return fmt.Errorf("could not marshall data for backup: %w", err)
 	}
 
-	if ing.Annotations == nil {
-		return nil, fmt.Errorf("backup not present")
+	ing, err := i.kuberepo.GetIngress(ctx, app.Ingress.Namespace, app.Ingress.Name)
+	if err != nil {
+		return fmt.Errorf("could not get ingress for backup: %w", err)
 	}
 
-	storedData, ok := ing.Annotations[ingressBackupAnnotation]
+	// We can't replace a missing backup, the backup data would be the current ingress data
+	// and this could be already secured.
+	storedData, ok := ing.Annotations[IngressBackupAnnotation]
 	if !ok {
-		return nil, fmt.Errorf("backup not present")
+		return fmt.Errorf("backup not present")
 	}
 
-	data := &Data{}
-	err = json.Unmarshal([]byte(storedData), data)
+	if storedData == string(jsonData) {
+		return nil
+	}
+
+	ing.Annotations[IngressBackupAnnotation] = string(jsonData)
+	err = i.kuberepo.UpdateIngress(ctx, ing)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshall from JSON stored backup: %w", err)
+		return fmt.Errorf("could not update ingress for backup: %w", err)
+	}
+
+	return nil
+}
+
+func (i ingressBackupper) GetBackup(ctx context.Context, app model.App) (*Data, error) {
+	ing, err := i.kuberepo.GetIngress(ctx, app.Ingress.Namespace, app.Ingress.Name)
+	if err != nil {
+		return nil, fmt.Errorf("could not get ingress for backup: %w", err)
+	}
+
+	data, ok, err := DecodeIngressBackup(ing)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("backup not present")
 	}
 
 	return data, nil
@@ -108,12 +128,12 @@ func (i ingressBackupper) DeleteBackup(ctx context.Context, app model.App) error
 		ing.Annotations = map[string]string{}
 	}
 
-	_, ok := ing.Annotations[ingressBackupAnnotation]
+	_, ok := ing.Annotations[IngressBackupAnnotation]
 	if !ok {
 		return nil
 	}
 
-	delete(ing.Annotations, ingressBackupAnnotation)
+	delete(ing.Annotations, IngressBackupAnnotation)
 
 	err = i.kuberepo.UpdateIngress(ctx, ing)
 	if err != nil {
@@ -122,3 +142,45 @@ func (i ingressBackupper) DeleteBackup(ctx context.Context, app model.App) error
 
 	return nil
 }
+
+// DecodeIngressBackup returns the backup stored on the ingress, if the ingress doesn't have a
+// backup it will return false.
+func DecodeIngressBackup(ing *networkingv1.Ingress) (*Data, bool, error) {
+	storedData, ok := ing.Annotations[IngressBackupAnnotation]
+	if !ok {
+		return nil, false, nil
+	}
+
+	data := &Data{}
+	err := json.Unmarshal([]byte(storedData), data)
+	if err != nil {
+		return nil, false, fmt.Errorf("could not unmarshall from JSON stored backup: %w", err)
+	}
+	upgradeLegacyData(data, ing)
+
+	return data, true, nil
+}
+
+// upgradeLegacyData converts the single backend backups made before supporting
+// multiple routes. These backups were only made on ingresses with a single rule and path
+// so we can get the route from the ingress.
+func upgradeLegacyData(data *Data, ing *networkingv1.Ingress) {
+	if len(data.Routes) > 0 || data.ServiceName == "" {
+		return
+	}
+
+	route := RouteData{
+		ServiceName:           data.ServiceName,
+		ServicePortOrNamePort: data.ServicePortOrNamePort,
+	}
+	if len(ing.Spec.Rules) > 0 {
+		route.Host = ing.Spec.Rules[0].Host
+		if ing.Spec.Rules[0].HTTP != nil && len(ing.Spec.Rules[0].HTTP.Paths) > 0 {
+			route.Path = ing.Spec.Rules[0].HTTP.Paths[0].Path
+		}
+	}
+
+	data.Routes = []RouteData{route}
+	data.ServiceName = ""
+	data.ServicePortOrNamePort = ""
+}
@@ -6,7 +6,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/slok/bilrost/internal/backup"
@@ -31,12 +31,14 @@ func TestIngressBackupperBackupOrGet(t *testing.T) {
 				},
 			},
 			data: backup.Data{
-				AuthBackendID:         "auth-test",
-				ServiceName:           "test-svc",
-				ServicePortOrNamePort: "http",
+				AuthBackendID: "auth-test",
+				Routes: []backup.RouteData{
+					{Host: "app.slok.dev", Path: "/", ServiceName: "test-svc", ServicePortOrNamePort: "http"},
+					{Host: "app.slok.dev", Path: "/api", ServiceName: "test-api-svc", ServicePortOrNamePort: "8080"},
+				},
 			},
 			mock: func(m *backupmock.KubernetesRepository) {
-				ing := &networkingv1beta1.Ingress{
+				ing := &networkingv1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name: "test-ing",
 						Annotations: map[string]string{
@@ -47,13 +49,15 @@ func TestIngressBackupperBackupOrGet(t *testing.T) {
 				m.On("GetIngress", mock.Anything, "test-ns", "test-ing").Once().Return(ing, nil)
 
 				expIng := ing.DeepCopy()
-				expIng.Annotations["auth.bilrost.slok.dev/backup"] = `{"authBackendID":"auth-test","serviceName":"test-svc","servicePortOrNamePort":"http"}`
+				expIng.Annotations["auth.bilrost.slok.dev/backup"] = `{"authBackendID":"auth-test","routes":[{"host":"app.slok.dev","path":"/","serviceName":"test-svc","servicePortOrNamePort":"http"},{"host":"app.slok.dev","path":"/api","serviceName":"test-api-svc","servicePortOrNamePort":"8080"}]}`
 				m.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
 			},
 			expData: backup.Data{
-				AuthBackendID:         "auth-test",
-				ServiceName:           "test-svc",
-				ServicePortOrNamePort: "http",
+				AuthBackendID: "auth-test",
+				Routes: []backup.RouteData{
+					{Host: "app.slok.dev", Path: "/", ServiceName: "test-svc", ServicePortOrNamePort: "http"},
+					{Host: "app.slok.dev", Path: "/api", ServiceName: "test-api-svc", ServicePortOrNamePort: "8080"},
+				},
 			},
 		},
 
@@ -65,25 +69,65 @@ func TestIngressBackupperBackupOrGet(t *testing.T) {
 				},
 			},
 			data: backup.Data{
-				ServiceName:           "test-svc",
-				ServicePortOrNamePort: "http",
+				Routes: []backup.RouteData{
+					{Host: "app.slok.dev", Path: "/", ServiceName: "test-svc", ServicePortOrNamePort: "http"},
+				},
 			},
 			mock: func(m *backupmock.KubernetesRepository) {
-				ing := &networkingv1beta1.Ingress{
+				ing := &networkingv1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name: "test-ing",
 						Annotations: map[string]string{
 							"test":                         "test1",
+							"auth.bilrost.slok.dev/backup": `{"authBackendID":"auth-test2","routes":[{"host":"app.slok.dev","path":"/","serviceName":"test-svc2","servicePortOrNamePort":"8080"}]}`,
+						},
+					},
+				}
+				m.On("GetIngress", mock.Anything, "test-ns", "test-ing").Once().Return(ing, nil)
+			},
+			expData: backup.Data{
+				AuthBackendID: "auth-test2",
+				Routes: []backup.RouteData{
+					{Host: "app.slok.dev", Path: "/", ServiceName: "test-svc2", ServicePortOrNamePort: "8080"},
+				},
+			},
+		},
+
+		"If the data already exists in the single backend format, it should return the data as routes.": {
+			app: model.App{
+				Ingress: model.KubernetesIngress{
+					Name:      "test-ing",
+					Namespace: "test-ns",
+				},
+			},
+			mock: func(m *backupmock.KubernetesRepository) {
+				ing := &networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-ing",
+						Annotations: map[string]string{
 							"auth.bilrost.slok.dev/backup": `{"authBackendID":"auth-test2","serviceName":"test-svc2","servicePortOrNamePort":"8080"}`,
 						},
 					},
+					Spec: networkingv1.IngressSpec{
+						Rules: []networkingv1.IngressRule{
+							{
+								Host: "app.slok.dev",
+								IngressRuleValue: networkingv1.IngressRuleValue{
+									HTTP: &networkingv1.HTTPIngressRuleValue{
+										Paths: []networkingv1.HTTPIngressPath{{Path: "/"}},
+									},
+								},
+							},
+						},
+					},
 				}
 				m.On("GetIngress", mock.Anything, "test-ns", "test-ing").Once().Return(ing, nil)
 			},
 			expData: backup.Data{
-				AuthBackendID:         "auth-test2",
-				ServiceName:           "test-svc2",
-				ServicePortOrNamePort: "8080",
+				AuthBackendID: "auth-test2",
+				Routes: []backup.RouteData{
+					{Host: "app.slok.dev", Path: "/", ServiceName: "test-svc2", ServicePortOrNamePort: "8080"},
+				},
 			},
 		},
 	}
@@ -123,7 +167,7 @@ func TestIngressBackupperGetBackup(t *testing.T) {
 				},
 			},
 			mock: func(m *backupmock.KubernetesRepository) {
-				ing := &networkingv1beta1.Ingress{
+				ing := &networkingv1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name: "test-ing",
 						Annotations: map[string]string{
@@ -143,21 +187,22 @@ func TestIngressBackupperGetBackup(t *testing.T) {
 				},
 			},
 			mock: func(m *backupmock.KubernetesRepository) {
-				ing := &networkingv1beta1.Ingress{
+				ing := &networkingv1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name: "test-ing",
 						Annotations: map[string]string{
 							"test":                         "test1",
-							"auth.bilrost.slok.dev/backup": `{"authBackendID":"auth-test","serviceName":"test-svc2","servicePortOrNamePort":"8080"}`,
+							"auth.bilrost.slok.dev/backup": `{"authBackendID":"auth-test","routes":[{"host":"app.slok.dev","serviceName":"test-svc2","servicePortOrNamePort":"8080"}]}`,
 						},
 					},
 				}
 				m.On("GetIngress", mock.Anything, "test-ns", "test-ing").Once().Return(ing, nil)
 			},
 			expData: backup.Data{
-				AuthBackendID:         "auth-test",
-				ServiceName:           "test-svc2",
-				ServicePortOrNamePort: "8080",
+				AuthBackendID: "auth-test",
+				Routes: []backup.RouteData{
+					{Host: "app.slok.dev", ServiceName: "test-svc2", ServicePortOrNamePort: "8080"},
+				},
 			},
 		},
 	}
@@ -196,7 +241,7 @@ func TestIngressBackupperDeleteBackup(t *testing.T) {
 				},
 			},
 			mock: func(m *backupmock.KubernetesRepository) {
-				ing := &networkingv1beta1.Ingress{
+				ing := &networkingv1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name: "test-ing",
 						Annotations: map[string]string{
@@ -221,7 +266,7 @@ func TestIngressBackupperDeleteBackup(t *testing.T) {
 				},
 			},
 			mock: func(m *backupmock.KubernetesRepository) {
-				ing := &networkingv1beta1.Ingress{
+				ing := &networkingv1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{
 						Name: "test-ing",
 						Annotations: map[string]string{
@@ -252,3 +297,102 @@ func TestIngressBackupperDeleteBackup(t *testing.T) {
 		})
 	}
 }
+
+func TestIngressBackupperUpdateBackup(t *testing.T) {
+	tests := map[string]struct {
+		app    model.App
+		data   backup.Data
+		mock   func(m *backupmock.KubernetesRepository)
+		expErr bool
+	}{
+		"If the backup data is already stored it should replace it on the ingress.": {
+			app: model.App{
+				Ingress: model.KubernetesIngress{
+					Name:      "test-ing",
+					Namespace: "test-ns",
+				},
+			},
+			data: backup.Data{
+				AuthBackendID: "auth-test2",
+				Routes:        []backup.RouteData{{Host: "test.slok.dev", ServiceName: "test-svc", ServicePortOrNamePort: "http"}},
+			},
+			mock: func(m *backupmock.KubernetesRepository) {
+				ing := &networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-ing",
+						Annotations: map[string]string{
+							"test":                         "test1",
+							"auth.bilrost.slok.dev/backup": `{"authBackendID":"auth-test","routes":[{"host":"test.slok.dev","serviceName":"test-svc","servicePortOrNamePort":"http"}]}`,
+						},
+					},
+				}
+				m.On("GetIngress", mock.Anything, "test-ns", "test-ing").Once().Return(ing, nil)
+
+				expIng := ing.DeepCopy()
+				expIng.Annotations["auth.bilrost.slok.dev/backup"] = `{"authBackendID":"auth-test2","routes":[{"host":"test.slok.dev","serviceName":"test-svc","servicePortOrNamePort":"http"}]}`
+				m.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+			},
+		},
+
+		"If the backup data is the same, it should not update the ingress.": {
+			app: model.App{
+				Ingress: model.KubernetesIngress{
+					Name:      "test-ing",
+					Namespace: "test-ns",
+				},
+			},
+			data: backup.Data{
+				AuthBackendID: "auth-test",
+				Routes:        []backup.RouteData{{Host: "test.slok.dev", ServiceName: "test-svc", ServicePortOrNamePort: "http"}},
+			},
+			mock: func(m *backupmock.KubernetesRepository) {
+				ing := &networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-ing",
+						Annotations: map[string]string{
+							"auth.bilrost.slok.dev/backup": `{"authBackendID":"auth-test","routes":[{"host":"test.slok.dev","serviceName":"test-svc","servicePortOrNamePort":"http"}]}`,
+						},
+					},
+				}
+				m.On("GetIngress", mock.Anything, "test-ns", "test-ing").Once().Return(ing, nil)
+			},
+		},
+
+		"If the backup data is not stored, it should fail.": {
+			app: model.App{
+				Ingress: model.KubernetesIngress{
+					Name:      "test-ing",
+					Namespace: "test-ns",
+				},
+			},
+			data: backup.Data{AuthBackendID: "auth-test"},
+			mock: func(m *backupmock.KubernetesRepository) {
+				ing := &networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test-ing",
+					},
+				}
+				m.On("GetIngress", mock.Anything, "test-ns", "test-ing").Once().Return(ing, nil)
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mk := &backupmock.KubernetesRepository{}
+			test.mock(mk)
+
+			bk := backup.NewIngressBackupper(mk, log.Dummy)
+			err := bk.UpdateBackup(context.TODO(), test.app, test.data)
+
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				mk.AssertExpectations(t)
+			}
+		})
+	}
+}
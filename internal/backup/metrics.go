@@ -26,6 +26,13 @@ func (m measuredBackupper) BackupOrGet(ctx context.Context, app model.App, data
 	return m.next.BackupOrGet(ctx, app, data)
 }
 
+func (m measuredBackupper) UpdateBackup(ctx context.Context, app model.App, data Data) (err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveBackupBackupperOperation(ctx, m.backupperType, "UpdateBackup", err == nil, t0)
+	}(time.Now())
+	return m.next.UpdateBackup(ctx, app, data)
+}
+
 func (m measuredBackupper) GetBackup(ctx context.Context, app model.App) (d *Data, err error) {
 	defer func(t0 time.Time) {
 		m.rec.ObserveBackupBackupperOperation(ctx, m.backupperType, "GetBackup", err == nil, t0)
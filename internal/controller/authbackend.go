@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/slok/bilrost/internal/log"
+	authv1 "github.com/slok/bilrost/pkg/apis/auth/v1"
+)
+
+// handleAuthBackend propagates the changes of an auth backend to the ingresses that are using it
+// by enqueuing them on the ingress enqueuer, this way we don't need to wait for the resync
+// interval. We don't reconcile them here, so a slow auth backend handling doesn't block the
+// auth backend controller workers.
+//
+// Only spec changes (generation) are propagated, the status updates made by the ingresses
+// reconciliation would trigger a reconciliation loop otherwise. The first time we see an auth
+// backend we only track it, all the ingresses are reconciled when the controller starts.
+func (h handler) handleAuthBackend(ctx context.Context, ab *authv1.AuthBackend) error {
+	logger := h.logger.WithKV(log.KV{"obj-name": ab.Name})
+
+	h.secretRefs.trackAuthBackend(ab)
+
+	if !h.abGenerations.changed(ab.Name, ab.Generation) {
+		logger.Debugf("auth backend spec not changed, nothing to do here...")
+		return nil
+	}
+
+	logger.Infof("auth backend changed, enqueuing its ingresses...")
+
+	// Don't use the app registerer created with the old auth backend configuration.
+	err := h.abInvalidator.InvalidateAppRegisterer(ab.Name)
+	if err != nil {
+		logger.Warningf("could not invalidate the auth backend app registerer: %s", err)
+	}
+
+	ings, err := h.repo.ListIngresses(ctx, h.ingressesNamespace, map[string]string{})
+	if err != nil {
+		h.abGenerations.forget(ab.Name)
+		return fmt.Errorf("could not list ingresses: %w", err)
+	}
+
+	errs := []string{}
+	for i := range ings.Items {
+		ing := &ings.Items[i]
+		if ing.Annotations[BackendAnnotation] != ab.Name {
+			continue
+		}
+
+		err := h.ingEnqueuer.EnqueueIngress(ctx, ing)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s/%s: %s", ing.Namespace, ing.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		// Retry on the next auth backend handling.
+		h.abGenerations.forget(ab.Name)
+		return fmt.Errorf("could not enqueue %d ingresses: %s", len(errs), strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// generations tracks the last handled generation of objects.
+type generations struct {
+	gens map[string]int64
+	mu   sync.Mutex
+}
+
+func newGenerations() *generations {
+	return &generations{gens: map[string]int64{}}
+}
+
+// changed returns true if the generation of the object is different from the last one tracked,
+// the first time an object is tracked is not considered a change.
+func (g *generations) changed(id string, gen int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	last, ok := g.gens[id]
+	g.gens[id] = gen
+
+	return ok && last != gen
+}
+
+// forget marks the object generation as unhandled so the next time is considered a change.
+func (g *generations) forget(id string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.gens[id] = 0
+}
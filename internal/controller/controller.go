@@ -5,7 +5,8 @@ import (
 	"fmt"
 
 	"github.com/spotahome/kooper/v2/controller"
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 
@@ -15,25 +16,74 @@ import (
 )
 
 const (
-	backendAnnotation = "auth.bilrost.slok.dev/backend"
-	handledAnnotation = "auth.bilrost.slok.dev/handled"
+	// BackendAnnotation is the ingress annotation with the auth backend ID used to secure the app.
+	BackendAnnotation = "auth.bilrost.slok.dev/backend"
+	// HandledAnnotation is the ingress annotation that marks the ingress as handled by the controller.
+	HandledAnnotation = "auth.bilrost.slok.dev/handled"
 	securityfinalizer = "finalizers.auth.bilrost.slok.dev/security"
 )
 
 // HandlerKubernetesRepository is the service to manage k8s resources by the Kubernetes handler.
 type HandlerKubernetesRepository interface {
 	GetIngressAuth(ctx context.Context, ns, name string) (*authv1.IngressAuth, error)
-	GetIngress(ctx context.Context, ns, name string) (*networkingv1beta1.Ingress, error)
-	UpdateIngress(ctx context.Context, ingress *networkingv1beta1.Ingress) error
+	UpdateIngressAuthStatus(ctx context.Context, ia *authv1.IngressAuth) error
+	GetAuthBackendCR(ctx context.Context, name string) (*authv1.AuthBackend, error)
+	UpdateAuthBackendStatus(ctx context.Context, ab *authv1.AuthBackend) error
+	GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error)
+	ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*networkingv1.IngressList, error)
+	UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error
 }
 
 //go:generate mockery -case underscore -output controllermock -outpkg controllermock -name HandlerKubernetesRepository
 
+// HandlerEventRecorder knows how to record events on Kubernetes objects.
+type HandlerEventRecorder interface {
+	Event(object runtime.Object, eventtype, reason, message string)
+}
+
+//go:generate mockery -case underscore -output controllermock -outpkg controllermock -name HandlerEventRecorder
+
+type dummyEventRecorder int
+
+func (dummyEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {}
+
+// HandlerAuthBackendInvalidator knows how to invalidate the cached data of an auth backend.
+type HandlerAuthBackendInvalidator interface {
+	InvalidateAppRegisterer(authBackendID string) error
+}
+
+//go:generate mockery -case underscore -output controllermock -outpkg controllermock -name HandlerAuthBackendInvalidator
+
+type dummyAuthBackendInvalidator int
+
+func (dummyAuthBackendInvalidator) InvalidateAppRegisterer(authBackendID string) error { return nil }
+
+// HandlerIngressEnqueuer knows how to enqueue ingresses so they are reconciled again by the
+// ingress controller.
+type HandlerIngressEnqueuer interface {
+	EnqueueIngress(ctx context.Context, ing *networkingv1.Ingress) error
+}
+
+//go:generate mockery -case underscore -output controllermock -outpkg controllermock -name HandlerIngressEnqueuer
+
+type dummyIngressEnqueuer int
+
+func (dummyIngressEnqueuer) EnqueueIngress(ctx context.Context, ing *networkingv1.Ingress) error {
+	return nil
+}
+
 // HandlerConfig is the configuration of the controller handler.
 type HandlerConfig struct {
-	KubernetesRepo HandlerKubernetesRepository
-	SecuritySvc    security.Service
-	Logger         log.Logger
+	KubernetesRepo         HandlerKubernetesRepository
+	SecuritySvc            security.Service
+	EventRecorder          HandlerEventRecorder
+	AuthBackendInvalidator HandlerAuthBackendInvalidator
+	// IngressEnqueuer is used to reconcile the ingresses of an auth backend when it changes.
+	IngressEnqueuer HandlerIngressEnqueuer
+	// NamespaceFilter is the namespace of the ingresses that will be reconciled when an
+	// auth backend changes, by default all.
+	NamespaceFilter string
+	Logger          log.Logger
 }
 
 func (c *HandlerConfig) defaults() error {
@@ -42,6 +92,18 @@ func (c *HandlerConfig) defaults() error {
 	}
 	c.Logger = c.Logger.WithKV(log.KV{"service": "controller.Handler"})
 
+	if c.EventRecorder == nil {
+		c.EventRecorder = dummyEventRecorder(0)
+	}
+
+	if c.AuthBackendInvalidator == nil {
+		c.AuthBackendInvalidator = dummyAuthBackendInvalidator(0)
+	}
+
+	if c.IngressEnqueuer == nil {
+		c.IngressEnqueuer = dummyIngressEnqueuer(0)
+	}
+
 	if c.KubernetesRepo == nil {
 		return fmt.Errorf("kubernetes repository is required")
 	}
@@ -54,9 +116,18 @@ func (c *HandlerConfig) defaults() error {
 }
 
 type handler struct {
-	repo        HandlerKubernetesRepository
-	securitySvc security.Service
-	logger      log.Logger
+	repo               HandlerKubernetesRepository
+	securitySvc        security.Service
+	eventRecorder      HandlerEventRecorder
+	abInvalidator      HandlerAuthBackendInvalidator
+	ingEnqueuer        HandlerIngressEnqueuer
+	abGenerations      *generations
+	secretVersions     *resourceVersions
+	secretRefs         *secretReferences
+	securedReports     *securedReports
+	appRegFailures     *appRegistrationFailures
+	ingressesNamespace string
+	logger             log.Logger
 }
 
 // NewHandler returns the handler for the controller.
@@ -66,6 +137,10 @@ type handler struct {
 // so this handler knows how to handle changes based on ingress obects and ingressAuth CR objects
 // depending on what is the received updated object it will call internally the required
 // handle process.
+//
+// It also handles auth backend CR objects and secrets, when an auth backend (or a secret referenced
+// by the auth backends or the ingress auths) changes, all the ingresses using it will be enqueued
+// to be reconciled again by the ingress controller.
 func NewHandler(cfg HandlerConfig) (controller.Handler, error) {
 	err := cfg.defaults()
 	if err != nil {
@@ -73,9 +148,18 @@ func NewHandler(cfg HandlerConfig) (controller.Handler, error) {
 	}
 
 	return handler{
-		repo:        cfg.KubernetesRepo,
-		securitySvc: cfg.SecuritySvc,
-		logger:      cfg.Logger,
+		repo:               cfg.KubernetesRepo,
+		securitySvc:        cfg.SecuritySvc,
+		eventRecorder:      cfg.EventRecorder,
+		abInvalidator:      cfg.AuthBackendInvalidator,
+		ingEnqueuer:        cfg.IngressEnqueuer,
+		abGenerations:      newGenerations(),
+		secretVersions:     newResourceVersions(),
+		secretRefs:         newSecretReferences(),
+		securedReports:     newSecuredReports(),
+		appRegFailures:     newAppRegistrationFailures(),
+		ingressesNamespace: cfg.NamespaceFilter,
+		logger:             cfg.Logger,
 	}, nil
 }
 
@@ -86,6 +170,9 @@ func NewHandler(cfg HandlerConfig) (controller.Handler, error) {
 // - If an ingress resource event is received we will try getting the corresponding ingressAuth CR and execute handling logic.
 // - If an ingressAuth CR is received we will try getting the ingress associated (same ns and name) and execute handling logic.
 //
+// - If an AuthBackend CR is received we will enqueue all the ingresses that use that auth backend.
+// - If a Secret is received we will enqueue all the ingresses that use that secret (by the AuthBackend or the IngressAuth).
+//
 // In case we don't have an IngressAuth CR associated with the ingress, we will use the default data as a
 // fallback, this gives us the ability to reconcile based only in ingress data.
 // On the other side if we have IngressAuth CR we will use this IngressAuth data for the reconciliation.
@@ -94,11 +181,12 @@ func NewHandler(cfg HandlerConfig) (controller.Handler, error) {
 // TODO(slok): Not optimized in k8s resource calls, some calls are not required, check this when we have problems with it.
 func (h handler) Handle(ctx context.Context, obj runtime.Object) error {
 	switch v := obj.(type) {
-	case *networkingv1beta1.Ingress:
+	case *networkingv1.Ingress:
 		h.logger.Debugf("ingress event received...")
 		return h.handle(ctx, v, nil)
 	case *authv1.IngressAuth:
 		h.logger.Debugf("ingressAuth event received...")
+		h.secretRefs.trackIngressAuth(v)
 		// We need ingress information, if not present then error.
 		ing, err := h.repo.GetIngress(ctx, v.Namespace, v.Name)
 		if err != nil {
@@ -106,18 +194,24 @@ func (h handler) Handle(ctx context.Context, obj runtime.Object) error {
 		}
 
 		return h.handle(ctx, ing, v)
+	case *authv1.AuthBackend:
+		h.logger.Debugf("authBackend event received...")
+		return h.handleAuthBackend(ctx, v)
+	case *corev1.Secret:
+		h.logger.Debugf("secret event received...")
+		return h.handleSecret(ctx, v)
 	}
 
 	h.logger.Debugf("kubernetes received object is not a valid type to be handled")
 	return nil
 }
 
-func (h handler) handle(ctx context.Context, ing *networkingv1beta1.Ingress, ia *authv1.IngressAuth) error {
+func (h handler) handle(ctx context.Context, ing *networkingv1.Ingress, ia *authv1.IngressAuth) error {
 	logger := h.logger.WithKV(log.KV{"obj-ns": ing.Namespace, "obj-id": ing.Name})
 
 	// Get the possible states of an ingress.
-	wantHandle := ing.Annotations[backendAnnotation] != ""
-	_, readyToBeHandled := ing.Annotations[handledAnnotation]
+	wantHandle := ing.Annotations[BackendAnnotation] != ""
+	_, readyToBeHandled := ing.Annotations[HandledAnnotation]
 	wantDelete := !ing.DeletionTimestamp.IsZero()
 	clean := wantDelete && !sliceContainsString(ing.ObjectMeta.Finalizers, securityfinalizer)
 
@@ -129,6 +223,7 @@ func (h handler) handle(ctx context.Context, ing *networkingv1beta1.Ingress, ia
 
 	err := validateIngress(ing)
 	if err != nil {
+		h.eventRecorder.Event(ing, corev1.EventTypeWarning, reasonInvalidIngress, err.Error())
 		return fmt.Errorf("the ingress that we want to handle is not valid: %w", err)
 	}
 
@@ -166,7 +261,9 @@ func (h handler) handle(ctx context.Context, ing *networkingv1beta1.Ingress, ia
 			}
 		}
 
-		err := h.securitySvc.SecureApp(ctx, mapToModel(ing, ia))
+		app := mapToModel(ing, ia)
+		status, err := h.securitySvc.SecureApp(ctx, app)
+		h.reportSecured(ctx, logger, app.AuthBackendID, ing, ia, status, err)
 		if err != nil {
 			return fmt.Errorf("could not secure the application: %w", err)
 		}
@@ -190,7 +287,7 @@ func (h handler) handle(ctx context.Context, ing *networkingv1beta1.Ingress, ia
 		// Try getting advanced options from the CR.
 		// TODO(slok): Do we need to get advanced options?
 		if ia == nil {
-			ia, err = h.tryGetIngressAuth(ctx, ing.Name, ing.Namespace)
+			ia, err = h.tryGetIngressAuth(ctx, ing.Namespace, ing.Name)
 			if err != nil {
 				return err
 			}
@@ -198,9 +295,12 @@ func (h handler) handle(ctx context.Context, ing *networkingv1beta1.Ingress, ia
 		}
 
 		err := h.securitySvc.RollbackAppSecurity(ctx, mapToModel(ing, ia))
+		h.recordUnsecuredEvents(ing, ia, err)
+		h.ensureUnsecuredStatus(ctx, logger, ia, err)
 		if err != nil {
 			return fmt.Errorf("could not rollback the ingress security: %w", err)
 		}
+		h.appRegFailures.forget(ing.Namespace + "/" + ing.Name)
 
 		// Not ours anymore, remove the habdled mark.
 		err = h.ensureIngressClean(ctx, ing.Namespace, ing.Name)
@@ -221,7 +321,7 @@ func (h handler) ensureIngressReady(ctx context.Context, ns, name string) error
 	}
 
 	finalizerPresent := sliceContainsString(storedIng.ObjectMeta.Finalizers, securityfinalizer)
-	_, handledAnnotPresent := storedIng.Annotations[handledAnnotation]
+	_, handledAnnotPresent := storedIng.Annotations[HandledAnnotation]
 
 	// If the ingress already ready, then don't update.
 	if finalizerPresent && handledAnnotPresent {
@@ -229,7 +329,7 @@ func (h handler) ensureIngressReady(ctx context.Context, ns, name string) error
 	}
 
 	// Set the information required on the ingress and update.
-	storedIng.Annotations[handledAnnotation] = "true"
+	storedIng.Annotations[HandledAnnotation] = "true"
 	if !finalizerPresent {
 		storedIng.ObjectMeta.Finalizers = append(storedIng.ObjectMeta.Finalizers, securityfinalizer)
 	}
@@ -248,7 +348,7 @@ func (h handler) ensureIngressClean(ctx context.Context, ns, name string) error
 	}
 
 	finalizerPresent := sliceContainsString(storedIng.ObjectMeta.Finalizers, securityfinalizer)
-	_, handledAnnotPresent := storedIng.Annotations[handledAnnotation]
+	_, handledAnnotPresent := storedIng.Annotations[HandledAnnotation]
 
 	// If the ingress already clean, then don't update.
 	if !finalizerPresent && !handledAnnotPresent {
@@ -256,7 +356,7 @@ func (h handler) ensureIngressClean(ctx context.Context, ns, name string) error
 	}
 
 	// Set the information required on the ingress and update.
-	delete(storedIng.Annotations, handledAnnotation)
+	delete(storedIng.Annotations, HandledAnnotation)
 	for i, f := range storedIng.ObjectMeta.Finalizers {
 		if f == securityfinalizer {
 			storedIng.ObjectMeta.Finalizers = append(storedIng.ObjectMeta.Finalizers[:i], storedIng.ObjectMeta.Finalizers[i+1:]...)
@@ -271,15 +371,33 @@ func (h handler) ensureIngressClean(ctx context.Context, ns, name string) error
 	return nil
 }
 
-func validateIngress(ing *networkingv1beta1.Ingress) error {
-	rulesLen := len(ing.Spec.Rules)
-	if rulesLen != 1 {
-		return fmt.Errorf("required rules on ingress is 1, got %d", rulesLen)
+func validateIngress(ing *networkingv1.Ingress) error {
+	if len(ing.Spec.Rules) == 0 {
+		return fmt.Errorf("required rules on ingress are missing")
 	}
 
-	pathsLen := len(ing.Spec.Rules[0].HTTP.Paths)
-	if pathsLen != 1 {
-		return fmt.Errorf("required paths on ingress is 1, got %d", pathsLen)
+	routes := map[string]bool{}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			return fmt.Errorf("required HTTP rule on ingress %q host is missing", rule.Host)
+		}
+
+		if len(rule.HTTP.Paths) == 0 {
+			return fmt.Errorf("required paths on ingress %q host are missing", rule.Host)
+		}
+
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service == nil {
+				return fmt.Errorf("required service backend on ingress %q host %q path is missing", rule.Host, path.Path)
+			}
+
+			// Host and path identify a route on the backups, they need to be unique.
+			route := rule.Host + path.Path
+			if routes[route] {
+				return fmt.Errorf("ingress %q host %q path is repeated", rule.Host, path.Path)
+			}
+			routes[route] = true
+		}
 	}
 
 	return nil
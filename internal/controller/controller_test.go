@@ -3,6 +3,7 @@ package controller_test
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -10,37 +11,43 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 
+	"github.com/slok/bilrost/internal/authbackend"
 	"github.com/slok/bilrost/internal/controller"
 	"github.com/slok/bilrost/internal/controller/controllermock"
 	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/security"
 	"github.com/slok/bilrost/internal/security/securitymock"
 	authv1 "github.com/slok/bilrost/pkg/apis/auth/v1"
 )
 
-func getBaseIngress() *networkingv1beta1.Ingress {
-	return &networkingv1beta1.Ingress{
+func getBaseIngress() *networkingv1.Ingress {
+	return &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "test",
 			Namespace: "test-ns",
+			UID:       "test-uid",
 			Labels:    map[string]string{"in-test": "true"},
 		},
-		Spec: networkingv1beta1.IngressSpec{
-			Rules: []networkingv1beta1.IngressRule{
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
 				{
 					Host: "https://bilrost-controller-test.slok.dev",
-					IngressRuleValue: networkingv1beta1.IngressRuleValue{
-						HTTP: &networkingv1beta1.HTTPIngressRuleValue{
-							Paths: []networkingv1beta1.HTTPIngressPath{
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
 								{
-									Backend: networkingv1beta1.IngressBackend{
-										ServiceName: "my-app",
-										ServicePort: intstr.FromString("http"),
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "my-app",
+											Port: networkingv1.ServiceBackendPort{Name: "http"},
+										},
 									},
 								},
 							},
@@ -61,7 +68,9 @@ func getBaseIngressAuth() *authv1.IngressAuth {
 		},
 		Spec: authv1.IngressAuthSpec{
 			AuthSettings: authv1.AuthSettings{
-				ScopeOrClaims: []string{"c1", "c2", "c3"},
+				ScopeOrClaims:        []string{"c1", "c2", "c3"},
+				ClientCredentialsKey: "test-key",
+				SecretRotation:       &authv1.SecretRotation{MaxAge: metav1.Duration{Duration: 90 * 24 * time.Hour}},
 			},
 			AuthProxySource: authv1.AuthProxySource{
 				Oauth2Proxy: &authv1.Oauth2ProxyAuthProxySource{
@@ -89,14 +98,19 @@ func getBaseApp() model.App {
 	return model.App{
 		ID:            "test-ns/test",
 		AuthBackendID: "test-backend-id",
-		Host:          "https://bilrost-controller-test.slok.dev",
 		Ingress: model.KubernetesIngress{
 			Name:      "test",
 			Namespace: "test-ns",
-			Upstream: model.KubernetesService{
-				Name:           "my-app",
-				Namespace:      "test-ns",
-				PortOrPortName: "http",
+			UID:       "test-uid",
+			Routes: []model.IngressRoute{
+				{
+					Host: "https://bilrost-controller-test.slok.dev",
+					Upstream: model.KubernetesService{
+						Name:           "my-app",
+						Namespace:      "test-ns",
+						PortOrPortName: "http",
+					},
+				},
 			},
 		},
 	}
@@ -104,16 +118,23 @@ func getBaseApp() model.App {
 
 func getAdvancedApp() model.App {
 	return model.App{
-		ID:            "test-ns/test",
-		AuthBackendID: "test-backend-id",
-		Host:          "https://bilrost-controller-test.slok.dev",
+		ID:                   "test-ns/test",
+		AuthBackendID:        "test-backend-id",
+		ClientCredentialsKey: "test-key",
+		SecretMaxAge:         90 * 24 * time.Hour,
 		Ingress: model.KubernetesIngress{
 			Name:      "test",
 			Namespace: "test-ns",
-			Upstream: model.KubernetesService{
-				Name:           "my-app",
-				Namespace:      "test-ns",
-				PortOrPortName: "http",
+			UID:       "test-uid",
+			Routes: []model.IngressRoute{
+				{
+					Host: "https://bilrost-controller-test.slok.dev",
+					Upstream: model.KubernetesService{
+						Name:           "my-app",
+						Namespace:      "test-ns",
+						PortOrPortName: "http",
+					},
+				},
 			},
 		},
 		ProxySettings: model.ProxySettings{
@@ -136,6 +157,28 @@ func getAdvancedApp() model.App {
 	}
 }
 
+// ingressAuthWithStatus matches an IngressAuth with the status ignoring the conditions transition time.
+func ingressAuthWithStatus(exp authv1.IngressAuthStatus) interface{} {
+	return mock.MatchedBy(func(ia *authv1.IngressAuth) bool {
+		got := ia.Status.DeepCopy()
+		for i := range got.Conditions {
+			got.Conditions[i].LastTransitionTime = metav1.Time{}
+		}
+		return reflect.DeepEqual(exp, *got)
+	})
+}
+
+// authBackendWithStatus matches an AuthBackend with the status ignoring the conditions transition time.
+func authBackendWithStatus(exp authv1.AuthBackendStatus) interface{} {
+	return mock.MatchedBy(func(ab *authv1.AuthBackend) bool {
+		got := ab.Status.DeepCopy()
+		for i := range got.Conditions {
+			got.Conditions[i].LastTransitionTime = metav1.Time{}
+		}
+		return reflect.DeepEqual(exp, *got)
+	})
+}
+
 func TestHandler(t *testing.T) {
 	tests := map[string]struct {
 		obj    func() runtime.Object
@@ -190,26 +233,41 @@ func TestHandler(t *testing.T) {
 			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {},
 		},
 
-		"An ingress with more than 1 ingress rule should error.": {
+		"An ingress with a rule without HTTP paths should error.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+				}
+				ing.Spec.Rules = append(ing.Spec.Rules, networkingv1.IngressRule{})
+				return ing
+			},
+			mock:   func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {},
+			expErr: true,
+		},
+
+		"An ingress with repeated host and path should error.": {
 			obj: func() runtime.Object {
 				ing := getBaseIngress()
 				ing.Annotations = map[string]string{
 					"auth.bilrost.slok.dev/backend": "test-backend-id",
 				}
-				ing.Spec.Rules = append(ing.Spec.Rules, networkingv1beta1.IngressRule{})
+				ing.Spec.Rules[0].HTTP.Paths = append(ing.Spec.Rules[0].HTTP.Paths, ing.Spec.Rules[0].HTTP.Paths[0])
 				return ing
 			},
 			mock:   func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {},
 			expErr: true,
 		},
 
-		"An ingress with more than 1 HTTP paths should error.": {
+		"An ingress with a non service backend should error.": {
 			obj: func() runtime.Object {
 				ing := getBaseIngress()
 				ing.Annotations = map[string]string{
 					"auth.bilrost.slok.dev/backend": "test-backend-id",
 				}
-				ing.Spec.Rules[0].HTTP.Paths = append(ing.Spec.Rules[0].HTTP.Paths, networkingv1beta1.HTTPIngressPath{})
+				ing.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1.IngressBackend{
+					Resource: &corev1.TypedLocalObjectReference{Kind: "StorageBucket", Name: "static-assets"},
+				}
 				return ing
 			},
 			mock:   func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {},
@@ -260,10 +318,13 @@ func TestHandler(t *testing.T) {
 				return ing
 			},
 			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
-				mkr.On("GetIngressAuth", mock.Anything, mock.Anything, mock.Anything).Once().Return(&authv1.IngressAuth{}, nil)
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(&authv1.IngressAuth{}, nil)
 
 				// Secure process.
-				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(nil)
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
 
 				// Our ingress is ok.
 				ing := getBaseIngress()
@@ -286,10 +347,13 @@ func TestHandler(t *testing.T) {
 				return ing
 			},
 			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
-				mkr.On("GetIngressAuth", mock.Anything, mock.Anything, mock.Anything).Once().Return(&authv1.IngressAuth{}, nil)
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(&authv1.IngressAuth{}, nil)
 
 				// Secure process.
-				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(nil)
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
 
 				// Some user or controller has deleted our marks.
 				ing := getBaseIngress()
@@ -324,7 +388,14 @@ func TestHandler(t *testing.T) {
 
 				// Secure process with advanced options (check mapping correct).
 				expApp := getAdvancedApp()
-				ms.On("SecureApp", mock.Anything, expApp).Once().Return(nil)
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("SecureApp", mock.Anything, expApp).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
 
 				// Some user or controller has deleted our marks.
 				ing := getBaseIngress()
@@ -344,81 +415,227 @@ func TestHandler(t *testing.T) {
 			},
 		},
 
-		"An ingress that was already handled without backend annotation should rollback and unmark.": {
+		"An ingress that is ready to be handled should be secured (with nginx external auth from IngressAuth CR).": {
 			obj: func() runtime.Object {
 				ing := getBaseIngress()
 				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
 					"auth.bilrost.slok.dev/handled": "true",
 				}
 				return ing
 			},
 			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
-				mkr.On("GetIngressAuth", mock.Anything, mock.Anything, mock.Anything).Once().Return(&authv1.IngressAuth{}, nil)
+				ia := getBaseIngressAuth()
+				ia.Spec.AuthProxySource = authv1.AuthProxySource{
+					Nginx: &authv1.NginxAuthProxySource{
+						CommonProxySettings: ia.Spec.AuthProxySource.Oauth2Proxy.CommonProxySettings,
+						ResponseHeaders:     []string{"X-Auth-Request-Email"},
+					},
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
 
-				// Rollback process.
-				expApp := getBaseApp()
-				expApp.AuthBackendID = "" // Because we don't have this.
-				ms.On("RollbackAppSecurity", mock.Anything, expApp).Once().Return(nil)
+				// Secure process with nginx options (check mapping correct).
+				expApp := getAdvancedApp()
+				expApp.ProxySettings.Nginx = &model.NginxProxySettings{
+					Oauth2ProxySettings: *expApp.ProxySettings.Oauth2Proxy,
+					ResponseHeaders:     []string{"X-Auth-Request-Email"},
+				}
+				expApp.ProxySettings.Oauth2Proxy = nil
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("SecureApp", mock.Anything, expApp).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
 
-				// Unmark as handled and remove the finalizer.
+				// Some user or controller has deleted our marks.
 				ing := getBaseIngress()
 				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+				}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+
+				// Marked as handled and with finalizer.
+				expIng := getBaseIngress()
+				expIng.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
 					"auth.bilrost.slok.dev/handled": "true",
 				}
-				ing.Finalizers = []string{
-					"test1",
-					"finalizers.auth.bilrost.slok.dev/security",
-					"test2",
+				expIng.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				mkr.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+			},
+		},
+
+		"An ingress that is ready to be handled should be secured (with Traefik forward auth from IngressAuth CR).": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				ia := getBaseIngressAuth()
+				ia.Spec.AuthProxySource = authv1.AuthProxySource{
+					Traefik: &authv1.TraefikAuthProxySource{
+						CommonProxySettings: ia.Spec.AuthProxySource.Oauth2Proxy.CommonProxySettings,
+						AuthResponseHeaders: []string{"X-Auth-Request-Email"},
+					},
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+
+				// Secure process with Traefik options (check mapping correct).
+				expApp := getAdvancedApp()
+				expApp.ProxySettings.Traefik = &model.TraefikProxySettings{
+					Oauth2ProxySettings: *expApp.ProxySettings.Oauth2Proxy,
+					AuthResponseHeaders: []string{"X-Auth-Request-Email"},
+				}
+				expApp.ProxySettings.Oauth2Proxy = nil
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("SecureApp", mock.Anything, expApp).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				// Some user or controller has deleted our marks.
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
 				}
 				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
 
-				expIng := ing.DeepCopy()
-				expIng.Annotations = map[string]string{}
-				expIng.Finalizers = []string{
-					"test1",
-					"test2",
+				// Marked as handled and with finalizer.
+				expIng := getBaseIngress()
+				expIng.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
 				}
+				expIng.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
 				mkr.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
 			},
 		},
 
-		"An ingress that has been deleted should be rollback and unmark.": {
+		"An ingress that is ready to be handled should be secured (with Skipper filters from IngressAuth CR).": {
 			obj: func() runtime.Object {
 				ing := getBaseIngress()
 				ing.Annotations = map[string]string{
 					"auth.bilrost.slok.dev/backend": "test-backend-id",
 					"auth.bilrost.slok.dev/handled": "true",
 				}
-				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
-				ing.DeletionTimestamp = &metav1.Time{Time: time.Now()}
 				return ing
 			},
 			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
-				mkr.On("GetIngressAuth", mock.Anything, mock.Anything, mock.Anything).Once().Return(&authv1.IngressAuth{}, nil)
+				ia := getBaseIngressAuth()
+				ia.Spec.AuthProxySource = authv1.AuthProxySource{
+					Skipper: &authv1.SkipperAuthProxySource{
+						Claims:          []string{"email"},
+						UserInfoFields:  []string{"name"},
+						UpstreamHeaders: []string{"X-Auth-Request-Email:claims.email"},
+					},
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
 
-				// Rollback process.
-				expApp := getBaseApp()
-				ms.On("RollbackAppSecurity", mock.Anything, expApp).Once().Return(nil)
+				// Secure process with Skipper options (check mapping correct).
+				expApp := getAdvancedApp()
+				expApp.ProxySettings.Skipper = &model.SkipperProxySettings{
+					Claims:          []string{"email"},
+					UserInfoFields:  []string{"name"},
+					UpstreamHeaders: []string{"X-Auth-Request-Email:claims.email"},
+				}
+				expApp.ProxySettings.Oauth2Proxy = nil
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("SecureApp", mock.Anything, expApp).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
 
-				// Unmark as handled and remove the finalizer.
+				// Some user or controller has deleted our marks.
 				ing := getBaseIngress()
 				ing.Annotations = map[string]string{
 					"auth.bilrost.slok.dev/backend": "test-backend-id",
+				}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+
+				// Marked as handled and with finalizer.
+				expIng := getBaseIngress()
+				expIng.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
 					"auth.bilrost.slok.dev/handled": "true",
 				}
-				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				expIng.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				mkr.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+			},
+		},
+
+		"An ingress that is ready to be handled should be secured (with Bilrost proxy from IngressAuth CR).": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				ia := getBaseIngressAuth()
+				ia.Spec.AuthProxySource = authv1.AuthProxySource{
+					BilrostProxy: &authv1.BilrostProxyAuthProxySource{
+						CommonProxySettings: authv1.CommonProxySettings{
+							Image:    "slok/bilrost:test",
+							Replicas: 3,
+						},
+						PassAccessToken: true,
+					},
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+
+				// Secure process with Bilrost proxy options (check mapping correct).
+				expApp := getAdvancedApp()
+				expApp.ProxySettings.BilrostProxy = &model.BilrostProxySettings{
+					Oauth2ProxySettings: model.Oauth2ProxySettings{
+						Image:    "slok/bilrost:test",
+						Replicas: 3,
+					},
+					PassAccessToken: true,
+				}
+				expApp.ProxySettings.Oauth2Proxy = nil
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("SecureApp", mock.Anything, expApp).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				// Some user or controller has deleted our marks.
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+				}
 				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
 
-				expIng := ing.DeepCopy()
+				// Marked as handled and with finalizer.
+				expIng := getBaseIngress()
 				expIng.Annotations = map[string]string{
 					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
 				}
-				expIng.Finalizers = []string{}
+				expIng.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
 				mkr.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
 			},
 		},
 
-		"An ingress that has been deleted should be rollback and unmark (with advanced options from IngressAuth CR).": {
+		"An ingress that is ready to be handled should be secured (with access control and skip auth routes from IngressAuth CR).": {
 			obj: func() runtime.Object {
 				ing := getBaseIngress()
 				ing.Annotations = map[string]string{
@@ -426,18 +643,42 @@ func TestHandler(t *testing.T) {
 					"auth.bilrost.slok.dev/handled": "true",
 				}
 				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
-				ing.DeletionTimestamp = &metav1.Time{Time: time.Now()}
 				return ing
 			},
 			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
 				ia := getBaseIngressAuth()
-				mkr.On("GetIngressAuth", mock.Anything, mock.Anything, mock.Anything).Once().Return(ia, nil)
+				ia.Spec.AuthSettings.AllowedGroups = []string{"admins"}
+				ia.Spec.AuthSettings.AllowedEmails = []string{"jane@my-company.dev"}
+				ia.Spec.AuthSettings.AllowedEmailDomains = []string{"my-company.dev"}
+				ia.Spec.AuthSettings.RequiredClaims = map[string]string{"email_verified": "true"}
+				ia.Spec.AuthSettings.SkipAuthRoutes = []authv1.SkipAuthRoute{
+					{Path: "^/public/"},
+					{Path: "^/health$", Methods: []string{"GET"}},
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
 
-				// Rollback process.
+				// Secure process with access control (check mapping correct).
 				expApp := getAdvancedApp()
-				ms.On("RollbackAppSecurity", mock.Anything, expApp).Once().Return(nil)
+				expApp.ProxySettings.AccessControl = model.AccessControl{
+					AllowedGroups:       []string{"admins"},
+					AllowedEmails:       []string{"jane@my-company.dev"},
+					AllowedEmailDomains: []string{"my-company.dev"},
+					RequiredClaims:      map[string]string{"email_verified": "true"},
+				}
+				expApp.ProxySettings.SkipAuthRoutes = []model.SkipAuthRoute{
+					{PathRegex: "^/public/"},
+					{PathRegex: "^/health$", Methods: []string{"GET"}},
+				}
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("SecureApp", mock.Anything, expApp).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
 
-				// Unmark as handled and remove the finalizer.
+				// Already marked as handled and with finalizer.
 				ing := getBaseIngress()
 				ing.Annotations = map[string]string{
 					"auth.bilrost.slok.dev/backend": "test-backend-id",
@@ -445,55 +686,1242 @@ func TestHandler(t *testing.T) {
 				}
 				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
 				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+			},
+		},
 
-				expIng := ing.DeepCopy()
-				expIng.Annotations = map[string]string{
+		"An ingress that is ready to be handled should be secured (with session settings from IngressAuth CR).": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
 					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
 				}
-				expIng.Finalizers = []string{}
-				mkr.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				ia := getBaseIngressAuth()
+				secure := false
+				ia.Spec.SessionSettings = authv1.SessionSettings{
+					CallbackPath: "/auth/callback",
+					Cookie: authv1.SessionCookie{
+						Name:     "_my_app",
+						Domain:   "slok.dev",
+						Expire:   &metav1.Duration{Duration: 12 * time.Hour},
+						Refresh:  &metav1.Duration{Duration: time.Hour},
+						SameSite: "strict",
+						Secure:   &secure,
+					},
+					Store: "redis",
+					Redis: &authv1.SessionRedis{
+						Address:           "redis.test-ns.svc:6379",
+						PasswordSecretRef: &authv1.LocalSecretKeyRef{Name: "redis-credentials", Key: "password"},
+					},
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+
+				// Secure process with session settings (check mapping correct).
+				expApp := getAdvancedApp()
+				expApp.ProxySettings.Session = model.SessionSettings{
+					CallbackPath: "/auth/callback",
+					Cookie: model.SessionCookie{
+						Name:     "_my_app",
+						Domain:   "slok.dev",
+						Expire:   12 * time.Hour,
+						Refresh:  time.Hour,
+						SameSite: "strict",
+						Insecure: true,
+					},
+					Store: model.SessionStoreRedis,
+					Redis: model.SessionRedis{
+						Address:            "redis.test-ns.svc:6379",
+						PasswordSecretName: "redis-credentials",
+						PasswordSecretKey:  "password",
+					},
+				}
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("SecureApp", mock.Anything, expApp).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				// Already marked as handled and with finalizer.
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
 			},
 		},
 
-		"An ingress that has been deleted and already cleaned should be ignored.": {
+		"An ingress that is ready to be handled should be secured (with upstream settings from IngressAuth CR).": {
 			obj: func() runtime.Object {
 				ing := getBaseIngress()
 				ing.Annotations = map[string]string{
 					"auth.bilrost.slok.dev/backend": "test-backend-id",
 					"auth.bilrost.slok.dev/handled": "true",
 				}
-				ing.Finalizers = []string{}
-				ing.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
 				return ing
 			},
-			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {},
-		},
-	}
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				ia := getBaseIngressAuth()
+				ia.Spec.UpstreamSettings = authv1.UpstreamSettings{
+					Scheme:             "https",
+					CABundle:           "-----BEGIN CERTIFICATE-----\nMIIBtest\n-----END CERTIFICATE-----\n",
+					InsecureSkipVerify: true,
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
 
-	for name, test := range tests {
-		t.Run(name, func(t *testing.T) {
-			assert := assert.New(t)
-			require := require.New(t)
+				// Secure process with upstream settings (check mapping correct).
+				expApp := getAdvancedApp()
+				expApp.ProxySettings.Upstream = model.UpstreamSettings{
+					Scheme:             "https",
+					CA:                 []byte("-----BEGIN CERTIFICATE-----\nMIIBtest\n-----END CERTIFICATE-----\n"),
+					InsecureSkipVerify: true,
+				}
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("SecureApp", mock.Anything, expApp).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
 
-			// Mocks.
-			mkr := &controllermock.HandlerKubernetesRepository{}
-			ms := &securitymock.Service{}
-			test.mock(mkr, ms)
+				// Already marked as handled and with finalizer.
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+			},
+		},
 
-			// Run.
-			cfg := controller.HandlerConfig{
-				KubernetesRepo: mkr,
-				SecuritySvc:    ms,
-			}
-			h, err := controller.NewHandler(cfg)
-			require.NoError(err)
-			err = h.Handle(context.TODO(), test.obj())
+		"An ingress that is ready to be handled with multiple rules and paths should be secured with all the routes.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				ing.Spec.Rules[0].HTTP.Paths = append(ing.Spec.Rules[0].HTTP.Paths, networkingv1.HTTPIngressPath{
+					Path: "/api",
+					Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{
+							Name: "my-api",
+							Port: networkingv1.ServiceBackendPort{Number: 8080},
+						},
+					},
+				})
+				rule := ing.Spec.Rules[0].DeepCopy()
+				rule.Host = "bilrost-controller-test2.slok.dev"
+				ing.Spec.Rules = append(ing.Spec.Rules, *rule)
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(&authv1.IngressAuth{}, nil)
+
+				// Secure process with all the routes.
+				expApp := getBaseApp()
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				apiUpstream := model.KubernetesService{Name: "my-api", Namespace: "test-ns", PortOrPortName: "8080"}
+				expApp.Ingress.Routes = []model.IngressRoute{
+					expApp.Ingress.Routes[0],
+					{Host: "https://bilrost-controller-test.slok.dev", Path: "/api", Upstream: apiUpstream},
+					{Host: "bilrost-controller-test2.slok.dev", Upstream: expApp.Ingress.Routes[0].Upstream},
+					{Host: "bilrost-controller-test2.slok.dev", Path: "/api", Upstream: apiUpstream},
+				}
+				ms.On("SecureApp", mock.Anything, expApp).Once().Return(&security.AppSecurityStatus{}, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				// Our ingress is ok.
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+			},
+		},
+
+		"An ingress that is ready to be handled should be secured and set the IngressAuth and AuthBackend status.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				ia := getBaseIngressAuth()
+				ia.Generation = 3
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+
+				status := &security.AppSecurityStatus{
+					BackendRegistered:     true,
+					ClientID:              "test-ns/test",
+					ProxyProvisioned:      true,
+					ProxyServiceName:      "test-bilrost-proxy",
+					IngressPointedToProxy: true,
+				}
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(status, nil)
+
+				expIAStatus := authv1.IngressAuthStatus{
+					ObservedGeneration: 3,
+					ClientID:           "test-ns/test",
+					ProxyServiceName:   "test-bilrost-proxy",
+					Conditions: []metav1.Condition{
+						{Type: "BackendRegistered", Status: metav1.ConditionTrue, ObservedGeneration: 3, Reason: "AppRegistered"},
+						{Type: "ProxyProvisioned", Status: metav1.ConditionTrue, ObservedGeneration: 3, Reason: "ProxyProvisioned"},
+						{Type: "IngressPointedToProxy", Status: metav1.ConditionTrue, ObservedGeneration: 3, Reason: "IngressPointedToProxy"},
+						{Type: "Ready", Status: metav1.ConditionTrue, ObservedGeneration: 3, Reason: "Secured"},
+					},
+				}
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, ingressAuthWithStatus(expIAStatus)).Once().Return(nil)
+
+				ab := &authv1.AuthBackend{ObjectMeta: metav1.ObjectMeta{Name: "test-backend-id", Generation: 2}}
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(ab, nil)
+				expABStatus := authv1.AuthBackendStatus{
+					ObservedGeneration: 2,
+					Conditions: []metav1.Condition{
+						{Type: "Ready", Status: metav1.ConditionTrue, ObservedGeneration: 2, Reason: "AppRegistered"},
+					},
+				}
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, authBackendWithStatus(expABStatus)).Once().Return(nil)
+
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+			},
+		},
+
+		"An ingress that is ready to be handled and already has the same status should not update the status.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				t0 := metav1.NewTime(time.Now().Add(-1 * time.Hour))
+				ia := getBaseIngressAuth()
+				ia.Generation = 3
+				ia.Status = authv1.IngressAuthStatus{
+					ObservedGeneration: 3,
+					ClientID:           "test-ns/test",
+					ProxyServiceName:   "test-bilrost-proxy",
+					Conditions: []metav1.Condition{
+						{Type: "BackendRegistered", Status: metav1.ConditionTrue, ObservedGeneration: 3, Reason: "AppRegistered", LastTransitionTime: t0},
+						{Type: "ProxyProvisioned", Status: metav1.ConditionTrue, ObservedGeneration: 3, Reason: "ProxyProvisioned", LastTransitionTime: t0},
+						{Type: "IngressPointedToProxy", Status: metav1.ConditionTrue, ObservedGeneration: 3, Reason: "IngressPointedToProxy", LastTransitionTime: t0},
+						{Type: "Ready", Status: metav1.ConditionTrue, ObservedGeneration: 3, Reason: "Secured", LastTransitionTime: t0},
+					},
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+
+				status := &security.AppSecurityStatus{
+					BackendRegistered:     true,
+					ClientID:              "test-ns/test",
+					ProxyProvisioned:      true,
+					ProxyServiceName:      "test-bilrost-proxy",
+					IngressPointedToProxy: true,
+				}
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(status, nil)
+
+				ab := &authv1.AuthBackend{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-backend-id", Generation: 2},
+					Status: authv1.AuthBackendStatus{
+						ObservedGeneration: 2,
+						Conditions: []metav1.Condition{
+							{Type: "Ready", Status: metav1.ConditionTrue, ObservedGeneration: 2, Reason: "AppRegistered", LastTransitionTime: t0},
+						},
+					},
+				}
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(ab, nil)
+
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+			},
+		},
+
+		"An ingress that fails being secured should set the error on the IngressAuth and AuthBackend status.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				ia := getBaseIngressAuth()
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(&security.AppSecurityStatus{}, fmt.Errorf("wanted error"))
+
+				expIAStatus := authv1.IngressAuthStatus{
+					LastError: "wanted error",
+					Conditions: []metav1.Condition{
+						{Type: "BackendRegistered", Status: metav1.ConditionFalse, Reason: "AppNotRegistered"},
+						{Type: "ProxyProvisioned", Status: metav1.ConditionFalse, Reason: "ProxyNotProvisioned"},
+						{Type: "IngressPointedToProxy", Status: metav1.ConditionFalse, Reason: "IngressNotPointedToProxy"},
+						{Type: "Ready", Status: metav1.ConditionFalse, Reason: "SecureFailed", Message: "wanted error"},
+					},
+				}
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, ingressAuthWithStatus(expIAStatus)).Once().Return(nil)
+
+				ab := &authv1.AuthBackend{ObjectMeta: metav1.ObjectMeta{Name: "test-backend-id"}}
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(ab, nil)
+				expABStatus := authv1.AuthBackendStatus{
+					LastError: "could not register test-ns/test ingress app: wanted error",
+					Conditions: []metav1.Condition{
+						{Type: "Ready", Status: metav1.ConditionFalse, Reason: "AppRegistrationFailed", Message: "could not register test-ns/test ingress app: wanted error"},
+					},
+				}
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, authBackendWithStatus(expABStatus)).Once().Return(nil)
+			},
+			expErr: true,
+		},
+
+		"An ingress that fails being secured due to a TLS handshake error should set a specific reason on the AuthBackend status.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				ia := getBaseIngressAuth()
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+
+				secErr := fmt.Errorf("wanted error: %w", authbackend.ErrTLSHandshake)
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(&security.AppSecurityStatus{}, secErr)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				ab := &authv1.AuthBackend{ObjectMeta: metav1.ObjectMeta{Name: "test-backend-id"}}
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(ab, nil)
+				expABErr := "could not register test-ns/test ingress app: " + secErr.Error()
+				expABStatus := authv1.AuthBackendStatus{
+					LastError: expABErr,
+					Conditions: []metav1.Condition{
+						{Type: "Ready", Status: metav1.ConditionFalse, Reason: "TLSHandshakeFailed", Message: expABErr},
+					},
+				}
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, authBackendWithStatus(expABStatus)).Once().Return(nil)
+			},
+			expErr: true,
+		},
+
+		"An ingress with invalid security settings should set a specific reason on the IngressAuth status and not fail the AuthBackend status.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				ia := getBaseIngressAuth()
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+
+				secErr := fmt.Errorf("%w: wanted error", security.ErrInvalidSettings)
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(&security.AppSecurityStatus{}, secErr)
+				expIAStatus := authv1.IngressAuthStatus{
+					LastError: secErr.Error(),
+					Conditions: []metav1.Condition{
+						{Type: "BackendRegistered", Status: metav1.ConditionFalse, Reason: "AppNotRegistered"},
+						{Type: "ProxyProvisioned", Status: metav1.ConditionFalse, Reason: "ProxyNotProvisioned"},
+						{Type: "IngressPointedToProxy", Status: metav1.ConditionFalse, Reason: "IngressNotPointedToProxy"},
+						{Type: "Ready", Status: metav1.ConditionFalse, Reason: "InvalidSettings", Message: secErr.Error()},
+					},
+				}
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, ingressAuthWithStatus(expIAStatus)).Once().Return(nil)
+
+				ab := &authv1.AuthBackend{ObjectMeta: metav1.ObjectMeta{Name: "test-backend-id"}}
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(ab, nil)
+				expABStatus := authv1.AuthBackendStatus{
+					Conditions: []metav1.Condition{
+						{Type: "Ready", Status: metav1.ConditionTrue, Reason: "AppRegistered"},
+					},
+				}
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, authBackendWithStatus(expABStatus)).Once().Return(nil)
+			},
+			expErr: true,
+		},
+
+		"An ingress that was already handled without backend annotation should rollback and unmark.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(&authv1.IngressAuth{}, nil)
+
+				// Rollback process.
+				expApp := getBaseApp()
+				expApp.AuthBackendID = "" // Because we don't have this.
+				expApp.Ingress.Annotations = map[string]string{"auth.bilrost.slok.dev/handled": "true"}
+				ms.On("RollbackAppSecurity", mock.Anything, expApp).Once().Return(nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				// Unmark as handled and remove the finalizer.
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{
+					"test1",
+					"finalizers.auth.bilrost.slok.dev/security",
+					"test2",
+				}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+
+				expIng := ing.DeepCopy()
+				expIng.Annotations = map[string]string{}
+				expIng.Finalizers = []string{
+					"test1",
+					"test2",
+				}
+				mkr.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+			},
+		},
+
+		"An ingress that has been deleted should be rollback and unmark.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				ing.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(&authv1.IngressAuth{}, nil)
+
+				// Rollback process.
+				expApp := getBaseApp()
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("RollbackAppSecurity", mock.Anything, expApp).Once().Return(nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				// Unmark as handled and remove the finalizer.
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+
+				expIng := ing.DeepCopy()
+				expIng.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+				}
+				expIng.Finalizers = []string{}
+				mkr.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+			},
+		},
+
+		"An ingress that has been deleted should be rollback and unmark (with advanced options from IngressAuth CR).": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				ing.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {
+				ia := getBaseIngressAuth()
+				ia.Status = authv1.IngressAuthStatus{
+					ClientID:         "test-ns/test",
+					ProxyServiceName: "test-bilrost-proxy",
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+
+				// Rollback process.
+				expApp := getAdvancedApp()
+				expApp.Ingress.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ms.On("RollbackAppSecurity", mock.Anything, expApp).Once().Return(nil)
+				expIAStatus := authv1.IngressAuthStatus{
+					Conditions: []metav1.Condition{
+						{Type: "BackendRegistered", Status: metav1.ConditionFalse, Reason: "AppNotRegistered"},
+						{Type: "ProxyProvisioned", Status: metav1.ConditionFalse, Reason: "ProxyNotProvisioned"},
+						{Type: "IngressPointedToProxy", Status: metav1.ConditionFalse, Reason: "IngressNotPointedToProxy"},
+						{Type: "Ready", Status: metav1.ConditionFalse, Reason: "Unsecured"},
+					},
+				}
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, ingressAuthWithStatus(expIAStatus)).Once().Return(nil)
+
+				// Unmark as handled and remove the finalizer.
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+
+				expIng := ing.DeepCopy()
+				expIng.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+				}
+				expIng.Finalizers = []string{}
+				mkr.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+			},
+		},
+
+		"An ingress that has been deleted and already cleaned should be ignored.": {
+			obj: func() runtime.Object {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{
+					"auth.bilrost.slok.dev/backend": "test-backend-id",
+					"auth.bilrost.slok.dev/handled": "true",
+				}
+				ing.Finalizers = []string{}
+				ing.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service) {},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			mkr := &controllermock.HandlerKubernetesRepository{}
+			ms := &securitymock.Service{}
+			test.mock(mkr, ms)
+
+			// Run.
+			cfg := controller.HandlerConfig{
+				KubernetesRepo: mkr,
+				SecuritySvc:    ms,
+			}
+			h, err := controller.NewHandler(cfg)
+			require.NoError(err)
+			err = h.Handle(context.TODO(), test.obj())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				mkr.AssertExpectations(t)
+				ms.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestHandlerEvents(t *testing.T) {
+	readyIngress := func() *networkingv1.Ingress {
+		ing := getBaseIngress()
+		ing.Annotations = map[string]string{
+			"auth.bilrost.slok.dev/backend": "test-backend-id",
+			"auth.bilrost.slok.dev/handled": "true",
+		}
+		ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+		return ing
+	}
+
+	tests := map[string]struct {
+		obj     func() runtime.Object
+		handles int
+		mock    func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder)
+		expErr  bool
+	}{
+		"An invalid ingress should record a warning event on the ingress.": {
+			obj: func() runtime.Object {
+				ing := readyIngress()
+				ing.Spec.Rules[0].HTTP = nil
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				mer.On("Event", mock.Anything, "Warning", "InvalidIngress", `required HTTP rule on ingress "https://bilrost-controller-test.slok.dev" host is missing`).Once()
+			},
+			expErr: true,
+		},
+
+		"A secured ingress should record an event on the ingress for each step and on the IngressAuth.": {
+			obj: func() runtime.Object { return readyIngress() },
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				ia := getBaseIngressAuth()
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(readyIngress(), nil)
+
+				status := &security.AppSecurityStatus{
+					BackendRegistered:     true,
+					ClientID:              "test-ns/test",
+					BackupStored:          true,
+					ProxyProvisioned:      true,
+					ProxyServiceName:      "test-bilrost-proxy",
+					IngressPointedToProxy: true,
+				}
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(status, nil)
+
+				ing := readyIngress()
+				mer.On("Event", ing, "Normal", "AppRegistered", `app registered on auth backend with "test-ns/test" client ID`).Once()
+				mer.On("Event", ing, "Normal", "BackupStored", "original ingress routes backup stored").Once()
+				mer.On("Event", ing, "Normal", "ProxyProvisioned", `auth proxy provisioned with "test-bilrost-proxy" service`).Once()
+				mer.On("Event", ing, "Normal", "IngressPointedToProxy", "ingress routes pointed to the auth proxy").Once()
+				mer.On("Event", ia, "Normal", "Secured", "ingress secured").Once()
+			},
+		},
+
+		"A secured ingress reconciled again should not record the same events again.": {
+			obj:     func() runtime.Object { return readyIngress() },
+			handles: 2,
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				ia := getBaseIngressAuth()
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Twice().Return(ia, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Twice().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Twice().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Twice().Return(nil)
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Twice().Return(readyIngress(), nil)
+
+				status := &security.AppSecurityStatus{
+					BackendRegistered:     true,
+					ClientID:              "test-ns/test",
+					BackupStored:          true,
+					ProxyProvisioned:      true,
+					ProxyServiceName:      "test-bilrost-proxy",
+					IngressPointedToProxy: true,
+				}
+				ms.On("SecureApp", mock.Anything, mock.Anything).Twice().Return(status, nil)
+
+				ing := readyIngress()
+				mer.On("Event", ing, "Normal", "AppRegistered", mock.Anything).Once()
+				mer.On("Event", ing, "Normal", "BackupStored", mock.Anything).Once()
+				mer.On("Event", ing, "Normal", "ProxyProvisioned", mock.Anything).Once()
+				mer.On("Event", ing, "Normal", "IngressPointedToProxy", mock.Anything).Once()
+				mer.On("Event", ia, "Normal", "Secured", "ingress secured").Once()
+			},
+		},
+
+		"A secured ingress with an already secured IngressAuth status should not record the events again.": {
+			obj: func() runtime.Object { return readyIngress() },
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				ia := getBaseIngressAuth()
+				ia.Status = authv1.IngressAuthStatus{
+					ClientID:         "test-ns/test",
+					ProxyServiceName: "test-bilrost-proxy",
+					Conditions: []metav1.Condition{
+						{Type: "BackendRegistered", Status: metav1.ConditionTrue, Reason: "AppRegistered"},
+						{Type: "ProxyProvisioned", Status: metav1.ConditionTrue, Reason: "ProxyProvisioned"},
+						{Type: "IngressPointedToProxy", Status: metav1.ConditionTrue, Reason: "IngressPointedToProxy"},
+						{Type: "Ready", Status: metav1.ConditionTrue, Reason: "Secured"},
+					},
+				}
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(readyIngress(), nil)
+
+				status := &security.AppSecurityStatus{
+					BackendRegistered:     true,
+					ClientID:              "test-ns/test",
+					BackupStored:          true,
+					ProxyProvisioned:      true,
+					ProxyServiceName:      "test-bilrost-proxy",
+					IngressPointedToProxy: true,
+				}
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(status, nil)
+			},
+		},
+
+		"A failure repeated on the next reconciliation should only record the failure once.": {
+			obj:     func() runtime.Object { return readyIngress() },
+			handles: 2,
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				ia := getBaseIngressAuth()
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Twice().Return(ia, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Twice().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Twice().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Twice().Return(nil)
+
+				status := &security.AppSecurityStatus{BackendRegistered: true, ClientID: "test-ns/test", BackupStored: true}
+				ms.On("SecureApp", mock.Anything, mock.Anything).Twice().Return(status, fmt.Errorf("wanted error"))
+
+				ing := readyIngress()
+				mer.On("Event", ing, "Normal", "AppRegistered", mock.Anything).Once()
+				mer.On("Event", ing, "Normal", "BackupStored", mock.Anything).Once()
+				mer.On("Event", ing, "Warning", "ProxyProvisionFailed", "could not secure the ingress: wanted error").Once()
+				mer.On("Event", ia, "Warning", "ProxyProvisionFailed", "could not secure the ingress: wanted error").Once()
+			},
+			expErr: true,
+		},
+
+		"A failure provisioning the proxy should record the succeeded steps and the failure on the ingress and the IngressAuth.": {
+			obj: func() runtime.Object { return readyIngress() },
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				ia := getBaseIngressAuth()
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				status := &security.AppSecurityStatus{
+					BackendRegistered: true,
+					ClientID:          "test-ns/test",
+					BackupStored:      true,
+				}
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(status, fmt.Errorf("wanted error"))
+
+				ing := readyIngress()
+				mer.On("Event", ing, "Normal", "AppRegistered", mock.Anything).Once()
+				mer.On("Event", ing, "Normal", "BackupStored", mock.Anything).Once()
+				mer.On("Event", ing, "Warning", "ProxyProvisionFailed", "could not secure the ingress: wanted error").Once()
+				mer.On("Event", ia, "Warning", "ProxyProvisionFailed", "could not secure the ingress: wanted error").Once()
+			},
+			expErr: true,
+		},
+
+		"A secured ingress migrated between auth backends should record the migration on the ingress and the IngressAuth.": {
+			obj: func() runtime.Object { return readyIngress() },
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				ia := getBaseIngressAuth()
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(readyIngress(), nil)
+
+				status := &security.AppSecurityStatus{
+					BackendRegistered:     true,
+					BackupStored:          true,
+					ProxyProvisioned:      true,
+					IngressPointedToProxy: true,
+					PreviousAuthBackendID: "old-backend-id",
+					AuthBackendMigrated:   true,
+				}
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(status, nil)
+
+				ing := readyIngress()
+				mer.On("Event", ing, "Normal", mock.Anything, mock.Anything).Times(4)
+				mer.On("Event", ing, "Normal", "AuthBackendMigrated", `app migrated from "old-backend-id" auth backend to "test-backend-id" auth backend`).Once()
+				mer.On("Event", ia, "Normal", "AuthBackendMigrated", `app migrated from "old-backend-id" auth backend to "test-backend-id" auth backend`).Once()
+				mer.On("Event", ia, "Normal", "Secured", "ingress secured").Once()
+			},
+		},
+
+		"A failure migrating between auth backends should record the failure on the ingress and the IngressAuth.": {
+			obj: func() runtime.Object { return readyIngress() },
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				ia := getBaseIngressAuth()
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(ia, nil)
+				mkr.On("UpdateIngressAuthStatus", mock.Anything, mock.Anything).Once().Return(nil)
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(&authv1.AuthBackend{}, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				status := &security.AppSecurityStatus{
+					BackendRegistered:     true,
+					BackupStored:          true,
+					ProxyProvisioned:      true,
+					IngressPointedToProxy: true,
+					PreviousAuthBackendID: "old-backend-id",
+				}
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(status, fmt.Errorf("wanted error"))
+
+				ing := readyIngress()
+				mer.On("Event", ing, "Normal", mock.Anything, mock.Anything).Times(4)
+				mer.On("Event", ing, "Warning", "AuthBackendMigrationFailed", "could not secure the ingress: wanted error").Once()
+				mer.On("Event", ia, "Warning", "AuthBackendMigrationFailed", "could not secure the ingress: wanted error").Once()
+			},
+			expErr: true,
+		},
+
+		"A failure registering the app should record the failure on the ingress and the AuthBackend.": {
+			obj: func() runtime.Object { return readyIngress() },
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(nil, kubeerrors.NewNotFound(schema.GroupResource{}, "test"))
+				ab := &authv1.AuthBackend{ObjectMeta: metav1.ObjectMeta{Name: "test-backend-id"}}
+				mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Once().Return(ab, nil)
+				mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Once().Return(nil)
+
+				ms.On("SecureApp", mock.Anything, mock.Anything).Once().Return(&security.AppSecurityStatus{}, fmt.Errorf("wanted error"))
+
+				mer.On("Event", readyIngress(), "Warning", "AppRegistrationFailed", "could not secure the ingress: wanted error").Once()
+				mer.On("Event", ab, "Warning", "AppRegistrationFailed", "could not register test-ns/test ingress app: wanted error").Once()
+			},
+			expErr: true,
+		},
+
+		"A security rollback should record an event on the ingress.": {
+			obj: func() runtime.Object {
+				ing := readyIngress()
+				delete(ing.Annotations, "auth.bilrost.slok.dev/backend")
+				return ing
+			},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, ms *securitymock.Service, mer *controllermock.HandlerEventRecorder) {
+				mkr.On("GetIngressAuth", mock.Anything, "test-ns", "test").Once().Return(nil, kubeerrors.NewNotFound(schema.GroupResource{}, "test"))
+				ms.On("RollbackAppSecurity", mock.Anything, mock.Anything).Once().Return(nil)
+				ing := readyIngress()
+				delete(ing.Annotations, "auth.bilrost.slok.dev/backend")
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+				mkr.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+
+				mer.On("Event", mock.Anything, "Normal", "Unsecured", "ingress security rollback completed").Once()
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			mkr := &controllermock.HandlerKubernetesRepository{}
+			ms := &securitymock.Service{}
+			mer := &controllermock.HandlerEventRecorder{}
+			test.mock(mkr, ms, mer)
+
+			// Run.
+			cfg := controller.HandlerConfig{
+				KubernetesRepo: mkr,
+				SecuritySvc:    ms,
+				EventRecorder:  mer,
+			}
+			h, err := controller.NewHandler(cfg)
+			require.NoError(err)
+			handles := test.handles
+			if handles == 0 {
+				handles = 1
+			}
+			for i := 0; i < handles; i++ {
+				err = h.Handle(context.TODO(), test.obj())
+			}
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			mer.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandlerAuthBackendStatus(t *testing.T) {
+	getIngress := func(name string) *networkingv1.Ingress {
+		ing := getBaseIngress()
+		ing.Name = name
+		ing.Annotations = map[string]string{
+			"auth.bilrost.slok.dev/backend": "test-backend-id",
+			"auth.bilrost.slok.dev/handled": "true",
+		}
+		ing.Finalizers = []string{"finalizers.auth.bilrost.slok.dev/security"}
+		return ing
+	}
+
+	readyStatus := authv1.AuthBackendStatus{
+		Conditions: []metav1.Condition{
+			{Type: "Ready", Status: metav1.ConditionTrue, Reason: "AppRegistered"},
+		},
+	}
+	failedStatus := func(msg string) authv1.AuthBackendStatus {
+		return authv1.AuthBackendStatus{
+			LastError: msg,
+			Conditions: []metav1.Condition{
+				{Type: "Ready", Status: metav1.ConditionFalse, Reason: "AppRegistrationFailed", Message: msg},
+			},
+		}
+	}
+
+	type handle struct {
+		ing       string
+		secErr    error
+		expStatus authv1.AuthBackendStatus
+	}
+
+	tests := map[string]struct {
+		handles []handle
+	}{
+		"A failed app registration should fail the AuthBackend status.": {
+			handles: []handle{
+				{ing: "test1", secErr: fmt.Errorf("wanted error"), expStatus: failedStatus("could not register test-ns/test1 ingress app: wanted error")},
+			},
+		},
+
+		"An app registered after another app failed should not set the AuthBackend status as ready.": {
+			handles: []handle{
+				{ing: "test1", secErr: fmt.Errorf("wanted error"), expStatus: failedStatus("could not register test-ns/test1 ingress app: wanted error")},
+				{ing: "test2", expStatus: failedStatus("could not register test-ns/test1 ingress app: wanted error")},
+			},
+		},
+
+		"Multiple failed app registrations should be aggregated on the AuthBackend status.": {
+			handles: []handle{
+				{ing: "test2", secErr: fmt.Errorf("wanted error 2"), expStatus: failedStatus("could not register test-ns/test2 ingress app: wanted error 2")},
+				{ing: "test1", secErr: fmt.Errorf("wanted error 1"), expStatus: failedStatus("could not register 2 ingress apps, test-ns/test1 ingress app: wanted error 1")},
+				{ing: "test1", expStatus: failedStatus("could not register test-ns/test2 ingress app: wanted error 2")},
+			},
+		},
+
+		"The AuthBackend status should be ready when all the failed apps are registered.": {
+			handles: []handle{
+				{ing: "test1", secErr: fmt.Errorf("wanted error"), expStatus: failedStatus("could not register test-ns/test1 ingress app: wanted error")},
+				{ing: "test2", secErr: fmt.Errorf("wanted error"), expStatus: failedStatus("could not register 2 ingress apps, test-ns/test1 ingress app: wanted error")},
+				{ing: "test1", expStatus: failedStatus("could not register test-ns/test2 ingress app: wanted error")},
+				{ing: "test2", expStatus: readyStatus},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			mkr := &controllermock.HandlerKubernetesRepository{}
+			ms := &securitymock.Service{}
+
+			ab := &authv1.AuthBackend{ObjectMeta: metav1.ObjectMeta{Name: "test-backend-id"}}
+			mkr.On("GetIngressAuth", mock.Anything, mock.Anything, mock.Anything).Return(nil, kubeerrors.NewNotFound(schema.GroupResource{}, "test"))
+			mkr.On("GetAuthBackendCR", mock.Anything, "test-backend-id").Return(ab, nil)
+			mkr.On("UpdateAuthBackendStatus", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				ab.Status = args.Get(1).(*authv1.AuthBackend).Status
+			})
+			for _, h := range test.handles {
+				mkr.On("GetIngress", mock.Anything, "test-ns", h.ing).Return(getIngress(h.ing), nil)
+
+				status := &security.AppSecurityStatus{BackendRegistered: h.secErr == nil}
+				appID := "test-ns/" + h.ing
+				ms.On("SecureApp", mock.Anything, mock.MatchedBy(func(app model.App) bool { return app.ID == appID })).Once().Return(status, h.secErr)
+			}
+
+			// Prepare.
+			h, err := controller.NewHandler(controller.HandlerConfig{
+				KubernetesRepo: mkr,
+				SecuritySvc:    ms,
+			})
+			require.NoError(err)
+
+			for _, handle := range test.handles {
+				// Execute.
+				_ = h.Handle(context.TODO(), getIngress(handle.ing))
+
+				// Check.
+				got := ab.Status.DeepCopy()
+				for i := range got.Conditions {
+					got.Conditions[i].LastTransitionTime = metav1.Time{}
+				}
+				assert.Equal(handle.expStatus, *got)
+			}
+			ms.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandlerAuthBackend(t *testing.T) {
+	getAuthBackend := func(gen int64) *authv1.AuthBackend {
+		return &authv1.AuthBackend{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "test-backend",
+				Generation: gen,
+			},
+		}
+	}
+
+	tests := map[string]struct {
+		objs   []runtime.Object
+		mock   func(mkr *controllermock.HandlerKubernetesRepository, mi *controllermock.HandlerAuthBackendInvalidator, me *controllermock.HandlerIngressEnqueuer)
+		expErr bool
+	}{
+		"An auth backend seen for the first time should only be tracked.": {
+			objs: []runtime.Object{getAuthBackend(1)},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, mi *controllermock.HandlerAuthBackendInvalidator, me *controllermock.HandlerIngressEnqueuer) {
+			},
+		},
+
+		"An auth backend without spec changes should not reconcile the ingresses.": {
+			objs: []runtime.Object{getAuthBackend(1), getAuthBackend(1)},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, mi *controllermock.HandlerAuthBackendInvalidator, me *controllermock.HandlerIngressEnqueuer) {
+			},
+		},
+
+		"An auth backend with spec changes should invalidate the backend and enqueue only the ingresses that use the auth backend.": {
+			objs: []runtime.Object{getAuthBackend(1), getAuthBackend(2)},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, mi *controllermock.HandlerAuthBackendInvalidator, me *controllermock.HandlerIngressEnqueuer) {
+				mi.On("InvalidateAppRegisterer", "test-backend").Once().Return(nil)
+
+				ing1 := getBaseIngress()
+				ing1.Name = "test1"
+				ing1.Annotations = map[string]string{"auth.bilrost.slok.dev/backend": "test-backend"}
+				ing2 := getBaseIngress()
+				ing2.Name = "test2"
+				ing2.Annotations = map[string]string{"auth.bilrost.slok.dev/backend": "other-backend"}
+				ing3 := getBaseIngress()
+				ing3.Name = "test3"
+				ing3.Annotations = map[string]string{}
+				ings := &networkingv1.IngressList{Items: []networkingv1.Ingress{*ing1, *ing2, *ing3}}
+				mkr.On("ListIngresses", mock.Anything, "test-ns", map[string]string{}).Once().Return(ings, nil)
+
+				// Only the ingress that uses the backend is enqueued, the handler doesn't reconcile it.
+				me.On("EnqueueIngress", mock.Anything, ing1).Once().Return(nil)
+			},
+		},
+
+		"Failing enqueuing the ingresses should fail the handling.": {
+			objs: []runtime.Object{getAuthBackend(1), getAuthBackend(2)},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, mi *controllermock.HandlerAuthBackendInvalidator, me *controllermock.HandlerIngressEnqueuer) {
+				mi.On("InvalidateAppRegisterer", "test-backend").Once().Return(nil)
+
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{"auth.bilrost.slok.dev/backend": "test-backend"}
+				ings := &networkingv1.IngressList{Items: []networkingv1.Ingress{*ing}}
+				mkr.On("ListIngresses", mock.Anything, "test-ns", map[string]string{}).Once().Return(ings, nil)
+				me.On("EnqueueIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr: true,
+		},
+
+		"Failing listing the ingresses should fail the handling.": {
+			objs: []runtime.Object{getAuthBackend(1), getAuthBackend(2)},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, mi *controllermock.HandlerAuthBackendInvalidator, me *controllermock.HandlerIngressEnqueuer) {
+				mi.On("InvalidateAppRegisterer", "test-backend").Once().Return(nil)
+				mkr.On("ListIngresses", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("wanted error"))
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			mkr := &controllermock.HandlerKubernetesRepository{}
+			mi := &controllermock.HandlerAuthBackendInvalidator{}
+			me := &controllermock.HandlerIngressEnqueuer{}
+			ms := &securitymock.Service{}
+			test.mock(mkr, mi, me)
+
+			// Run.
+			cfg := controller.HandlerConfig{
+				KubernetesRepo:         mkr,
+				SecuritySvc:            ms,
+				AuthBackendInvalidator: mi,
+				IngressEnqueuer:        me,
+				NamespaceFilter:        "test-ns",
+			}
+			h, err := controller.NewHandler(cfg)
+			require.NoError(err)
+			for _, obj := range test.objs {
+				err = h.Handle(context.TODO(), obj)
+			}
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				mkr.AssertExpectations(t)
+				mi.AssertExpectations(t)
+				me.AssertExpectations(t)
+				ms.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestHandlerSecret(t *testing.T) {
+	getSecret := func(version string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "test-secret",
+				Namespace:       "test-ns",
+				ResourceVersion: version,
+			},
+		}
+	}
+
+	keycloakBackend := &authv1.AuthBackend{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-backend", Generation: 1},
+		Spec: authv1.AuthBackendSpec{AuthBackendSource: authv1.AuthBackendSource{
+			Keycloak: &authv1.AuthBackendKeycloak{
+				AdminCredentialsSecretRef: authv1.SecretRef{Name: "test-secret", Namespace: "test-ns"},
+			},
+		}},
+	}
+	dexBackend := &authv1.AuthBackend{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-dex-backend", Generation: 1},
+		Spec: authv1.AuthBackendSpec{AuthBackendSource: authv1.AuthBackendSource{
+			Dex: &authv1.AuthBackendDex{TLS: &authv1.AuthBackendDexTLS{
+				ClientCertSecretRef: &authv1.SecretRef{Name: "test-secret", Namespace: "other-ns"},
+			}},
+		}},
+	}
+	redisIngressAuth := getBaseIngressAuth()
+	redisIngressAuth.Name = "test2"
+	redisIngressAuth.Spec.SessionSettings.Redis = &authv1.SessionRedis{
+		Address:           "redis:6379",
+		PasswordSecretRef: &authv1.LocalSecretKeyRef{Name: "test-secret", Key: "password"},
+	}
+
+	tests := map[string]struct {
+		objs   []runtime.Object
+		mock   func(mkr *controllermock.HandlerKubernetesRepository, me *controllermock.HandlerIngressEnqueuer)
+		expErr bool
+	}{
+		"A secret that is not referenced should be ignored.": {
+			objs: []runtime.Object{dexBackend, getSecret("1"), getSecret("2")},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, me *controllermock.HandlerIngressEnqueuer) {},
+		},
+
+		"A referenced secret seen for the first time should only be tracked.": {
+			objs: []runtime.Object{keycloakBackend, getSecret("1")},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, me *controllermock.HandlerIngressEnqueuer) {},
+		},
+
+		"A referenced secret without changes should not reconcile the ingresses.": {
+			objs: []runtime.Object{keycloakBackend, getSecret("1"), getSecret("1")},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, me *controllermock.HandlerIngressEnqueuer) {},
+		},
+
+		"A secret referenced after being seen should reconcile the ingresses on changes.": {
+			objs: []runtime.Object{getSecret("1"), keycloakBackend, getSecret("2")},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, me *controllermock.HandlerIngressEnqueuer) {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{"auth.bilrost.slok.dev/backend": "test-backend"}
+				ings := &networkingv1.IngressList{Items: []networkingv1.Ingress{*ing}}
+				mkr.On("ListIngresses", mock.Anything, "test-ns", map[string]string{}).Once().Return(ings, nil)
+				me.On("EnqueueIngress", mock.Anything, ing).Once().Return(nil)
+			},
+		},
+
+		"A changed secret should enqueue only the ingresses that use it by the auth backend or the ingress auth.": {
+			objs: []runtime.Object{keycloakBackend, dexBackend, redisIngressAuth, getSecret("1"), getSecret("2")},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, me *controllermock.HandlerIngressEnqueuer) {
+				// The ingress auth handling is not relevant for the secret references tracking.
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test2").Once().Return(nil, fmt.Errorf("wanted error"))
+
+				ing1 := getBaseIngress()
+				ing1.Name = "test1"
+				ing1.Annotations = map[string]string{"auth.bilrost.slok.dev/backend": "test-backend"}
+				ing2 := getBaseIngress()
+				ing2.Name = "test2"
+				ing2.Annotations = map[string]string{"auth.bilrost.slok.dev/backend": "other-backend"}
+				ing3 := getBaseIngress()
+				ing3.Name = "test3"
+				ing3.Annotations = map[string]string{"auth.bilrost.slok.dev/backend": "test-dex-backend"}
+				ing4 := getBaseIngress()
+				ing4.Name = "test2"
+				ing4.Namespace = "other-ns"
+				ing4.Annotations = map[string]string{}
+				ings := &networkingv1.IngressList{Items: []networkingv1.Ingress{*ing1, *ing2, *ing3, *ing4}}
+				mkr.On("ListIngresses", mock.Anything, "test-ns", map[string]string{}).Once().Return(ings, nil)
+
+				me.On("EnqueueIngress", mock.Anything, ing1).Once().Return(nil)
+				me.On("EnqueueIngress", mock.Anything, ing2).Once().Return(nil)
+			},
+		},
+
+		"Failing enqueuing the ingresses should fail the handling.": {
+			objs: []runtime.Object{keycloakBackend, getSecret("1"), getSecret("2")},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, me *controllermock.HandlerIngressEnqueuer) {
+				ing := getBaseIngress()
+				ing.Annotations = map[string]string{"auth.bilrost.slok.dev/backend": "test-backend"}
+				ings := &networkingv1.IngressList{Items: []networkingv1.Ingress{*ing}}
+				mkr.On("ListIngresses", mock.Anything, "test-ns", map[string]string{}).Once().Return(ings, nil)
+				me.On("EnqueueIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr: true,
+		},
+
+		"Failing listing the ingresses should fail the handling.": {
+			objs: []runtime.Object{keycloakBackend, getSecret("1"), getSecret("2")},
+			mock: func(mkr *controllermock.HandlerKubernetesRepository, me *controllermock.HandlerIngressEnqueuer) {
+				mkr.On("ListIngresses", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("wanted error"))
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			mkr := &controllermock.HandlerKubernetesRepository{}
+			me := &controllermock.HandlerIngressEnqueuer{}
+			ms := &securitymock.Service{}
+			test.mock(mkr, me)
+
+			// Run.
+			cfg := controller.HandlerConfig{
+				KubernetesRepo:  mkr,
+				SecuritySvc:     ms,
+				IngressEnqueuer: me,
+				NamespaceFilter: "test-ns",
+			}
+			h, err := controller.NewHandler(cfg)
+			require.NoError(err)
+			for _, obj := range test.objs {
+				err = h.Handle(context.TODO(), obj)
+			}
 
 			// Check.
 			if test.expErr {
 				assert.Error(err)
 			} else if assert.NoError(err) {
 				mkr.AssertExpectations(t)
+				me.AssertExpectations(t)
 				ms.AssertExpectations(t)
 			}
 		})
@@ -9,7 +9,7 @@ import (
 
 	v1 "github.com/slok/bilrost/pkg/apis/auth/v1"
 
-	v1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 )
 
 // HandlerKubernetesRepository is an autogenerated mock type for the HandlerKubernetesRepository type
@@ -17,16 +17,39 @@ type HandlerKubernetesRepository struct {
 	mock.Mock
 }
 
+// GetAuthBackendCR provides a mock function with given fields: ctx, name
+func (_m *HandlerKubernetesRepository) GetAuthBackendCR(ctx context.Context, name string) (*v1.AuthBackend, error) {
+	ret := _m.Called(ctx, name)
+
+	var r0 *v1.AuthBackend
+	if rf, ok := ret.Get(0).(func(context.Context, string) *v1.AuthBackend); ok {
+		r0 = rf(ctx, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*v1.AuthBackend)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetIngress provides a mock function with given fields: ctx, ns, name
-func (_m *HandlerKubernetesRepository) GetIngress(ctx context.Context, ns string, name string) (*v1beta1.Ingress, error) {
+func (_m *HandlerKubernetesRepository) GetIngress(ctx context.Context, ns string, name string) (*networkingv1.Ingress, error) {
 	ret := _m.Called(ctx, ns, name)
 
-	var r0 *v1beta1.Ingress
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) *v1beta1.Ingress); ok {
+	var r0 *networkingv1.Ingress
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *networkingv1.Ingress); ok {
 		r0 = rf(ctx, ns, name)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*v1beta1.Ingress)
+			r0 = ret.Get(0).(*networkingv1.Ingress)
 		}
 	}
 
@@ -63,12 +86,49 @@ func (_m *HandlerKubernetesRepository) GetIngressAuth(ctx context.Context, ns st
 	return r0, r1
 }
 
+// ListIngresses provides a mock function with given fields: ctx, ns, labelSelector
+func (_m *HandlerKubernetesRepository) ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*networkingv1.IngressList, error) {
+	ret := _m.Called(ctx, ns, labelSelector)
+
+	var r0 *networkingv1.IngressList
+	if rf, ok := ret.Get(0).(func(context.Context, string, map[string]string) *networkingv1.IngressList); ok {
+		r0 = rf(ctx, ns, labelSelector)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*networkingv1.IngressList)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, map[string]string) error); ok {
+		r1 = rf(ctx, ns, labelSelector)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateAuthBackendStatus provides a mock function with given fields: ctx, ab
+func (_m *HandlerKubernetesRepository) UpdateAuthBackendStatus(ctx context.Context, ab *v1.AuthBackend) error {
+	ret := _m.Called(ctx, ab)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *v1.AuthBackend) error); ok {
+		r0 = rf(ctx, ab)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // UpdateIngress provides a mock function with given fields: ctx, ingress
-func (_m *HandlerKubernetesRepository) UpdateIngress(ctx context.Context, ingress *v1beta1.Ingress) error {
+func (_m *HandlerKubernetesRepository) UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error {
 	ret := _m.Called(ctx, ingress)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, *v1beta1.Ingress) error); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *networkingv1.Ingress) error); ok {
 		r0 = rf(ctx, ingress)
 	} else {
 		r0 = ret.Error(0)
@@ -76,3 +136,17 @@ func (_m *HandlerKubernetesRepository) UpdateIngress(ctx context.Context, ingres
 
 	return r0
 }
+
+// UpdateIngressAuthStatus provides a mock function with given fields: ctx, ia
+func (_m *HandlerKubernetesRepository) UpdateIngressAuthStatus(ctx context.Context, ia *v1.IngressAuth) error {
+	ret := _m.Called(ctx, ia)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *v1.IngressAuth) error); ok {
+		r0 = rf(ctx, ia)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -9,7 +9,9 @@ import (
 
 	v1 "github.com/slok/bilrost/pkg/apis/auth/v1"
 
-	v1beta1 "k8s.io/api/networking/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+
+	networkingv1 "k8s.io/api/networking/v1"
 
 	watch "k8s.io/apimachinery/pkg/watch"
 )
@@ -19,6 +21,29 @@ type RetrieverKubernetesRepository struct {
 	mock.Mock
 }
 
+// ListAuthBackends provides a mock function with given fields: ctx, labelSelector
+func (_m *RetrieverKubernetesRepository) ListAuthBackends(ctx context.Context, labelSelector map[string]string) (*v1.AuthBackendList, error) {
+	ret := _m.Called(ctx, labelSelector)
+
+	var r0 *v1.AuthBackendList
+	if rf, ok := ret.Get(0).(func(context.Context, map[string]string) *v1.AuthBackendList); ok {
+		r0 = rf(ctx, labelSelector)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*v1.AuthBackendList)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, map[string]string) error); ok {
+		r1 = rf(ctx, labelSelector)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ListIngressAuths provides a mock function with given fields: ctx, ns, labelSelector
 func (_m *RetrieverKubernetesRepository) ListIngressAuths(ctx context.Context, ns string, labelSelector map[string]string) (*v1.IngressAuthList, error) {
 	ret := _m.Called(ctx, ns, labelSelector)
@@ -43,15 +68,15 @@ func (_m *RetrieverKubernetesRepository) ListIngressAuths(ctx context.Context, n
 }
 
 // ListIngresses provides a mock function with given fields: ctx, ns, labelSelector
-func (_m *RetrieverKubernetesRepository) ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*v1beta1.IngressList, error) {
+func (_m *RetrieverKubernetesRepository) ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*networkingv1.IngressList, error) {
 	ret := _m.Called(ctx, ns, labelSelector)
 
-	var r0 *v1beta1.IngressList
-	if rf, ok := ret.Get(0).(func(context.Context, string, map[string]string) *v1beta1.IngressList); ok {
+	var r0 *networkingv1.IngressList
+	if rf, ok := ret.Get(0).(func(context.Context, string, map[string]string) *networkingv1.IngressList); ok {
 		r0 = rf(ctx, ns, labelSelector)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*v1beta1.IngressList)
+			r0 = ret.Get(0).(*networkingv1.IngressList)
 		}
 	}
 
@@ -65,6 +90,52 @@ func (_m *RetrieverKubernetesRepository) ListIngresses(ctx context.Context, ns s
 	return r0, r1
 }
 
+// ListSecretsExcludingTypes provides a mock function with given fields: ctx, ns, types
+func (_m *RetrieverKubernetesRepository) ListSecretsExcludingTypes(ctx context.Context, ns string, types []string) (*corev1.SecretList, error) {
+	ret := _m.Called(ctx, ns, types)
+
+	var r0 *corev1.SecretList
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) *corev1.SecretList); ok {
+		r0 = rf(ctx, ns, types)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*corev1.SecretList)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, []string) error); ok {
+		r1 = rf(ctx, ns, types)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// WatchAuthBackends provides a mock function with given fields: ctx, labelSelector
+func (_m *RetrieverKubernetesRepository) WatchAuthBackends(ctx context.Context, labelSelector map[string]string) (watch.Interface, error) {
+	ret := _m.Called(ctx, labelSelector)
+
+	var r0 watch.Interface
+	if rf, ok := ret.Get(0).(func(context.Context, map[string]string) watch.Interface); ok {
+		r0 = rf(ctx, labelSelector)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(watch.Interface)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, map[string]string) error); ok {
+		r1 = rf(ctx, labelSelector)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // WatchIngressAuths provides a mock function with given fields: ctx, ns, labelSelector
 func (_m *RetrieverKubernetesRepository) WatchIngressAuths(ctx context.Context, ns string, labelSelector map[string]string) (watch.Interface, error) {
 	ret := _m.Called(ctx, ns, labelSelector)
@@ -110,3 +181,26 @@ func (_m *RetrieverKubernetesRepository) WatchIngresses(ctx context.Context, ns
 
 	return r0, r1
 }
+
+// WatchSecretsExcludingTypes provides a mock function with given fields: ctx, ns, types
+func (_m *RetrieverKubernetesRepository) WatchSecretsExcludingTypes(ctx context.Context, ns string, types []string) (watch.Interface, error) {
+	ret := _m.Called(ctx, ns, types)
+
+	var r0 watch.Interface
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string) watch.Interface); ok {
+		r0 = rf(ctx, ns, types)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(watch.Interface)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, []string) error); ok {
+		r1 = rf(ctx, ns, types)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/spotahome/kooper/v2/controller"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/slok/bilrost/internal/log"
+)
+
+// IngressEnqueuerKubernetesRepository is the service to manage k8s resources by the ingress enqueuer.
+type IngressEnqueuerKubernetesRepository interface {
+	GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error)
+}
+
+//go:generate mockery -case underscore -output controllermock -outpkg controllermock -name IngressEnqueuerKubernetesRepository
+
+// IngressEnqueuerConfig is the configuration of the ingress enqueuer.
+type IngressEnqueuerConfig struct {
+	KubernetesRepo IngressEnqueuerKubernetesRepository
+	// Workers is the number of concurrent workers reconciling the enqueued ingresses.
+	Workers int
+	// Retries is the number of times an ingress reconciliation will be retried before giving up.
+	Retries int
+	Logger  log.Logger
+}
+
+func (c *IngressEnqueuerConfig) defaults() error {
+	if c.KubernetesRepo == nil {
+		return fmt.Errorf("kubernetes repository is required")
+	}
+
+	if c.Workers <= 0 {
+		c.Workers = 1
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Dummy
+	}
+	c.Logger = c.Logger.WithKV(log.KV{"service": "controller.IngressEnqueuer"})
+
+	return nil
+}
+
+// IngressEnqueuer enqueues ingresses to be reconciled again out of the ingress controller events
+// (e.g: the auth backend they use has changed).
+//
+// Only the ingress keys are enqueued, the enqueued ingresses are got from the API server before
+// being handled, this way we always reconcile the latest state of the ingress.
+type IngressEnqueuer struct {
+	queue    workqueue.RateLimitingInterface
+	kuberepo IngressEnqueuerKubernetesRepository
+	workers  int
+	retries  int
+	logger   log.Logger
+}
+
+// NewIngressEnqueuer returns a new IngressEnqueuer.
+func NewIngressEnqueuer(cfg IngressEnqueuerConfig) (*IngressEnqueuer, error) {
+	err := cfg.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &IngressEnqueuer{
+		queue:    workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		kuberepo: cfg.KubernetesRepo,
+		workers:  cfg.Workers,
+		retries:  cfg.Retries,
+		logger:   cfg.Logger,
+	}, nil
+}
+
+// EnqueueIngress satisfies HandlerIngressEnqueuer interface.
+func (i *IngressEnqueuer) EnqueueIngress(_ context.Context, ing *networkingv1.Ingress) error {
+	key, err := cache.MetaNamespaceKeyFunc(ing)
+	if err != nil {
+		return fmt.Errorf("could not get ingress key: %w", err)
+	}
+	i.queue.Add(key)
+
+	return nil
+}
+
+// Run handles the enqueued ingresses with the handler until the context is cancelled.
+func (i *IngressEnqueuer) Run(ctx context.Context, h controller.Handler) error {
+	var wg sync.WaitGroup
+	for w := 0; w < i.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i.processNext(ctx, h) {
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	i.queue.ShutDown()
+	wg.Wait()
+
+	return nil
+}
+
+// processNext handles the next enqueued ingress, returns false when the queue has been shut down.
+func (i *IngressEnqueuer) processNext(ctx context.Context, h controller.Handler) bool {
+	item, shutdown := i.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer i.queue.Done(item)
+
+	key := item.(string)
+	logger := i.logger.WithKV(log.KV{"object-key": key})
+
+	err := i.handle(ctx, h, key)
+	switch {
+	case err == nil:
+		i.queue.Forget(item)
+	case i.queue.NumRequeues(item) < i.retries:
+		logger.Warningf("error on enqueued ingress processing, retrying: %s", err)
+		i.queue.AddRateLimited(item)
+	default:
+		logger.Errorf("error on enqueued ingress processing: %s", err)
+		i.queue.Forget(item)
+	}
+
+	return true
+}
+
+func (i *IngressEnqueuer) handle(ctx context.Context, h controller.Handler, key string) error {
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return fmt.Errorf("invalid ingress key: %w", err)
+	}
+
+	ing, err := i.kuberepo.GetIngress(ctx, ns, name)
+	if err != nil {
+		// Deleted after being enqueued, nothing to reconcile.
+		if kubeerrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("could not get ingress: %w", err)
+	}
+
+	return h.Handle(ctx, ing)
+}
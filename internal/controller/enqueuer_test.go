@@ -0,0 +1,89 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	koopercontrollermock "github.com/spotahome/kooper/v2/controller/controllermock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/slok/bilrost/internal/controller"
+	"github.com/slok/bilrost/internal/controller/controllermock"
+)
+
+func TestIngressEnqueuer(t *testing.T) {
+	staleIng := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "test1", Namespace: "test-ns", ResourceVersion: "1"}}
+	latestIng := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "test1", Namespace: "test-ns", ResourceVersion: "2"}}
+
+	tests := map[string]struct {
+		mock func(mkr *controllermock.IngressEnqueuerKubernetesRepository, mh *koopercontrollermock.Handler, done chan struct{})
+	}{
+		"The enqueued ingresses should be handled with their latest state.": {
+			mock: func(mkr *controllermock.IngressEnqueuerKubernetesRepository, mh *koopercontrollermock.Handler, done chan struct{}) {
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test1").Once().Return(latestIng, nil)
+				mh.On("Handle", mock.Anything, latestIng).Once().Return(nil).Run(func(mock.Arguments) { close(done) })
+			},
+		},
+
+		"The enqueued ingresses that have been deleted should be ignored.": {
+			mock: func(mkr *controllermock.IngressEnqueuerKubernetesRepository, mh *koopercontrollermock.Handler, done chan struct{}) {
+				notFound := kubeerrors.NewNotFound(schema.GroupResource{}, "test1")
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test1").Once().Return(nil, notFound).Run(func(mock.Arguments) { close(done) })
+			},
+		},
+
+		"The failed ingress handlings should be retried.": {
+			mock: func(mkr *controllermock.IngressEnqueuerKubernetesRepository, mh *koopercontrollermock.Handler, done chan struct{}) {
+				mkr.On("GetIngress", mock.Anything, "test-ns", "test1").Twice().Return(latestIng, nil)
+				mh.On("Handle", mock.Anything, latestIng).Once().Return(fmt.Errorf("wanted error"))
+				mh.On("Handle", mock.Anything, latestIng).Once().Return(nil).Run(func(mock.Arguments) { close(done) })
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			done := make(chan struct{})
+			mkr := &controllermock.IngressEnqueuerKubernetesRepository{}
+			mh := &koopercontrollermock.Handler{}
+			test.mock(mkr, mh, done)
+
+			// Prepare.
+			e, err := controller.NewIngressEnqueuer(controller.IngressEnqueuerConfig{
+				KubernetesRepo: mkr,
+				Retries:        1,
+			})
+			require.NoError(err)
+			ctx, cancel := context.WithCancel(context.Background())
+			runErr := make(chan error)
+			go func() { runErr <- e.Run(ctx, mh) }()
+
+			// Execute.
+			err = e.EnqueueIngress(context.TODO(), staleIng)
+			require.NoError(err)
+
+			// Check.
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				require.FailNow("timeout waiting for the enqueued ingress handling")
+			}
+			cancel()
+			assert.NoError(<-runErr)
+			mkr.AssertExpectations(t)
+			mh.AssertExpectations(t)
+		})
+	}
+}
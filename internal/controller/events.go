@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/security"
+	authv1 "github.com/slok/bilrost/pkg/apis/auth/v1"
+)
+
+// Events reasons.
+const (
+	reasonInvalidIngress            = "InvalidIngress"
+	reasonBackupStored              = "BackupStored"
+	reasonBackupFailed              = "BackupFailed"
+	reasonProxyProvisionFailed      = "ProxyProvisionFailed"
+	reasonIngressPointToProxyFailed = "IngressPointToProxyFailed"
+	reasonAuthBackendMigrated       = "AuthBackendMigrated"
+	reasonAuthBackendMigrateFailed  = "AuthBackendMigrationFailed"
+)
+
+// reportSecured reports the result of the securing process using events and the
+// status of the involved resources.
+func (h handler) reportSecured(ctx context.Context, logger log.Logger, authBackendID string, ing *networkingv1.Ingress, ia *authv1.IngressAuth, status *security.AppSecurityStatus, secErr error) {
+	if status == nil {
+		status = &security.AppSecurityStatus{}
+	}
+
+	var ab *authv1.AuthBackend
+	if authBackendID != "" {
+		var err error
+		ab, err = h.repo.GetAuthBackendCR(ctx, authBackendID)
+		if err != nil {
+			logger.Debugf("could not get auth backend to report: %s", err)
+		}
+	}
+
+	h.appRegFailures.track(ing.Namespace+"/"+ing.Name, authBackendID, *status, secErr)
+	h.recordSecuredEvents(ing, ia, ab, *status, secErr)
+	h.ensureSecuredStatus(ctx, logger, ia, ab, *status, secErr)
+}
+
+// recordSecuredEvents records an event on the ingress for each of the securing process steps that
+// succeeded, and the failed step if any. The IngressAuth and the AuthBackend only receive the
+// events that are relevant to them.
+//
+// The ingresses are reconciled periodically, so we only record the events of the steps that changed
+// since the previously reported result, this way we don't flood the events on every resync.
+func (h handler) recordSecuredEvents(ing *networkingv1.Ingress, ia *authv1.IngressAuth, ab *authv1.AuthBackend, status security.AppSecurityStatus, secErr error) {
+	report := securedReport{status: status}
+	if secErr != nil {
+		report.reason = securedFailureReason(status)
+		report.err = secErr.Error()
+	}
+	prev, ok := h.securedReports.swap(ing.Namespace+"/"+ing.Name, report)
+	if !ok {
+		prev = previousSecuredReport(ia)
+	}
+	prevStatus := prev.status
+
+	if status.BackendRegistered && (!prevStatus.BackendRegistered || status.ClientID != prevStatus.ClientID) {
+		h.eventRecorder.Event(ing, corev1.EventTypeNormal, reasonAppRegistered, fmt.Sprintf("app registered on auth backend with %q client ID", status.ClientID))
+	}
+	if status.BackupStored && !prevStatus.BackupStored {
+		h.eventRecorder.Event(ing, corev1.EventTypeNormal, reasonBackupStored, "original ingress routes backup stored")
+	}
+	if status.ProxyProvisioned && (!prevStatus.ProxyProvisioned || status.ProxyServiceName != prevStatus.ProxyServiceName) {
+		msg := "auth provisioned on the ingress controller"
+		if status.ProxyServiceName != "" {
+			msg = fmt.Sprintf("auth proxy provisioned with %q service", status.ProxyServiceName)
+		}
+		h.eventRecorder.Event(ing, corev1.EventTypeNormal, reasonProxyProvisioned, msg)
+	}
+	if status.IngressPointedToProxy && !prevStatus.IngressPointedToProxy {
+		h.eventRecorder.Event(ing, corev1.EventTypeNormal, reasonIngressPointed, "ingress routes pointed to the auth proxy")
+	}
+	// The migration only happens once, the next reconciliations don't have a previous auth backend.
+	if status.AuthBackendMigrated {
+		msg := fmt.Sprintf("app migrated from %q auth backend to %q auth backend", status.PreviousAuthBackendID, ing.Annotations[BackendAnnotation])
+		h.eventRecorder.Event(ing, corev1.EventTypeNormal, reasonAuthBackendMigrated, msg)
+		if ia != nil {
+			h.eventRecorder.Event(ia, corev1.EventTypeNormal, reasonAuthBackendMigrated, msg)
+		}
+	}
+
+	if secErr == nil {
+		if ia != nil && !prev.secured() {
+			h.eventRecorder.Event(ia, corev1.EventTypeNormal, reasonSecured, "ingress secured")
+		}
+		return
+	}
+
+	if report.reason == prev.reason && report.err == prev.err {
+		return
+	}
+
+	msg := fmt.Sprintf("could not secure the ingress: %s", secErr)
+	h.eventRecorder.Event(ing, corev1.EventTypeWarning, report.reason, msg)
+	if ia != nil {
+		h.eventRecorder.Event(ia, corev1.EventTypeWarning, report.reason, msg)
+	}
+	if ab != nil && report.reason == reasonAppRegistrationFailed {
+		h.eventRecorder.Event(ab, corev1.EventTypeWarning, report.reason, fmt.Sprintf("could not register %s/%s ingress app: %s", ing.Namespace, ing.Name, secErr))
+	}
+}
+
+// recordUnsecuredEvents records the result of the security rollback process on the ingress
+// and the IngressAuth (if present).
+func (h handler) recordUnsecuredEvents(ing *networkingv1.Ingress, ia *authv1.IngressAuth, rollbackErr error) {
+	eventType, reason, msg := corev1.EventTypeNormal, reasonUnsecured, "ingress security rollback completed"
+	if rollbackErr != nil {
+		eventType, reason, msg = corev1.EventTypeWarning, reasonUnsecureFailed, fmt.Sprintf("could not rollback the ingress security: %s", rollbackErr)
+	}
+
+	h.eventRecorder.Event(ing, eventType, reason, msg)
+	if ia != nil {
+		h.eventRecorder.Event(ia, eventType, reason, msg)
+	}
+
+	// If the app is secured again, all the steps will be reported again.
+	h.securedReports.forget(ing.Namespace + "/" + ing.Name)
+}
+
+// securedFailureReason returns the reason of the failure based on the first step of the
+// securing process that didn't succeed.
+func securedFailureReason(status security.AppSecurityStatus) string {
+	switch {
+	case !status.BackendRegistered:
+		return reasonAppRegistrationFailed
+	case !status.BackupStored:
+		return reasonBackupFailed
+	case !status.ProxyProvisioned:
+		return reasonProxyProvisionFailed
+	case !status.IngressPointedToProxy:
+		return reasonIngressPointToProxyFailed
+	case status.PreviousAuthBackendID != "" && !status.AuthBackendMigrated:
+		return reasonAuthBackendMigrateFailed
+	default:
+		return reasonSecureFailed
+	}
+}
+
+// securedReport is the result of the securing process of an app that has been reported.
+type securedReport struct {
+	// known is false when we don't know the previous result (e.g: the first reconciliation).
+	known  bool
+	status security.AppSecurityStatus
+	reason string
+	err    string
+}
+
+func (s securedReport) secured() bool { return s.known && s.err == "" }
+
+// previousSecuredReport returns the previously reported result based on the IngressAuth status, this
+// way we don't record again all the events when the controller restarts.
+func previousSecuredReport(ia *authv1.IngressAuth) securedReport {
+	if ia == nil || meta.FindStatusCondition(ia.Status.Conditions, authv1.IngressAuthConditionReady) == nil {
+		return securedReport{}
+	}
+
+	conds := ia.Status.Conditions
+	status := security.AppSecurityStatus{
+		BackendRegistered:     meta.IsStatusConditionTrue(conds, authv1.IngressAuthConditionBackendRegistered),
+		ClientID:              ia.Status.ClientID,
+		ProxyProvisioned:      meta.IsStatusConditionTrue(conds, authv1.IngressAuthConditionProxyProvisioned),
+		ProxyServiceName:      ia.Status.ProxyServiceName,
+		IngressPointedToProxy: meta.IsStatusConditionTrue(conds, authv1.IngressAuthConditionIngressPointedToProxy),
+	}
+	// The backup is stored before provisioning the proxy.
+	status.BackupStored = status.ProxyProvisioned
+
+	report := securedReport{known: true, status: status}
+	if !meta.IsStatusConditionTrue(conds, authv1.IngressAuthConditionReady) {
+		report.reason = securedFailureReason(status)
+		report.err = ia.Status.LastError
+	}
+
+	return report
+}
+
+// securedReports tracks the last reported securing result of the apps.
+type securedReports struct {
+	reports map[string]securedReport
+	mu      sync.Mutex
+}
+
+func newSecuredReports() *securedReports {
+	return &securedReports{reports: map[string]securedReport{}}
+}
+
+// swap tracks the new report of the app and returns the previous one, if any.
+func (s *securedReports) swap(id string, report securedReport) (securedReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.reports[id]
+	report.known = true
+	s.reports[id] = report
+
+	return prev, ok
+}
+
+// forget removes the tracked report of the app.
+func (s *securedReports) forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.reports, id)
+}
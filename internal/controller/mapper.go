@@ -2,39 +2,68 @@ package controller
 
 import (
 	"fmt"
+	"strconv"
 
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 
 	"github.com/slok/bilrost/internal/model"
 	authv1 "github.com/slok/bilrost/pkg/apis/auth/v1"
 )
 
 // maps an ingress and a ingress auth to a model, is safe to pass ingress auth `nil`.
-func mapToModel(ing *networkingv1beta1.Ingress, ia *authv1.IngressAuth) model.App {
+func mapToModel(ing *networkingv1.Ingress, ia *authv1.IngressAuth) model.App {
 	app := mapIngressToModel(ing)
 	app.ProxySettings = mapIngressAuthToModel(ia)
+	if ia != nil {
+		app.ClientCredentialsKey = ia.Spec.AuthSettings.ClientCredentialsKey
+		if ia.Spec.AuthSettings.SecretRotation != nil {
+			app.SecretMaxAge = ia.Spec.AuthSettings.SecretRotation.MaxAge.Duration
+		}
+	}
 
 	return app
 }
 
 // mapIngressToModel maps the base data of the app, this data is obtained from the ingress.
-func mapIngressToModel(ing *networkingv1beta1.Ingress) model.App {
+func mapIngressToModel(ing *networkingv1.Ingress) model.App {
+	routes := []model.IngressRoute{}
+	for _, rule := range ing.Spec.Rules {
+		for _, path := range rule.HTTP.Paths {
+			routes = append(routes, model.IngressRoute{
+				Host: rule.Host,
+				Path: path.Path,
+				Upstream: model.KubernetesService{
+					Name:           path.Backend.Service.Name,
+					Namespace:      ing.Namespace,
+					PortOrPortName: mapServiceBackendPortToModel(path.Backend.Service.Port),
+				},
+			})
+		}
+	}
+
 	return model.App{
 		ID:            fmt.Sprintf("%s/%s", ing.Namespace, ing.Name),
-		AuthBackendID: ing.Annotations[backendAnnotation],
-		Host:          ing.Spec.Rules[0].Host,
+		AuthBackendID: ing.Annotations[BackendAnnotation],
 		Ingress: model.KubernetesIngress{
-			Name:      ing.Name,
-			Namespace: ing.Namespace,
-			Upstream: model.KubernetesService{
-				Name:           ing.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName,
-				Namespace:      ing.Namespace,
-				PortOrPortName: ing.Spec.Rules[0].HTTP.Paths[0].Backend.ServicePort.String(),
-			},
+			Name:        ing.Name,
+			Namespace:   ing.Namespace,
+			UID:         string(ing.UID),
+			Annotations: ing.Annotations,
+			Routes:      routes,
 		},
 	}
 }
 
+// mapServiceBackendPortToModel maps the port of an ingress service backend, the port
+// can be a port name or a port number.
+func mapServiceBackendPortToModel(port networkingv1.ServiceBackendPort) string {
+	if port.Name != "" {
+		return port.Name
+	}
+
+	return strconv.Itoa(int(port.Number))
+}
+
 // mapIngressAuthToModel maps proxy settings based data.
 // TODO(slok): Load defaults from cmd start.
 func mapIngressAuthToModel(ia *authv1.IngressAuth) model.ProxySettings {
@@ -45,6 +74,21 @@ func mapIngressAuthToModel(ia *authv1.IngressAuth) model.ProxySettings {
 	// Set global proxy settings.
 	ps := model.ProxySettings{
 		Scopes: ia.Spec.AuthSettings.ScopeOrClaims,
+		AccessControl: model.AccessControl{
+			AllowedGroups:       ia.Spec.AuthSettings.AllowedGroups,
+			AllowedEmails:       ia.Spec.AuthSettings.AllowedEmails,
+			AllowedEmailDomains: ia.Spec.AuthSettings.AllowedEmailDomains,
+			RequiredClaims:      ia.Spec.AuthSettings.RequiredClaims,
+		},
+		SkipAuthRoutes: mapSkipAuthRoutesToModel(ia.Spec.AuthSettings.SkipAuthRoutes),
+		Session:        mapSessionSettingsToModel(ia.Spec.SessionSettings),
+		Upstream: model.UpstreamSettings{
+			Scheme:             ia.Spec.UpstreamSettings.Scheme,
+			InsecureSkipVerify: ia.Spec.UpstreamSettings.InsecureSkipVerify,
+		},
+	}
+	if ia.Spec.UpstreamSettings.CABundle != "" {
+		ps.Upstream.CA = []byte(ia.Spec.UpstreamSettings.CABundle)
 	}
 
 	// Set specific proxy settings.
@@ -56,7 +100,92 @@ func mapIngressAuthToModel(ia *authv1.IngressAuth) model.ProxySettings {
 			Replicas:  ia.Spec.AuthProxySource.Oauth2Proxy.Replicas,
 			Resources: ia.Spec.AuthProxySource.Oauth2Proxy.Resources,
 		}
+
+	case ia.Spec.AuthProxySource.BilrostProxy != nil:
+		ps.BilrostProxy = &model.BilrostProxySettings{
+			Oauth2ProxySettings: model.Oauth2ProxySettings{
+				Image:     ia.Spec.AuthProxySource.BilrostProxy.Image,
+				Replicas:  ia.Spec.AuthProxySource.BilrostProxy.Replicas,
+				Resources: ia.Spec.AuthProxySource.BilrostProxy.Resources,
+			},
+			PassAccessToken: ia.Spec.AuthProxySource.BilrostProxy.PassAccessToken,
+		}
+
+	case ia.Spec.AuthProxySource.Nginx != nil:
+		ps.Nginx = &model.NginxProxySettings{
+			Oauth2ProxySettings: model.Oauth2ProxySettings{
+				Image:     ia.Spec.AuthProxySource.Nginx.Image,
+				Replicas:  ia.Spec.AuthProxySource.Nginx.Replicas,
+				Resources: ia.Spec.AuthProxySource.Nginx.Resources,
+			},
+			ResponseHeaders: ia.Spec.AuthProxySource.Nginx.ResponseHeaders,
+		}
+
+	case ia.Spec.AuthProxySource.Traefik != nil:
+		ps.Traefik = &model.TraefikProxySettings{
+			Oauth2ProxySettings: model.Oauth2ProxySettings{
+				Image:     ia.Spec.AuthProxySource.Traefik.Image,
+				Replicas:  ia.Spec.AuthProxySource.Traefik.Replicas,
+				Resources: ia.Spec.AuthProxySource.Traefik.Resources,
+			},
+			AuthResponseHeaders: ia.Spec.AuthProxySource.Traefik.AuthResponseHeaders,
+		}
+
+	case ia.Spec.AuthProxySource.Skipper != nil:
+		ps.Skipper = &model.SkipperProxySettings{
+			Claims:          ia.Spec.AuthProxySource.Skipper.Claims,
+			UserInfoFields:  ia.Spec.AuthProxySource.Skipper.UserInfoFields,
+			UpstreamHeaders: ia.Spec.AuthProxySource.Skipper.UpstreamHeaders,
+		}
 	}
 
 	return ps
 }
+
+func mapSkipAuthRoutesToModel(routes []authv1.SkipAuthRoute) []model.SkipAuthRoute {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	mRoutes := make([]model.SkipAuthRoute, 0, len(routes))
+	for _, r := range routes {
+		mRoutes = append(mRoutes, model.SkipAuthRoute{
+			PathRegex: r.Path,
+			Methods:   r.Methods,
+		})
+	}
+
+	return mRoutes
+}
+
+// mapSessionSettingsToModel maps the session settings, the cookie is secure unless is disabled explicitly.
+func mapSessionSettingsToModel(ss authv1.SessionSettings) model.SessionSettings {
+	session := model.SessionSettings{
+		CallbackPath: ss.CallbackPath,
+		Cookie: model.SessionCookie{
+			Name:     ss.Cookie.Name,
+			Domain:   ss.Cookie.Domain,
+			SameSite: ss.Cookie.SameSite,
+			Insecure: ss.Cookie.Secure != nil && !*ss.Cookie.Secure,
+		},
+		Store: model.SessionStore(ss.Store),
+	}
+	if ss.Cookie.Expire != nil {
+		session.Cookie.Expire = ss.Cookie.Expire.Duration
+	}
+	if ss.Cookie.Refresh != nil {
+		session.Cookie.Refresh = ss.Cookie.Refresh.Duration
+	}
+	if ss.Redis != nil {
+		session.Redis = model.SessionRedis{
+			Address: ss.Redis.Address,
+			Image:   ss.Redis.Image,
+		}
+		if ss.Redis.PasswordSecretRef != nil {
+			session.Redis.PasswordSecretName = ss.Redis.PasswordSecretRef.Name
+			session.Redis.PasswordSecretKey = ss.Redis.PasswordSecretRef.Key
+		}
+	}
+
+	return session
+}
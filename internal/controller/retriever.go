@@ -4,7 +4,8 @@ import (
 	"context"
 
 	"github.com/spotahome/kooper/v2/controller"
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
@@ -15,10 +16,14 @@ import (
 
 // RetrieverKubernetesRepository is the service to manage k8s resources by the Kubernetes retrievers.
 type RetrieverKubernetesRepository interface {
-	ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*networkingv1beta1.IngressList, error)
+	ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*networkingv1.IngressList, error)
 	WatchIngresses(ctx context.Context, ns string, labelSelector map[string]string) (watch.Interface, error)
 	ListIngressAuths(ctx context.Context, ns string, labelSelector map[string]string) (*authv1.IngressAuthList, error)
 	WatchIngressAuths(ctx context.Context, ns string, labelSelector map[string]string) (watch.Interface, error)
+	ListAuthBackends(ctx context.Context, labelSelector map[string]string) (*authv1.AuthBackendList, error)
+	WatchAuthBackends(ctx context.Context, labelSelector map[string]string) (watch.Interface, error)
+	ListSecretsExcludingTypes(ctx context.Context, ns string, types []string) (*corev1.SecretList, error)
+	WatchSecretsExcludingTypes(ctx context.Context, ns string, types []string) (watch.Interface, error)
 }
 
 //go:generate mockery -case underscore -output controllermock -outpkg controllermock -name RetrieverKubernetesRepository
@@ -46,3 +51,35 @@ func NewIngressAuthRetriever(ns string, kuberepo RetrieverKubernetesRepository)
 		},
 	})
 }
+
+// NewAuthBackendRetriever returns the retriever for auth backend CR events.
+func NewAuthBackendRetriever(kuberepo RetrieverKubernetesRepository) controller.Retriever {
+	return controller.MustRetrieverFromListerWatcher(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return kuberepo.ListAuthBackends(context.TODO(), map[string]string{})
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return kuberepo.WatchAuthBackends(context.TODO(), map[string]string{})
+		},
+	})
+}
+
+// ignoredSecretTypes are the secret types that are not used by the auth backends and the ingress
+// auths, these are the most common and the largest ones, so we don't watch them.
+var ignoredSecretTypes = []string{
+	string(corev1.SecretTypeServiceAccountToken),
+	"helm.sh/release.v1",
+}
+
+// NewSecretRetriever returns the retriever for secret events, the secrets are watched on all
+// the namespaces because the auth backends can reference secrets of any namespace.
+func NewSecretRetriever(kuberepo RetrieverKubernetesRepository) controller.Retriever {
+	return controller.MustRetrieverFromListerWatcher(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return kuberepo.ListSecretsExcludingTypes(context.TODO(), "", ignoredSecretTypes)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return kuberepo.WatchSecretsExcludingTypes(context.TODO(), "", ignoredSecretTypes)
+		},
+	})
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/slok/bilrost/internal/log"
+	authv1 "github.com/slok/bilrost/pkg/apis/auth/v1"
+)
+
+// handleSecret propagates the changes of the secrets referenced by the auth backends (e.g: Dex TLS
+// certificates, admin credentials...) and the ingress auths (e.g: Redis password) to the ingresses
+// that depend on them by enqueuing them on the ingress enqueuer, this way the rotated secrets
+// are used without waiting for the resync interval.
+//
+// The referenced secrets are looked up on the references tracked by the auth backend and ingress
+// auth handlings, so the secrets that are not referenced don't make any API call. The first time
+// we see a secret we only track it, all the ingresses are reconciled when the controller starts.
+func (h handler) handleSecret(ctx context.Context, secret *corev1.Secret) error {
+	logger := h.logger.WithKV(log.KV{"obj-ns": secret.Namespace, "obj-name": secret.Name})
+
+	id := secret.Namespace + "/" + secret.Name
+	if !h.secretVersions.changed(id, secret.ResourceVersion) {
+		logger.Debugf("secret not changed, nothing to do here...")
+		return nil
+	}
+
+	authBackends, ingressAuths := h.secretRefs.referencedBy(secret.Namespace, secret.Name)
+	if len(authBackends) == 0 && len(ingressAuths) == 0 {
+		logger.Debugf("secret not referenced, nothing to do here...")
+		return nil
+	}
+
+	logger.Infof("referenced secret changed, enqueuing its ingresses...")
+
+	ings, err := h.repo.ListIngresses(ctx, h.ingressesNamespace, map[string]string{})
+	if err != nil {
+		h.secretVersions.forget(id)
+		return fmt.Errorf("could not list ingresses: %w", err)
+	}
+
+	errs := []string{}
+	for i := range ings.Items {
+		ing := &ings.Items[i]
+		byAuthBackend := authBackends[ing.Annotations[BackendAnnotation]]
+		byIngressAuth := ingressAuths[ing.Namespace+"/"+ing.Name]
+		if !byAuthBackend && !byIngressAuth {
+			continue
+		}
+
+		err := h.ingEnqueuer.EnqueueIngress(ctx, ing)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s/%s: %s", ing.Namespace, ing.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		// Retry on the next secret handling.
+		h.secretVersions.forget(id)
+		return fmt.Errorf("could not enqueue %d ingresses: %s", len(errs), strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// secretReferences is a reverse index of the secrets referenced by the auth backends and the
+// ingress auths.
+type secretReferences struct {
+	// authBackends are the secrets (`ns/name`) referenced by each auth backend.
+	authBackends map[string][]string
+	// ingressAuths are the secrets (`ns/name`) referenced by each ingress auth (`ns/name`).
+	ingressAuths map[string][]string
+	mu           sync.Mutex
+}
+
+func newSecretReferences() *secretReferences {
+	return &secretReferences{
+		authBackends: map[string][]string{},
+		ingressAuths: map[string][]string{},
+	}
+}
+
+// trackAuthBackend replaces the tracked secret references of the auth backend.
+func (s *secretReferences) trackAuthBackend(ab *authv1.AuthBackend) {
+	refs := []string{}
+	spec := ab.Spec
+	switch {
+	case spec.Dex != nil && spec.Dex.TLS != nil:
+		if r := spec.Dex.TLS.CASecretRef; r != nil {
+			refs = append(refs, r.Namespace+"/"+r.Name)
+		}
+		if r := spec.Dex.TLS.ClientCertSecretRef; r != nil {
+			refs = append(refs, r.Namespace+"/"+r.Name)
+		}
+	case spec.OIDCDynamicRegistration != nil && spec.OIDCDynamicRegistration.InitialAccessTokenSecretRef != nil:
+		r := spec.OIDCDynamicRegistration.InitialAccessTokenSecretRef
+		refs = append(refs, r.Namespace+"/"+r.Name)
+	case spec.Auth0 != nil:
+		r := spec.Auth0.ManagementCredentialsSecretRef
+		refs = append(refs, r.Namespace+"/"+r.Name)
+	case spec.Keycloak != nil:
+		r := spec.Keycloak.AdminCredentialsSecretRef
+		refs = append(refs, r.Namespace+"/"+r.Name)
+	case spec.StaticOIDC != nil:
+		r := spec.StaticOIDC.ClientsSecretRef
+		refs = append(refs, r.Namespace+"/"+r.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authBackends[ab.Name] = refs
+}
+
+// trackIngressAuth replaces the tracked secret references of the ingress auth.
+func (s *secretReferences) trackIngressAuth(ia *authv1.IngressAuth) {
+	refs := []string{}
+	if r := ia.Spec.SessionSettings.Redis; r != nil && r.PasswordSecretRef != nil {
+		refs = append(refs, ia.Namespace+"/"+r.PasswordSecretRef.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ingressAuths[ia.Namespace+"/"+ia.Name] = refs
+}
+
+// referencedBy returns the auth backends and the ingress auths (`ns/name`) that reference the secret.
+func (s *secretReferences) referencedBy(ns, name string) (authBackends, ingressAuths map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := ns + "/" + name
+	authBackends = map[string]bool{}
+	for ab, refs := range s.authBackends {
+		if containsString(refs, id) {
+			authBackends[ab] = true
+		}
+	}
+
+	ingressAuths = map[string]bool{}
+	for ia, refs := range s.ingressAuths {
+		if containsString(refs, id) {
+			ingressAuths[ia] = true
+		}
+	}
+
+	return authBackends, ingressAuths
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceVersions tracks the last handled resource version of objects.
+type resourceVersions struct {
+	versions map[string]string
+	mu       sync.Mutex
+}
+
+func newResourceVersions() *resourceVersions {
+	return &resourceVersions{versions: map[string]string{}}
+}
+
+// changed returns true if the resource version of the object is different from the last one
+// tracked, the first time an object is tracked is not considered a change.
+func (r *resourceVersions) changed(id, version string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last, ok := r.versions[id]
+	r.versions[id] = version
+
+	return ok && last != version
+}
+
+// forget marks the object resource version as unhandled so the next time is considered a change.
+func (r *resourceVersions) forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.versions[id] = ""
+}
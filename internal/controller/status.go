@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/security"
+	authv1 "github.com/slok/bilrost/pkg/apis/auth/v1"
+)
+
+// Status conditions reasons.
+const (
+	reasonSecured               = "Secured"
+	reasonSecureFailed          = "SecureFailed"
+	reasonUnsecured             = "Unsecured"
+	reasonUnsecureFailed        = "UnsecureFailed"
+	reasonAppRegistered         = "AppRegistered"
+	reasonAppNotRegistered      = "AppNotRegistered"
+	reasonProxyProvisioned      = "ProxyProvisioned"
+	reasonProxyNotProvisioned   = "ProxyNotProvisioned"
+	reasonIngressPointed        = "IngressPointedToProxy"
+	reasonIngressNotPointed     = "IngressNotPointedToProxy"
+	reasonAppRegistrationFailed = "AppRegistrationFailed"
+	reasonTLSHandshakeFailed    = "TLSHandshakeFailed"
+	reasonInvalidSettings       = "InvalidSettings"
+)
+
+// ensureSecuredStatus sets the status of the IngressAuth and the AuthBackend used (if present)
+// based on the result of the securing process.
+//
+// Failing to set the status will not fail the handling, the status is informative and will be set
+// again on the next reconciliation.
+//
+// The AuthBackend status is based on the tracked app registration failures of all its apps.
+func (h handler) ensureSecuredStatus(ctx context.Context, logger log.Logger, ia *authv1.IngressAuth, ab *authv1.AuthBackend, status security.AppSecurityStatus, secErr error) {
+	if ia != nil {
+		newStatus := newSecuredIngressAuthStatus(ia, status, secErr)
+		err := h.ensureIngressAuthStatus(ctx, ia, newStatus)
+		if err != nil {
+			logger.Warningf("could not set ingress auth status: %s", err)
+		}
+	}
+
+	if ab != nil {
+		newStatus := newAuthBackendStatus(ab, h.appRegFailures.byAuthBackend(ab.Name))
+		if reflect.DeepEqual(ab.Status, newStatus) {
+			return
+		}
+		ab = ab.DeepCopy()
+		ab.Status = newStatus
+		err := h.repo.UpdateAuthBackendStatus(ctx, ab)
+		if err != nil {
+			logger.Warningf("could not set auth backend status: %s", err)
+		}
+	}
+}
+
+// ensureUnsecuredStatus sets the status of the IngressAuth (if present) based on the result
+// of the security rollback process.
+func (h handler) ensureUnsecuredStatus(ctx context.Context, logger log.Logger, ia *authv1.IngressAuth, rollbackErr error) {
+	if ia == nil {
+		return
+	}
+
+	newStatus := newUnsecuredIngressAuthStatus(ia, rollbackErr)
+	err := h.ensureIngressAuthStatus(ctx, ia, newStatus)
+	if err != nil {
+		logger.Warningf("could not set ingress auth status: %s", err)
+	}
+}
+
+// ensureIngressAuthStatus only updates the status if it has changed, this way we don't trigger
+// new IngressAuth events on every reconciliation.
+func (h handler) ensureIngressAuthStatus(ctx context.Context, ia *authv1.IngressAuth, status authv1.IngressAuthStatus) error {
+	if reflect.DeepEqual(ia.Status, status) {
+		return nil
+	}
+
+	ia = ia.DeepCopy()
+	ia.Status = status
+	return h.repo.UpdateIngressAuthStatus(ctx, ia)
+}
+
+func newSecuredIngressAuthStatus(ia *authv1.IngressAuth, status security.AppSecurityStatus, secErr error) authv1.IngressAuthStatus {
+	res := *ia.Status.DeepCopy()
+	res.ObservedGeneration = ia.Generation
+	res.LastError = ""
+	if secErr != nil {
+		res.LastError = secErr.Error()
+	}
+
+	// Don't lose the already known data if we failed before getting it again.
+	if status.ClientID != "" {
+		res.ClientID = status.ClientID
+	}
+	if status.ProxyServiceName != "" {
+		res.ProxyServiceName = status.ProxyServiceName
+	}
+
+	gen := ia.Generation
+	setCondition(&res.Conditions, gen, authv1.IngressAuthConditionBackendRegistered, status.BackendRegistered, reasonAppRegistered, reasonAppNotRegistered, "")
+	setCondition(&res.Conditions, gen, authv1.IngressAuthConditionProxyProvisioned, status.ProxyProvisioned, reasonProxyProvisioned, reasonProxyNotProvisioned, "")
+	setCondition(&res.Conditions, gen, authv1.IngressAuthConditionIngressPointedToProxy, status.IngressPointedToProxy, reasonIngressPointed, reasonIngressNotPointed, "")
+	// The invalid settings will fail until the user fixes them, give them their own reason so they
+	// are easy to spot.
+	notOKReason := reasonSecureFailed
+	if errors.Is(secErr, security.ErrInvalidSettings) {
+		notOKReason = reasonInvalidSettings
+	}
+	setCondition(&res.Conditions, gen, authv1.IngressAuthConditionReady, secErr == nil, reasonSecured, notOKReason, res.LastError)
+
+	return res
+}
+
+func newUnsecuredIngressAuthStatus(ia *authv1.IngressAuth, rollbackErr error) authv1.IngressAuthStatus {
+	res := *ia.Status.DeepCopy()
+	res.ObservedGeneration = ia.Generation
+	gen := ia.Generation
+
+	// If we failed, we don't know what has been rolled back, only set the error.
+	if rollbackErr != nil {
+		res.LastError = rollbackErr.Error()
+		setCondition(&res.Conditions, gen, authv1.IngressAuthConditionReady, false, "", reasonUnsecureFailed, res.LastError)
+		return res
+	}
+
+	res.LastError = ""
+	res.ClientID = ""
+	res.ProxyServiceName = ""
+	setCondition(&res.Conditions, gen, authv1.IngressAuthConditionBackendRegistered, false, "", reasonAppNotRegistered, "")
+	setCondition(&res.Conditions, gen, authv1.IngressAuthConditionProxyProvisioned, false, "", reasonProxyNotProvisioned, "")
+	setCondition(&res.Conditions, gen, authv1.IngressAuthConditionIngressPointedToProxy, false, "", reasonIngressNotPointed, "")
+	setCondition(&res.Conditions, gen, authv1.IngressAuthConditionReady, false, "", reasonUnsecured, "")
+
+	return res
+}
+
+// newAuthBackendStatus returns the status of the auth backend, the status is based on the
+// app registration failures of all the apps that use the auth backend, this way the status
+// doesn't depend on the order the apps are reconciled.
+func newAuthBackendStatus(ab *authv1.AuthBackend, failures []appRegistrationFailure) authv1.AuthBackendStatus {
+	res := *ab.Status.DeepCopy()
+	res.ObservedGeneration = ab.Generation
+	res.LastError = ""
+
+	// TLS handshake failures are usually a misconfiguration of the auth backend certificates,
+	// give them their own reason so they are easy to spot.
+	notOKReason := reasonAppRegistrationFailed
+	for _, f := range failures {
+		if f.tlsHandshake {
+			notOKReason = reasonTLSHandshakeFailed
+			break
+		}
+	}
+
+	switch len(failures) {
+	case 0:
+	case 1:
+		res.LastError = fmt.Sprintf("could not register %s ingress app: %s", failures[0].app, failures[0].err)
+	default:
+		res.LastError = fmt.Sprintf("could not register %d ingress apps, %s ingress app: %s", len(failures), failures[0].app, failures[0].err)
+	}
+
+	setCondition(&res.Conditions, ab.Generation, authv1.AuthBackendConditionReady, len(failures) == 0, reasonAppRegistered, notOKReason, res.LastError)
+
+	return res
+}
+
+// appRegistrationFailure is an app that could not be registered on its auth backend.
+type appRegistrationFailure struct {
+	authBackendID string
+	app           string
+	err           string
+	tlsHandshake  bool
+}
+
+// appRegistrationFailures tracks the apps that could not be registered on their auth backend.
+type appRegistrationFailures struct {
+	// failures are the failures by app (`ns/name`).
+	failures map[string]appRegistrationFailure
+	mu       sync.Mutex
+}
+
+func newAppRegistrationFailures() *appRegistrationFailures {
+	return &appRegistrationFailures{failures: map[string]appRegistrationFailure{}}
+}
+
+// track tracks the app registration result of the securing process. The invalid app settings are
+// not a failure of the auth backend, so these are not tracked.
+func (a *appRegistrationFailures) track(app, authBackendID string, status security.AppSecurityStatus, secErr error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if authBackendID == "" || status.BackendRegistered || secErr == nil || errors.Is(secErr, security.ErrInvalidSettings) {
+		delete(a.failures, app)
+		return
+	}
+
+	a.failures[app] = appRegistrationFailure{
+		authBackendID: authBackendID,
+		app:           app,
+		err:           secErr.Error(),
+		tlsHandshake:  errors.Is(secErr, authbackend.ErrTLSHandshake),
+	}
+}
+
+// forget removes the tracked failure of the app.
+func (a *appRegistrationFailures) forget(app string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.failures, app)
+}
+
+// byAuthBackend returns the tracked failures of the auth backend apps sorted by app.
+func (a *appRegistrationFailures) byAuthBackend(authBackendID string) []appRegistrationFailure {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	res := []appRegistrationFailure{}
+	for _, f := range a.failures {
+		if f.authBackendID == authBackendID {
+			res = append(res, f)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].app < res[j].app })
+
+	return res
+}
+
+// setCondition sets the condition, the transition time will be only updated if the
+// condition status changes.
+func setCondition(conditions *[]metav1.Condition, generation int64, condType string, ok bool, okReason, notOKReason, msg string) {
+	cond := metav1.Condition{
+		Type:               condType,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             okReason,
+		Message:            msg,
+	}
+	if !ok {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = notOKReason
+	}
+
+	meta.SetStatusCondition(conditions, cond)
+}
@@ -0,0 +1,291 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/slok/bilrost/internal/authbackend"
+	"github.com/slok/bilrost/internal/authbackend/dex"
+	"github.com/slok/bilrost/internal/backup"
+	"github.com/slok/bilrost/internal/controller"
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/metrics"
+	"github.com/slok/bilrost/internal/model"
+)
+
+// KubernetesRepository is the service used by the janitor to interact with k8s.
+type KubernetesRepository interface {
+	ListSecrets(ctx context.Context, ns string, labelSelector map[string]string) (*corev1.SecretList, error)
+	DeleteSecret(ctx context.Context, ns, name string) error
+	ListDeployments(ctx context.Context, ns string, labelSelector map[string]string) (*appsv1.DeploymentList, error)
+	DeleteDeployment(ctx context.Context, ns, name string) error
+	ListServices(ctx context.Context, ns string, labelSelector map[string]string) (*corev1.ServiceList, error)
+	DeleteService(ctx context.Context, ns, name string) error
+	ListConfigMaps(ctx context.Context, ns string, labelSelector map[string]string) (*corev1.ConfigMapList, error)
+	DeleteConfigMap(ctx context.Context, ns, name string) error
+	ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*networkingv1.IngressList, error)
+	DeleteIngress(ctx context.Context, ns, name string) error
+	ListTraefikMiddlewares(ctx context.Context, ns string, labelSelector map[string]string) (*unstructured.UnstructuredList, error)
+	DeleteTraefikMiddleware(ctx context.Context, ns, name string) error
+	GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error)
+	GetAuthBackend(ctx context.Context, id string) (*model.AuthBackend, error)
+}
+
+//go:generate mockery -case underscore -output janitormock -outpkg janitormock -name KubernetesRepository
+
+// Janitor knows how to clean the resources that Bilrost has left behind.
+type Janitor interface {
+	// Clean makes a single cleaning pass.
+	Clean(ctx context.Context) error
+	// Run makes a cleaning pass periodically until the context is cancelled.
+	Run(ctx context.Context) error
+}
+
+// DexClientJanitorConfig is the configuration of the Dex clients janitor.
+type DexClientJanitorConfig struct {
+	RunningNamespace string
+	// Interval is the interval between the cleaning passes.
+	Interval time.Duration
+	// MinAge is the min age of the client data to be cleaned, this way we don't clean
+	// apps that are being secured right now (e.g: the ingress handled mark not set yet).
+	MinAge time.Duration
+	// DryRun will only report the orphaned clients, without cleaning them.
+	DryRun                bool
+	KubernetesRepository  KubernetesRepository
+	AuthBackendRegFactory authbackend.AppRegistererFactory
+	MetricsRecorder       metrics.Recorder
+	TimeNow               func() time.Time
+	Logger                log.Logger
+}
+
+func (c *DexClientJanitorConfig) defaults() error {
+	if c.RunningNamespace == "" {
+		return fmt.Errorf("the namespace where the app is running is required")
+	}
+
+	if c.KubernetesRepository == nil {
+		return fmt.Errorf("a Kubernetes repository required")
+	}
+
+	if c.AuthBackendRegFactory == nil {
+		return fmt.Errorf("an auth backend app registerer factory is required")
+	}
+
+	if c.Interval == 0 {
+		c.Interval = time.Hour
+	}
+
+	if c.MinAge == 0 {
+		c.MinAge = 15 * time.Minute
+	}
+
+	if c.MetricsRecorder == nil {
+		c.MetricsRecorder = metrics.Dummy
+	}
+
+	if c.TimeNow == nil {
+		c.TimeNow = time.Now
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Dummy
+	}
+	c.Logger = c.Logger.WithKV(log.KV{"service": "janitor.DexClientJanitor", "dryRun": c.DryRun})
+
+	return nil
+}
+
+type dexClientJanitor struct {
+	runningNamespace string
+	interval         time.Duration
+	minAge           time.Duration
+	dryRun           bool
+	kuberepo         KubernetesRepository
+	abRegFactory     authbackend.AppRegistererFactory
+	metricsRec       metrics.Recorder
+	timeNow          func() time.Time
+	logger           log.Logger
+}
+
+// NewDexClientJanitor returns a janitor that cleans the orphaned Dex clients and their client
+// data secrets.
+//
+// A Dex client is orphaned when its app ingress is missing, is not handled by Bilrost or has
+// been migrated to a different auth backend (e.g: the ingress has been force deleted removing
+// the finalizer or the security rollback failed halfway).
+func NewDexClientJanitor(config DexClientJanitorConfig) (Janitor, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("could not create Dex client janitor: %w", err)
+	}
+
+	return dexClientJanitor{
+		runningNamespace: config.RunningNamespace,
+		interval:         config.Interval,
+		minAge:           config.MinAge,
+		dryRun:           config.DryRun,
+		kuberepo:         config.KubernetesRepository,
+		abRegFactory:     config.AuthBackendRegFactory,
+		metricsRec:       config.MetricsRecorder,
+		timeNow:          config.TimeNow,
+		logger:           config.Logger,
+	}, nil
+}
+
+func (d dexClientJanitor) Run(ctx context.Context) error {
+	d.logger.WithKV(log.KV{"interval": d.interval}).Infof("Dex client janitor running")
+
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		err := d.Clean(ctx)
+		if err != nil {
+			d.logger.Errorf("could not clean orphaned Dex clients: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+func (d dexClientJanitor) Clean(ctx context.Context) error {
+	secrets, err := d.kuberepo.ListSecrets(ctx, d.runningNamespace, map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/component":  dex.ClientDataComponent,
+	})
+	if err != nil {
+		return fmt.Errorf("could not list Dex client data secrets: %w", err)
+	}
+
+	var cleaned, failed int
+	for _, sec := range secrets.Items {
+		appID := sec.Annotations[dex.ClientIDAnnotation]
+		abID := sec.Annotations[dex.AuthBackendAnnotation]
+		logger := d.logger.WithKV(log.KV{"app": appID, "authBackend": abID, "secret": sec.Name})
+
+		// Don't clean the apps that could be in the middle of the securing process.
+		if d.timeNow().Sub(sec.CreationTimestamp.Time) < d.minAge {
+			continue
+		}
+
+		orphan, err := d.isOrphan(ctx, appID, abID)
+		if err != nil {
+			logger.Warningf("could not check if the Dex client is orphaned: %s", err)
+			continue
+		}
+		if !orphan {
+			continue
+		}
+
+		// The secrets created before having the auth backend on them, can't be cleaned
+		// because we don't know the Dex where the client is registered.
+		if abID == "" {
+			logger.Warningf("orphaned Dex client without auth backend, ignoring")
+			continue
+		}
+
+		if d.dryRun {
+			logger.Infof("orphaned Dex client found (dry-run)")
+			d.metricsRec.IncDexClientJanitorOrphanCleaned(ctx, abID, true, true)
+			continue
+		}
+
+		err = d.unregister(ctx, appID, abID)
+		d.metricsRec.IncDexClientJanitorOrphanCleaned(ctx, abID, false, err == nil)
+		if err != nil {
+			failed++
+			logger.Errorf("could not clean orphaned Dex client: %s", err)
+			continue
+		}
+		cleaned++
+		logger.Infof("orphaned Dex client cleaned")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d orphaned Dex clients could not be cleaned", failed)
+	}
+
+	d.logger.Debugf("%d orphaned Dex clients cleaned", cleaned)
+
+	return nil
+}
+
+// isOrphan checks if the app ingress is missing, is not handled, or has finished the migration
+// to a different auth backend.
+//
+// While an auth backend migration is running (or has failed) the proxy could still be using the
+// client of the previous auth backend, the backup has the auth backend in use until the migration
+// finishes, so we only check the auth backend when the backup and the ingress agree.
+func (d dexClientJanitor) isOrphan(ctx context.Context, appID, abID string) (bool, error) {
+	s := strings.SplitN(appID, "/", 2)
+	if len(s) != 2 {
+		return false, fmt.Errorf("invalid app ID %q", appID)
+	}
+
+	ing, err := d.kuberepo.GetIngress(ctx, s[0], s[1])
+	if err != nil {
+		if kubeerrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("could not get ingress: %w", err)
+	}
+
+	if _, ok := ing.Annotations[controller.HandledAnnotation]; !ok {
+		return true, nil
+	}
+
+	if abID == "" {
+		return false, nil
+	}
+
+	bkData, ok, err := backup.DecodeIngressBackup(ing)
+	if err != nil {
+		return false, fmt.Errorf("could not decode ingress backup: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+
+	inUseABID := bkData.AuthBackendID
+	migrated := inUseABID != "" && inUseABID == ing.Annotations[controller.BackendAnnotation]
+	if migrated && inUseABID != abID {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// unregister unregisters the app using the auth backend app registerer, this will delete the
+// Dex client and the client data secret.
+func (d dexClientJanitor) unregister(ctx context.Context, appID, abID string) error {
+	ab, err := d.kuberepo.GetAuthBackend(ctx, abID)
+	if err != nil {
+		return fmt.Errorf("could not get auth backend: %w", err)
+	}
+	if ab.Dex == nil {
+		return fmt.Errorf("auth backend is not a Dex auth backend")
+	}
+
+	ar, err := d.abRegFactory.GetAppRegisterer(*ab)
+	if err != nil {
+		return fmt.Errorf("could not get auth backend app registerer: %w", err)
+	}
+
+	err = ar.UnregisterApp(ctx, appID)
+	if err != nil {
+		return fmt.Errorf("could not unregister app: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package janitor_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/authbackend/authbackendmock"
+	"github.com/slok/bilrost/internal/janitor"
+	"github.com/slok/bilrost/internal/janitor/janitormock"
+	"github.com/slok/bilrost/internal/model"
+)
+
+var testNow = time.Date(2021, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func getDexClientSecret(appID, abID string) corev1.Secret {
+	return corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "bilrost-dex-cli-test",
+			Namespace:         "bilrost",
+			CreationTimestamp: metav1.NewTime(testNow.Add(-time.Hour)),
+			Annotations: map[string]string{
+				"bilrost.slok.dev/dex-client-id": appID,
+				"bilrost.slok.dev/auth-backend":  abID,
+			},
+		},
+	}
+}
+
+// getHandledIngress returns a handled ingress, the backup auth backend is the one in use
+// by the app, it's different to the ingress one while the auth backend migration is running.
+func getHandledIngress(abID, backupABID string) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test-ns",
+			Annotations: map[string]string{
+				"auth.bilrost.slok.dev/backend": abID,
+				"auth.bilrost.slok.dev/handled": "true",
+				"auth.bilrost.slok.dev/backup":  fmt.Sprintf(`{"authBackendID":%q}`, backupABID),
+			},
+		},
+	}
+}
+
+func getDexAuthBackend() *model.AuthBackend {
+	return &model.AuthBackend{ID: "test-backend", Dex: &model.AuthBackendDex{APIURL: "dex:81"}}
+}
+
+func TestDexClientJanitorClean(t *testing.T) {
+	notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+
+	tests := map[string]struct {
+		dryRun bool
+		mock   func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer)
+		expErr bool
+	}{
+		"Having an error listing the secrets should fail.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"A Dex client of a handled ingress should not be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				expLabels := map[string]string{
+					"app.kubernetes.io/managed-by": "bilrost",
+					"app.kubernetes.io/component":  "dex-client-data",
+				}
+				secrets := &corev1.SecretList{Items: []corev1.Secret{getDexClientSecret("test-ns/test", "test-backend")}}
+				mk.On("ListSecrets", mock.Anything, "bilrost", expLabels).Once().Return(secrets, nil)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(getHandledIngress("test-backend", "test-backend"), nil)
+			},
+		},
+
+		"A Dex client of a missing ingress should be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				secrets := &corev1.SecretList{Items: []corev1.Secret{getDexClientSecret("test-ns/test", "test-backend")}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(nil, notFoundErr)
+
+				mk.On("GetAuthBackend", mock.Anything, "test-backend").Once().Return(getDexAuthBackend(), nil)
+				mf.On("GetAppRegisterer", *getDexAuthBackend()).Once().Return(mar, nil)
+				mar.On("UnregisterApp", mock.Anything, "test-ns/test").Once().Return(nil)
+			},
+		},
+
+		"A Dex client of a not handled ingress should be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				secrets := &corev1.SecretList{Items: []corev1.Secret{getDexClientSecret("test-ns/test", "test-backend")}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				ing := getHandledIngress("test-backend", "test-backend")
+				delete(ing.Annotations, "auth.bilrost.slok.dev/handled")
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+
+				mk.On("GetAuthBackend", mock.Anything, "test-backend").Once().Return(getDexAuthBackend(), nil)
+				mf.On("GetAppRegisterer", *getDexAuthBackend()).Once().Return(mar, nil)
+				mar.On("UnregisterApp", mock.Anything, "test-ns/test").Once().Return(nil)
+			},
+		},
+
+		"A Dex client of an ingress migrated to a different auth backend should be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				secrets := &corev1.SecretList{Items: []corev1.Secret{getDexClientSecret("test-ns/test", "test-backend")}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(getHandledIngress("other-backend", "other-backend"), nil)
+
+				mk.On("GetAuthBackend", mock.Anything, "test-backend").Once().Return(getDexAuthBackend(), nil)
+				mf.On("GetAppRegisterer", *getDexAuthBackend()).Once().Return(mar, nil)
+				mar.On("UnregisterApp", mock.Anything, "test-ns/test").Once().Return(nil)
+			},
+		},
+
+		"A Dex client of an ingress with a failed or running auth backend migration should not be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				// The proxy is still using the previous auth backend client.
+				secrets := &corev1.SecretList{Items: []corev1.Secret{getDexClientSecret("test-ns/test", "test-backend")}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(getHandledIngress("other-backend", "test-backend"), nil)
+			},
+		},
+
+		"A Dex client of the new auth backend of an ingress with a running auth backend migration should not be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				secrets := &corev1.SecretList{Items: []corev1.Secret{getDexClientSecret("test-ns/test", "other-backend")}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(getHandledIngress("other-backend", "test-backend"), nil)
+			},
+		},
+
+		"A Dex client of a handled ingress without backup should not be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				secrets := &corev1.SecretList{Items: []corev1.Secret{getDexClientSecret("test-ns/test", "test-backend")}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				ing := getHandledIngress("other-backend", "")
+				delete(ing.Annotations, "auth.bilrost.slok.dev/backup")
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(ing, nil)
+			},
+		},
+
+		"An orphaned Dex client in dry-run mode should not be cleaned.": {
+			dryRun: true,
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				secrets := &corev1.SecretList{Items: []corev1.Secret{getDexClientSecret("test-ns/test", "test-backend")}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(nil, notFoundErr)
+			},
+		},
+
+		"A recently created Dex client should not be checked.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				sec := getDexClientSecret("test-ns/test", "test-backend")
+				sec.CreationTimestamp = metav1.NewTime(testNow.Add(-time.Minute))
+				secrets := &corev1.SecretList{Items: []corev1.Secret{sec}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+			},
+		},
+
+		"An orphaned Dex client without auth backend should not be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				sec := getDexClientSecret("test-ns/test", "")
+				delete(sec.Annotations, "bilrost.slok.dev/auth-backend")
+				secrets := &corev1.SecretList{Items: []corev1.Secret{sec}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(nil, notFoundErr)
+			},
+		},
+
+		"An orphaned Dex client of a missing auth backend should fail.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				secrets := &corev1.SecretList{Items: []corev1.Secret{getDexClientSecret("test-ns/test", "test-backend")}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(nil, notFoundErr)
+				mk.On("GetAuthBackend", mock.Anything, "test-backend").Once().Return(nil, notFoundErr)
+			},
+			expErr: true,
+		},
+
+		"An error unregistering an orphaned Dex client should fail after checking all the clients.": {
+			mock: func(mk *janitormock.KubernetesRepository, mf *authbackendmock.AppRegistererFactory, mar *authbackendmock.AppRegisterer) {
+				secrets := &corev1.SecretList{Items: []corev1.Secret{
+					getDexClientSecret("test-ns/test", "test-backend"),
+					getDexClientSecret("test-ns/test2", "test-backend"),
+				}}
+				mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(secrets, nil)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(nil, notFoundErr)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test2").Once().Return(nil, notFoundErr)
+
+				mk.On("GetAuthBackend", mock.Anything, "test-backend").Twice().Return(getDexAuthBackend(), nil)
+				mf.On("GetAppRegisterer", *getDexAuthBackend()).Twice().Return(mar, nil)
+				mar.On("UnregisterApp", mock.Anything, "test-ns/test").Once().Return(fmt.Errorf("whatever"))
+				mar.On("UnregisterApp", mock.Anything, "test-ns/test2").Once().Return(nil)
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			mk := &janitormock.KubernetesRepository{}
+			mf := &authbackendmock.AppRegistererFactory{}
+			mar := &authbackendmock.AppRegisterer{}
+			test.mock(mk, mf, mar)
+
+			// Prepare.
+			j, err := janitor.NewDexClientJanitor(janitor.DexClientJanitorConfig{
+				RunningNamespace:      "bilrost",
+				DryRun:                test.dryRun,
+				KubernetesRepository:  mk,
+				AuthBackendRegFactory: mf,
+				TimeNow:               func() time.Time { return testNow },
+			})
+			require.NoError(err)
+
+			// Execute.
+			err = j.Clean(context.TODO())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			mk.AssertExpectations(t)
+			mf.AssertExpectations(t)
+			mar.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/controller"
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/metrics"
+)
+
+// ProxyJanitorConfig is the configuration of the proxy resources janitor.
+type ProxyJanitorConfig struct {
+	// Namespace is the namespace where the proxy resources will be searched, by default all.
+	Namespace string
+	// Interval is the interval between the cleaning passes.
+	Interval time.Duration
+	// MinAge is the min age of the proxy resources to be cleaned, this way we don't clean
+	// proxies that are being provisioned right now.
+	MinAge time.Duration
+	// DryRun will only report the orphaned proxy resources, without cleaning them.
+	DryRun               bool
+	KubernetesRepository KubernetesRepository
+	MetricsRecorder      metrics.Recorder
+	TimeNow              func() time.Time
+	Logger               log.Logger
+}
+
+func (c *ProxyJanitorConfig) defaults() error {
+	if c.KubernetesRepository == nil {
+		return fmt.Errorf("a Kubernetes repository required")
+	}
+
+	if c.Interval == 0 {
+		c.Interval = time.Hour
+	}
+
+	if c.MinAge == 0 {
+		c.MinAge = 15 * time.Minute
+	}
+
+	if c.MetricsRecorder == nil {
+		c.MetricsRecorder = metrics.Dummy
+	}
+
+	if c.TimeNow == nil {
+		c.TimeNow = time.Now
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Dummy
+	}
+	c.Logger = c.Logger.WithKV(log.KV{"service": "janitor.ProxyJanitor", "dryRun": c.DryRun})
+
+	return nil
+}
+
+type proxyJanitor struct {
+	namespace  string
+	interval   time.Duration
+	minAge     time.Duration
+	dryRun     bool
+	kuberepo   KubernetesRepository
+	metricsRec metrics.Recorder
+	timeNow    func() time.Time
+	logger     log.Logger
+}
+
+// NewProxyJanitor returns a janitor that cleans the orphaned proxy resources (deployments,
+// services, secrets, configmaps, auth ingresses, Traefik middlewares and the session store
+// deployments and services) created by the proxy provisioners.
+//
+// The proxy resources are orphaned when their app ingress is missing or is not handled by
+// Bilrost anymore (e.g: the ingress has been force deleted removing the finalizer or the
+// security rollback failed halfway).
+func NewProxyJanitor(config ProxyJanitorConfig) (Janitor, error) {
+	err := config.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("could not create proxy janitor: %w", err)
+	}
+
+	return proxyJanitor{
+		namespace:  config.Namespace,
+		interval:   config.Interval,
+		minAge:     config.MinAge,
+		dryRun:     config.DryRun,
+		kuberepo:   config.KubernetesRepository,
+		metricsRec: config.MetricsRecorder,
+		timeNow:    config.TimeNow,
+		logger:     config.Logger,
+	}, nil
+}
+
+func (p proxyJanitor) Run(ctx context.Context) error {
+	p.logger.WithKV(log.KV{"interval": p.interval}).Infof("proxy janitor running")
+
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		err := p.Clean(ctx)
+		if err != nil {
+			p.logger.Errorf("could not clean orphaned proxy resources: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// These are set by the proxy provisioners on the proxy resources.
+const (
+	proxyComponent                 = "proxy"
+	proxyResourceNameSuffix        = "-bilrost-proxy"
+	sessionStoreComponent          = "session-store"
+	sessionStoreResourceNameSuffix = "-bilrost-proxy-redis"
+	// The skip auth ingresses have the proxy name as the instance label.
+	skipAuthComponent = "skip-auth"
+	instanceLabel     = "app.kubernetes.io/instance"
+)
+
+const (
+	proxyResourceService           = "service"
+	proxyResourceDeployment        = "deployment"
+	proxyResourceSecret            = "secret"
+	proxyResourceConfigMap         = "configmap"
+	proxyResourceIngress           = "ingress"
+	proxyResourceTraefikMiddleware = "traefik-middleware"
+)
+
+type proxyResource struct {
+	resource  string
+	namespace string
+	name      string
+	// ingressName is the name of the app ingress of the resource, empty if unknown.
+	ingressName string
+	createdAt   time.Time
+}
+
+func (p proxyJanitor) Clean(ctx context.Context) error {
+	resources, err := p.listProxyResources(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The resources of the same proxy share the ingress, check each ingress only once.
+	orphanIngresses := map[string]bool{}
+
+	var cleaned, failed int
+	for _, r := range resources {
+		logger := p.logger.WithKV(log.KV{"obj-ns": r.namespace, "obj-name": r.name, "resource": r.resource})
+
+		// Don't clean the proxies that could be in the middle of the provisioning process.
+		if p.timeNow().Sub(r.createdAt) < p.minAge {
+			continue
+		}
+
+		ingName := r.ingressName
+		if ingName == "" {
+			logger.Warningf("proxy resource name without the proxy suffix, ignoring")
+			continue
+		}
+
+		ingKey := r.namespace + "/" + ingName
+		orphan, ok := orphanIngresses[ingKey]
+		if !ok {
+			orphan, err = p.isOrphan(ctx, r.namespace, ingName, ingName+proxyResourceNameSuffix)
+			if err != nil {
+				logger.Warningf("could not check if the proxy resource is orphaned: %s", err)
+			}
+			orphanIngresses[ingKey] = orphan
+		}
+		if !orphan {
+			continue
+		}
+
+		if p.dryRun {
+			logger.Infof("orphaned proxy resource found (dry-run)")
+			p.metricsRec.IncProxyJanitorOrphanCleaned(ctx, r.resource, true, true)
+			continue
+		}
+
+		err = p.delete(ctx, r)
+		p.metricsRec.IncProxyJanitorOrphanCleaned(ctx, r.resource, false, err == nil)
+		if err != nil {
+			failed++
+			logger.Errorf("could not clean orphaned proxy resource: %s", err)
+			continue
+		}
+		cleaned++
+		logger.Infof("orphaned proxy resource cleaned")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d orphaned proxy resources could not be cleaned", failed)
+	}
+
+	p.logger.Debugf("%d orphaned proxy resources cleaned", cleaned)
+
+	return nil
+}
+
+// listProxyResources returns all the proxy resources in the same order the provisioners
+// unprovision them, first the auth resources and the service so the proxy stops receiving
+// traffic.
+func (p proxyJanitor) listProxyResources(ctx context.Context) ([]proxyResource, error) {
+	resources := []proxyResource{}
+	for _, c := range []struct {
+		component  string
+		nameSuffix string
+	}{
+		{component: proxyComponent, nameSuffix: proxyResourceNameSuffix},
+		{component: sessionStoreComponent, nameSuffix: sessionStoreResourceNameSuffix},
+	} {
+		rs, err := p.listComponentResources(ctx, c.component, c.nameSuffix)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, rs...)
+	}
+
+	rs, err := p.listSkipAuthIngresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resources = append(resources, rs...)
+
+	return resources, nil
+}
+
+func (p proxyJanitor) listComponentResources(ctx context.Context, component, nameSuffix string) ([]proxyResource, error) {
+	selector := map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/component":  component,
+	}
+
+	resources := []proxyResource{}
+	newResource := func(resource string, obj metav1.Object) proxyResource {
+		return proxyResource{
+			resource:    resource,
+			namespace:   obj.GetNamespace(),
+			name:        obj.GetName(),
+			ingressName: trimNameSuffix(obj.GetName(), nameSuffix),
+			createdAt:   obj.GetCreationTimestamp().Time,
+		}
+	}
+
+	// Traefik could not be installed on the cluster.
+	mws, err := p.kuberepo.ListTraefikMiddlewares(ctx, p.namespace, selector)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return nil, fmt.Errorf("could not list %s Traefik middlewares: %w", component, err)
+	}
+	if mws != nil {
+		for i := range mws.Items {
+			resources = append(resources, newResource(proxyResourceTraefikMiddleware, &mws.Items[i]))
+		}
+	}
+
+	ings, err := p.kuberepo.ListIngresses(ctx, p.namespace, selector)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s ingresses: %w", component, err)
+	}
+	for i := range ings.Items {
+		resources = append(resources, newResource(proxyResourceIngress, &ings.Items[i]))
+	}
+
+	svcs, err := p.kuberepo.ListServices(ctx, p.namespace, selector)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s services: %w", component, err)
+	}
+	for i := range svcs.Items {
+		resources = append(resources, newResource(proxyResourceService, &svcs.Items[i]))
+	}
+
+	deps, err := p.kuberepo.ListDeployments(ctx, p.namespace, selector)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s deployments: %w", component, err)
+	}
+	for i := range deps.Items {
+		resources = append(resources, newResource(proxyResourceDeployment, &deps.Items[i]))
+	}
+
+	secs, err := p.kuberepo.ListSecrets(ctx, p.namespace, selector)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s secrets: %w", component, err)
+	}
+	for i := range secs.Items {
+		resources = append(resources, newResource(proxyResourceSecret, &secs.Items[i]))
+	}
+
+	cms, err := p.kuberepo.ListConfigMaps(ctx, p.namespace, selector)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s configmaps: %w", component, err)
+	}
+	for i := range cms.Items {
+		resources = append(resources, newResource(proxyResourceConfigMap, &cms.Items[i]))
+	}
+
+	return resources, nil
+}
+
+// listSkipAuthIngresses returns the ingresses of the app routes that skip the authentication, these
+// are named after the proxy with a suffix that could have an index, so the app ingress name is taken
+// from the instance label.
+func (p proxyJanitor) listSkipAuthIngresses(ctx context.Context) ([]proxyResource, error) {
+	selector := map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/component":  skipAuthComponent,
+	}
+	ings, err := p.kuberepo.ListIngresses(ctx, p.namespace, selector)
+	if err != nil {
+		return nil, fmt.Errorf("could not list %s ingresses: %w", skipAuthComponent, err)
+	}
+
+	resources := []proxyResource{}
+	for _, ing := range ings.Items {
+		resources = append(resources, proxyResource{
+			resource:    proxyResourceIngress,
+			namespace:   ing.Namespace,
+			name:        ing.Name,
+			ingressName: trimNameSuffix(ing.Labels[instanceLabel], proxyResourceNameSuffix),
+			createdAt:   ing.CreationTimestamp.Time,
+		})
+	}
+
+	return resources, nil
+}
+
+// trimNameSuffix returns the name without the suffix, empty if the name doesn't have the suffix.
+func trimNameSuffix(name, suffix string) string {
+	trimmed := strings.TrimSuffix(name, suffix)
+	if trimmed == name {
+		return ""
+	}
+	return trimmed
+}
+
+// isOrphan checks if the proxy ingress is missing, or is not being handled.
+func (p proxyJanitor) isOrphan(ctx context.Context, ns, ingName, proxySvcName string) (bool, error) {
+	ing, err := p.kuberepo.GetIngress(ctx, ns, ingName)
+	if err != nil {
+		if kubeerrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("could not get ingress: %w", err)
+	}
+
+	if _, ok := ing.Annotations[controller.HandledAnnotation]; ok {
+		return false, nil
+	}
+
+	// Deleting a proxy that is still receiving traffic would make the app unavailable.
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil && path.Backend.Service.Name == proxySvcName {
+				return false, fmt.Errorf("ingress not handled but still routing to the proxy")
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func (p proxyJanitor) delete(ctx context.Context, r proxyResource) error {
+	var err error
+	switch r.resource {
+	case proxyResourceService:
+		err = p.kuberepo.DeleteService(ctx, r.namespace, r.name)
+	case proxyResourceDeployment:
+		err = p.kuberepo.DeleteDeployment(ctx, r.namespace, r.name)
+	case proxyResourceSecret:
+		err = p.kuberepo.DeleteSecret(ctx, r.namespace, r.name)
+	case proxyResourceConfigMap:
+		err = p.kuberepo.DeleteConfigMap(ctx, r.namespace, r.name)
+	case proxyResourceIngress:
+		err = p.kuberepo.DeleteIngress(ctx, r.namespace, r.name)
+	case proxyResourceTraefikMiddleware:
+		err = p.kuberepo.DeleteTraefikMiddleware(ctx, r.namespace, r.name)
+	default:
+		return fmt.Errorf("unknown proxy resource %q", r.resource)
+	}
+
+	// Kubernetes could have garbage collected the resource by its owner references.
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
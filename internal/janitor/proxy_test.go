@@ -0,0 +1,300 @@
+package janitor_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/slok/bilrost/internal/janitor"
+	"github.com/slok/bilrost/internal/janitor/janitormock"
+)
+
+func getProxyObjectMeta(name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:              name,
+		Namespace:         "test-ns",
+		CreationTimestamp: metav1.NewTime(testNow.Add(-time.Hour)),
+	}
+}
+
+type proxyResources struct {
+	mws  *unstructured.UnstructuredList
+	ings *networkingv1.IngressList
+	svcs *corev1.ServiceList
+	deps *appsv1.DeploymentList
+	secs *corev1.SecretList
+	cms  *corev1.ConfigMapList
+}
+
+// getProxyResources returns the resources of the proxy and the auth resources of the proxy.
+func getProxyResources() proxyResources {
+	om := getProxyObjectMeta("test-bilrost-proxy")
+	mw := unstructured.Unstructured{}
+	mw.SetName(om.Name)
+	mw.SetNamespace(om.Namespace)
+	mw.SetCreationTimestamp(om.CreationTimestamp)
+
+	return proxyResources{
+		mws:  &unstructured.UnstructuredList{Items: []unstructured.Unstructured{mw}},
+		ings: &networkingv1.IngressList{Items: []networkingv1.Ingress{{ObjectMeta: om}}},
+		svcs: &corev1.ServiceList{Items: []corev1.Service{{ObjectMeta: om}}},
+		deps: &appsv1.DeploymentList{Items: []appsv1.Deployment{{ObjectMeta: om}}},
+		secs: &corev1.SecretList{Items: []corev1.Secret{{ObjectMeta: om}}},
+		cms:  &corev1.ConfigMapList{Items: []corev1.ConfigMap{{ObjectMeta: om}}},
+	}
+}
+
+// getSessionStoreResources returns the resources of the proxy Redis session store.
+func getSessionStoreResources() proxyResources {
+	om := getProxyObjectMeta("test-bilrost-proxy-redis")
+
+	return proxyResources{
+		mws:  &unstructured.UnstructuredList{},
+		ings: &networkingv1.IngressList{},
+		svcs: &corev1.ServiceList{Items: []corev1.Service{{ObjectMeta: om}}},
+		deps: &appsv1.DeploymentList{Items: []appsv1.Deployment{{ObjectMeta: om}}},
+		secs: &corev1.SecretList{},
+		cms:  &corev1.ConfigMapList{},
+	}
+}
+
+func mockListResources(mk *janitormock.KubernetesRepository, component string, r proxyResources) {
+	expLabels := map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/component":  component,
+	}
+	if r.mws != nil {
+		mk.On("ListTraefikMiddlewares", mock.Anything, "", expLabels).Once().Return(r.mws, nil)
+	} else {
+		// Missing Traefik CRD.
+		notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+		mk.On("ListTraefikMiddlewares", mock.Anything, "", expLabels).Once().Return(nil, notFoundErr)
+	}
+	mk.On("ListIngresses", mock.Anything, "", expLabels).Once().Return(r.ings, nil)
+	mk.On("ListServices", mock.Anything, "", expLabels).Once().Return(r.svcs, nil)
+	mk.On("ListDeployments", mock.Anything, "", expLabels).Once().Return(r.deps, nil)
+	mk.On("ListSecrets", mock.Anything, "", expLabels).Once().Return(r.secs, nil)
+	mk.On("ListConfigMaps", mock.Anything, "", expLabels).Once().Return(r.cms, nil)
+}
+
+// getSkipAuthIngresses returns the ingresses of the app routes that skip the authentication.
+func getSkipAuthIngresses() *networkingv1.IngressList {
+	om := getProxyObjectMeta("test-bilrost-proxy-skip-auth-0")
+	om.Labels = map[string]string{"app.kubernetes.io/instance": "test-bilrost-proxy"}
+
+	return &networkingv1.IngressList{Items: []networkingv1.Ingress{{ObjectMeta: om}}}
+}
+
+func mockListSkipAuthIngresses(mk *janitormock.KubernetesRepository, ings *networkingv1.IngressList) {
+	expLabels := map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/component":  "skip-auth",
+	}
+	mk.On("ListIngresses", mock.Anything, "", expLabels).Once().Return(ings, nil)
+}
+
+func mockProxyResources(mk *janitormock.KubernetesRepository) {
+	mockListResources(mk, "proxy", getProxyResources())
+	mockListResources(mk, "session-store", getSessionStoreResources())
+	mockListSkipAuthIngresses(mk, getSkipAuthIngresses())
+}
+
+func mockDeleteResources(mk *janitormock.KubernetesRepository, err error) {
+	mk.On("DeleteTraefikMiddleware", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(err)
+	mk.On("DeleteIngress", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(err)
+	mk.On("DeleteService", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(err)
+	mk.On("DeleteDeployment", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(err)
+	mk.On("DeleteSecret", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(err)
+	mk.On("DeleteConfigMap", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(err)
+	mk.On("DeleteService", mock.Anything, "test-ns", "test-bilrost-proxy-redis").Once().Return(err)
+	mk.On("DeleteDeployment", mock.Anything, "test-ns", "test-bilrost-proxy-redis").Once().Return(err)
+	mk.On("DeleteIngress", mock.Anything, "test-ns", "test-bilrost-proxy-skip-auth-0").Once().Return(err)
+}
+
+func getUnhandledIngress(backendSvc string) *networkingv1.Ingress {
+	ing := getHandledIngress("test-backend", "test-backend")
+	ing.Annotations = nil
+	ing.Spec.Rules = []networkingv1.IngressRule{{
+		IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{
+					Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{Name: backendSvc},
+					},
+				}},
+			},
+		},
+	}}
+	return ing
+}
+
+func TestProxyJanitorClean(t *testing.T) {
+	notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+
+	tests := map[string]struct {
+		dryRun bool
+		mock   func(mk *janitormock.KubernetesRepository)
+		expErr bool
+	}{
+		"Having an error listing the proxy resources should fail.": {
+			mock: func(mk *janitormock.KubernetesRepository) {
+				mk.On("ListTraefikMiddlewares", mock.Anything, mock.Anything, mock.Anything).Once().Return(&unstructured.UnstructuredList{}, nil)
+				mk.On("ListIngresses", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"The proxy resources of a handled ingress should not be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository) {
+				mockProxyResources(mk)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(getHandledIngress("test-backend", "test-backend"), nil)
+			},
+		},
+
+		"The proxy resources of a missing ingress should be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository) {
+				mockProxyResources(mk)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(nil, notFoundErr)
+				mockDeleteResources(mk, nil)
+			},
+		},
+
+		"The proxy resources of a not handled ingress should be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository) {
+				mockProxyResources(mk)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(getUnhandledIngress("my-app"), nil)
+				mockDeleteResources(mk, nil)
+			},
+		},
+
+		"The proxy resources of a not handled ingress that still routes to the proxy should not be cleaned.": {
+			mock: func(mk *janitormock.KubernetesRepository) {
+				mockProxyResources(mk)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(getUnhandledIngress("test-bilrost-proxy"), nil)
+			},
+		},
+
+		"Missing Traefik on the cluster should not fail.": {
+			mock: func(mk *janitormock.KubernetesRepository) {
+				r := getProxyResources()
+				r.mws = nil
+				mockListResources(mk, "proxy", r)
+				r = getSessionStoreResources()
+				r.mws = nil
+				mockListResources(mk, "session-store", r)
+				mockListSkipAuthIngresses(mk, getSkipAuthIngresses())
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(getHandledIngress("test-backend", "test-backend"), nil)
+			},
+		},
+
+		"Orphaned proxy resources in dry-run mode should not be cleaned.": {
+			dryRun: true,
+			mock: func(mk *janitormock.KubernetesRepository) {
+				mockProxyResources(mk)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(nil, notFoundErr)
+			},
+		},
+
+		"Recently created proxy resources should not be checked.": {
+			mock: func(mk *janitormock.KubernetesRepository) {
+				recent := metav1.NewTime(testNow.Add(-time.Minute))
+				for _, r := range []proxyResources{getProxyResources(), getSessionStoreResources()} {
+					for i := range r.mws.Items {
+						r.mws.Items[i].SetCreationTimestamp(recent)
+					}
+					for i := range r.ings.Items {
+						r.ings.Items[i].CreationTimestamp = recent
+					}
+					for i := range r.svcs.Items {
+						r.svcs.Items[i].CreationTimestamp = recent
+					}
+					for i := range r.deps.Items {
+						r.deps.Items[i].CreationTimestamp = recent
+					}
+					for i := range r.secs.Items {
+						r.secs.Items[i].CreationTimestamp = recent
+					}
+					for i := range r.cms.Items {
+						r.cms.Items[i].CreationTimestamp = recent
+					}
+					mk.On("ListTraefikMiddlewares", mock.Anything, mock.Anything, mock.Anything).Once().Return(r.mws, nil)
+					mk.On("ListIngresses", mock.Anything, mock.Anything, mock.Anything).Once().Return(r.ings, nil)
+					mk.On("ListServices", mock.Anything, mock.Anything, mock.Anything).Once().Return(r.svcs, nil)
+					mk.On("ListDeployments", mock.Anything, mock.Anything, mock.Anything).Once().Return(r.deps, nil)
+					mk.On("ListSecrets", mock.Anything, mock.Anything, mock.Anything).Once().Return(r.secs, nil)
+					mk.On("ListConfigMaps", mock.Anything, mock.Anything, mock.Anything).Once().Return(r.cms, nil)
+				}
+				ings := getSkipAuthIngresses()
+				ings.Items[0].CreationTimestamp = recent
+				mk.On("ListIngresses", mock.Anything, mock.Anything, mock.Anything).Once().Return(ings, nil)
+			},
+		},
+
+		"Orphaned proxy resources already deleted should not fail.": {
+			mock: func(mk *janitormock.KubernetesRepository) {
+				mockProxyResources(mk)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(nil, notFoundErr)
+				mockDeleteResources(mk, notFoundErr)
+			},
+		},
+
+		"An error deleting an orphaned proxy resource should fail after cleaning the rest.": {
+			mock: func(mk *janitormock.KubernetesRepository) {
+				mockProxyResources(mk)
+				mk.On("GetIngress", mock.Anything, "test-ns", "test").Once().Return(nil, notFoundErr)
+
+				mk.On("DeleteTraefikMiddleware", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteService", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteDeployment", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(fmt.Errorf("whatever"))
+				mk.On("DeleteSecret", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteService", mock.Anything, "test-ns", "test-bilrost-proxy-redis").Once().Return(nil)
+				mk.On("DeleteDeployment", mock.Anything, "test-ns", "test-bilrost-proxy-redis").Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "test-ns", "test-bilrost-proxy-skip-auth-0").Once().Return(nil)
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Mocks.
+			mk := &janitormock.KubernetesRepository{}
+			test.mock(mk)
+
+			// Prepare.
+			j, err := janitor.NewProxyJanitor(janitor.ProxyJanitorConfig{
+				DryRun:               test.dryRun,
+				KubernetesRepository: mk,
+				TimeNow:              func() time.Time { return testNow },
+			})
+			require.NoError(err)
+
+			// Execute.
+			err = j.Clean(context.TODO())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			mk.AssertExpectations(t)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
@@ -15,6 +16,9 @@ type Factory interface {
 	NewCoreClient(ctx context.Context, cfg *rest.Config) (kubernetes.Interface, error)
 	// NewCoreClient returns a Kubernetes client or Bilrost CRDs.
 	NewBilrostClient(ctx context.Context, cfg *rest.Config) (kubernetesbilrost.Interface, error)
+	// NewDynamicClient returns a Kubernetes dynamic client for the CRDs that we don't have
+	// typed clients (e.g: Traefik middlewares).
+	NewDynamicClient(ctx context.Context, cfg *rest.Config) (dynamic.Interface, error)
 }
 
 // BaseFactory is the base factory that knows how to return K8s clients.
@@ -29,3 +33,7 @@ func (baseFactory) NewCoreClient(_ context.Context, cfg *rest.Config) (kubernete
 func (baseFactory) NewBilrostClient(_ context.Context, cfg *rest.Config) (kubernetesbilrost.Interface, error) {
 	return kubernetesbilrost.NewForConfig(cfg)
 }
+
+func (baseFactory) NewDynamicClient(_ context.Context, cfg *rest.Config) (dynamic.Interface, error) {
+	return dynamic.NewForConfig(cfg)
+}
@@ -0,0 +1,78 @@
+package kubernetes
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	kubernetesscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/slok/bilrost/internal/controller"
+	"github.com/slok/bilrost/internal/log"
+	kubernetesbilrostscheme "github.com/slok/bilrost/pkg/kubernetes/gen/clientset/versioned/scheme"
+)
+
+// EventRecorder records Kubernetes events on the apiserver.
+type EventRecorder struct {
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+}
+
+// NewEventRecorder returns a new EventRecorder that records the events as the `component` source.
+// Use `Stop` to stop recording events.
+func NewEventRecorder(coreCli kubernetes.Interface, component string, logger log.Logger) (*EventRecorder, error) {
+	logger = logger.WithKV(log.KV{"service": "kubernetes.EventRecorder"})
+
+	// We need to know our CRs to get the references of the objects.
+	scheme := runtime.NewScheme()
+	err := kubernetesscheme.AddToScheme(scheme)
+	if err != nil {
+		return nil, fmt.Errorf("could not register Kubernetes types: %w", err)
+	}
+	err = kubernetesbilrostscheme.AddToScheme(scheme)
+	if err != nil {
+		return nil, fmt.Errorf("could not register Bilrost types: %w", err)
+	}
+
+	// By default the events are throttled by object, we record multiple events per object
+	// on each reconciliation, so the regular events would drop the important ones (e.g: failures).
+	// Throttle by object and reason instead.
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		SpamKeyFunc: func(e *corev1.Event) string {
+			return strings.Join([]string{
+				e.Source.Component,
+				e.Source.Host,
+				e.InvolvedObject.Kind,
+				e.InvolvedObject.Namespace,
+				e.InvolvedObject.Name,
+				string(e.InvolvedObject.UID),
+				e.InvolvedObject.APIVersion,
+				e.Type,
+				e.Reason,
+			}, "")
+		},
+	})
+	broadcaster.StartLogging(logger.Debugf)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: coreCli.CoreV1().Events("")})
+
+	return &EventRecorder{
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme, corev1.EventSource{Component: component}),
+	}, nil
+}
+
+// Event satisfies controller.HandlerEventRecorder interface.
+func (e EventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	e.recorder.Event(object, eventtype, reason, message)
+}
+
+// Stop stops recording events.
+func (e EventRecorder) Stop() {
+	e.broadcaster.Shutdown()
+}
+
+var _ controller.HandlerEventRecorder = EventRecorder{}
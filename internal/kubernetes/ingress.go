@@ -0,0 +1,202 @@
+package kubernetes
+
+import (
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/util/retry"
+)
+
+// discoveryBackoff is the backoff used to retry the discovery API calls, the discovery can fail
+// transiently (e.g: the apiserver is restarting), so we retry before failing.
+var discoveryBackoff = wait.Backoff{
+	Steps:    5,
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+}
+
+// servesIngressV1 checks using the discovery API if the apiserver serves
+// `networking.k8s.io/v1` ingresses. Kubernetes clusters before 1.19 only serve
+// `networking.k8s.io/v1beta1` ingresses, and clusters from 1.22 only serve `networking.k8s.io/v1`.
+//
+// The discovery errors are retried, if the discovery keeps failing we return the error instead
+// of guessing the version, using the wrong one would fail all the ingress calls.
+func servesIngressV1(cli discovery.DiscoveryInterface) (bool, error) {
+	var resources *metav1.APIResourceList
+	err := retry.OnError(discoveryBackoff, func(err error) bool { return !kubeerrors.IsNotFound(err) }, func() error {
+		var err error
+		resources, err = cli.ServerResourcesForGroupVersion(networkingv1.SchemeGroupVersion.String())
+		return err
+	})
+	if err != nil {
+		// The group version is not served at all.
+		if kubeerrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, r := range resources.APIResources {
+		if r.Name == "ingresses" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Our app works with `networking.k8s.io/v1` ingresses, these helpers convert from and to
+// `networking.k8s.io/v1beta1` ingresses so we can work with clusters that don't serve v1.
+
+func ingressV1beta1ToV1(ing *networkingv1beta1.Ingress) *networkingv1.Ingress {
+	res := &networkingv1.Ingress{
+		ObjectMeta: *ing.ObjectMeta.DeepCopy(),
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ing.Spec.IngressClassName,
+		},
+		Status: networkingv1.IngressStatus{
+			LoadBalancer: *ing.Status.LoadBalancer.DeepCopy(),
+		},
+	}
+	if ing.Spec.Backend != nil {
+		b := ingressBackendV1beta1ToV1(*ing.Spec.Backend)
+		res.Spec.DefaultBackend = &b
+	}
+
+	for _, tls := range ing.Spec.TLS {
+		res.Spec.TLS = append(res.Spec.TLS, networkingv1.IngressTLS{
+			Hosts:      tls.Hosts,
+			SecretName: tls.SecretName,
+		})
+	}
+
+	for _, rule := range ing.Spec.Rules {
+		r := networkingv1.IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			r.HTTP = &networkingv1.HTTPIngressRuleValue{}
+			for _, path := range rule.HTTP.Paths {
+				p := networkingv1.HTTPIngressPath{
+					Path:    path.Path,
+					Backend: ingressBackendV1beta1ToV1(path.Backend),
+				}
+				if path.PathType != nil {
+					pt := networkingv1.PathType(*path.PathType)
+					p.PathType = &pt
+				}
+				r.HTTP.Paths = append(r.HTTP.Paths, p)
+			}
+		}
+		res.Spec.Rules = append(res.Spec.Rules, r)
+	}
+
+	return res
+}
+
+func ingressBackendV1beta1ToV1(b networkingv1beta1.IngressBackend) networkingv1.IngressBackend {
+	res := networkingv1.IngressBackend{Resource: b.Resource}
+	if b.ServiceName == "" {
+		return res
+	}
+
+	res.Service = &networkingv1.IngressServiceBackend{Name: b.ServiceName}
+	if b.ServicePort.Type == intstr.Int {
+		res.Service.Port.Number = b.ServicePort.IntVal
+	} else {
+		res.Service.Port.Name = b.ServicePort.StrVal
+	}
+
+	return res
+}
+
+func ingressV1ToV1beta1(ing *networkingv1.Ingress) *networkingv1beta1.Ingress {
+	res := &networkingv1beta1.Ingress{
+		ObjectMeta: *ing.ObjectMeta.DeepCopy(),
+		Spec: networkingv1beta1.IngressSpec{
+			IngressClassName: ing.Spec.IngressClassName,
+		},
+		Status: networkingv1beta1.IngressStatus{
+			LoadBalancer: *ing.Status.LoadBalancer.DeepCopy(),
+		},
+	}
+	if ing.Spec.DefaultBackend != nil {
+		b := ingressBackendV1ToV1beta1(*ing.Spec.DefaultBackend)
+		res.Spec.Backend = &b
+	}
+
+	for _, tls := range ing.Spec.TLS {
+		res.Spec.TLS = append(res.Spec.TLS, networkingv1beta1.IngressTLS{
+			Hosts:      tls.Hosts,
+			SecretName: tls.SecretName,
+		})
+	}
+
+	for _, rule := range ing.Spec.Rules {
+		r := networkingv1beta1.IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			r.HTTP = &networkingv1beta1.HTTPIngressRuleValue{}
+			for _, path := range rule.HTTP.Paths {
+				p := networkingv1beta1.HTTPIngressPath{
+					Path:    path.Path,
+					Backend: ingressBackendV1ToV1beta1(path.Backend),
+				}
+				if path.PathType != nil {
+					pt := networkingv1beta1.PathType(*path.PathType)
+					p.PathType = &pt
+				}
+				r.HTTP.Paths = append(r.HTTP.Paths, p)
+			}
+		}
+		res.Spec.Rules = append(res.Spec.Rules, r)
+	}
+
+	return res
+}
+
+func ingressBackendV1ToV1beta1(b networkingv1.IngressBackend) networkingv1beta1.IngressBackend {
+	res := networkingv1beta1.IngressBackend{Resource: b.Resource}
+	if b.Service == nil {
+		return res
+	}
+
+	res.ServiceName = b.Service.Name
+	if b.Service.Port.Name != "" {
+		res.ServicePort = intstr.FromString(b.Service.Port.Name)
+	} else {
+		res.ServicePort = intstr.FromInt(int(b.Service.Port.Number))
+	}
+
+	return res
+}
+
+func ingressListV1beta1ToV1(l *networkingv1beta1.IngressList) *networkingv1.IngressList {
+	res := &networkingv1.IngressList{
+		ListMeta: l.ListMeta,
+	}
+	for i := range l.Items {
+		res.Items = append(res.Items, *ingressV1beta1ToV1(&l.Items[i]))
+	}
+
+	return res
+}
+
+// ingressV1beta1WatchToV1 wraps a watcher of `networking.k8s.io/v1beta1` ingresses
+// and converts the received events to `networking.k8s.io/v1` ingresses.
+func ingressV1beta1WatchToV1(w watch.Interface) watch.Interface {
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		ing, ok := in.Object.(*networkingv1beta1.Ingress)
+		if !ok {
+			// Could be a status object (e.g errors), let the consumer handle these.
+			return in, true
+		}
+
+		return watch.Event{Type: in.Type, Object: ingressV1beta1ToV1(ing)}, true
+	})
+}
@@ -3,22 +3,36 @@ package kubernetes
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"strconv"
+	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/slok/bilrost/internal/authbackend/auth0"
 	"github.com/slok/bilrost/internal/authbackend/dex"
+	"github.com/slok/bilrost/internal/authbackend/oidcregistration"
+	"github.com/slok/bilrost/internal/authbackend/staticoidc"
 	"github.com/slok/bilrost/internal/controller"
+	"github.com/slok/bilrost/internal/janitor"
 	"github.com/slok/bilrost/internal/log"
 	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/proxy/bilrostproxy"
+	"github.com/slok/bilrost/internal/proxy/nginx"
 	"github.com/slok/bilrost/internal/proxy/oauth2proxy"
+	"github.com/slok/bilrost/internal/proxy/skipper"
+	"github.com/slok/bilrost/internal/proxy/traefik"
 	"github.com/slok/bilrost/internal/security"
 	authv1 "github.com/slok/bilrost/pkg/apis/auth/v1"
 	kubernetesbilrost "github.com/slok/bilrost/pkg/kubernetes/gen/clientset/versioned"
@@ -27,18 +41,36 @@ import (
 // Service is the Kubernetes service that implements different interfaces around
 // the app that are related with Kubernetes apiserver communication.
 type Service struct {
-	coreCli    kubernetes.Interface
-	bilrostCli kubernetesbilrost.Interface
-	logger     log.Logger
+	coreCli        kubernetes.Interface
+	bilrostCli     kubernetesbilrost.Interface
+	dynamicCli     dynamic.Interface
+	ingressV1beta1 bool
+	logger         log.Logger
 }
 
 // NewService returns a new repository.
-func NewService(coreCli kubernetes.Interface, bilrostCli kubernetesbilrost.Interface, logger log.Logger) Service {
-	return Service{
-		bilrostCli: bilrostCli,
-		coreCli:    coreCli,
-		logger:     logger.WithKV(log.KV{"service": "kubernetes.Service"}),
+//
+// The service will use `networking.k8s.io/v1` ingresses, but if the apiserver only serves
+// `networking.k8s.io/v1beta1` ingresses (detected using discovery), it will use these
+// converting them from and to `networking.k8s.io/v1` transparently.
+func NewService(coreCli kubernetes.Interface, bilrostCli kubernetesbilrost.Interface, dynamicCli dynamic.Interface, logger log.Logger) (Service, error) {
+	logger = logger.WithKV(log.KV{"service": "kubernetes.Service"})
+
+	v1, err := servesIngressV1(coreCli.Discovery())
+	if err != nil {
+		return Service{}, fmt.Errorf("could not discover the ingress API version served: %w", err)
 	}
+	if !v1 {
+		logger.Infof("networking.k8s.io/v1 ingresses not served, using networking.k8s.io/v1beta1")
+	}
+
+	return Service{
+		bilrostCli:     bilrostCli,
+		coreCli:        coreCli,
+		dynamicCli:     dynamicCli,
+		ingressV1beta1: !v1,
+		logger:         logger,
+	}, nil
 }
 
 // GetAuthBackend satisifies controller.AuthBackendRepository interface.
@@ -51,13 +83,96 @@ func (s Service) GetAuthBackend(ctx context.Context, id string) (*model.AuthBack
 	}
 
 	res := mapAuthBackendK8sToModel(ab)
+
+	// Load the auth backends secret data.
+	if res.Dex != nil && ab.Spec.Dex.TLS != nil {
+		tls, err := s.getAuthBackendDexTLS(ctx, *ab.Spec.Dex.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("could not get Dex TLS configuration: %w", err)
+		}
+		res.Dex.TLS = tls
+	}
+
+	if res.OIDCDynamicRegistration != nil && ab.Spec.OIDCDynamicRegistration.InitialAccessTokenSecretRef != nil {
+		token, err := s.getSecretKey(ctx, *ab.Spec.OIDCDynamicRegistration.InitialAccessTokenSecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("could not get initial access token: %w", err)
+		}
+		res.OIDCDynamicRegistration.InitialAccessToken = token
+	}
+
+	if res.Auth0 != nil {
+		data, err := s.getSecretData(ctx, ab.Spec.Auth0.ManagementCredentialsSecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("could not get Auth0 management credentials: %w", err)
+		}
+		res.Auth0.ClientID = string(data["clientID"])
+		res.Auth0.ClientSecret = string(data["clientSecret"])
+	}
+
+	if res.Keycloak != nil {
+		data, err := s.getSecretData(ctx, ab.Spec.Keycloak.AdminCredentialsSecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("could not get Keycloak admin credentials: %w", err)
+		}
+		res.Keycloak.Username = string(data["username"])
+		res.Keycloak.Password = string(data["password"])
+		res.Keycloak.ClientID = string(data["clientID"])
+		res.Keycloak.ClientSecret = string(data["clientSecret"])
+	}
+
 	logger.Debugf("auth backends got")
 
 	return res, nil
 }
 
+// GetAuthBackendCR satisfies controller.HandlerKubernetesRepository interface.
+func (s Service) GetAuthBackendCR(ctx context.Context, name string) (*authv1.AuthBackend, error) {
+	logger := s.logger.WithKV(log.KV{"obj-name": name})
+
+	ab, err := s.bilrostCli.AuthV1().AuthBackends().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debugf("auth backend got")
+
+	return ab, nil
+}
+
+// UpdateAuthBackendStatus satisfies controller.HandlerKubernetesRepository interface.
+func (s Service) UpdateAuthBackendStatus(ctx context.Context, ab *authv1.AuthBackend) error {
+	logger := s.logger.WithKV(log.KV{"obj-name": ab.Name})
+
+	_, err := s.bilrostCli.AuthV1().AuthBackends().UpdateStatus(ctx, ab, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("auth backend status updated")
+
+	return nil
+}
+
+// ListAuthBackends satisfies controller.RetrieverKubernetesRepository interface.
+func (s Service) ListAuthBackends(ctx context.Context, labelSelector map[string]string) (*authv1.AuthBackendList, error) {
+	return s.bilrostCli.AuthV1().AuthBackends().List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(labelSelector).String(),
+	})
+}
+
+// WatchAuthBackends satisfies controller.RetrieverKubernetesRepository interface.
+func (s Service) WatchAuthBackends(ctx context.Context, labelSelector map[string]string) (watch.Interface, error) {
+	return s.bilrostCli.AuthV1().AuthBackends().Watch(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(labelSelector).String(),
+	})
+}
+
 func mapAuthBackendK8sToModel(ab *authv1.AuthBackend) *model.AuthBackend {
 	res := &model.AuthBackend{ID: ab.Name}
+	if ab.Spec.SecretRotation != nil {
+		res.SecretMaxAge = ab.Spec.SecretRotation.MaxAge.Duration
+	}
 
 	switch {
 	case ab.Spec.Dex != nil:
@@ -65,11 +180,82 @@ func mapAuthBackendK8sToModel(ab *authv1.AuthBackend) *model.AuthBackend {
 			APIURL:    ab.Spec.Dex.APIAddress,
 			PublicURL: ab.Spec.Dex.PublicURL,
 		}
+	case ab.Spec.OIDCDynamicRegistration != nil:
+		res.OIDCDynamicRegistration = &model.AuthBackendOIDCDynamicRegistration{
+			IssuerURL:            ab.Spec.OIDCDynamicRegistration.IssuerURL,
+			RegistrationEndpoint: ab.Spec.OIDCDynamicRegistration.RegistrationEndpoint,
+		}
+	case ab.Spec.Auth0 != nil:
+		res.Auth0 = &model.AuthBackendAuth0{
+			Domain: ab.Spec.Auth0.Domain,
+		}
+	case ab.Spec.StaticOIDC != nil:
+		res.StaticOIDC = &model.AuthBackendStaticOIDC{
+			IssuerURL:              ab.Spec.StaticOIDC.IssuerURL,
+			ClientsSecretName:      ab.Spec.StaticOIDC.ClientsSecretRef.Name,
+			ClientsSecretNamespace: ab.Spec.StaticOIDC.ClientsSecretRef.Namespace,
+		}
+	case ab.Spec.Keycloak != nil:
+		res.Keycloak = &model.AuthBackendKeycloak{
+			URL:          ab.Spec.Keycloak.URL,
+			Realm:        ab.Spec.Keycloak.Realm,
+			AdminRealm:   ab.Spec.Keycloak.AdminRealm,
+			GroupsMapper: ab.Spec.Keycloak.GroupsMapper,
+		}
 	}
 
 	return res
 }
 
+func (s Service) getAuthBackendDexTLS(ctx context.Context, cfg authv1.AuthBackendDexTLS) (*model.AuthBackendDexTLS, error) {
+	res := &model.AuthBackendDexTLS{ServerName: cfg.ServerName}
+
+	if cfg.CASecretRef != nil {
+		ca, err := s.getSecretKey(ctx, *cfg.CASecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("could not get CA: %w", err)
+		}
+		res.CA = []byte(ca)
+	}
+
+	if cfg.ClientCertSecretRef != nil {
+		data, err := s.getSecretData(ctx, *cfg.ClientCertSecretRef)
+		if err != nil {
+			return nil, fmt.Errorf("could not get client certificate: %w", err)
+		}
+		res.ClientCert = data[corev1.TLSCertKey]
+		res.ClientKey = data[corev1.TLSPrivateKeyKey]
+		if len(res.ClientCert) == 0 || len(res.ClientKey) == 0 {
+			return nil, fmt.Errorf("missing client certificate or key on %s/%s secret", cfg.ClientCertSecretRef.Namespace, cfg.ClientCertSecretRef.Name)
+		}
+	}
+
+	return res, nil
+}
+
+func (s Service) getSecretKey(ctx context.Context, ref authv1.SecretKeyRef) (string, error) {
+	data, err := s.getSecretData(ctx, authv1.SecretRef{Name: ref.Name, Namespace: ref.Namespace})
+	if err != nil {
+		return "", err
+	}
+
+	v, ok := data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("missing %q key on %s/%s secret", ref.Key, ref.Namespace, ref.Name)
+	}
+
+	return string(v), nil
+}
+
+func (s Service) getSecretData(ctx context.Context, ref authv1.SecretRef) (map[string][]byte, error) {
+	sec, err := s.coreCli.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return sec.Data, nil
+}
+
 // GetIngressAuth satisfies multiple interfaces.
 func (s Service) GetIngressAuth(ctx context.Context, namespace, name string) (*authv1.IngressAuth, error) {
 	logger := s.logger.WithKV(log.KV{"obj-ns": namespace, "obj-name": name})
@@ -84,6 +270,20 @@ func (s Service) GetIngressAuth(ctx context.Context, namespace, name string) (*a
 	return ia, nil
 }
 
+// UpdateIngressAuthStatus satisfies controller.HandlerKubernetesRepository interface.
+func (s Service) UpdateIngressAuthStatus(ctx context.Context, ia *authv1.IngressAuth) error {
+	logger := s.logger.WithKV(log.KV{"obj-ns": ia.Namespace, "obj-name": ia.Name})
+
+	_, err := s.bilrostCli.AuthV1().IngressAuths(ia.Namespace).UpdateStatus(ctx, ia, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("ingress auth status updated")
+
+	return nil
+}
+
 // ListIngressAuths satisfies multiple interfaces.
 func (s Service) ListIngressAuths(ctx context.Context, ns string, labelSelector map[string]string) (*authv1.IngressAuthList, error) {
 	return s.bilrostCli.AuthV1().IngressAuths(ns).List(ctx, metav1.ListOptions{
@@ -116,6 +316,22 @@ func (s Service) EnsureDeployment(ctx context.Context, dep *appsv1.Deployment) e
 		return nil
 	}
 
+	// The deployment selector is immutable, recreate the deployment when it changes
+	// (e.g: the app changed the proxy implementation).
+	if !reflect.DeepEqual(storedDep.Spec.Selector, dep.Spec.Selector) {
+		err = s.coreCli.AppsV1().Deployments(dep.Namespace).Delete(ctx, dep.Name, metav1.DeleteOptions{})
+		if err != nil && !kubeerrors.IsNotFound(err) {
+			return err
+		}
+		_, err = s.coreCli.AppsV1().Deployments(dep.Namespace).Create(ctx, dep, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		logger.Debugf("deployment has been recreated")
+
+		return nil
+	}
+
 	// Force overwrite.
 	dep.ObjectMeta.ResourceVersion = storedDep.ResourceVersion
 	_, err = s.coreCli.AppsV1().Deployments(dep.Namespace).Update(ctx, dep, metav1.UpdateOptions{})
@@ -127,6 +343,13 @@ func (s Service) EnsureDeployment(ctx context.Context, dep *appsv1.Deployment) e
 	return nil
 }
 
+// ListDeployments satisfies janitor.KubernetesRepository interface.
+func (s Service) ListDeployments(ctx context.Context, ns string, labelSelector map[string]string) (*appsv1.DeploymentList, error) {
+	return s.coreCli.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(labelSelector).String(),
+	})
+}
+
 // DeleteDeployment satisfies oauth2proxy.KubernetesRepository interface.
 func (s Service) DeleteDeployment(ctx context.Context, ns, name string) error {
 	logger := s.logger.WithKV(log.KV{"obj-ns": ns, "obj-name": name})
@@ -170,6 +393,18 @@ func (s Service) EnsureService(ctx context.Context, svc *corev1.Service) error {
 	return nil
 }
 
+// GetService satisfies skipper.KubernetesRepository interface.
+func (s Service) GetService(ctx context.Context, ns, name string) (*corev1.Service, error) {
+	return s.coreCli.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+}
+
+// ListServices satisfies janitor.KubernetesRepository interface.
+func (s Service) ListServices(ctx context.Context, ns string, labelSelector map[string]string) (*corev1.ServiceList, error) {
+	return s.coreCli.CoreV1().Services(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(labelSelector).String(),
+	})
+}
+
 // DeleteService satisfies oauth2proxy.KubernetesRepository interface.
 func (s Service) DeleteService(ctx context.Context, ns, name string) error {
 	logger := s.logger.WithKV(log.KV{"obj-ns": ns, "obj-name": name})
@@ -197,6 +432,36 @@ func (s Service) GetSecret(ctx context.Context, ns, name string) (*corev1.Secret
 	return secret, nil
 }
 
+// ListSecrets satisfies janitor.KubernetesRepository interface.
+func (s Service) ListSecrets(ctx context.Context, ns string, labelSelector map[string]string) (*corev1.SecretList, error) {
+	return s.coreCli.CoreV1().Secrets(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(labelSelector).String(),
+	})
+}
+
+// ListSecretsExcludingTypes satisfies controller.RetrieverKubernetesRepository interface.
+func (s Service) ListSecretsExcludingTypes(ctx context.Context, ns string, types []string) (*corev1.SecretList, error) {
+	return s.coreCli.CoreV1().Secrets(ns).List(ctx, metav1.ListOptions{
+		FieldSelector: excludeSecretTypesSelector(types),
+	})
+}
+
+// WatchSecretsExcludingTypes satisfies controller.RetrieverKubernetesRepository interface.
+func (s Service) WatchSecretsExcludingTypes(ctx context.Context, ns string, types []string) (watch.Interface, error) {
+	return s.coreCli.CoreV1().Secrets(ns).Watch(ctx, metav1.ListOptions{
+		FieldSelector: excludeSecretTypesSelector(types),
+	})
+}
+
+func excludeSecretTypesSelector(types []string) string {
+	selectors := make([]fields.Selector, 0, len(types))
+	for _, t := range types {
+		selectors = append(selectors, fields.OneTermNotEqualSelector("type", t))
+	}
+
+	return fields.AndSelectors(selectors...).String()
+}
+
 // EnsureSecret satisfies oauth2proxy.KubernetesRepository interface.
 func (s Service) EnsureSecret(ctx context.Context, secret *corev1.Secret) error {
 	logger := s.logger.WithKV(log.KV{"obj-ns": secret.Namespace, "obj-name": secret.Name})
@@ -239,13 +504,72 @@ func (s Service) DeleteSecret(ctx context.Context, ns, name string) error {
 	return nil
 }
 
-// GetIngress satisfies oauth2proxy.KubernetesRepository interface.
-func (s Service) GetIngress(ctx context.Context, ns, name string) (*networkingv1beta1.Ingress, error) {
+// ListConfigMaps satisfies janitor.KubernetesRepository interface.
+func (s Service) ListConfigMaps(ctx context.Context, ns string, labelSelector map[string]string) (*corev1.ConfigMapList, error) {
+	return s.coreCli.CoreV1().ConfigMaps(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(labelSelector).String(),
+	})
+}
+
+// EnsureConfigMap satisfies oauth2proxy.KubernetesRepository interface.
+func (s Service) EnsureConfigMap(ctx context.Context, cm *corev1.ConfigMap) error {
+	logger := s.logger.WithKV(log.KV{"obj-ns": cm.Namespace, "obj-name": cm.Name})
+
+	storedCM, err := s.coreCli.CoreV1().ConfigMaps(cm.Namespace).Get(ctx, cm.Name, metav1.GetOptions{})
+	if err != nil {
+		if !kubeerrors.IsNotFound(err) {
+			return err
+		}
+		_, err = s.coreCli.CoreV1().ConfigMaps(cm.Namespace).Create(ctx, cm, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		logger.Debugf("configmap has been created")
+
+		return nil
+	}
+
+	// Force overwrite.
+	cm.ObjectMeta.ResourceVersion = storedCM.ResourceVersion
+	_, err = s.coreCli.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	logger.Debugf("configmap has been updated")
+
+	return nil
+}
+
+// DeleteConfigMap satisfies oauth2proxy.KubernetesRepository interface.
+func (s Service) DeleteConfigMap(ctx context.Context, ns, name string) error {
 	logger := s.logger.WithKV(log.KV{"obj-ns": ns, "obj-name": name})
 
-	ing, err := s.coreCli.NetworkingV1beta1().Ingresses(ns).Get(ctx, name, metav1.GetOptions{})
+	err := s.coreCli.CoreV1().ConfigMaps(ns).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	logger.Debugf("configmap has been deleted")
+	return nil
+}
+
+// GetIngress satisfies oauth2proxy.KubernetesRepository interface.
+func (s Service) GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error) {
+	logger := s.logger.WithKV(log.KV{"obj-ns": ns, "obj-name": name})
+
+	var ing *networkingv1.Ingress
+	if s.ingressV1beta1 {
+		legacyIng, err := s.coreCli.NetworkingV1beta1().Ingresses(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		ing = ingressV1beta1ToV1(legacyIng)
+	} else {
+		var err error
+		ing, err = s.coreCli.NetworkingV1().Ingresses(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	logger.Debugf("ingress got")
@@ -254,10 +578,15 @@ func (s Service) GetIngress(ctx context.Context, ns, name string) (*networkingv1
 }
 
 // UpdateIngress satisfies oauth2proxy.KubernetesRepository interface.
-func (s Service) UpdateIngress(ctx context.Context, ingress *networkingv1beta1.Ingress) error {
+func (s Service) UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error {
 	logger := s.logger.WithKV(log.KV{"obj-ns": ingress.Namespace, "obj-name": ingress.Name})
 
-	_, err := s.coreCli.NetworkingV1beta1().Ingresses(ingress.Namespace).Update(ctx, ingress, metav1.UpdateOptions{})
+	var err error
+	if s.ingressV1beta1 {
+		_, err = s.coreCli.NetworkingV1beta1().Ingresses(ingress.Namespace).Update(ctx, ingressV1ToV1beta1(ingress), metav1.UpdateOptions{})
+	} else {
+		_, err = s.coreCli.NetworkingV1().Ingresses(ingress.Namespace).Update(ctx, ingress, metav1.UpdateOptions{})
+	}
 	if err != nil {
 		return err
 	}
@@ -267,42 +596,188 @@ func (s Service) UpdateIngress(ctx context.Context, ingress *networkingv1beta1.I
 	return nil
 }
 
-// ListIngresses satisfies controller.IngressControllerKubeService interface.
-func (s Service) ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*networkingv1beta1.IngressList, error) {
-	return s.coreCli.NetworkingV1beta1().Ingresses(ns).List(ctx, metav1.ListOptions{
+// EnsureIngress satisfies nginx.KubernetesRepository interface.
+func (s Service) EnsureIngress(ctx context.Context, ingress *networkingv1.Ingress) error {
+	logger := s.logger.WithKV(log.KV{"obj-ns": ingress.Namespace, "obj-name": ingress.Name})
+
+	storedIng, err := s.GetIngress(ctx, ingress.Namespace, ingress.Name)
+	if err != nil {
+		if !kubeerrors.IsNotFound(err) {
+			return err
+		}
+		if s.ingressV1beta1 {
+			_, err = s.coreCli.NetworkingV1beta1().Ingresses(ingress.Namespace).Create(ctx, ingressV1ToV1beta1(ingress), metav1.CreateOptions{})
+		} else {
+			_, err = s.coreCli.NetworkingV1().Ingresses(ingress.Namespace).Create(ctx, ingress, metav1.CreateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+		logger.Debugf("ingress has been created")
+
+		return nil
+	}
+
+	// Force overwrite.
+	ingress.ObjectMeta.ResourceVersion = storedIng.ResourceVersion
+	err = s.UpdateIngress(ctx, ingress)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteIngress satisfies nginx.KubernetesRepository interface.
+func (s Service) DeleteIngress(ctx context.Context, ns, name string) error {
+	logger := s.logger.WithKV(log.KV{"obj-ns": ns, "obj-name": name})
+
+	var err error
+	if s.ingressV1beta1 {
+		err = s.coreCli.NetworkingV1beta1().Ingresses(ns).Delete(ctx, name, metav1.DeleteOptions{})
+	} else {
+		err = s.coreCli.NetworkingV1().Ingresses(ns).Delete(ctx, name, metav1.DeleteOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("ingress has been deleted")
+	return nil
+}
+
+var traefikMiddlewareGVR = schema.GroupVersionResource{Group: "traefik.containo.us", Version: "v1alpha1", Resource: "middlewares"}
+
+// EnsureTraefikMiddleware satisfies traefik.KubernetesRepository interface.
+func (s Service) EnsureTraefikMiddleware(ctx context.Context, mw *unstructured.Unstructured) error {
+	logger := s.logger.WithKV(log.KV{"obj-ns": mw.GetNamespace(), "obj-name": mw.GetName()})
+
+	cli := s.dynamicCli.Resource(traefikMiddlewareGVR).Namespace(mw.GetNamespace())
+	storedMw, err := cli.Get(ctx, mw.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if !kubeerrors.IsNotFound(err) {
+			return err
+		}
+		_, err = cli.Create(ctx, mw, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		logger.Debugf("traefik middleware has been created")
+
+		return nil
+	}
+
+	// Force overwrite.
+	mw.SetResourceVersion(storedMw.GetResourceVersion())
+	_, err = cli.Update(ctx, mw, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	logger.Debugf("traefik middleware has been updated")
+
+	return nil
+}
+
+// ListTraefikMiddlewares satisfies janitor.KubernetesRepository interface.
+func (s Service) ListTraefikMiddlewares(ctx context.Context, ns string, labelSelector map[string]string) (*unstructured.UnstructuredList, error) {
+	return s.dynamicCli.Resource(traefikMiddlewareGVR).Namespace(ns).List(ctx, metav1.ListOptions{
 		LabelSelector: labels.Set(labelSelector).String(),
 	})
 }
 
+// DeleteTraefikMiddleware satisfies traefik.KubernetesRepository interface.
+func (s Service) DeleteTraefikMiddleware(ctx context.Context, ns, name string) error {
+	logger := s.logger.WithKV(log.KV{"obj-ns": ns, "obj-name": name})
+
+	err := s.dynamicCli.Resource(traefikMiddlewareGVR).Namespace(ns).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		return err
+	}
+
+	logger.Debugf("traefik middleware has been deleted")
+	return nil
+}
+
+// ListIngresses satisfies controller.IngressControllerKubeService interface.
+func (s Service) ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*networkingv1.IngressList, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: labels.Set(labelSelector).String(),
+	}
+
+	if s.ingressV1beta1 {
+		l, err := s.coreCli.NetworkingV1beta1().Ingresses(ns).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return ingressListV1beta1ToV1(l), nil
+	}
+
+	return s.coreCli.NetworkingV1().Ingresses(ns).List(ctx, opts)
+}
+
 // WatchIngresses satisfies controller.IngressControllerKubeService interface.
 func (s Service) WatchIngresses(ctx context.Context, ns string, labelSelector map[string]string) (watch.Interface, error) {
-	return s.coreCli.NetworkingV1beta1().Ingresses(ns).Watch(ctx, metav1.ListOptions{
+	opts := metav1.ListOptions{
 		LabelSelector: labels.Set(labelSelector).String(),
-	})
-}
+	}
 
-// GetServiceHostAndPort satisifies security.KubeServiceTranslator interface.
-func (s Service) GetServiceHostAndPort(ctx context.Context, svc model.KubernetesService) (string, int, error) {
-	host := fmt.Sprintf("%s.%s.svc.cluster.local", svc.Name, svc.Namespace)
-	port, err := strconv.Atoi(svc.PortOrPortName)
-	if err == nil {
-		return host, port, nil
+	if s.ingressV1beta1 {
+		w, err := s.coreCli.NetworkingV1beta1().Ingresses(ns).Watch(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return ingressV1beta1WatchToV1(w), nil
 	}
 
-	// Our port is based on a name.
+	return s.coreCli.NetworkingV1().Ingresses(ns).Watch(ctx, opts)
+}
+
+// GetServiceEndpoint satisifies security.KubeServiceTranslator interface.
+func (s Service) GetServiceEndpoint(ctx context.Context, svc model.KubernetesService) (*security.ServiceEndpoint, error) {
+	// Don't use the cluster domain, the clusters can have a custom one.
+	host := fmt.Sprintf("%s.%s.svc", svc.Name, svc.Namespace)
+	port, portErr := strconv.Atoi(svc.PortOrPortName)
+
 	// TODO(slok): Should we optimize with DNS SRV resolution although is worse for development? make it optional?.
 	service, err := s.coreCli.CoreV1().Services(svc.Namespace).Get(ctx, svc.Name, metav1.GetOptions{})
 	if err != nil {
-		return "", 0, err
+		// With a port number we don't need the service, although we will not know its app protocol.
+		if portErr == nil && kubeerrors.IsNotFound(err) {
+			return &security.ServiceEndpoint{Host: host, Port: port}, nil
+		}
+		return nil, err
 	}
 
-	for _, port := range service.Spec.Ports {
-		if port.Name == svc.PortOrPortName {
-			return host, int(port.Port), nil
+	for _, p := range service.Spec.Ports {
+		if p.Name == svc.PortOrPortName || (portErr == nil && int(p.Port) == port) {
+			return &security.ServiceEndpoint{Host: host, Port: int(p.Port), Scheme: getAppProtocolScheme(p.AppProtocol)}, nil
 		}
 	}
 
-	return "", 0, fmt.Errorf("missing %s port name on service %s/%s", svc.PortOrPortName, svc.Namespace, svc.Name)
+	if portErr == nil {
+		return &security.ServiceEndpoint{Host: host, Port: port}, nil
+	}
+
+	return nil, fmt.Errorf("missing %s port name on service %s/%s", svc.PortOrPortName, svc.Namespace, svc.Name)
+}
+
+// getAppProtocolScheme returns the scheme of a service port app protocol, the IANA service
+// names and the Kubernetes standard app protocols are supported.
+func getAppProtocolScheme(appProtocol *string) string {
+	if appProtocol == nil {
+		return ""
+	}
+
+	switch strings.ToLower(*appProtocol) {
+	case "http", "kubernetes.io/ws":
+		return "http"
+	case "https", "kubernetes.io/wss":
+		return "https"
+	case "h2c", "kubernetes.io/h2c":
+		return "h2c"
+	}
+
+	return ""
 }
 
 // checkInterface, is a custom internal type that has all the interfaces that our kubernetes.Service must satisfy
@@ -315,9 +790,17 @@ type checkInterface interface {
 	security.AuthBackendRepository
 	security.KubeServiceTranslator
 	oauth2proxy.KubernetesRepository
+	bilrostproxy.KubernetesRepository
+	nginx.KubernetesRepository
+	traefik.KubernetesRepository
+	skipper.KubernetesRepository
 	controller.HandlerKubernetesRepository
 	controller.RetrieverKubernetesRepository
 	dex.KubernetesRepository
+	oidcregistration.KubernetesRepository
+	auth0.KubernetesRepository
+	staticoidc.KubernetesRepository
+	janitor.KubernetesRepository
 }
 
 var _ checkInterface = Service{}
@@ -6,11 +6,13 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/watch"
 
 	"github.com/slok/bilrost/internal/metrics"
 	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/security"
 	authv1 "github.com/slok/bilrost/pkg/apis/auth/v1"
 )
 
@@ -34,6 +36,22 @@ func (m MeasuredService) GetAuthBackend(ctx context.Context, id string) (a *mode
 	return m.next.GetAuthBackend(ctx, id)
 }
 
+// GetAuthBackendCR satisfies controller.HandlerKubernetesRepository interface.
+func (m MeasuredService) GetAuthBackendCR(ctx context.Context, name string) (ab *authv1.AuthBackend, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, "", "GetAuthBackendCR", err == nil, t0)
+	}(time.Now())
+	return m.next.GetAuthBackendCR(ctx, name)
+}
+
+// UpdateAuthBackendStatus satisfies controller.HandlerKubernetesRepository interface.
+func (m MeasuredService) UpdateAuthBackendStatus(ctx context.Context, ab *authv1.AuthBackend) (err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, "", "UpdateAuthBackendStatus", err == nil, t0)
+	}(time.Now())
+	return m.next.UpdateAuthBackendStatus(ctx, ab)
+}
+
 // GetIngressAuth satisfies multiple interfaces.
 func (m MeasuredService) GetIngressAuth(ctx context.Context, namespace, name string) (ia *authv1.IngressAuth, err error) {
 	defer func(t0 time.Time) {
@@ -42,6 +60,30 @@ func (m MeasuredService) GetIngressAuth(ctx context.Context, namespace, name str
 	return m.next.GetIngressAuth(ctx, namespace, name)
 }
 
+// UpdateIngressAuthStatus satisfies controller.HandlerKubernetesRepository interface.
+func (m MeasuredService) UpdateIngressAuthStatus(ctx context.Context, ia *authv1.IngressAuth) (err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ia.Namespace, "UpdateIngressAuthStatus", err == nil, t0)
+	}(time.Now())
+	return m.next.UpdateIngressAuthStatus(ctx, ia)
+}
+
+// ListAuthBackends satisfies controller.RetrieverKubernetesRepository interface.
+func (m MeasuredService) ListAuthBackends(ctx context.Context, labelSelector map[string]string) (abl *authv1.AuthBackendList, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, "", "ListAuthBackends", err == nil, t0)
+	}(time.Now())
+	return m.next.ListAuthBackends(ctx, labelSelector)
+}
+
+// WatchAuthBackends satisfies controller.RetrieverKubernetesRepository interface.
+func (m MeasuredService) WatchAuthBackends(ctx context.Context, labelSelector map[string]string) (i watch.Interface, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, "", "WatchAuthBackends", err == nil, t0)
+	}(time.Now())
+	return m.next.WatchAuthBackends(ctx, labelSelector)
+}
+
 // ListIngressAuths satisfies multiple interfaces.
 func (m MeasuredService) ListIngressAuths(ctx context.Context, namespace string, labelSelector map[string]string) (ial *authv1.IngressAuthList, err error) {
 	defer func(t0 time.Time) {
@@ -66,6 +108,14 @@ func (m MeasuredService) EnsureDeployment(ctx context.Context, dep *appsv1.Deplo
 	return m.next.EnsureDeployment(ctx, dep)
 }
 
+// ListDeployments satisfies janitor.KubernetesRepository interface.
+func (m MeasuredService) ListDeployments(ctx context.Context, ns string, labelSelector map[string]string) (d *appsv1.DeploymentList, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "ListDeployments", err == nil, t0)
+	}(time.Now())
+	return m.next.ListDeployments(ctx, ns, labelSelector)
+}
+
 // DeleteDeployment satisfies oauth2proxy.KubernetesRepository interface.
 func (m MeasuredService) DeleteDeployment(ctx context.Context, ns, name string) (err error) {
 	defer func(t0 time.Time) {
@@ -82,6 +132,22 @@ func (m MeasuredService) EnsureService(ctx context.Context, svc *corev1.Service)
 	return m.next.EnsureService(ctx, svc)
 }
 
+// GetService satisfies skipper.KubernetesRepository interface.
+func (m MeasuredService) GetService(ctx context.Context, ns, name string) (s *corev1.Service, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "GetService", err == nil, t0)
+	}(time.Now())
+	return m.next.GetService(ctx, ns, name)
+}
+
+// ListServices satisfies janitor.KubernetesRepository interface.
+func (m MeasuredService) ListServices(ctx context.Context, ns string, labelSelector map[string]string) (s *corev1.ServiceList, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "ListServices", err == nil, t0)
+	}(time.Now())
+	return m.next.ListServices(ctx, ns, labelSelector)
+}
+
 // DeleteService satisfies oauth2proxy.KubernetesRepository interface.
 func (m MeasuredService) DeleteService(ctx context.Context, ns, name string) (err error) {
 	defer func(t0 time.Time) {
@@ -98,6 +164,30 @@ func (m MeasuredService) GetSecret(ctx context.Context, ns, name string) (s *cor
 	return m.next.GetSecret(ctx, ns, name)
 }
 
+// ListSecrets satisfies janitor.KubernetesRepository interface.
+func (m MeasuredService) ListSecrets(ctx context.Context, ns string, labelSelector map[string]string) (s *corev1.SecretList, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "ListSecrets", err == nil, t0)
+	}(time.Now())
+	return m.next.ListSecrets(ctx, ns, labelSelector)
+}
+
+// ListSecretsExcludingTypes satisfies controller.RetrieverKubernetesRepository interface.
+func (m MeasuredService) ListSecretsExcludingTypes(ctx context.Context, ns string, types []string) (s *corev1.SecretList, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "ListSecretsExcludingTypes", err == nil, t0)
+	}(time.Now())
+	return m.next.ListSecretsExcludingTypes(ctx, ns, types)
+}
+
+// WatchSecretsExcludingTypes satisfies controller.RetrieverKubernetesRepository interface.
+func (m MeasuredService) WatchSecretsExcludingTypes(ctx context.Context, ns string, types []string) (i watch.Interface, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "WatchSecretsExcludingTypes", err == nil, t0)
+	}(time.Now())
+	return m.next.WatchSecretsExcludingTypes(ctx, ns, types)
+}
+
 // EnsureSecret satisfies oauth2proxy.KubernetesRepository interface.
 func (m MeasuredService) EnsureSecret(ctx context.Context, secret *corev1.Secret) (err error) {
 	defer func(t0 time.Time) {
@@ -114,8 +204,32 @@ func (m MeasuredService) DeleteSecret(ctx context.Context, ns, name string) (err
 	return m.next.DeleteSecret(ctx, ns, name)
 }
 
+// ListConfigMaps satisfies janitor.KubernetesRepository interface.
+func (m MeasuredService) ListConfigMaps(ctx context.Context, ns string, labelSelector map[string]string) (c *corev1.ConfigMapList, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "ListConfigMaps", err == nil, t0)
+	}(time.Now())
+	return m.next.ListConfigMaps(ctx, ns, labelSelector)
+}
+
+// EnsureConfigMap satisfies oauth2proxy.KubernetesRepository interface.
+func (m MeasuredService) EnsureConfigMap(ctx context.Context, cm *corev1.ConfigMap) (err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, cm.Namespace, "EnsureConfigMap", err == nil, t0)
+	}(time.Now())
+	return m.next.EnsureConfigMap(ctx, cm)
+}
+
+// DeleteConfigMap satisfies oauth2proxy.KubernetesRepository interface.
+func (m MeasuredService) DeleteConfigMap(ctx context.Context, ns, name string) (err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "DeleteConfigMap", err == nil, t0)
+	}(time.Now())
+	return m.next.DeleteConfigMap(ctx, ns, name)
+}
+
 // GetIngress satisfies oauth2proxy.KubernetesRepository interface.
-func (m MeasuredService) GetIngress(ctx context.Context, ns, name string) (i *networkingv1beta1.Ingress, err error) {
+func (m MeasuredService) GetIngress(ctx context.Context, ns, name string) (i *networkingv1.Ingress, err error) {
 	defer func(t0 time.Time) {
 		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "GetIngress", err == nil, t0)
 	}(time.Now())
@@ -123,15 +237,55 @@ func (m MeasuredService) GetIngress(ctx context.Context, ns, name string) (i *ne
 }
 
 // UpdateIngress satisfies oauth2proxy.KubernetesRepository interface.
-func (m MeasuredService) UpdateIngress(ctx context.Context, ingress *networkingv1beta1.Ingress) (err error) {
+func (m MeasuredService) UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) (err error) {
 	defer func(t0 time.Time) {
 		m.rec.ObserveKubernetesServiceOperation(ctx, ingress.Namespace, "UpdateIngress", err == nil, t0)
 	}(time.Now())
 	return m.next.UpdateIngress(ctx, ingress)
 }
 
+// EnsureIngress satisfies nginx.KubernetesRepository interface.
+func (m MeasuredService) EnsureIngress(ctx context.Context, ingress *networkingv1.Ingress) (err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ingress.Namespace, "EnsureIngress", err == nil, t0)
+	}(time.Now())
+	return m.next.EnsureIngress(ctx, ingress)
+}
+
+// DeleteIngress satisfies nginx.KubernetesRepository interface.
+func (m MeasuredService) DeleteIngress(ctx context.Context, ns, name string) (err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "DeleteIngress", err == nil, t0)
+	}(time.Now())
+	return m.next.DeleteIngress(ctx, ns, name)
+}
+
+// EnsureTraefikMiddleware satisfies traefik.KubernetesRepository interface.
+func (m MeasuredService) EnsureTraefikMiddleware(ctx context.Context, mw *unstructured.Unstructured) (err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, mw.GetNamespace(), "EnsureTraefikMiddleware", err == nil, t0)
+	}(time.Now())
+	return m.next.EnsureTraefikMiddleware(ctx, mw)
+}
+
+// ListTraefikMiddlewares satisfies janitor.KubernetesRepository interface.
+func (m MeasuredService) ListTraefikMiddlewares(ctx context.Context, ns string, labelSelector map[string]string) (l *unstructured.UnstructuredList, err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "ListTraefikMiddlewares", err == nil, t0)
+	}(time.Now())
+	return m.next.ListTraefikMiddlewares(ctx, ns, labelSelector)
+}
+
+// DeleteTraefikMiddleware satisfies traefik.KubernetesRepository interface.
+func (m MeasuredService) DeleteTraefikMiddleware(ctx context.Context, ns, name string) (err error) {
+	defer func(t0 time.Time) {
+		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "DeleteTraefikMiddleware", err == nil, t0)
+	}(time.Now())
+	return m.next.DeleteTraefikMiddleware(ctx, ns, name)
+}
+
 // ListIngresses satisfies controller.IngressControllerKubeService interface.
-func (m MeasuredService) ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (i *networkingv1beta1.IngressList, err error) {
+func (m MeasuredService) ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (i *networkingv1.IngressList, err error) {
 	defer func(t0 time.Time) {
 		m.rec.ObserveKubernetesServiceOperation(ctx, ns, "ListIngresses", err == nil, t0)
 	}(time.Now())
@@ -146,12 +300,12 @@ func (m MeasuredService) WatchIngresses(ctx context.Context, ns string, labelSel
 	return m.next.WatchIngresses(ctx, ns, labelSelector)
 }
 
-// GetServiceHostAndPort satisifies security.KubeServiceTranslator interface.
-func (m MeasuredService) GetServiceHostAndPort(ctx context.Context, svc model.KubernetesService) (h string, p int, err error) {
+// GetServiceEndpoint satisifies security.KubeServiceTranslator interface.
+func (m MeasuredService) GetServiceEndpoint(ctx context.Context, svc model.KubernetesService) (ep *security.ServiceEndpoint, err error) {
 	defer func(t0 time.Time) {
-		m.rec.ObserveKubernetesServiceOperation(ctx, svc.Namespace, "GetServiceHostAndPort", err == nil, t0)
+		m.rec.ObserveKubernetesServiceOperation(ctx, svc.Namespace, "GetServiceEndpoint", err == nil, t0)
 	}(time.Now())
-	return m.next.GetServiceHostAndPort(ctx, svc)
+	return m.next.GetServiceEndpoint(ctx, svc)
 }
 
 var _ checkInterface = MeasuredService{}
@@ -0,0 +1,180 @@
+package leaderelection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/metrics"
+)
+
+// Runner knows how to run a function only while being the leader.
+type Runner interface {
+	// Run blocks until it's the leader, then runs `f`. It will return when `f` returns, when the
+	// leadership is lost (with an error) or when the context is cancelled.
+	Run(ctx context.Context, f func(ctx context.Context) error) error
+}
+
+// Config is the configuration of the leader election Runner.
+type Config struct {
+	// LeaseClient is the Kubernetes client used to manage the Lease.
+	LeaseClient coordinationv1.LeasesGetter
+	// LeaseName is the name of the Lease used as the lock.
+	LeaseName string
+	// LeaseNamespace is the namespace of the Lease used as the lock.
+	LeaseNamespace string
+	// Identity is the ID of the instance, must be unique between all the candidates.
+	Identity string
+	// LeaseDuration is the duration that the standby candidates will wait until forcing
+	// the acquisition of the leadership.
+	LeaseDuration time.Duration
+	// RenewDeadline is the duration that the leader will retry refreshing the leadership
+	// before giving up.
+	RenewDeadline time.Duration
+	// RetryPeriod is the duration the candidates will wait between tries of actions.
+	RetryPeriod time.Duration
+	// MetricsRecorder is the metrics recorder used to report the role of the instance.
+	MetricsRecorder metrics.Recorder
+	Logger          log.Logger
+}
+
+func (c *Config) defaults() error {
+	if c.Logger == nil {
+		c.Logger = log.Dummy
+	}
+	c.Logger = c.Logger.WithKV(log.KV{"service": "leaderelection.Runner"})
+
+	if c.LeaseClient == nil {
+		return fmt.Errorf("lease client is required")
+	}
+
+	if c.LeaseName == "" {
+		return fmt.Errorf("lease name is required")
+	}
+
+	if c.LeaseNamespace == "" {
+		return fmt.Errorf("lease namespace is required")
+	}
+
+	if c.Identity == "" {
+		return fmt.Errorf("identity is required")
+	}
+
+	if c.MetricsRecorder == nil {
+		return fmt.Errorf("metrics recorder is required")
+	}
+
+	if c.LeaseDuration == 0 {
+		c.LeaseDuration = 15 * time.Second
+	}
+
+	if c.RenewDeadline == 0 {
+		c.RenewDeadline = 10 * time.Second
+	}
+
+	if c.RetryPeriod == 0 {
+		c.RetryPeriod = 2 * time.Second
+	}
+
+	if c.LeaseDuration <= c.RenewDeadline {
+		return fmt.Errorf("lease duration must be greater than renew deadline")
+	}
+
+	return nil
+}
+
+type runner struct {
+	cfg     leaderelection.LeaderElectionConfig
+	lease   string
+	id      string
+	metrics metrics.Recorder
+	logger  log.Logger
+}
+
+// NewRunner returns a new leader election Runner based on Kubernetes Leases.
+func NewRunner(cfg Config) (Runner, error) {
+	err := cfg.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	lease := fmt.Sprintf("%s/%s", cfg.LeaseNamespace, cfg.LeaseName)
+	return runner{
+		cfg: leaderelection.LeaderElectionConfig{
+			Lock: &resourcelock.LeaseLock{
+				LeaseMeta: metav1.ObjectMeta{
+					Name:      cfg.LeaseName,
+					Namespace: cfg.LeaseNamespace,
+				},
+				Client:     cfg.LeaseClient,
+				LockConfig: resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+			},
+			LeaseDuration:   cfg.LeaseDuration,
+			RenewDeadline:   cfg.RenewDeadline,
+			RetryPeriod:     cfg.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            lease,
+		},
+		lease:   lease,
+		id:      cfg.Identity,
+		metrics: cfg.MetricsRecorder,
+		logger:  cfg.Logger.WithKV(log.KV{"lease": lease, "identity": cfg.Identity}),
+	}, nil
+}
+
+func (r runner) Run(ctx context.Context, f func(ctx context.Context) error) error {
+	leCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Start as standby.
+	r.metrics.SetLeaderElectionIsLeader(ctx, r.lease, false)
+	r.logger.Infof("running as standby, waiting for the leadership...")
+
+	errC := make(chan error, 1)
+	cfg := r.cfg
+	cfg.Callbacks = leaderelection.LeaderCallbacks{
+		OnStartedLeading: func(ctx context.Context) {
+			r.metrics.SetLeaderElectionIsLeader(ctx, r.lease, true)
+			r.logger.Infof("leadership acquired, running as leader")
+
+			// If our function ends, we don't want to be the leader anymore.
+			errC <- f(ctx)
+			cancel()
+		},
+		OnStoppedLeading: func() {
+			r.metrics.SetLeaderElectionIsLeader(ctx, r.lease, false)
+			r.logger.Infof("not running as leader anymore")
+		},
+		OnNewLeader: func(identity string) {
+			if identity != r.id {
+				r.logger.WithKV(log.KV{"leader": identity}).Infof("running as standby")
+			}
+		},
+	}
+
+	le, err := leaderelection.NewLeaderElector(cfg)
+	if err != nil {
+		return fmt.Errorf("could not create leader elector: %w", err)
+	}
+
+	// Blocks until the context is cancelled or the leadership is lost.
+	le.Run(leCtx)
+
+	select {
+	case err := <-errC:
+		return err
+	default:
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	return fmt.Errorf("leadership lost")
+}
@@ -0,0 +1,97 @@
+package leaderelection_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/slok/bilrost/internal/leaderelection"
+	"github.com/slok/bilrost/internal/log"
+	bilrostprometheus "github.com/slok/bilrost/internal/metrics/prometheus"
+)
+
+func TestRunnerRun(t *testing.T) {
+	tests := map[string]struct {
+		f      func(ctx context.Context) error
+		cancel bool
+		expErr bool
+	}{
+		"The leader should run the function and return when the function ends.": {
+			f: func(ctx context.Context) error { return nil },
+		},
+
+		"The leader should return the error of the function.": {
+			f:      func(ctx context.Context) error { return fmt.Errorf("wanted error") },
+			expErr: true,
+		},
+
+		"The leader should end without error when the context is cancelled.": {
+			f: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+			cancel: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			cli := fake.NewSimpleClientset()
+			r, err := leaderelection.NewRunner(leaderelection.Config{
+				LeaseClient:     cli.CoordinationV1(),
+				LeaseName:       "test-lease",
+				LeaseNamespace:  "test-ns",
+				Identity:        "test-id",
+				LeaseDuration:   2 * time.Second,
+				RenewDeadline:   1 * time.Second,
+				RetryPeriod:     100 * time.Millisecond,
+				MetricsRecorder: bilrostprometheus.NewRecorder(prometheus.NewRegistry()),
+				Logger:          log.Dummy,
+			})
+			require.NoError(err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			ran := make(chan struct{})
+			f := func(ctx context.Context) error {
+				close(ran)
+				return test.f(ctx)
+			}
+			if test.cancel {
+				go func() {
+					<-ran
+					cancel()
+				}()
+			}
+
+			err = r.Run(ctx, f)
+
+			// Check the function has been run as the leader.
+			select {
+			case <-ran:
+			default:
+				assert.Fail("function not executed")
+			}
+
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				// The lease should be ours.
+				lease, err := cli.CoordinationV1().Leases("test-ns").Get(context.Background(), "test-lease", metav1.GetOptions{})
+				require.NoError(err)
+				assert.NotNil(lease.Spec.HolderIdentity)
+			}
+		})
+	}
+}
@@ -17,4 +17,37 @@ type Recorder interface {
 	ObserveAuthBackendAppRegistererOperation(ctx context.Context, appRegistererType, op string, success bool, startAt time.Time)
 	ObserveBackupBackupperOperation(ctx context.Context, backupperType, op string, success bool, startAt time.Time)
 	ObserveKubernetesServiceOperation(ctx context.Context, ns, op string, success bool, startAt time.Time)
+	SetLeaderElectionIsLeader(ctx context.Context, lease string, isLeader bool)
+	SetClientSecretAge(ctx context.Context, authBackend, app string, age time.Duration)
+	DeleteClientSecretAge(ctx context.Context, authBackend, app string)
+	IncDexClientJanitorOrphanCleaned(ctx context.Context, authBackend string, dryRun, success bool)
+	IncProxyJanitorOrphanCleaned(ctx context.Context, resource string, dryRun, success bool)
 }
+
+// Dummy is a dummy metrics recorder.
+var Dummy Recorder = dummy{MetricsRecorder: koopercontroller.DummyMetricsRecorder}
+
+type dummy struct {
+	koopercontroller.MetricsRecorder
+}
+
+func (dummy) ObserveDexAuthBackendDexClientOp(context.Context, string, bool, time.Time) {}
+
+func (dummy) ObserveOIDCProvisionerOperation(context.Context, string, string, bool, time.Time) {}
+
+func (dummy) ObserveAuthBackendAppRegistererOperation(context.Context, string, string, bool, time.Time) {
+}
+
+func (dummy) ObserveBackupBackupperOperation(context.Context, string, string, bool, time.Time) {}
+
+func (dummy) ObserveKubernetesServiceOperation(context.Context, string, string, bool, time.Time) {}
+
+func (dummy) SetLeaderElectionIsLeader(context.Context, string, bool) {}
+
+func (dummy) SetClientSecretAge(context.Context, string, string, time.Duration) {}
+
+func (dummy) DeleteClientSecretAge(context.Context, string, string) {}
+
+func (dummy) IncDexClientJanitorOrphanCleaned(context.Context, string, bool, bool) {}
+
+func (dummy) IncProxyJanitorOrphanCleaned(context.Context, string, bool, bool) {}
@@ -20,6 +20,10 @@ type recorder struct {
 	authBackAppRegOpDuration  *prometheus.HistogramVec
 	backupBackupperOpDuration *prometheus.HistogramVec
 	k8sServiceOpDuration      *prometheus.HistogramVec
+	leaderElectionIsLeader    *prometheus.GaugeVec
+	clientSecretAge           *prometheus.GaugeVec
+	dexCliJanitorOrphans      *prometheus.CounterVec
+	proxyJanitorOrphans       *prometheus.CounterVec
 }
 
 // NewRecorder returns a new metrics.Recorder that knows how
@@ -32,6 +36,9 @@ func NewRecorder(reg prometheus.Registerer) metrics.Recorder {
 		promAuthBackAppRegSubsystem = "auth_backend_app_registerer"
 		promBackupperSubsystem      = "backup_backupper"
 		promKubernetesSvcSubsystem  = "kubernetes_service"
+		promLeaderElectionSubsystem = "leader_election"
+		promDexCliJanitorSubsystem  = "dex_client_janitor"
+		promProxyJanitorSubsystem   = "proxy_janitor"
 	)
 
 	r := recorder{
@@ -73,6 +80,33 @@ func NewRecorder(reg prometheus.Registerer) metrics.Recorder {
 			Name:      "operation_duration_seconds",
 			Help:      "The duration for a kubernetes service operation.",
 		}, []string{"namespace", "operation", "success"}),
+
+		leaderElectionIsLeader: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: promNamespace,
+			Subsystem: promLeaderElectionSubsystem,
+			Name:      "is_leader",
+			Help:      "Is 1 if the instance is the leader of the lease, 0 if it's a standby.",
+		}, []string{"lease"}),
+
+		clientSecretAge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: promNamespace,
+			Name:      "client_secret_age_seconds",
+			Help:      "The age of the apps client secrets on the auth backends.",
+		}, []string{"auth_backend", "app"}),
+
+		dexCliJanitorOrphans: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Subsystem: promDexCliJanitorSubsystem,
+			Name:      "orphans_cleaned_total",
+			Help:      "The total number of orphaned Dex clients cleaned by the janitor.",
+		}, []string{"auth_backend", "dry_run", "success"}),
+
+		proxyJanitorOrphans: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: promNamespace,
+			Subsystem: promProxyJanitorSubsystem,
+			Name:      "orphans_cleaned_total",
+			Help:      "The total number of orphaned proxy resources cleaned by the janitor.",
+		}, []string{"resource", "dry_run", "success"}),
 	}
 
 	// Register metrics.
@@ -82,6 +116,10 @@ func NewRecorder(reg prometheus.Registerer) metrics.Recorder {
 		r.authBackAppRegOpDuration,
 		r.backupBackupperOpDuration,
 		r.k8sServiceOpDuration,
+		r.leaderElectionIsLeader,
+		r.clientSecretAge,
+		r.dexCliJanitorOrphans,
+		r.proxyJanitorOrphans,
 	)
 
 	return r
@@ -111,3 +149,27 @@ func (r recorder) ObserveKubernetesServiceOperation(_ context.Context, namespace
 	r.k8sServiceOpDuration.WithLabelValues(namespace, op, strconv.FormatBool(success)).
 		Observe(time.Since(startAt).Seconds())
 }
+
+func (r recorder) SetLeaderElectionIsLeader(_ context.Context, lease string, isLeader bool) {
+	v := 0.0
+	if isLeader {
+		v = 1
+	}
+	r.leaderElectionIsLeader.WithLabelValues(lease).Set(v)
+}
+
+func (r recorder) SetClientSecretAge(_ context.Context, authBackend, app string, age time.Duration) {
+	r.clientSecretAge.WithLabelValues(authBackend, app).Set(age.Seconds())
+}
+
+func (r recorder) DeleteClientSecretAge(_ context.Context, authBackend, app string) {
+	r.clientSecretAge.DeleteLabelValues(authBackend, app)
+}
+
+func (r recorder) IncDexClientJanitorOrphanCleaned(_ context.Context, authBackend string, dryRun, success bool) {
+	r.dexCliJanitorOrphans.WithLabelValues(authBackend, strconv.FormatBool(dryRun), strconv.FormatBool(success)).Inc()
+}
+
+func (r recorder) IncProxyJanitorOrphanCleaned(_ context.Context, resource string, dryRun, success bool) {
+	r.proxyJanitorOrphans.WithLabelValues(resource, strconv.FormatBool(dryRun), strconv.FormatBool(success)).Inc()
+}
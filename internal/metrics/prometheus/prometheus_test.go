@@ -290,6 +290,72 @@ func TestMetricsRecorder(t *testing.T) {
 				`bilrost_kubernetes_service_operation_duration_seconds_count{namespace="ns2",operation="op3",success="false"} 1`,
 			},
 		},
+
+		"Measure leader election state.": {
+			measure: func(r metrics.Recorder) {
+				ctx := context.TODO()
+				r.SetLeaderElectionIsLeader(ctx, "ns1/lease1", true)
+				r.SetLeaderElectionIsLeader(ctx, "ns1/lease2", true)
+				r.SetLeaderElectionIsLeader(ctx, "ns1/lease2", false)
+			},
+			expMetrics: []string{
+				`# HELP bilrost_leader_election_is_leader Is 1 if the instance is the leader of the lease, 0 if it's a standby.`,
+				`# TYPE bilrost_leader_election_is_leader gauge`,
+				`bilrost_leader_election_is_leader{lease="ns1/lease1"} 1`,
+				`bilrost_leader_election_is_leader{lease="ns1/lease2"} 0`,
+			},
+		},
+
+		"Measure client secrets age.": {
+			measure: func(r metrics.Recorder) {
+				ctx := context.TODO()
+				r.SetClientSecretAge(ctx, "ab1", "ns1/app1", 90*time.Second)
+				r.SetClientSecretAge(ctx, "ab1", "ns1/app2", 2*time.Hour)
+				r.SetClientSecretAge(ctx, "ab1", "ns1/app2", 3*time.Hour)
+				r.SetClientSecretAge(ctx, "ab2", "ns1/app3", time.Hour)
+				r.DeleteClientSecretAge(ctx, "ab2", "ns1/app3")
+			},
+			expMetrics: []string{
+				`# HELP bilrost_client_secret_age_seconds The age of the apps client secrets on the auth backends.`,
+				`# TYPE bilrost_client_secret_age_seconds gauge`,
+				`bilrost_client_secret_age_seconds{app="ns1/app1",auth_backend="ab1"} 90`,
+				`bilrost_client_secret_age_seconds{app="ns1/app2",auth_backend="ab1"} 10800`,
+			},
+		},
+
+		"Measure Dex client janitor orphans cleaned.": {
+			measure: func(r metrics.Recorder) {
+				ctx := context.TODO()
+				r.IncDexClientJanitorOrphanCleaned(ctx, "ab1", false, true)
+				r.IncDexClientJanitorOrphanCleaned(ctx, "ab1", false, true)
+				r.IncDexClientJanitorOrphanCleaned(ctx, "ab1", false, false)
+				r.IncDexClientJanitorOrphanCleaned(ctx, "ab2", true, true)
+			},
+			expMetrics: []string{
+				`# HELP bilrost_dex_client_janitor_orphans_cleaned_total The total number of orphaned Dex clients cleaned by the janitor.`,
+				`# TYPE bilrost_dex_client_janitor_orphans_cleaned_total counter`,
+				`bilrost_dex_client_janitor_orphans_cleaned_total{auth_backend="ab1",dry_run="false",success="true"} 2`,
+				`bilrost_dex_client_janitor_orphans_cleaned_total{auth_backend="ab1",dry_run="false",success="false"} 1`,
+				`bilrost_dex_client_janitor_orphans_cleaned_total{auth_backend="ab2",dry_run="true",success="true"} 1`,
+			},
+		},
+
+		"Measure proxy janitor orphans cleaned.": {
+			measure: func(r metrics.Recorder) {
+				ctx := context.TODO()
+				r.IncProxyJanitorOrphanCleaned(ctx, "deployment", false, true)
+				r.IncProxyJanitorOrphanCleaned(ctx, "deployment", false, true)
+				r.IncProxyJanitorOrphanCleaned(ctx, "service", false, false)
+				r.IncProxyJanitorOrphanCleaned(ctx, "secret", true, true)
+			},
+			expMetrics: []string{
+				`# HELP bilrost_proxy_janitor_orphans_cleaned_total The total number of orphaned proxy resources cleaned by the janitor.`,
+				`# TYPE bilrost_proxy_janitor_orphans_cleaned_total counter`,
+				`bilrost_proxy_janitor_orphans_cleaned_total{dry_run="false",resource="deployment",success="true"} 2`,
+				`bilrost_proxy_janitor_orphans_cleaned_total{dry_run="false",resource="service",success="false"} 1`,
+				`bilrost_proxy_janitor_orphans_cleaned_total{dry_run="true",resource="secret",success="true"} 1`,
+			},
+		},
 	}
 
 	for name, test := range tests {
@@ -1,36 +1,125 @@
 package model
 
 import (
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 )
 
 // AuthBackend is the backend that has the auth system.
 type AuthBackend struct {
 	ID string
+	// SecretMaxAge is the max age of the apps client secrets, if 0 the secrets will not be rotated.
+	SecretMaxAge time.Duration
 
-	Dex *AuthBackendDex
+	Dex                     *AuthBackendDex
+	OIDCDynamicRegistration *AuthBackendOIDCDynamicRegistration
+	Auth0                   *AuthBackendAuth0
+	Keycloak                *AuthBackendKeycloak
+	StaticOIDC              *AuthBackendStaticOIDC
 }
 
 // AuthBackendDex is the configuration of dex AuthBackend.
 type AuthBackendDex struct {
 	APIURL    string
 	PublicURL string
+	// TLS is optional, if missing the connection will be insecure.
+	TLS *AuthBackendDexTLS
+}
+
+// AuthBackendDexTLS is the TLS configuration of the dex AuthBackend API connection.
+type AuthBackendDexTLS struct {
+	// CA is the PEM encoded CA bundle, optional.
+	CA []byte
+	// ClientCert and ClientKey are the PEM encoded client certificate and key, optional.
+	ClientCert []byte
+	ClientKey  []byte
+	ServerName string
+}
+
+// AuthBackendOIDCDynamicRegistration is the configuration of an OIDC AuthBackend that
+// supports dynamic client registration.
+type AuthBackendOIDCDynamicRegistration struct {
+	IssuerURL string
+	// RegistrationEndpoint is optional, if missing it will be discovered.
+	RegistrationEndpoint string
+	// InitialAccessToken is optional, only required if the auth backend requires it.
+	InitialAccessToken string
+}
+
+// AuthBackendAuth0 is the configuration of an Auth0 AuthBackend.
+type AuthBackendAuth0 struct {
+	Domain string
+	// ClientID and ClientSecret are the Auth0 Management API credentials.
+	ClientID     string
+	ClientSecret string
+}
+
+// AuthBackendKeycloak is the configuration of a Keycloak AuthBackend.
+type AuthBackendKeycloak struct {
+	URL        string
+	Realm      string
+	AdminRealm string
+	// Username and Password, or ClientID and ClientSecret are the admin REST API credentials.
+	Username     string
+	Password     string
+	ClientID     string
+	ClientSecret string
+	GroupsMapper bool
+}
+
+// AuthBackendStaticOIDC is the configuration of an OIDC AuthBackend with pre-provisioned clients.
+type AuthBackendStaticOIDC struct {
+	IssuerURL              string
+	ClientsSecretName      string
+	ClientsSecretNamespace string
 }
 
 // App is a representation of an app that wants to be secured.
 type App struct {
 	ID            string
 	AuthBackendID string
-	Host          string
+	// ClientCredentialsKey is the key of the app pre-provisioned client credentials (optional).
+	ClientCredentialsKey string
+	// SecretMaxAge is the max age of the app client secret, overrides the auth backend one (optional).
+	SecretMaxAge  time.Duration
 	Ingress       KubernetesIngress
 	ProxySettings ProxySettings
 }
 
-// KubernetesIngress is the kubernetes service related to the App.
+// Hosts returns the different public hosts of the app, in the same order they
+// appear on the app routes.
+func (a App) Hosts() []string {
+	hosts := []string{}
+	seen := map[string]bool{}
+	for _, r := range a.Ingress.Routes {
+		if seen[r.Host] {
+			continue
+		}
+		seen[r.Host] = true
+		hosts = append(hosts, r.Host)
+	}
+
+	return hosts
+}
+
+// KubernetesIngress is the kubernetes ingress related to the App.
 type KubernetesIngress struct {
 	Name      string
 	Namespace string
-	Upstream  KubernetesService
+	// UID is the Kubernetes UID of the ingress, used to set the ingress as the owner of
+	// the resources created for the app (optional).
+	UID string
+	// Annotations are the annotations of the ingress.
+	Annotations map[string]string
+	Routes      []IngressRoute
+}
+
+// IngressRoute is a public host and path of the app that is routed to an upstream.
+type IngressRoute struct {
+	Host     string
+	Path     string
+	Upstream KubernetesService
 }
 
 // KubernetesService is the kubernetes service related to the App.
@@ -42,8 +131,92 @@ type KubernetesService struct {
 
 // ProxySettings settings are the settings of an oauth2-proxy.
 type ProxySettings struct {
-	Scopes      []string
-	Oauth2Proxy *Oauth2ProxySettings
+	Scopes        []string
+	AccessControl AccessControl
+	// SkipAuthRoutes are the app routes that the proxy will not authenticate.
+	SkipAuthRoutes []SkipAuthRoute
+	Session        SessionSettings
+	Upstream       UpstreamSettings
+	Oauth2Proxy    *Oauth2ProxySettings
+	BilrostProxy   *BilrostProxySettings
+	Nginx          *NginxProxySettings
+	Traefik        *TraefikProxySettings
+	Skipper        *SkipperProxySettings
+}
+
+// AccessControl are the requirements of the authenticated users to access the app, if
+// empty, any authenticated user can access the app.
+type AccessControl struct {
+	// AllowedGroups, the user needs to be in any of them.
+	AllowedGroups []string
+	// AllowedEmails and AllowedEmailDomains, the user needs an allowed email or an email
+	// of an allowed domain.
+	AllowedEmails       []string
+	AllowedEmailDomains []string
+	// RequiredClaims are the ID token claims and the values that the user needs.
+	RequiredClaims map[string]string
+}
+
+// SkipAuthRoute is an app route that doesn't require authentication.
+type SkipAuthRoute struct {
+	// PathRegex matches the request paths.
+	PathRegex string
+	// Methods are the HTTP methods of the route, if empty, any method.
+	Methods []string
+}
+
+// SessionSettings are the settings of the user sessions on the proxy.
+type SessionSettings struct {
+	// CallbackPath is the path of the OIDC callback, if empty the default one will be used.
+	CallbackPath string
+	Cookie       SessionCookie
+	// Store is where the sessions are stored, if empty the sessions are stored on the cookie.
+	Store SessionStore
+	// Redis are the settings of the Redis store.
+	Redis SessionRedis
+}
+
+// SessionCookie are the settings of the session cookie, the empty settings will use
+// the proxy defaults.
+type SessionCookie struct {
+	Name     string
+	Domain   string
+	Expire   time.Duration
+	Refresh  time.Duration
+	SameSite string
+	// Insecure disables the secure flag of the cookie.
+	Insecure bool
+}
+
+// SessionStore is where the user sessions are stored.
+type SessionStore string
+
+const (
+	// SessionStoreCookie stores the sessions on the user cookie.
+	SessionStoreCookie SessionStore = "cookie"
+	// SessionStoreRedis stores the sessions on Redis, the user cookie only has the session ticket.
+	SessionStoreRedis SessionStore = "redis"
+)
+
+// SessionRedis are the settings of the Redis session store.
+type SessionRedis struct {
+	// Address of an existing Redis (`host:port`), if empty a Redis will be provisioned with the proxy.
+	Address string
+	// PasswordSecretName and PasswordSecretKey reference the password of the existing Redis
+	// on the app namespace, optional.
+	PasswordSecretName string
+	PasswordSecretKey  string
+	// Image of the provisioned Redis, if empty the default one will be used.
+	Image string
+}
+
+// UpstreamSettings are the settings used by the proxy to connect to the app upstreams.
+type UpstreamSettings struct {
+	// Scheme of the upstreams, if empty it will be based on the Kubernetes service port.
+	Scheme string
+	// CA is the PEM encoded CA bundle used to verify the upstreams certificates, optional.
+	CA                 []byte
+	InsecureSkipVerify bool
 }
 
 // Oauth2ProxySettings are the settings for an oauth2proxy.
@@ -52,3 +225,31 @@ type Oauth2ProxySettings struct {
 	Replicas  int
 	Resources *corev1.ResourceRequirements // Stable and core (in K8s) enough type to accept as a valid app model type.
 }
+
+// BilrostProxySettings are the settings for the Bilrost OIDC proxy.
+type BilrostProxySettings struct {
+	Oauth2ProxySettings
+	PassAccessToken bool
+}
+
+// NginxProxySettings are the settings for the nginx ingress controller external auth, the
+// authentication is made by an oauth2-proxy.
+type NginxProxySettings struct {
+	Oauth2ProxySettings
+	ResponseHeaders []string
+}
+
+// TraefikProxySettings are the settings for the Traefik forward auth, the authentication is
+// made by an oauth2-proxy.
+type TraefikProxySettings struct {
+	Oauth2ProxySettings
+	AuthResponseHeaders []string
+}
+
+// SkipperProxySettings are the settings for the Skipper OIDC filters, the authentication is
+// made by Skipper without a proxy.
+type SkipperProxySettings struct {
+	Claims          []string
+	UserInfoFields  []string
+	UpstreamHeaders []string
+}
@@ -0,0 +1,86 @@
+package oidcproxy
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errUnauthorized is returned when an authenticated user is not allowed to access the app.
+var errUnauthorized = errors.New("user not allowed")
+
+// authorize checks the user claims against the access control configuration, if the
+// configuration is empty, all the authenticated users are allowed.
+func (h handler) authorize(claims *idTokenClaims) error {
+	if len(h.cfg.AllowedGroups) > 0 && !anyGroupAllowed(claims.Groups, h.cfg.AllowedGroups) {
+		return fmt.Errorf("%w: not in any of the allowed groups", errUnauthorized)
+	}
+
+	if len(h.cfg.AllowedEmails) > 0 || len(h.cfg.AllowedEmailDomains) > 0 {
+		if claims.Email == "" || (claims.EmailVerified != nil && !*claims.EmailVerified) {
+			return fmt.Errorf("%w: missing verified email", errUnauthorized)
+		}
+		if !emailAllowed(claims.Email, h.cfg.AllowedEmails, h.cfg.AllowedEmailDomains) {
+			return fmt.Errorf("%w: %q email not allowed", errUnauthorized, claims.Email)
+		}
+	}
+
+	for claim, value := range h.cfg.RequiredClaims {
+		if !claimHasValue(claims.Raw[claim], value) {
+			return fmt.Errorf("%w: %q claim doesn't have the required value", errUnauthorized, claim)
+		}
+	}
+
+	return nil
+}
+
+func anyGroupAllowed(groups, allowed []string) bool {
+	for _, g := range groups {
+		for _, a := range allowed {
+			if g == a {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// emailAllowed returns true if the email is an allowed email or is from an allowed domain.
+func emailAllowed(email string, allowedEmails, allowedDomains []string) bool {
+	for _, e := range allowedEmails {
+		if strings.EqualFold(email, e) {
+			return true
+		}
+	}
+
+	for _, d := range allowedDomains {
+		if strings.HasSuffix(strings.ToLower(email), "@"+strings.ToLower(d)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// claimHasValue returns true if the claim has the value, if the claim is a list,
+// any of the items can have the value.
+func claimHasValue(claim interface{}, value string) bool {
+	switch c := claim.(type) {
+	case string:
+		return c == value
+	case bool:
+		return strconv.FormatBool(c) == value
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64) == value
+	case []interface{}:
+		for _, item := range c {
+			if claimHasValue(item, value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
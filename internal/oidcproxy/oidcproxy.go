@@ -0,0 +1,755 @@
+package oidcproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/oauth2"
+
+	"github.com/slok/bilrost/internal/log"
+)
+
+// Proxy endpoints.
+const (
+	startPath   = "/oauth2/start"
+	signOutPath = "/oauth2/sign_out"
+	authPath    = "/oauth2/auth"
+)
+
+const loginStateMaxAge = 15 * time.Minute
+
+// Upstream is an app upstream where the authenticated requests are proxied.
+type Upstream struct {
+	// Path is the path prefix of the requests that will be proxied to the upstream, the
+	// prefix is matched by path segments.
+	Path string
+	// URL is the URL of the upstream, only the scheme and the host are used, the requests
+	// are proxied with their original path.
+	URL *url.URL
+}
+
+// SkipAuthRoute is an app route that is proxied without authentication.
+type SkipAuthRoute struct {
+	// Method is the HTTP method of the route, if empty, any method.
+	Method string
+	// PathRegex matches the request paths.
+	PathRegex *regexp.Regexp
+}
+
+// Config is the configuration of the proxy.
+type Config struct {
+	// IssuerURL is the URL of the OIDC provider.
+	IssuerURL string
+	// ClientID is the OIDC client ID of the app.
+	ClientID string
+	// ClientSecret is the OIDC client secret of the app.
+	ClientSecret string
+	// RedirectURL is the URL of the callback, if it's only a path, the request host will be used.
+	RedirectURL string
+	// Scopes are the OIDC scopes requested on the sign in.
+	Scopes []string
+	// CookieSecret is the secret used to encrypt the cookies.
+	CookieSecret string
+	// CookieName is the name of the session cookie.
+	CookieName string
+	// CookieDomain is the domain of the cookies, by default the request host.
+	CookieDomain string
+	// CookieSecure sets the secure flag on the cookies.
+	CookieSecure bool
+	// CookieSameSite is the SameSite attribute of the cookies, by default lax.
+	CookieSameSite http.SameSite
+	// CookieExpire is the max age of the session, after it the user needs to sign in again.
+	CookieExpire time.Duration
+	// CookieRefresh is the duration after the session tokens are refreshed, if 0, they
+	// will only be refreshed when they expire.
+	CookieRefresh time.Duration
+	// Upstreams are the app upstreams.
+	Upstreams []Upstream
+	// UpstreamTLSConfig is the TLS configuration used to connect to the https upstreams, by
+	// default the system one.
+	UpstreamTLSConfig *tls.Config
+	// SkipAuthRoutes are the routes proxied to the upstreams without authentication.
+	SkipAuthRoutes []SkipAuthRoute
+	// AuthOnly will not proxy the requests, it only answers the auth requests of the
+	// ingress controller (e.g: nginx external auth).
+	AuthOnly bool
+	// PassAccessToken will pass the OIDC access token to the upstreams.
+	PassAccessToken bool
+	// AllowedGroups are the groups allowed to access, the user needs to be in any of them.
+	AllowedGroups []string
+	// AllowedEmails and AllowedEmailDomains restrict the access to the users with an
+	// allowed email or an email of an allowed domain.
+	AllowedEmails       []string
+	AllowedEmailDomains []string
+	// RequiredClaims are the ID token claims and the values required to access.
+	RequiredClaims map[string]string
+	// HTTPClient is the client used to communicate with the OIDC provider.
+	HTTPClient *http.Client
+	// TimeNow is used to get the current time.
+	TimeNow func() time.Time
+	// Logger is the logger.
+	Logger log.Logger
+}
+
+func (c *Config) defaults() error {
+	if c.IssuerURL == "" {
+		return fmt.Errorf("issuer URL is required")
+	}
+
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return fmt.Errorf("client ID and client secret are required")
+	}
+
+	if c.RedirectURL == "" {
+		c.RedirectURL = "/oauth2/callback"
+	}
+
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	if c.CookieName == "" {
+		c.CookieName = "_bilrost_proxy"
+	}
+
+	if c.CookieSameSite == 0 {
+		c.CookieSameSite = http.SameSiteLaxMode
+	}
+
+	if c.CookieExpire == 0 {
+		c.CookieExpire = 7 * 24 * time.Hour
+	}
+
+	if len(c.Upstreams) == 0 && !c.AuthOnly {
+		return fmt.Errorf("at least one upstream is required")
+	}
+
+	if c.HTTPClient == nil {
+		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if c.TimeNow == nil {
+		c.TimeNow = time.Now
+	}
+
+	if c.Logger == nil {
+		c.Logger = log.Dummy
+	}
+	c.Logger = c.Logger.WithKV(log.KV{"service": "oidcproxy.Handler"})
+
+	return nil
+}
+
+type upstreamProxy struct {
+	// path is the path prefix without the trailing slash.
+	path  string
+	proxy *httputil.ReverseProxy
+}
+
+// matchesPath returns true if the path is on the upstream path prefix, the match is made
+// by path segments, so `/api` prefix matches `/api` and `/api/v1` but not `/apiv2`.
+func (u upstreamProxy) matchesPath(path string) bool {
+	return path == u.path || strings.HasPrefix(path, u.path+"/")
+}
+
+type handler struct {
+	cfg          Config
+	callbackPath string
+	provider     *provider
+	codec        *cookieCodec
+	upstreams    []upstreamProxy
+	logger       log.Logger
+}
+
+// NewHandler returns a new OIDC auth proxy handler, it authenticates the users using the OIDC
+// authorization code flow with PKCE, stores the sessions on encrypted cookies (refreshing the
+// tokens when they expire) and proxies the authenticated requests to the upstreams with the user
+// information headers.
+func NewHandler(cfg Config) (http.Handler, error) {
+	err := cfg.defaults()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	redirectURL, err := url.Parse(cfg.RedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect URL: %w", err)
+	}
+
+	codec, err := newCookieCodec(cfg.CookieSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cookie secret: %w", err)
+	}
+
+	// Longest paths first, so the most specific upstream is selected.
+	upstreams := make([]upstreamProxy, 0, len(cfg.Upstreams))
+	for _, u := range cfg.Upstreams {
+		upstreams = append(upstreams, upstreamProxy{
+			path:  strings.TrimSuffix(u.Path, "/"),
+			proxy: newUpstreamReverseProxy(u.URL, cfg.UpstreamTLSConfig, cfg.Logger),
+		})
+	}
+	sort.SliceStable(upstreams, func(i, j int) bool { return len(upstreams[i].path) > len(upstreams[j].path) })
+
+	return handler{
+		cfg:          cfg,
+		callbackPath: redirectURL.Path,
+		provider:     newProvider(cfg.IssuerURL, cfg.ClientID, cfg.HTTPClient, cfg.TimeNow),
+		codec:        codec,
+		upstreams:    upstreams,
+		logger:       cfg.Logger,
+	}, nil
+}
+
+// newUpstreamReverseProxy returns the reverse proxy of an upstream, the `h2c` upstreams
+// are proxied using HTTP/2 without TLS.
+func newUpstreamReverseProxy(u *url.URL, tlsConfig *tls.Config, logger log.Logger) *httputil.ReverseProxy {
+	target := &url.URL{Scheme: u.Scheme, Host: u.Host}
+	var transport http.RoundTripper
+	switch u.Scheme {
+	case "h2c":
+		target.Scheme = "http"
+		transport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}
+	case "https":
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tlsConfig
+		transport = t
+	}
+
+	rp := httputil.NewSingleHostReverseProxy(target)
+	if transport != nil {
+		rp.Transport = transport
+	}
+	rp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logger.Errorf("could not proxy request to %q upstream: %s", u.Host, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	return rp
+}
+
+func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case startPath:
+		h.handleStart(w, r, r.URL.Query().Get("rd"))
+	case h.callbackPath:
+		h.handleCallback(w, r)
+	case signOutPath:
+		h.handleSignOut(w, r)
+	case authPath:
+		h.handleAuth(w, r)
+	default:
+		h.handleProxy(w, r)
+	}
+}
+
+// handleStart starts the sign in flow redirecting the user to the OIDC provider.
+func (h handler) handleStart(w http.ResponseWriter, r *http.Request, redirectTo string) {
+	oauth2Cfg, err := h.getOauth2Config(r)
+	if err != nil {
+		h.logger.Errorf("could not start sign in: %s", err)
+		http.Error(w, "could not start sign in", http.StatusInternalServerError)
+		return
+	}
+
+	ls := loginState{RedirectTo: sanitizeRedirect(redirectTo), CreatedAt: h.cfg.TimeNow()}
+	ls.State, err = randomString(32)
+	if err == nil {
+		ls.Nonce, err = randomString(32)
+	}
+	if err == nil {
+		ls.CodeVerifier, err = randomString(32)
+	}
+	if err != nil {
+		h.logger.Errorf("could not generate sign in state: %s", err)
+		http.Error(w, "could not start sign in", http.StatusInternalServerError)
+		return
+	}
+
+	value, err := h.codec.encode(h.csrfCookieName(), ls)
+	if err != nil {
+		h.logger.Errorf("could not encode sign in state: %s", err)
+		http.Error(w, "could not start sign in", http.StatusInternalServerError)
+		return
+	}
+	h.setCookie(w, h.csrfCookieName(), value, loginStateMaxAge)
+
+	authURL := oauth2Cfg.AuthCodeURL(ls.State,
+		oauth2.SetAuthURLParam("nonce", ls.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(ls.CodeVerifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleCallback finishes the sign in flow, exchanges the code for the tokens and creates
+// the user session.
+func (h handler) handleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		h.logger.Warningf("OIDC provider sign in error: %s: %s", e, q.Get("error_description"))
+		http.Error(w, "sign in failed", http.StatusForbidden)
+		return
+	}
+
+	// Check the sign in flow was started by us.
+	ls := loginState{}
+	c, err := r.Cookie(h.csrfCookieName())
+	if err == nil {
+		err = h.codec.decode(h.csrfCookieName(), c.Value, &ls)
+	}
+	if err != nil || ls.State == "" || subtle.ConstantTimeCompare([]byte(ls.State), []byte(q.Get("state"))) != 1 ||
+		h.cfg.TimeNow().Sub(ls.CreatedAt) > loginStateMaxAge {
+		http.Error(w, "invalid sign in state", http.StatusForbidden)
+		return
+	}
+	h.setCookie(w, h.csrfCookieName(), "", -1)
+
+	oauth2Cfg, err := h.getOauth2Config(r)
+	if err != nil {
+		h.logger.Errorf("could not finish sign in: %s", err)
+		http.Error(w, "could not finish sign in", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := h.oauth2Context(r.Context())
+	token, err := oauth2Cfg.Exchange(ctx, q.Get("code"), oauth2.SetAuthURLParam("code_verifier", ls.CodeVerifier))
+	if err != nil {
+		h.logger.Warningf("could not exchange code: %s", err)
+		http.Error(w, "could not finish sign in", http.StatusInternalServerError)
+		return
+	}
+
+	s := &session{CreatedAt: h.cfg.TimeNow()}
+	err = h.updateSession(ctx, s, token, ls.Nonce)
+	if errors.Is(err, errUnauthorized) {
+		h.logger.Infof("access denied: %s", err)
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		h.logger.Warningf("invalid sign in tokens: %s", err)
+		http.Error(w, "could not finish sign in", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.saveSession(w, r, s)
+	if err != nil {
+		h.logger.Errorf("could not save session: %s", err)
+		http.Error(w, "could not finish sign in", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, ls.RedirectTo, http.StatusFound)
+}
+
+func (h handler) handleSignOut(w http.ResponseWriter, r *http.Request) {
+	h.deleteSessionCookies(w, r)
+	http.Redirect(w, r, sanitizeRedirect(r.URL.Query().Get("rd")), http.StatusFound)
+}
+
+// handleAuth answers the ingress controllers auth requests, the user information is
+// returned on the response headers.
+func (h handler) handleAuth(w http.ResponseWriter, r *http.Request) {
+	s := h.getSession(w, r)
+	if s == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	for k, v := range h.identityHeaders(s, "X-Auth-Request-") {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h handler) handleProxy(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.AuthOnly {
+		http.NotFound(w, r)
+		return
+	}
+
+	var s *session
+	if !h.skipAuth(r) {
+		s = h.getSession(w, r)
+		if s == nil {
+			// Only the browser navigations can follow the sign in flow.
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			h.handleStart(w, r, r.URL.RequestURI())
+			return
+		}
+	}
+
+	var upstream *upstreamProxy
+	for i, u := range h.upstreams {
+		if u.matchesPath(r.URL.Path) {
+			upstream = &h.upstreams[i]
+			break
+		}
+	}
+	if upstream == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Don't trust the identity headers of the client and don't leak our cookies to the upstream.
+	r = r.Clone(r.Context())
+	for _, prefix := range []string{"X-Forwarded-", "X-Auth-Request-"} {
+		for _, k := range identityHeaderNames {
+			r.Header.Del(prefix + k)
+		}
+	}
+	if s != nil {
+		for _, prefix := range []string{"X-Forwarded-", "X-Auth-Request-"} {
+			for k, v := range h.identityHeaders(s, prefix) {
+				r.Header.Set(k, v)
+			}
+		}
+	}
+	h.removeProxyCookies(r)
+
+	upstream.proxy.ServeHTTP(w, r)
+}
+
+// skipAuth returns true if the request matches any of the routes that don't require
+// authentication.
+func (h handler) skipAuth(r *http.Request) bool {
+	for _, route := range h.cfg.SkipAuthRoutes {
+		if route.Method != "" && route.Method != r.Method {
+			continue
+		}
+		if route.PathRegex != nil && route.PathRegex.MatchString(r.URL.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var identityHeaderNames = []string{"User", "Email", "Preferred-Username", "Groups", "Access-Token"}
+
+func (h handler) identityHeaders(s *session, prefix string) map[string]string {
+	headers := map[string]string{prefix + "User": s.User}
+	if s.Email != "" {
+		headers[prefix+"Email"] = s.Email
+	}
+	if s.PreferredUsername != "" {
+		headers[prefix+"Preferred-Username"] = s.PreferredUsername
+	}
+	if len(s.Groups) > 0 {
+		headers[prefix+"Groups"] = strings.Join(s.Groups, ",")
+	}
+	if h.cfg.PassAccessToken && s.AccessToken != "" {
+		headers[prefix+"Access-Token"] = s.AccessToken
+	}
+
+	return headers
+}
+
+// getSession returns the user session of the request, if the session tokens have expired, they
+// will be refreshed. If the request doesn't have a valid session it will return nil.
+func (h handler) getSession(w http.ResponseWriter, r *http.Request) *session {
+	value := h.readSessionCookies(r)
+	if value == "" {
+		return nil
+	}
+
+	s := &session{}
+	err := h.codec.decode(h.cfg.CookieName, value, s)
+	if err != nil {
+		h.logger.Debugf("invalid session cookie: %s", err)
+		return nil
+	}
+
+	now := h.cfg.TimeNow()
+	if now.Sub(s.CreatedAt) > h.cfg.CookieExpire {
+		return nil
+	}
+
+	refresh := h.cfg.CookieRefresh > 0 && now.Sub(s.UpdatedAt) >= h.cfg.CookieRefresh
+	if now.Before(s.ExpiresAt) && !refresh {
+		return s
+	}
+
+	// Expired session or refresh required, refresh the tokens.
+	if s.RefreshToken == "" {
+		return nil
+	}
+
+	oauth2Cfg, err := h.getOauth2Config(r)
+	if err != nil {
+		h.logger.Errorf("could not refresh session: %s", err)
+		return nil
+	}
+
+	ctx := h.oauth2Context(r.Context())
+	token, err := oauth2Cfg.TokenSource(ctx, &oauth2.Token{RefreshToken: s.RefreshToken}).Token()
+	if err != nil {
+		h.logger.Debugf("could not refresh session tokens: %s", err)
+		return nil
+	}
+
+	err = h.updateSession(ctx, s, token, "")
+	if err != nil {
+		h.logger.Warningf("invalid refreshed tokens: %s", err)
+		return nil
+	}
+
+	err = h.saveSession(w, r, s)
+	if err != nil {
+		h.logger.Errorf("could not save refreshed session: %s", err)
+		return nil
+	}
+
+	return s
+}
+
+// updateSession updates the session with the tokens, the ID token is optional on the refreshes.
+func (h handler) updateSession(ctx context.Context, s *session, token *oauth2.Token, nonce string) error {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" && s.User == "" {
+		return fmt.Errorf("ID token missing")
+	}
+
+	var idTokenExpiry time.Time
+	if rawIDToken != "" {
+		claims, err := h.provider.verifyIDToken(ctx, rawIDToken, nonce)
+		if err != nil {
+			return err
+		}
+
+		if s.User != "" && s.User != claims.Subject {
+			return fmt.Errorf("ID token subject changed")
+		}
+		// Check on every ID token, the user claims could have changed since the sign in.
+		err = h.authorize(claims)
+		if err != nil {
+			return err
+		}
+
+		s.User = claims.Subject
+		s.Email = claims.Email
+		s.PreferredUsername = claims.PreferredUsername
+		s.Groups = claims.Groups
+		idTokenExpiry = time.Unix(claims.Expiry, 0)
+	}
+
+	// Only store the access token when used, the session cookies are size limited.
+	if h.cfg.PassAccessToken {
+		s.AccessToken = token.AccessToken
+	}
+	if token.RefreshToken != "" {
+		s.RefreshToken = token.RefreshToken
+	}
+	s.ExpiresAt = token.Expiry
+	if s.ExpiresAt.IsZero() {
+		s.ExpiresAt = idTokenExpiry
+	}
+	s.UpdatedAt = h.cfg.TimeNow()
+
+	return nil
+}
+
+const (
+	// sessionCookieChunkSize is the max size of a session cookie value, the browsers drop the
+	// cookies bigger than 4096 bytes (name, value and attributes).
+	sessionCookieChunkSize = 3840
+	// maxSessionCookieChunks is the max number of cookies a session can be split into, the
+	// servers limit the size of the request headers (e.g: 8KB on nginx by default).
+	maxSessionCookieChunks = 3
+)
+
+// saveSession stores the session on the session cookie, if the session doesn't fit in a
+// single cookie it will be split into multiple cookies (`{name}_0`, `{name}_1`...). The
+// session cookies of the request that are not used anymore are deleted.
+func (h handler) saveSession(w http.ResponseWriter, r *http.Request, s *session) error {
+	value, err := h.codec.encode(h.cfg.CookieName, s)
+	if err != nil {
+		return err
+	}
+
+	chunks := []string{}
+	for len(value) > sessionCookieChunkSize {
+		chunks = append(chunks, value[:sessionCookieChunkSize])
+		value = value[sessionCookieChunkSize:]
+	}
+	chunks = append(chunks, value)
+	if len(chunks) > maxSessionCookieChunks {
+		return fmt.Errorf("session is too big, %d cookies required and the max is %d", len(chunks), maxSessionCookieChunks)
+	}
+
+	names := []string{h.cfg.CookieName}
+	if len(chunks) > 1 {
+		names = make([]string, 0, len(chunks))
+		for i := range chunks {
+			names = append(names, h.sessionChunkCookieName(i))
+		}
+	}
+
+	h.deleteSessionCookies(w, r, names...)
+	maxAge := h.cfg.CookieExpire - h.cfg.TimeNow().Sub(s.CreatedAt)
+	for i, chunk := range chunks {
+		h.setCookie(w, names[i], chunk, maxAge)
+	}
+
+	return nil
+}
+
+// readSessionCookies returns the session cookie value, joining the chunks if the session was
+// split into multiple cookies. If missing it will return an empty value.
+func (h handler) readSessionCookies(r *http.Request) string {
+	c, err := r.Cookie(h.cfg.CookieName)
+	if err == nil {
+		return c.Value
+	}
+
+	var sb strings.Builder
+	for i := 0; i < maxSessionCookieChunks; i++ {
+		c, err := r.Cookie(h.sessionChunkCookieName(i))
+		if err != nil {
+			break
+		}
+		sb.WriteString(c.Value)
+	}
+
+	return sb.String()
+}
+
+// deleteSessionCookies deletes the session cookies of the request, except the kept ones.
+func (h handler) deleteSessionCookies(w http.ResponseWriter, r *http.Request, keep ...string) {
+	for _, c := range r.Cookies() {
+		if !h.isSessionCookie(c.Name) || sliceContainsString(keep, c.Name) {
+			continue
+		}
+		h.setCookie(w, c.Name, "", -1)
+	}
+}
+
+func (h handler) isSessionCookie(name string) bool {
+	if name == h.cfg.CookieName {
+		return true
+	}
+
+	i, err := strconv.Atoi(strings.TrimPrefix(name, h.cfg.CookieName+"_"))
+	return strings.HasPrefix(name, h.cfg.CookieName+"_") && err == nil && i >= 0
+}
+
+func (h handler) sessionChunkCookieName(i int) string {
+	return fmt.Sprintf("%s_%d", h.cfg.CookieName, i)
+}
+
+func (h handler) getOauth2Config(r *http.Request) (*oauth2.Config, error) {
+	endpoint, err := h.provider.getEndpoint(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Config{
+		ClientID:     h.cfg.ClientID,
+		ClientSecret: h.cfg.ClientSecret,
+		Endpoint:     endpoint,
+		RedirectURL:  h.getRedirectURL(r),
+		Scopes:       h.cfg.Scopes,
+	}, nil
+}
+
+// getRedirectURL returns the callback URL, if the configured redirect URL is only a path
+// we will use the request host, this way a proxy can serve multiple hosts.
+func (h handler) getRedirectURL(r *http.Request) string {
+	if strings.HasPrefix(h.cfg.RedirectURL, "http://") || strings.HasPrefix(h.cfg.RedirectURL, "https://") {
+		return h.cfg.RedirectURL
+	}
+
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, host, h.cfg.RedirectURL)
+}
+
+func (h handler) oauth2Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, h.cfg.HTTPClient)
+}
+
+func (h handler) csrfCookieName() string {
+	return h.cfg.CookieName + "_csrf"
+}
+
+func (h handler) setCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   h.cfg.CookieDomain,
+		HttpOnly: true,
+		Secure:   h.cfg.CookieSecure,
+		SameSite: h.cfg.CookieSameSite,
+		MaxAge:   int(maxAge.Seconds()),
+	}
+	// Delete the cookie.
+	if maxAge < 0 {
+		c.MaxAge = -1
+	}
+
+	http.SetCookie(w, c)
+}
+
+// removeProxyCookies removes the proxy cookies from the request.
+func (h handler) removeProxyCookies(r *http.Request) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if strings.HasPrefix(c.Name, h.cfg.CookieName) {
+			continue
+		}
+		r.AddCookie(c)
+	}
+}
+
+// sanitizeRedirect only allows redirecting to relative paths of the same host, this way
+// the proxy can't be used as an open redirect.
+func sanitizeRedirect(rd string) string {
+	if !strings.HasPrefix(rd, "/") || strings.HasPrefix(rd, "//") || strings.HasPrefix(rd, "/\\") {
+		return "/"
+	}
+
+	return rd
+}
+
+func sliceContainsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
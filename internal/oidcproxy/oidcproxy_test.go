@@ -0,0 +1,640 @@
+package oidcproxy_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/oidcproxy"
+)
+
+const (
+	testClientID     = "my-app-bilrost"
+	testClientSecret = "my-secret"
+)
+
+type fakeCode struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+// fakeIdP is an in-process OIDC provider that approves all the sign ins.
+type fakeIdP struct {
+	t         *testing.T
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	expiresIn int
+
+	mu          sync.Mutex
+	kid         string
+	groups      []string
+	codes       map[string]fakeCode
+	refreshes   int
+	keysFetches int
+}
+
+func newFakeIdP(t *testing.T, expiresIn int) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &fakeIdP{t: t, key: key, expiresIn: expiresIn, kid: "test", groups: []string{"team-a", "team-b"}, codes: map[string]fakeCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.handleDiscovery)
+	mux.HandleFunc("/keys", idp.handleKeys)
+	mux.HandleFunc("/auth", idp.handleAuth)
+	mux.HandleFunc("/token", idp.handleToken)
+	idp.server = httptest.NewServer(mux)
+
+	return idp
+}
+
+func (f *fakeIdP) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 f.server.URL,
+		"authorization_endpoint": f.server.URL + "/auth",
+		"token_endpoint":         f.server.URL + "/token",
+		"jwks_uri":               f.server.URL + "/keys",
+	})
+}
+
+func (f *fakeIdP) handleKeys(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.keysFetches++
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": f.kid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (f *fakeIdP) handleAuth(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid auth request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	code := fmt.Sprintf("code-%d", len(f.codes))
+	f.codes[code] = fakeCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), redirectURI: q.Get("redirect_uri")}
+	f.mu.Unlock()
+
+	http.Redirect(w, r, fmt.Sprintf("%s?code=%s&state=%s", q.Get("redirect_uri"), code, url.QueryEscape(q.Get("state"))), http.StatusFound)
+}
+
+func (f *fakeIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	nonce := ""
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		code, ok := f.codes[r.FormValue("code")]
+		delete(f.codes, r.FormValue("code"))
+		h := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || code.challenge != base64.RawURLEncoding.EncodeToString(h[:]) || code.redirectURI != r.FormValue("redirect_uri") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		nonce = code.nonce
+	case "refresh_token":
+		if r.FormValue("refresh_token") != "refresh-token" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		f.refreshes++
+	default:
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  fmt.Sprintf("access-token-%d", f.refreshes),
+		"token_type":    "bearer",
+		"expires_in":    f.expiresIn,
+		"refresh_token": "refresh-token",
+		"id_token":      f.idToken(nonce),
+	})
+}
+
+func (f *fakeIdP) idToken(nonce string) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": f.kid, "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":                f.server.URL,
+		"sub":                "user-id",
+		"aud":                testClientID,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              nonce,
+		"email":              "user@slok.dev",
+		"preferred_username": "user",
+		"groups":             f.groups,
+	})
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	h := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, h[:])
+	require.NoError(f.t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (f *fakeIdP) refreshCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refreshes
+}
+
+func (f *fakeIdP) keysFetchCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.keysFetches
+}
+
+// rotateKey changes the ID of the signing key, the ID tokens signed before the rotation will
+// have an unknown key for the proxy.
+func (f *fakeIdP) rotateKey(kid string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.kid = kid
+}
+
+// newEchoUpstream returns an upstream that answers with the request headers prefixed with `X-Echo-`,
+// the upstream will use TLS with `https` scheme and HTTP/2 without TLS with `h2c` scheme.
+func newEchoUpstream(scheme string) (*httptest.Server, *url.URL) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range r.Header {
+			w.Header().Set("X-Echo-"+k, strings.Join(v, ","))
+		}
+		w.Header().Set("X-Echo-Path", r.URL.Path)
+		w.Header().Set("X-Echo-Proto", r.Proto)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var srv *httptest.Server
+	switch scheme {
+	case "https":
+		srv = httptest.NewTLSServer(h)
+	case "h2c":
+		srv = httptest.NewServer(h2c.NewHandler(h, &http2.Server{}))
+	default:
+		srv = httptest.NewServer(h)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	if scheme == "h2c" {
+		u.Scheme = "h2c"
+	}
+
+	return srv, u
+}
+
+// getGroups returns n groups, used to get big sessions.
+func getGroups(n int) []string {
+	groups := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		groups = append(groups, fmt.Sprintf("team-%04d", i))
+	}
+	return groups
+}
+
+type testRequest struct {
+	method     string
+	path       string
+	headers    map[string]string
+	expStatus  int
+	expHeaders map[string]string
+}
+
+func TestHandler(t *testing.T) {
+	tests := map[string]struct {
+		authOnly       bool
+		upstreamScheme string
+		config         func(cfg *oidcproxy.Config)
+		expiresIn      int
+		idpGroups      []string
+		requests       []testRequest
+		expRefreshes   int
+	}{
+		"An unauthenticated request should sign in the user and be proxied with the user headers.": {
+			expiresIn: 3600,
+			requests: []testRequest{
+				{
+					method:    http.MethodGet,
+					path:      "/test?q=1",
+					headers:   map[string]string{"X-Forwarded-Email": "attacker@slok.dev", "X-Auth-Request-Groups": "admin"},
+					expStatus: http.StatusOK,
+					expHeaders: map[string]string{
+						"X-Echo-Path":                              "/test",
+						"X-Echo-X-Forwarded-User":                  "user-id",
+						"X-Echo-X-Forwarded-Email":                 "user@slok.dev",
+						"X-Echo-X-Forwarded-Preferred-Username":    "user",
+						"X-Echo-X-Forwarded-Groups":                "team-a,team-b",
+						"X-Echo-X-Auth-Request-User":               "user-id",
+						"X-Echo-X-Auth-Request-Email":              "user@slok.dev",
+						"X-Echo-X-Auth-Request-Preferred-Username": "user",
+						"X-Echo-X-Auth-Request-Groups":             "team-a,team-b",
+						"X-Echo-X-Auth-Request-Access-Token":       "",
+						"X-Echo-Cookie":                            "",
+					},
+				},
+			},
+		},
+
+		"An https upstream should be proxied using the upstream TLS configuration.": {
+			upstreamScheme: "https",
+			expiresIn:      3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-X-Forwarded-User": "user-id"}},
+			},
+		},
+
+		"An https upstream with an unknown certificate should fail.": {
+			upstreamScheme: "https",
+			config: func(cfg *oidcproxy.Config) {
+				cfg.UpstreamTLSConfig = nil
+			},
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusBadGateway},
+			},
+		},
+
+		"An h2c upstream should be proxied using HTTP/2 without TLS.": {
+			upstreamScheme: "h2c",
+			expiresIn:      3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-Proto": "HTTP/2.0"}},
+			},
+		},
+
+		"An unauthenticated non navigation request should not start the sign in.": {
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodPost, path: "/test", expStatus: http.StatusUnauthorized},
+			},
+		},
+
+		"The skip auth routes should be proxied without authentication and without the user headers.": {
+			config: func(cfg *oidcproxy.Config) {
+				cfg.SkipAuthRoutes = []oidcproxy.SkipAuthRoute{
+					{PathRegex: regexp.MustCompile(`^/public/`)},
+					{Method: http.MethodGet, PathRegex: regexp.MustCompile(`^/health$`)},
+				}
+			},
+			expiresIn: 3600,
+			requests: []testRequest{
+				{
+					method:    http.MethodPost,
+					path:      "/public/test",
+					headers:   map[string]string{"X-Forwarded-User": "attacker"},
+					expStatus: http.StatusOK,
+					expHeaders: map[string]string{
+						"X-Echo-Path":             "/public/test",
+						"X-Echo-X-Forwarded-User": "",
+					},
+				},
+				{method: http.MethodGet, path: "/health", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-Path": "/health"}},
+				{method: http.MethodPost, path: "/health", expStatus: http.StatusUnauthorized},
+				{method: http.MethodPost, path: "/test/public/", expStatus: http.StatusUnauthorized},
+			},
+		},
+
+		"The upstream path prefix should be matched by path segments.": {
+			config: func(cfg *oidcproxy.Config) {
+				cfg.Upstreams[0].Path = "/api/"
+			},
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/api", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-Path": "/api"}},
+				{method: http.MethodGet, path: "/api/v1", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-Path": "/api/v1"}},
+				{method: http.MethodGet, path: "/apiv2", expStatus: http.StatusNotFound},
+			},
+		},
+
+		"The sign in should only redirect to the proxy host paths.": {
+			expiresIn: 3600,
+			requests: []testRequest{
+				{
+					method:     http.MethodGet,
+					path:       "/oauth2/start?rd=https://attacker.slok.dev",
+					expStatus:  http.StatusOK,
+					expHeaders: map[string]string{"X-Echo-Path": "/"},
+				},
+			},
+		},
+
+		"An expired session should be refreshed.": {
+			expiresIn: -1,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-X-Forwarded-User": "user-id"}},
+			},
+			expRefreshes: 2,
+		},
+
+		"A session should be refreshed after the cookie refresh duration.": {
+			config: func(cfg *oidcproxy.Config) {
+				cfg.CookieRefresh = time.Nanosecond
+			},
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-X-Forwarded-User": "user-id"}},
+			},
+			expRefreshes: 2,
+		},
+
+		"A custom callback path should be used on the sign in.": {
+			config: func(cfg *oidcproxy.Config) {
+				cfg.RedirectURL = "/auth/callback"
+			},
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-X-Forwarded-User": "user-id"}},
+				{method: http.MethodGet, path: "/oauth2/callback?code=code-0&state=whatever", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-Path": "/oauth2/callback"}},
+			},
+		},
+
+		"A signed out user should need to sign in again.": {
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/oauth2/sign_out?rd=/oauth2/auth", expStatus: http.StatusUnauthorized},
+			},
+		},
+
+		"A session that doesn't fit in a cookie should be split into multiple cookies.": {
+			expiresIn: 3600,
+			idpGroups: getGroups(400),
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/oauth2/auth", expStatus: http.StatusAccepted, expHeaders: map[string]string{"X-Auth-Request-User": "user-id"}},
+			},
+		},
+
+		"A signed out user with a session split into multiple cookies should need to sign in again.": {
+			expiresIn: 3600,
+			idpGroups: getGroups(400),
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK},
+				{method: http.MethodGet, path: "/oauth2/sign_out?rd=/oauth2/auth", expStatus: http.StatusUnauthorized},
+			},
+		},
+
+		"A session too big to be stored should fail the sign in.": {
+			expiresIn: 3600,
+			idpGroups: getGroups(2000),
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusInternalServerError},
+			},
+		},
+
+		"A callback without a sign in started by the proxy should fail.": {
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/oauth2/callback?code=code-0&state=whatever", expStatus: http.StatusForbidden},
+			},
+		},
+
+		"In auth only mode, the auth requests should be authenticated with the user session.": {
+			authOnly:  true,
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/oauth2/auth", expStatus: http.StatusUnauthorized},
+				{
+					method:    http.MethodGet,
+					path:      "/oauth2/start?rd=/oauth2/auth",
+					expStatus: http.StatusAccepted,
+					expHeaders: map[string]string{
+						"X-Auth-Request-User":               "user-id",
+						"X-Auth-Request-Email":              "user@slok.dev",
+						"X-Auth-Request-Preferred-Username": "user",
+						"X-Auth-Request-Groups":             "team-a,team-b",
+					},
+				},
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusNotFound},
+			},
+		},
+
+		"A user that matches the access control should be allowed.": {
+			config: func(cfg *oidcproxy.Config) {
+				cfg.AllowedGroups = []string{"team-b", "team-c"}
+				cfg.AllowedEmails = []string{"admin@slok.dev"}
+				cfg.AllowedEmailDomains = []string{"SLOK.dev"}
+				cfg.RequiredClaims = map[string]string{"groups": "team-a", "preferred_username": "user"}
+			},
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusOK, expHeaders: map[string]string{"X-Echo-X-Forwarded-User": "user-id"}},
+			},
+		},
+
+		"A user that is not in the allowed groups should be denied.": {
+			config: func(cfg *oidcproxy.Config) {
+				cfg.AllowedGroups = []string{"team-c"}
+			},
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusForbidden},
+			},
+		},
+
+		"A user without an allowed email should be denied.": {
+			config: func(cfg *oidcproxy.Config) {
+				cfg.AllowedEmails = []string{"admin@slok.dev"}
+				cfg.AllowedEmailDomains = []string{"other.slok.dev"}
+			},
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusForbidden},
+			},
+		},
+
+		"A user without the required claims should be denied.": {
+			config: func(cfg *oidcproxy.Config) {
+				cfg.RequiredClaims = map[string]string{"preferred_username": "admin"}
+			},
+			expiresIn: 3600,
+			requests: []testRequest{
+				{method: http.MethodGet, path: "/test", expStatus: http.StatusForbidden},
+			},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			// Prepare.
+			idp := newFakeIdP(t, test.expiresIn)
+			defer idp.server.Close()
+			if test.idpGroups != nil {
+				idp.groups = test.idpGroups
+			}
+			upstream, upstreamURL := newEchoUpstream(test.upstreamScheme)
+			defer upstream.Close()
+
+			cfg := oidcproxy.Config{
+				IssuerURL:    idp.server.URL,
+				ClientID:     testClientID,
+				ClientSecret: testClientSecret,
+				CookieSecret: "0123456789abcdef0123456789abcdef",
+				Upstreams:    []oidcproxy.Upstream{{Path: "/", URL: upstreamURL}},
+				AuthOnly:     test.authOnly,
+				Logger:       log.Dummy,
+			}
+			if test.upstreamScheme == "https" {
+				cas := x509.NewCertPool()
+				cas.AddCert(upstream.Certificate())
+				cfg.UpstreamTLSConfig = &tls.Config{RootCAs: cas}
+			}
+			if test.config != nil {
+				test.config(&cfg)
+			}
+			h, err := oidcproxy.NewHandler(cfg)
+			require.NoError(err)
+			proxy := httptest.NewServer(h)
+			defer proxy.Close()
+
+			jar, _ := cookiejar.New(nil)
+			client := &http.Client{Jar: jar}
+
+			// Execute and check.
+			for _, r := range test.requests {
+				req, err := http.NewRequest(r.method, proxy.URL+r.path, nil)
+				require.NoError(err)
+				for k, v := range r.headers {
+					req.Header.Set(k, v)
+				}
+
+				resp, err := client.Do(req)
+				require.NoError(err)
+				resp.Body.Close()
+
+				assert.Equal(r.expStatus, resp.StatusCode, r.path)
+				for k, v := range r.expHeaders {
+					assert.Equal(v, resp.Header.Get(k), k)
+				}
+			}
+			assert.Equal(test.expRefreshes, idp.refreshCount())
+		})
+	}
+}
+
+func TestHandlerProviderKeysRefresh(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Prepare.
+	idp := newFakeIdP(t, 3600)
+	defer idp.server.Close()
+	upstream, upstreamURL := newEchoUpstream("")
+	defer upstream.Close()
+
+	var mu sync.Mutex
+	now := time.Now()
+	h, err := oidcproxy.NewHandler(oidcproxy.Config{
+		IssuerURL:    idp.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		CookieSecret: "0123456789abcdef0123456789abcdef",
+		Upstreams:    []oidcproxy.Upstream{{Path: "/", URL: upstreamURL}},
+		TimeNow: func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		},
+		Logger: log.Dummy,
+	})
+	require.NoError(err)
+	proxy := httptest.NewServer(h)
+	defer proxy.Close()
+
+	signIn := func() int {
+		jar, _ := cookiejar.New(nil)
+		client := &http.Client{Jar: jar}
+		resp, err := client.Get(proxy.URL + "/test")
+		require.NoError(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Execute and check.
+	assert.Equal(http.StatusOK, signIn())
+	assert.Equal(1, idp.keysFetchCount())
+
+	// The unknown keys should not fetch the keys again until the refresh interval passes.
+	idp.rotateKey("rotated")
+	assert.Equal(http.StatusInternalServerError, signIn())
+	assert.Equal(http.StatusInternalServerError, signIn())
+	assert.Equal(1, idp.keysFetchCount())
+
+	mu.Lock()
+	now = now.Add(2 * time.Minute)
+	mu.Unlock()
+	assert.Equal(http.StatusOK, signIn())
+	assert.Equal(http.StatusOK, signIn())
+	assert.Equal(2, idp.keysFetchCount())
+}
+
+func TestNewHandlerInvalidConfig(t *testing.T) {
+	tests := map[string]struct {
+		cfg oidcproxy.Config
+	}{
+		"Missing client credentials should fail.": {
+			cfg: oidcproxy.Config{IssuerURL: "https://dex.slok.dev", CookieSecret: "0123456789abcdef", AuthOnly: true},
+		},
+
+		"Missing upstreams should fail.": {
+			cfg: oidcproxy.Config{IssuerURL: "https://dex.slok.dev", ClientID: "a", ClientSecret: "b", CookieSecret: "0123456789abcdef"},
+		},
+
+		"A short cookie secret should fail.": {
+			cfg: oidcproxy.Config{IssuerURL: "https://dex.slok.dev", ClientID: "a", ClientSecret: "b", CookieSecret: "short", AuthOnly: true},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := oidcproxy.NewHandler(test.cfg)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package oidcproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// keysMinRefreshInterval is the minimum interval between the provider keys fetches, this way
+// the tokens with unknown keys can't make the proxy hit the provider on every request.
+const keysMinRefreshInterval = time.Minute
+
+// provider knows how to discover the OIDC provider and verify the ID tokens issued by it.
+//
+// The discovery and the ID tokens verification are made with go-oidc.
+type provider struct {
+	issuerURL  string
+	clientID   string
+	httpClient *http.Client
+	timeNow    func() time.Time
+
+	mu       sync.Mutex
+	endpoint oauth2.Endpoint
+	verifier *oidc.IDTokenVerifier
+}
+
+func newProvider(issuerURL, clientID string, httpClient *http.Client, timeNow func() time.Time) *provider {
+	return &provider{
+		issuerURL:  issuerURL,
+		clientID:   clientID,
+		httpClient: httpClient,
+		timeNow:    timeNow,
+	}
+}
+
+// discover discovers the provider, the discovery is lazy and retried until it succeeds, this
+// way the proxy can start before the provider is ready.
+func (p *provider) discover(ctx context.Context) (oauth2.Endpoint, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.verifier != nil {
+		return p.endpoint, p.verifier, nil
+	}
+
+	op, err := oidc.NewProvider(oidc.ClientContext(ctx, p.httpClient), p.issuerURL)
+	if err != nil {
+		return oauth2.Endpoint{}, nil, fmt.Errorf("could not discover OIDC provider: %w", err)
+	}
+
+	var m struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	err = op.Claims(&m)
+	if err != nil {
+		return oauth2.Endpoint{}, nil, fmt.Errorf("invalid OIDC provider metadata: %w", err)
+	}
+
+	endpoint := op.Endpoint()
+	if endpoint.AuthURL == "" || endpoint.TokenURL == "" || m.JWKSURI == "" {
+		return oauth2.Endpoint{}, nil, fmt.Errorf("OIDC provider metadata is missing required endpoints")
+	}
+
+	ks := &keySet{jwksURL: m.JWKSURI, httpClient: p.httpClient, timeNow: p.timeNow}
+	p.endpoint = endpoint
+	p.verifier = oidc.NewVerifier(p.issuerURL, ks, &oidc.Config{
+		ClientID:             p.clientID,
+		SupportedSigningAlgs: []string{oidc.RS256, oidc.ES256},
+		Now:                  p.timeNow,
+	})
+
+	return p.endpoint, p.verifier, nil
+}
+
+// getEndpoint returns the OAuth2 endpoints of the provider.
+func (p *provider) getEndpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	endpoint, _, err := p.discover(ctx)
+	return endpoint, err
+}
+
+// idTokenClaims are the ID token claims used by the proxy.
+type idTokenClaims struct {
+	Subject           string   `json:"sub"`
+	Expiry            int64    `json:"exp"`
+	Email             string   `json:"email"`
+	EmailVerified     *bool    `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Groups            []string `json:"groups"`
+	// Raw are all the claims of the ID token.
+	Raw map[string]interface{} `json:"-"`
+}
+
+// verifyIDToken verifies the ID token signature and claims, and returns the claims. If the
+// nonce is empty it will not be checked (e.g: refreshed tokens).
+func (p *provider) verifyIDToken(ctx context.Context, rawIDToken, nonce string) (*idTokenClaims, error) {
+	_, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if nonce != "" && idToken.Nonce != nonce {
+		return nil, fmt.Errorf("invalid ID token nonce")
+	}
+
+	claims := &idTokenClaims{}
+	err = idToken.Claims(claims)
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	err = idToken.Claims(&claims.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	return claims, nil
+}
+
+// keySet are the provider signing keys, satisfies oidc.KeySet interface. The keys are fetched
+// again when a token is signed with an unknown key, this way the provider key rotations are
+// handled. The fetches are rate limited, until the next fetch is allowed, the unknown keys are
+// missed without asking the provider.
+//
+// The keys parsing and the signature verification are made with go-jose.
+type keySet struct {
+	jwksURL    string
+	httpClient *http.Client
+	timeNow    func() time.Time
+
+	mu   sync.Mutex
+	keys []jose.JSONWebKey
+	// fetchedAt is the last time we tried fetching the keys.
+	fetchedAt time.Time
+}
+
+func (k *keySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWT: %w", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, fmt.Errorf("only JWTs with a single signature are supported")
+	}
+	kid := jws.Signatures[0].Header.KeyID
+
+	keys, err := k.getKeys(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		if kid != "" && keys[i].KeyID != kid {
+			continue
+		}
+		payload, err := jws.Verify(&keys[i])
+		if err == nil {
+			return payload, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid JWT signature")
+}
+
+// getKeys returns the provider keys, the keys are fetched again when there isn't any key
+// with the key ID (any key if the key ID is empty).
+func (k *keySet) getKeys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, key := range k.keys {
+		if kid == "" || key.KeyID == kid {
+			return k.keys, nil
+		}
+	}
+
+	now := k.timeNow()
+	if !k.fetchedAt.IsZero() && now.Sub(k.fetchedAt) < keysMinRefreshInterval {
+		return nil, fmt.Errorf("unknown %q key", kid)
+	}
+	k.fetchedAt = now
+
+	keys, err := k.fetchKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get OIDC provider keys: %w", err)
+	}
+	k.keys = keys
+
+	return k.keys, nil
+}
+
+func (k *keySet) fetchKeys(ctx context.Context) ([]jose.JSONWebKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected %d status code from %q", resp.StatusCode, k.jwksURL)
+	}
+
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []jose.JSONWebKey{}
+	for _, raw := range jwks.Keys {
+		var key jose.JSONWebKey
+		err := key.UnmarshalJSON(raw)
+		if err != nil {
+			// Ignore the keys we don't support.
+			continue
+		}
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
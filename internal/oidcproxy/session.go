@@ -0,0 +1,113 @@
+package oidcproxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// session is the authenticated user session stored on the session cookie.
+type session struct {
+	User              string    `json:"u"`
+	Email             string    `json:"e,omitempty"`
+	PreferredUsername string    `json:"p,omitempty"`
+	Groups            []string  `json:"g,omitempty"`
+	AccessToken       string    `json:"at,omitempty"`
+	RefreshToken      string    `json:"rt,omitempty"`
+	ExpiresAt         time.Time `json:"exp"`
+	CreatedAt         time.Time `json:"cat"`
+	UpdatedAt         time.Time `json:"uat"`
+}
+
+// loginState is the state of a sign in flow stored on the CSRF cookie until the
+// provider redirects to the callback.
+type loginState struct {
+	State        string    `json:"s"`
+	Nonce        string    `json:"n"`
+	CodeVerifier string    `json:"cv"`
+	RedirectTo   string    `json:"rd"`
+	CreatedAt    time.Time `json:"cat"`
+}
+
+// cookieCodec encrypts and authenticates the cookie values using AES-GCM, the cookie name
+// is used as additional data so a value can't be used on a different cookie.
+type cookieCodec struct {
+	aead cipher.AEAD
+}
+
+func newCookieCodec(secret string) (*cookieCodec, error) {
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("cookie secret must have at least 16 characters")
+	}
+
+	// Derive a key with the required size from the secret.
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cookieCodec{aead: aead}, nil
+}
+
+func (c *cookieCodec) encode(name string, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, data, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *cookieCodec) decode(name, value string, v interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return fmt.Errorf("malformed cookie: %w", err)
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return fmt.Errorf("malformed cookie")
+	}
+
+	data, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(name))
+	if err != nil {
+		return fmt.Errorf("invalid cookie: %w", err)
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// randomString returns a URL safe random string with n bytes of entropy.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge returns the S256 PKCE code challenge of the code verifier.
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
@@ -0,0 +1,588 @@
+package bilrostproxy
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/proxy"
+)
+
+// KubernetesRepository is the proxy kubernetes service used to communicate with Kubernetes.
+type KubernetesRepository interface {
+	EnsureConfigMap(ctx context.Context, cm *corev1.ConfigMap) error
+	DeleteConfigMap(ctx context.Context, ns, name string) error
+	EnsureDeployment(ctx context.Context, dep *appsv1.Deployment) error
+	EnsureService(ctx context.Context, svc *corev1.Service) error
+	EnsureSecret(ctx context.Context, sec *corev1.Secret) error
+	GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error)
+	UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error
+}
+
+//go:generate mockery -case underscore -output bilrostproxymock -outpkg bilrostproxymock -name KubernetesRepository
+
+type provisioner struct {
+	kuberepo KubernetesRepository
+	next     proxy.OIDCProvisioner
+	image    string
+	logger   log.Logger
+}
+
+// NewOIDCProvisioner returns a new oidc provisioner that secures the apps using the Bilrost OIDC
+// proxy (`bilrost-proxy` command of the image), for the apps that have Bilrost proxy settings, the
+// rest of the apps will be provisioned by the next provisioner. The image is the default image
+// of the proxy deployments, the controller image of the same version should be used, this way the
+// proxy and the controller versions match.
+//
+// The proxy resources have the same names as the oauth2-proxy ones, this way changing the proxy
+// of an app updates the resources in place, and the unprovision is delegated on the next
+// provisioner (oauth2-proxy) that deletes them.
+func NewOIDCProvisioner(kuberepo KubernetesRepository, next proxy.OIDCProvisioner, image string, logger log.Logger) proxy.OIDCProvisioner {
+	return provisioner{
+		kuberepo: kuberepo,
+		next:     next,
+		image:    image,
+		logger:   logger.WithKV(log.KV{"service": "proxy.bilrostproxy.OIDCProvisioner"}),
+	}
+}
+
+func (p provisioner) Provision(ctx context.Context, settings proxy.OIDCProxySettings) (*proxy.OIDCProxyStatus, error) {
+	if settings.App.ProxySettings.BilrostProxy == nil {
+		return p.next.Provision(ctx, settings)
+	}
+
+	status := &proxy.OIDCProxyStatus{
+		ServiceName: getResourceName(settings.App.Ingress.Name),
+	}
+
+	// The proxy sessions are stored on the encrypted cookies.
+	if settings.App.ProxySettings.Session.Store == model.SessionStoreRedis {
+		return status, fmt.Errorf("redis session store is not supported by Bilrost proxy")
+	}
+
+	// Provision proxy.
+	secret, err := p.provisionSecret(ctx, settings)
+	if err != nil {
+		return status, fmt.Errorf("could not provision secret on Kubernetes: %w", err)
+	}
+
+	cm, err := p.provisionConfigMap(ctx, settings)
+	if err != nil {
+		return status, fmt.Errorf("could not provision configmap on Kubernetes: %w", err)
+	}
+
+	dep, err := p.provisionDeployment(ctx, settings, secret, cm)
+	if err != nil {
+		return status, fmt.Errorf("could not provision deployment on Kubernetes: %w", err)
+	}
+
+	err = p.provisionDeploymentService(ctx, dep)
+	if err != nil {
+		return status, fmt.Errorf("could not provision service on Kubernetes: %w", err)
+	}
+	status.Provisioned = true
+
+	// Point ingress to the secure proxy.
+	err = p.setIngressToProxy(ctx, settings)
+	if err != nil {
+		return status, fmt.Errorf("could not update ingress on Kubernetes to point to the proxy: %w", err)
+	}
+	status.IngressPointed = true
+
+	return status, nil
+}
+
+func (p provisioner) Unprovision(ctx context.Context, settings proxy.UnprovisionSettings) error {
+	return p.next.Unprovision(ctx, settings)
+}
+
+// The secret data is the same as the oauth2-proxy one, the proxy loads it from the env vars.
+const (
+	oidcClientIDEnv      = "OIDC_CLIENT_ID"
+	oidcClientSecretEnv  = "OIDC_CLIENT_SECRET"
+	proxyCookieSecretEnv = "PROXY_COOKIE_SECRET"
+)
+
+func (p provisioner) provisionSecret(ctx context.Context, settings proxy.OIDCProxySettings) (*corev1.Secret, error) {
+	name := getResourceName(settings.App.Ingress.Name)
+
+	// Idempotent cookie secret seed based on clientID and clientSecret.
+	cookieSecretMd5 := md5.Sum([]byte(fmt.Sprintf("%s-%s", settings.ClientID, settings.ClientSecret)))
+	cookieSecret := fmt.Sprintf("%x", cookieSecretMd5)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       settings.App.Ingress.Namespace,
+			Labels:          getLabels(name),
+			OwnerReferences: getOwnerReferences(settings.App.Ingress),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			oidcClientIDEnv:      []byte(settings.ClientID),
+			oidcClientSecretEnv:  []byte(settings.ClientSecret),
+			proxyCookieSecretEnv: []byte(cookieSecret),
+		},
+	}
+
+	err := p.kuberepo.EnsureSecret(ctx, secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not ensure proxy secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+const (
+	upstreamCAFileKey       = "upstream-ca.crt"
+	upstreamCAFileMountPath = "/etc/bilrost/upstream-ca"
+)
+
+// provisionConfigMap provisions the configmap with the upstream CA file of the proxy, if the app
+// doesn't have an upstream CA, the configmap will be deleted and nil will be returned.
+func (p provisioner) provisionConfigMap(ctx context.Context, settings proxy.OIDCProxySettings) (*corev1.ConfigMap, error) {
+	name := getResourceName(settings.App.Ingress.Name)
+	ns := settings.App.Ingress.Namespace
+
+	ca := settings.App.ProxySettings.Upstream.CA
+	if len(ca) == 0 {
+		err := p.kuberepo.DeleteConfigMap(ctx, ns, name)
+		if err != nil && !kubeerrors.IsNotFound(err) {
+			return nil, fmt.Errorf("could not delete proxy configmap: %w", err)
+		}
+		return nil, nil
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       ns,
+			Labels:          getLabels(name),
+			OwnerReferences: getOwnerReferences(settings.App.Ingress),
+		},
+		Data: map[string]string{
+			upstreamCAFileKey: string(ca),
+		},
+	}
+
+	err := p.kuberepo.EnsureConfigMap(ctx, cm)
+	if err != nil {
+		return nil, fmt.Errorf("could not ensure proxy configmap: %w", err)
+	}
+
+	return cm, nil
+}
+
+func (p provisioner) provisionDeployment(ctx context.Context, settings proxy.OIDCProxySettings, secret *corev1.Secret, cm *corev1.ConfigMap) (*appsv1.Deployment, error) {
+	const proxyInternalPort = 4180
+
+	name := secret.Name
+	labels := getLabels(name)
+
+	// Force a rolling deploy when the secret or the configmap change.
+	checksumLabels := getLabels(name)
+	checksum, err := secretChecksum(secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not get checksum of secret data: %w", err)
+	}
+	checksumLabels["bilrost.slok.dev/secret-checksum-to-force-update"] = checksum
+	if cm != nil {
+		checksum, err := configMapChecksum(cm)
+		if err != nil {
+			return nil, fmt.Errorf("could not get checksum of configmap data: %w", err)
+		}
+		checksumLabels["bilrost.slok.dev/configmap-checksum-to-force-update"] = checksum
+	}
+
+	customSettings := p.getCustomizableSettings(settings)
+
+	upstreamArgs, err := getUpstreamArgs(settings.Upstreams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstreams: %w", err)
+	}
+
+	// If we only have one public URL we can set the full redirect URL, otherwise
+	// we set only the path and the proxy will use the request host.
+	redirectURL := settings.CallbackPath
+	if len(settings.URLs) == 1 {
+		redirectURL = settings.URLs[0] + redirectURL
+	}
+
+	args := []string{
+		fmt.Sprintf(`--oidc-issuer-url=%s`, settings.IssuerURL),
+		fmt.Sprintf(`--listen-address=0.0.0.0:%d`, proxyInternalPort),
+		fmt.Sprintf(`--redirect-url=%s`, redirectURL),
+	}
+	args = append(args, upstreamArgs...)
+	if cm != nil {
+		args = append(args, fmt.Sprintf(`--upstream-ca-file=%s/%s`, upstreamCAFileMountPath, upstreamCAFileKey))
+	}
+	if settings.App.ProxySettings.Upstream.InsecureSkipVerify {
+		args = append(args, `--upstream-insecure-skip-verify`)
+	}
+	for _, s := range customSettings.Scopes {
+		args = append(args, fmt.Sprintf(`--scope=%s`, s))
+	}
+	args = append(args, getSessionArgs(settings.App.ProxySettings.Session)...)
+	args = append(args, getAccessControlArgs(settings.App.ProxySettings.AccessControl)...)
+	args = append(args, getSkipAuthArgs(settings.App.ProxySettings.SkipAuthRoutes)...)
+	if settings.App.ProxySettings.BilrostProxy.PassAccessToken {
+		args = append(args, `--pass-access-token`)
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       secret.Namespace,
+			Labels:          labels,
+			OwnerReferences: secret.OwnerReferences,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &customSettings.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: checksumLabels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:    "app",
+							Image:   customSettings.Image,
+							Command: []string{"/usr/local/bin/bilrost-proxy"},
+							Args:    args,
+							Ports: []corev1.ContainerPort{
+								{
+									ContainerPort: proxyInternalPort,
+									Name:          "http",
+									Protocol:      "TCP",
+								},
+							},
+							Resources: customSettings.Resources,
+							EnvFrom: []corev1.EnvFromSource{{
+								SecretRef: &corev1.SecretEnvSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: name,
+									},
+								},
+							}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if cm != nil {
+		podSpec := &deployment.Spec.Template.Spec
+		podSpec.Volumes = []corev1.Volume{{
+			Name: "upstream-ca",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cm.Name},
+				},
+			},
+		}}
+		podSpec.Containers[0].VolumeMounts = []corev1.VolumeMount{{
+			Name:      "upstream-ca",
+			MountPath: upstreamCAFileMountPath,
+			ReadOnly:  true,
+		}}
+	}
+
+	err = p.kuberepo.EnsureDeployment(ctx, deployment)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up proxy deployment: %w", err)
+	}
+
+	return deployment, nil
+}
+
+// getAccessControlArgs returns the flags that restrict the users that can access the app.
+func getAccessControlArgs(ac model.AccessControl) []string {
+	args := []string{}
+	for _, g := range ac.AllowedGroups {
+		args = append(args, fmt.Sprintf(`--allowed-group=%s`, g))
+	}
+	for _, e := range ac.AllowedEmails {
+		args = append(args, fmt.Sprintf(`--allowed-email=%s`, e))
+	}
+	for _, d := range ac.AllowedEmailDomains {
+		args = append(args, fmt.Sprintf(`--allowed-email-domain=%s`, d))
+	}
+
+	// Sorted to have the same args on every provision.
+	claims := make([]string, 0, len(ac.RequiredClaims))
+	for c := range ac.RequiredClaims {
+		claims = append(claims, c)
+	}
+	sort.Strings(claims)
+	for _, c := range claims {
+		args = append(args, fmt.Sprintf(`--required-claim=%s=%s`, c, ac.RequiredClaims[c]))
+	}
+
+	return args
+}
+
+// getSkipAuthArgs returns the flags of the routes that don't require authentication.
+func getSkipAuthArgs(routes []model.SkipAuthRoute) []string {
+	args := []string{}
+	for _, r := range routes {
+		if len(r.Methods) == 0 {
+			args = append(args, fmt.Sprintf(`--skip-auth-route=%s`, r.PathRegex))
+			continue
+		}
+		for _, m := range r.Methods {
+			args = append(args, fmt.Sprintf(`--skip-auth-route=%s=%s`, m, r.PathRegex))
+		}
+	}
+
+	return args
+}
+
+// getSessionArgs returns the flags of the user session settings, the proxy sets the secure
+// flag on the cookies by default.
+func getSessionArgs(session model.SessionSettings) []string {
+	args := []string{}
+	if session.Cookie.Name != "" {
+		args = append(args, fmt.Sprintf(`--cookie-name=%s`, session.Cookie.Name))
+	}
+	if session.Cookie.Domain != "" {
+		args = append(args, fmt.Sprintf(`--cookie-domain=%s`, session.Cookie.Domain))
+	}
+	if session.Cookie.Insecure {
+		args = append(args, `--no-cookie-secure`)
+	}
+	if session.Cookie.SameSite != "" {
+		args = append(args, fmt.Sprintf(`--cookie-samesite=%s`, session.Cookie.SameSite))
+	}
+	if session.Cookie.Expire > 0 {
+		args = append(args, fmt.Sprintf(`--cookie-expire=%s`, session.Cookie.Expire))
+	}
+	if session.Cookie.Refresh > 0 {
+		args = append(args, fmt.Sprintf(`--cookie-refresh=%s`, session.Cookie.Refresh))
+	}
+
+	return args
+}
+
+// getUpstreamArgs returns the upstream flags for each of the upstreams, the proxy routes
+// the upstreams by path (without host), so the same path can't point to different upstreams.
+func getUpstreamArgs(upstreams []proxy.Upstream) ([]string, error) {
+	args := []string{}
+	pathURLs := map[string]string{}
+	for _, u := range upstreams {
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		if !strings.HasSuffix(path, "/") {
+			path += "/"
+		}
+
+		storedURL, ok := pathURLs[path]
+		if ok {
+			if storedURL != u.URL {
+				return nil, fmt.Errorf("path %q has multiple upstreams (%q and %q)", path, storedURL, u.URL)
+			}
+			continue
+		}
+		pathURLs[path] = u.URL
+
+		upstreamURL := u.URL
+		if path != "/" {
+			upstreamURL = strings.TrimSuffix(upstreamURL, "/") + path
+		}
+		args = append(args, fmt.Sprintf(`--upstream=%s`, upstreamURL))
+	}
+
+	return args, nil
+}
+
+type customizableSettings struct {
+	Image     string
+	Scopes    []string
+	Replicas  int32
+	Resources corev1.ResourceRequirements
+}
+
+func (p provisioner) getCustomizableSettings(settings proxy.OIDCProxySettings) customizableSettings {
+	defaults := customizableSettings{
+		Image:    p.image,
+		Scopes:   []string{"openid", "email", "profile", "groups", "offline_access"},
+		Replicas: int32(2),
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("15m"),
+				corev1.ResourceMemory: resource.MustParse("20Mi"),
+			},
+		},
+	}
+
+	// Set custom settings.
+	if len(settings.App.ProxySettings.Scopes) > 0 {
+		defaults.Scopes = settings.App.ProxySettings.Scopes
+	}
+
+	bilrostProxySettings := settings.App.ProxySettings.BilrostProxy
+	if bilrostProxySettings.Image != "" {
+		defaults.Image = bilrostProxySettings.Image
+	}
+	if bilrostProxySettings.Replicas != 0 {
+		defaults.Replicas = int32(bilrostProxySettings.Replicas)
+	}
+	if bilrostProxySettings.Resources != nil {
+		defaults.Resources = *bilrostProxySettings.Resources
+	}
+
+	return defaults
+}
+
+const (
+	proxySvcPort = 80
+	proxySvcName = "http"
+)
+
+func (p provisioner) provisionDeploymentService(ctx context.Context, dep *appsv1.Deployment) error {
+	labels := getLabels(dep.Name)
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            dep.Name,
+			Namespace:       dep.Namespace,
+			Labels:          labels,
+			OwnerReferences: dep.OwnerReferences,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     "ClusterIP",
+			Selector: labels,
+			Ports: []corev1.ServicePort{
+				{
+					Port:       proxySvcPort,
+					Name:       proxySvcName,
+					TargetPort: intstr.FromInt(int(dep.Spec.Template.Spec.Containers[0].Ports[0].ContainerPort)),
+				},
+			},
+		},
+	}
+
+	err := p.kuberepo.EnsureService(ctx, svc)
+	if err != nil {
+		return fmt.Errorf("could not ensure proxy service: %w", err)
+	}
+
+	return nil
+}
+
+// setIngressToProxy points all the ingress routes to the proxy.
+func (p provisioner) setIngressToProxy(ctx context.Context, settings proxy.OIDCProxySettings) error {
+	ing, err := p.kuberepo.GetIngress(ctx, settings.App.Ingress.Namespace, settings.App.Ingress.Name)
+	if err != nil {
+		return err
+	}
+
+	if len(ing.Spec.Rules) == 0 {
+		return fmt.Errorf("ingress required rules are missing")
+	}
+
+	proxyBackend := networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: getResourceName(settings.App.Ingress.Name),
+			Port: networkingv1.ServiceBackendPort{Name: proxySvcName},
+		},
+	}
+
+	changed := false
+	for i, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			return fmt.Errorf("ingress required HTTP rule on %q host is missing", rule.Host)
+		}
+
+		for j, path := range rule.HTTP.Paths {
+			if reflect.DeepEqual(path.Backend, proxyBackend) {
+				continue
+			}
+			ing.Spec.Rules[i].HTTP.Paths[j].Backend = proxyBackend
+			changed = true
+		}
+	}
+
+	if !changed {
+		p.logger.Debugf("ingress already pointing to the proxy, ignoring update")
+		return nil
+	}
+
+	err = p.kuberepo.UpdateIngress(ctx, ing)
+	if err != nil {
+		return fmt.Errorf("could not update ingress with backend: %w", err)
+	}
+
+	return nil
+}
+
+// getResourceName returns the name of the proxy resources, these are the same
+// used by the oauth2-proxy provisioner.
+func getResourceName(name string) string {
+	return fmt.Sprintf("%s-bilrost-proxy", name)
+}
+
+// getOwnerReferences returns the owner references to the app ingress, so Kubernetes garbage
+// collects the proxy resources if the ingress is deleted.
+func getOwnerReferences(ing model.KubernetesIngress) []metav1.OwnerReference {
+	if ing.UID == "" {
+		return nil
+	}
+
+	return []metav1.OwnerReference{{
+		APIVersion: networkingv1.SchemeGroupVersion.String(),
+		Kind:       "Ingress",
+		Name:       ing.Name,
+		UID:        types.UID(ing.UID),
+	}}
+}
+
+func getLabels(name string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/name":       "bilrost-proxy",
+		"app.kubernetes.io/component":  "proxy",
+		"app.kubernetes.io/instance":   name,
+	}
+}
+
+func secretChecksum(s *corev1.Secret) (string, error) {
+	d, err := json.Marshal(s.Data)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := md5.Sum(d)
+	return fmt.Sprintf("%x", checksum), nil
+}
+
+func configMapChecksum(cm *corev1.ConfigMap) (string, error) {
+	d, err := json.Marshal(cm.Data)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := md5.Sum(d)
+	return fmt.Sprintf("%x", checksum), nil
+}
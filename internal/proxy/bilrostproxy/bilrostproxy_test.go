@@ -0,0 +1,670 @@
+package bilrostproxy_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/proxy"
+	"github.com/slok/bilrost/internal/proxy/bilrostproxy"
+	"github.com/slok/bilrost/internal/proxy/bilrostproxy/bilrostproxymock"
+	"github.com/slok/bilrost/internal/proxy/proxymock"
+)
+
+func getBaseSettings() proxy.OIDCProxySettings {
+	return proxy.OIDCProxySettings{
+		URLs:         []string{"https://my-app.my-cluster.dev"},
+		CallbackPath: "/oauth2/callback",
+		Upstreams:    []proxy.Upstream{{URL: "http://my-app.my-ns.svc.cluster.local:8080"}},
+		IssuerURL:    "https://dex.my-cluster.dev",
+		ClientID:     "my-app-bilrost",
+		ClientSecret: "my-secret",
+		App: model.App{
+			ID:            "test-ns/my-app",
+			AuthBackendID: "test-ns-dex-backend",
+			Ingress: model.KubernetesIngress{
+				Namespace: "my-ns",
+				Name:      "my-app",
+				UID:       "my-app-uid",
+			},
+			ProxySettings: model.ProxySettings{
+				BilrostProxy: &model.BilrostProxySettings{},
+			},
+		},
+	}
+}
+
+func getCustomSettings() proxy.OIDCProxySettings {
+	s := getBaseSettings()
+	s.App.ProxySettings = model.ProxySettings{
+		Scopes: []string{"c9", "c19"},
+		BilrostProxy: &model.BilrostProxySettings{
+			Oauth2ProxySettings: model.Oauth2ProxySettings{
+				Image:    "slok/bilrost:v99.99.99",
+				Replicas: 99,
+				Resources: &corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("9m"),
+						corev1.ResourceMemory: resource.MustParse("19Mi"),
+					},
+				},
+			},
+			PassAccessToken: true,
+		},
+	}
+	return s
+}
+
+func getBaseLabels() map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/name":       "bilrost-proxy",
+		"app.kubernetes.io/component":  "proxy",
+		"app.kubernetes.io/instance":   "my-app-bilrost-proxy",
+	}
+}
+
+func getBaseOwnerReferences() []metav1.OwnerReference {
+	return []metav1.OwnerReference{{
+		APIVersion: "networking.k8s.io/v1",
+		Kind:       "Ingress",
+		Name:       "my-app",
+		UID:        "my-app-uid",
+	}}
+}
+
+func getBaseSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "my-app-bilrost-proxy",
+			Namespace:       "my-ns",
+			Labels:          getBaseLabels(),
+			OwnerReferences: getBaseOwnerReferences(),
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"OIDC_CLIENT_ID":      []byte("my-app-bilrost"),
+			"OIDC_CLIENT_SECRET":  []byte("my-secret"),
+			"PROXY_COOKIE_SECRET": []byte("cc00ba6b82692c7c95ec8b6acb16aa39"),
+		},
+	}
+}
+
+func getBaseDeployment() *appsv1.Deployment {
+	replicas := int32(2)
+	checkSumLabels := getBaseLabels()
+	checkSumLabels["bilrost.slok.dev/secret-checksum-to-force-update"] = "6310c0ad4266de889e142b381343c55e"
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "my-app-bilrost-proxy",
+			Namespace:       "my-ns",
+			Labels:          getBaseLabels(),
+			OwnerReferences: getBaseOwnerReferences(),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: getBaseLabels(),
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: checkSumLabels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:    "app",
+							Image:   "slok/bilrost:v1.0.0",
+							Command: []string{"/usr/local/bin/bilrost-proxy"},
+							Args: []string{
+								"--oidc-issuer-url=https://dex.my-cluster.dev",
+								"--listen-address=0.0.0.0:4180",
+								"--redirect-url=https://my-app.my-cluster.dev/oauth2/callback",
+								"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+								"--scope=openid",
+								"--scope=email",
+								"--scope=profile",
+								"--scope=groups",
+								"--scope=offline_access",
+							},
+							Ports: []corev1.ContainerPort{
+								{
+									ContainerPort: 4180,
+									Name:          "http",
+									Protocol:      "TCP",
+								},
+							},
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU:    resource.MustParse("15m"),
+									corev1.ResourceMemory: resource.MustParse("20Mi"),
+								},
+							},
+							EnvFrom: []corev1.EnvFromSource{{
+								SecretRef: &corev1.SecretEnvSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: "my-app-bilrost-proxy",
+									},
+								},
+							}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getBaseService() *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "my-app-bilrost-proxy",
+			Namespace:       "my-ns",
+			Labels:          getBaseLabels(),
+			OwnerReferences: getBaseOwnerReferences(),
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     "ClusterIP",
+			Selector: getBaseLabels(),
+			Ports: []corev1.ServicePort{
+				{
+					Port:       80,
+					Name:       "http",
+					TargetPort: intstr.FromInt(4180),
+				},
+			},
+		},
+	}
+}
+
+func getIngress(svcName string, port networkingv1.ServiceBackendPort) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-app",
+			Namespace: "my-ns",
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: svcName,
+											Port: port,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getAppIngress() *networkingv1.Ingress {
+	return getIngress("my-app", networkingv1.ServiceBackendPort{Number: 8080})
+}
+
+func getProxiedIngress() *networkingv1.Ingress {
+	return getIngress("my-app-bilrost-proxy", networkingv1.ServiceBackendPort{Name: "http"})
+}
+
+func TestOIDCProvisionerProvision(t *testing.T) {
+	tests := map[string]struct {
+		settings  func() proxy.OIDCProxySettings
+		mock      func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner)
+		expStatus *proxy.OIDCProxyStatus
+		expErr    bool
+	}{
+		"An app without Bilrost proxy settings should be provisioned by the next provisioner.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.BilrostProxy = nil
+				return s
+			},
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning should provision a secret, a deployment, a service, and swap the ingress.": {
+			settings: getBaseSettings,
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, getBaseDeployment()).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, getBaseService()).Once().Return(nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(), nil)
+				mk.On("UpdateIngress", mock.Anything, getProxiedIngress()).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with custom settings should provision the proxy with the custom settings.": {
+			settings: getCustomSettings,
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expDep := getBaseDeployment()
+				replicas := int32(99)
+				expDep.Spec.Replicas = &replicas
+				expDep.Spec.Template.Spec.Containers[0].Image = "slok/bilrost:v99.99.99"
+				expDep.Spec.Template.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("9m"),
+						corev1.ResourceMemory: resource.MustParse("19Mi"),
+					},
+				}
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--listen-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/oauth2/callback",
+					"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+					"--scope=c9",
+					"--scope=c19",
+					"--pass-access-token",
+				}
+
+				mk.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, getBaseService()).Once().Return(nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(), nil)
+				mk.On("UpdateIngress", mock.Anything, getProxiedIngress()).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with multiple URLs and upstreams should set the redirect path and all the upstreams.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.URLs = []string{"https://my-app.my-cluster.dev", "https://my-app2.my-cluster.dev"}
+				s.Upstreams = []proxy.Upstream{
+					{URL: "http://my-app.my-ns.svc.cluster.local:8080", Path: "/"},
+					{URL: "http://my-api.my-ns.svc.cluster.local:80", Path: "/api"},
+				}
+				return s
+			},
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--listen-address=0.0.0.0:4180",
+					"--redirect-url=/oauth2/callback",
+					"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+					"--upstream=http://my-api.my-ns.svc.cluster.local:80/api/",
+					"--scope=openid",
+					"--scope=email",
+					"--scope=profile",
+					"--scope=groups",
+					"--scope=offline_access",
+				}
+
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(), nil)
+				mk.On("UpdateIngress", mock.Anything, getProxiedIngress()).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with access control should restrict the proxy users.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.AccessControl = model.AccessControl{
+					AllowedGroups:       []string{"admins", "devs"},
+					AllowedEmails:       []string{"jane@my-company.dev"},
+					AllowedEmailDomains: []string{"my-company.dev"},
+					RequiredClaims:      map[string]string{"email_verified": "true", "hd": "my-company.dev"},
+				}
+				return s
+			},
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--listen-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/oauth2/callback",
+					"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+					"--scope=openid",
+					"--scope=email",
+					"--scope=profile",
+					"--scope=groups",
+					"--scope=offline_access",
+					"--allowed-group=admins",
+					"--allowed-group=devs",
+					"--allowed-email=jane@my-company.dev",
+					"--allowed-email-domain=my-company.dev",
+					"--required-claim=email_verified=true",
+					"--required-claim=hd=my-company.dev",
+				}
+
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(), nil)
+				mk.On("UpdateIngress", mock.Anything, getProxiedIngress()).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with skip auth routes should configure the proxy to not authenticate them.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.SkipAuthRoutes = []model.SkipAuthRoute{
+					{PathRegex: "^/public/"},
+					{PathRegex: "^/api/health$", Methods: []string{"GET", "HEAD"}},
+				}
+				return s
+			},
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Spec.Containers[0].Args = append(expDep.Spec.Template.Spec.Containers[0].Args,
+					"--skip-auth-route=^/public/",
+					"--skip-auth-route=GET=^/api/health$",
+					"--skip-auth-route=HEAD=^/api/health$",
+				)
+
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(), nil)
+				mk.On("UpdateIngress", mock.Anything, getProxiedIngress()).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with session settings should configure the callback and the session cookie.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.CallbackPath = "/auth/callback"
+				s.App.ProxySettings.Session = model.SessionSettings{
+					CallbackPath: "/auth/callback",
+					Cookie: model.SessionCookie{
+						Name:     "_my_app",
+						Domain:   "my-cluster.dev",
+						Expire:   12 * time.Hour,
+						Refresh:  time.Hour,
+						SameSite: "strict",
+						Insecure: true,
+					},
+				}
+				return s
+			},
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--listen-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/auth/callback",
+					"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+					"--scope=openid",
+					"--scope=email",
+					"--scope=profile",
+					"--scope=groups",
+					"--scope=offline_access",
+					"--cookie-name=_my_app",
+					"--cookie-domain=my-cluster.dev",
+					"--no-cookie-secure",
+					"--cookie-samesite=strict",
+					"--cookie-expire=12h0m0s",
+					"--cookie-refresh=1h0m0s",
+				}
+
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(), nil)
+				mk.On("UpdateIngress", mock.Anything, getProxiedIngress()).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with upstream TLS settings should provision the CA configmap and configure the proxy upstream TLS.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.Upstreams = []proxy.Upstream{{URL: "h2c://my-app.my-ns.svc.cluster.local:8080"}}
+				s.App.ProxySettings.Upstream = model.UpstreamSettings{
+					Scheme:             "h2c",
+					CA:                 []byte("-----BEGIN CERTIFICATE-----\nMIIBtest\n-----END CERTIFICATE-----\n"),
+					InsecureSkipVerify: true,
+				}
+				return s
+			},
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expCM := &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            "my-app-bilrost-proxy",
+						Namespace:       "my-ns",
+						Labels:          getBaseLabels(),
+						OwnerReferences: getBaseOwnerReferences(),
+					},
+					Data: map[string]string{
+						"upstream-ca.crt": "-----BEGIN CERTIFICATE-----\nMIIBtest\n-----END CERTIFICATE-----\n",
+					},
+				}
+
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Labels["bilrost.slok.dev/configmap-checksum-to-force-update"] = "c02713530c7520eaf13e226e872ac946"
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--listen-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/oauth2/callback",
+					"--upstream=h2c://my-app.my-ns.svc.cluster.local:8080",
+					"--upstream-ca-file=/etc/bilrost/upstream-ca/upstream-ca.crt",
+					"--upstream-insecure-skip-verify",
+					"--scope=openid",
+					"--scope=email",
+					"--scope=profile",
+					"--scope=groups",
+					"--scope=offline_access",
+				}
+				expDep.Spec.Template.Spec.Volumes = []corev1.Volume{{
+					Name: "upstream-ca",
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "my-app-bilrost-proxy"},
+						},
+					},
+				}}
+				expDep.Spec.Template.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{
+					Name:      "upstream-ca",
+					MountPath: "/etc/bilrost/upstream-ca",
+					ReadOnly:  true,
+				}}
+
+				mk.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+				mk.On("EnsureConfigMap", mock.Anything, expCM).Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, getBaseService()).Once().Return(nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(), nil)
+				mk.On("UpdateIngress", mock.Anything, getProxiedIngress()).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"If stored ingress already has been swapped, it shouldn't be updated.": {
+			settings: getBaseSettings,
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getProxiedIngress(), nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"Having the same path with different upstreams should fail.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.Upstreams = []proxy.Upstream{
+					{URL: "http://my-app.my-ns.svc.cluster.local:8080", Path: "/api"},
+					{URL: "http://my-api.my-ns.svc.cluster.local:80", Path: "/api/"},
+				}
+				return s
+			},
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+		},
+
+		"The redis session store should fail because the Bilrost proxy doesn't support it.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.Session.Store = model.SessionStoreRedis
+				return s
+			},
+			mock:      func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+		},
+
+		"Failing setting up the secret should stop the provision process.": {
+			settings: getBaseSettings,
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+		},
+
+		"Failing setting up the upstream CA configmap should stop the provision process.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.Upstream.CA = []byte("-----BEGIN CERTIFICATE-----\nMIIBtest\n-----END CERTIFICATE-----\n")
+				return s
+			},
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("EnsureConfigMap", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+		},
+
+		"Failing setting up the deployment should stop the provision process.": {
+			settings: getBaseSettings,
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+		},
+
+		"Failing setting up the service should stop the provision process.": {
+			settings: getBaseSettings,
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+		},
+
+		"Failing updating app ingress should stop the provision process.": {
+			settings: getBaseSettings,
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("GetIngress", mock.Anything, mock.Anything, mock.Anything).Once().Return(getAppIngress(), nil)
+				mk.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mk := &bilrostproxymock.KubernetesRepository{}
+			mp := &proxymock.OIDCProvisioner{}
+			test.mock(mk, mp)
+
+			prov := bilrostproxy.NewOIDCProvisioner(mk, mp, "slok/bilrost:v1.0.0", log.Dummy)
+			gotStatus, err := prov.Provision(context.TODO(), test.settings())
+
+			assert.Equal(test.expStatus, gotStatus)
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				mk.AssertExpectations(t)
+				mp.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestOIDCProvisionerUnprovision(t *testing.T) {
+	tests := map[string]struct {
+		mock   func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner)
+		expErr bool
+	}{
+		"Unprovisioning should be delegated to the next provisioner.": {
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mp.On("Unprovision", mock.Anything, proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"}).Once().Return(nil)
+			},
+		},
+
+		"Failing unprovisioning on the next provisioner should fail.": {
+			mock: func(mk *bilrostproxymock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mp.On("Unprovision", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			mk := &bilrostproxymock.KubernetesRepository{}
+			mp := &proxymock.OIDCProvisioner{}
+			test.mock(mk, mp)
+
+			prov := bilrostproxy.NewOIDCProvisioner(mk, mp, "", log.Dummy)
+			err := prov.Unprovision(context.TODO(), proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"})
+
+			if test.expErr {
+				assert.Error(err)
+			} else if assert.NoError(err) {
+				mk.AssertExpectations(t)
+				mp.AssertExpectations(t)
+			}
+		})
+	}
+}
@@ -18,7 +18,7 @@ func NewMeasuredOIDCProvisioner(provType string, rec metrics.Recorder, next OIDC
 	return measuredOIDCProvisioner{provType: provType, rec: rec, next: next}
 }
 
-func (m measuredOIDCProvisioner) Provision(ctx context.Context, settings OIDCProxySettings) (err error) {
+func (m measuredOIDCProvisioner) Provision(ctx context.Context, settings OIDCProxySettings) (st *OIDCProxyStatus, err error) {
 	defer func(t0 time.Time) {
 		m.rec.ObserveOIDCProvisionerOperation(ctx, m.provType, "Provision", err == nil, t0)
 	}(time.Now())
@@ -0,0 +1,452 @@
+package nginx
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/proxy"
+)
+
+// KubernetesRepository is the proxy kubernetes service used to communicate with Kubernetes.
+type KubernetesRepository interface {
+	GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error)
+	UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error
+	EnsureIngress(ctx context.Context, ingress *networkingv1.Ingress) error
+	DeleteIngress(ctx context.Context, ns, name string) error
+}
+
+//go:generate mockery -case underscore -output nginxmock -outpkg nginxmock -name KubernetesRepository
+
+// Nginx ingress controller external auth annotations.
+const (
+	authURLAnnotation             = "nginx.ingress.kubernetes.io/auth-url"
+	authSigninAnnotation          = "nginx.ingress.kubernetes.io/auth-signin"
+	authResponseHeadersAnnotation = "nginx.ingress.kubernetes.io/auth-response-headers"
+)
+
+// externalAuthAnnotations are the annotations restored on the unprovision, the rest of the
+// backed up annotations belong to other provisioners.
+var externalAuthAnnotations = []string{authURLAnnotation, authSigninAnnotation, authResponseHeadersAnnotation}
+
+const (
+	ingressClassAnnotation = "kubernetes.io/ingress.class"
+	useRegexAnnotation     = "nginx.ingress.kubernetes.io/use-regex"
+	nginxAnnotationPrefix  = "nginx.ingress.kubernetes.io/"
+)
+
+var defaultResponseHeaders = []string{"X-Auth-Request-User", "X-Auth-Request-Email"}
+
+type provisioner struct {
+	kuberepo KubernetesRepository
+	next     proxy.OIDCProvisioner
+	logger   log.Logger
+}
+
+// NewOIDCProvisioner returns a new oidc provisioner that secures the apps using the nginx ingress
+// controller external auth, for the apps that have nginx proxy settings, the rest of the apps
+// will be provisioned by the next provisioner.
+//
+// The authentication is made by an oauth2-proxy provisioned by the next provisioner in auth
+// only mode (one per app), the app ingress routes will point to the app and will be annotated
+// so nginx authenticates the requests against the proxy. An ingress for the proxy
+// `/oauth2` path will be created on the app hosts to handle the sign in flow.
+//
+// The routes that skip the authentication are regex paths of another ingress, without the external
+// auth, that routes them to the app.
+func NewOIDCProvisioner(kuberepo KubernetesRepository, next proxy.OIDCProvisioner, logger log.Logger) proxy.OIDCProvisioner {
+	return provisioner{
+		kuberepo: kuberepo,
+		next:     next,
+		logger:   logger.WithKV(log.KV{"service": "proxy.nginx.OIDCProvisioner"}),
+	}
+}
+
+func (p provisioner) Provision(ctx context.Context, settings proxy.OIDCProxySettings) (*proxy.OIDCProxyStatus, error) {
+	ns := settings.App.Ingress.Namespace
+	name := settings.App.Ingress.Name
+
+	// Not using nginx external auth, once the next provisioner has pointed the ingress to its proxy,
+	// we clean the external auth in case the app was using it before.
+	if settings.App.ProxySettings.Nginx == nil {
+		status, err := p.next.Provision(ctx, settings)
+		if err != nil {
+			return status, err
+		}
+
+		err = p.unprovisionExternalAuth(ctx, ns, name, settings.OriginalAnnotations, false)
+		if err != nil {
+			return status, fmt.Errorf("could not unprovision nginx external auth: %w", err)
+		}
+
+		return status, nil
+	}
+
+	// Set the external auth before the next provisioner points the ingress to the app, this
+	// way the app is never exposed without authentication (e.g: the app was being secured with
+	// the proxy in front).
+	err := p.provisionExternalAuth(ctx, settings)
+	if err != nil {
+		return &proxy.OIDCProxyStatus{ServiceName: getResourceName(name)}, fmt.Errorf("could not provision nginx external auth: %w", err)
+	}
+
+	// The proxy only authenticates, it will not be in front of the app.
+	nginxSettings := settings.App.ProxySettings.Nginx.Oauth2ProxySettings
+	settings.App.ProxySettings.Oauth2Proxy = &nginxSettings
+	settings.AuthOnly = true
+
+	status, err := p.next.Provision(ctx, settings)
+	if status == nil {
+		status = &proxy.OIDCProxyStatus{ServiceName: getResourceName(name)}
+	}
+	status.IngressPointed = true
+
+	return status, err
+}
+
+func (p provisioner) provisionExternalAuth(ctx context.Context, settings proxy.OIDCProxySettings) error {
+	ns := settings.App.Ingress.Namespace
+	name := settings.App.Ingress.Name
+	proxyName := getResourceName(name)
+
+	ing, err := p.kuberepo.GetIngress(ctx, ns, name)
+	if err != nil {
+		return err
+	}
+
+	// Sign in flow routes of the proxy.
+	authIng, err := getAuthIngress(ing, proxyName, settings.CallbackPath)
+	if err != nil {
+		return fmt.Errorf("invalid ingress: %w", err)
+	}
+	err = p.kuberepo.EnsureIngress(ctx, authIng)
+	if err != nil {
+		return fmt.Errorf("could not ensure proxy auth ingress: %w", err)
+	}
+
+	// Unauthenticated routes of the app.
+	skipAuthRoutes := settings.App.ProxySettings.SkipAuthRoutes
+	if len(skipAuthRoutes) > 0 {
+		skipAuthIng, err := getSkipAuthIngress(ing, proxyName, settings.App.Ingress.Routes, skipAuthRoutes)
+		if err != nil {
+			return fmt.Errorf("invalid ingress: %w", err)
+		}
+		err = p.kuberepo.EnsureIngress(ctx, skipAuthIng)
+		if err != nil {
+			return fmt.Errorf("could not ensure skip auth ingress: %w", err)
+		}
+	} else {
+		err = p.kuberepo.DeleteIngress(ctx, ns, getSkipAuthResourceName(proxyName))
+		if err != nil && !kubeerrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete skip auth ingress: %w", err)
+		}
+	}
+
+	// External auth.
+	responseHeaders := settings.App.ProxySettings.Nginx.ResponseHeaders
+	if len(responseHeaders) == 0 {
+		responseHeaders = defaultResponseHeaders
+	}
+	annotations := map[string]string{
+		authURLAnnotation:             getAuthURL(ns, proxyName),
+		authSigninAnnotation:          "https://$host/oauth2/start?rd=$escaped_request_uri",
+		authResponseHeadersAnnotation: strings.Join(responseHeaders, ","),
+	}
+
+	changed := false
+	if ing.Annotations == nil {
+		ing.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		if ing.Annotations[k] == v {
+			continue
+		}
+		ing.Annotations[k] = v
+		changed = true
+	}
+
+	if !changed {
+		p.logger.Debugf("ingress already has the external auth annotations, ignoring update")
+		return nil
+	}
+
+	err = p.kuberepo.UpdateIngress(ctx, ing)
+	if err != nil {
+		return fmt.Errorf("could not update ingress with external auth annotations: %w", err)
+	}
+
+	return nil
+}
+
+func (p provisioner) Unprovision(ctx context.Context, settings proxy.UnprovisionSettings) error {
+	err := p.unprovisionExternalAuth(ctx, settings.IngressNamespace, settings.IngressName, settings.OriginalAnnotations, true)
+	if err != nil {
+		return fmt.Errorf("could not unprovision nginx external auth: %w", err)
+	}
+
+	return p.next.Unprovision(ctx, settings)
+}
+
+// unprovisionExternalAuth deletes the proxy auth and skip auth ingresses and restores the original
+// external auth annotations, if the ingress is not using our external auth it will not be touched.
+// The ingresses are deleted first, this way if the restore fails it will be retried.
+//
+// When force is true, the auth ingress will be deleted although the app ingress is not using our
+// external auth (e.g: rollbacks where we don't know how the app was secured).
+func (p provisioner) unprovisionExternalAuth(ctx context.Context, ns, name string, originalAnnotations map[string]string, force bool) error {
+	proxyName := getResourceName(name)
+
+	ing, err := p.kuberepo.GetIngress(ctx, ns, name)
+	if err != nil {
+		return err
+	}
+
+	ours := ing.Annotations[authURLAnnotation] == getAuthURL(ns, proxyName)
+	if !ours && !force {
+		return nil
+	}
+
+	err = p.kuberepo.DeleteIngress(ctx, ns, proxyName)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete proxy auth ingress: %w", err)
+	}
+
+	err = p.kuberepo.DeleteIngress(ctx, ns, getSkipAuthResourceName(proxyName))
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete skip auth ingress: %w", err)
+	}
+
+	if !ours {
+		return nil
+	}
+
+	newAnnotations := map[string]string{}
+	for k, v := range ing.Annotations {
+		newAnnotations[k] = v
+	}
+	for _, k := range externalAuthAnnotations {
+		v, ok := originalAnnotations[k]
+		if ok {
+			newAnnotations[k] = v
+		} else {
+			delete(newAnnotations, k)
+		}
+	}
+
+	if reflect.DeepEqual(ing.Annotations, newAnnotations) {
+		return nil
+	}
+
+	ing.Annotations = newAnnotations
+	err = p.kuberepo.UpdateIngress(ctx, ing)
+	if err != nil {
+		return fmt.Errorf("could not restore ingress original annotations: %w", err)
+	}
+
+	return nil
+}
+
+// getAuthIngress returns the ingress that routes the `/oauth2` path and the callback path of the
+// app hosts to the proxy, so the proxy can handle the sign in flow (start, callback...).
+func getAuthIngress(ing *networkingv1.Ingress, proxyName, callbackPath string) (*networkingv1.Ingress, error) {
+	if len(ing.Spec.Rules) == 0 {
+		return nil, fmt.Errorf("ingress required rules are missing")
+	}
+
+	backend := networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: proxyName,
+			Port: networkingv1.ServiceBackendPort{Name: "http"},
+		},
+	}
+	pathType := networkingv1.PathTypePrefix
+	paths := []networkingv1.HTTPIngressPath{{Path: "/oauth2", PathType: &pathType, Backend: backend}}
+	if !strings.HasPrefix(callbackPath, "/oauth2/") {
+		exactPathType := networkingv1.PathTypeExact
+		paths = append(paths, networkingv1.HTTPIngressPath{Path: callbackPath, PathType: &exactPathType, Backend: backend})
+	}
+
+	rules := []networkingv1.IngressRule{}
+	hosts := map[string]bool{}
+	for _, r := range ing.Spec.Rules {
+		if hosts[r.Host] {
+			continue
+		}
+		hosts[r.Host] = true
+
+		rules = append(rules, networkingv1.IngressRule{
+			Host: r.Host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+			},
+		})
+	}
+
+	// Use the same ingress controller as the app.
+	var annotations map[string]string
+	if class, ok := ing.Annotations[ingressClassAnnotation]; ok {
+		annotations = map[string]string{ingressClassAnnotation: class}
+	}
+
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        proxyName,
+			Namespace:   ing.Namespace,
+			Labels:      getLabels(proxyName),
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: networkingv1.SchemeGroupVersion.String(),
+				Kind:       "Ingress",
+				Name:       ing.Name,
+				UID:        ing.UID,
+			}},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ing.Spec.IngressClassName,
+			TLS:              ing.Spec.TLS,
+			Rules:            rules,
+		},
+	}, nil
+}
+
+// getSkipAuthIngress returns the ingress that routes the paths that skip the authentication of the
+// app hosts to the app, without the external auth. The paths are nginx regexes (nginx anchors them
+// at the start), these take precedence over the app ingress paths when they are longer.
+//
+// The route upstream is unknown, so the hosts are validated to have a single upstream, and the
+// nginx annotations of the app ingress (e.g: backend protocol) are kept.
+func getSkipAuthIngress(ing *networkingv1.Ingress, proxyName string, appRoutes []model.IngressRoute, skipAuthRoutes []model.SkipAuthRoute) (*networkingv1.Ingress, error) {
+	if len(ing.Spec.Rules) == 0 {
+		return nil, fmt.Errorf("ingress required rules are missing")
+	}
+
+	pathType := networkingv1.PathTypeImplementationSpecific
+	rules := []networkingv1.IngressRule{}
+	hosts := map[string]bool{}
+	for _, r := range ing.Spec.Rules {
+		if hosts[r.Host] {
+			continue
+		}
+		hosts[r.Host] = true
+
+		backend, ok := getHostBackend(appRoutes, r.Host)
+		if !ok {
+			return nil, fmt.Errorf("%q host upstream is missing", r.Host)
+		}
+
+		paths := make([]networkingv1.HTTPIngressPath, 0, len(skipAuthRoutes))
+		for _, sr := range skipAuthRoutes {
+			paths = append(paths, networkingv1.HTTPIngressPath{
+				Path:     strings.TrimPrefix(sr.PathRegex, "^"),
+				PathType: &pathType,
+				Backend:  backend,
+			})
+		}
+
+		rules = append(rules, networkingv1.IngressRule{
+			Host: r.Host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+			},
+		})
+	}
+
+	annotations := map[string]string{}
+	for k, v := range ing.Annotations {
+		if k == ingressClassAnnotation || strings.HasPrefix(k, nginxAnnotationPrefix) {
+			annotations[k] = v
+		}
+	}
+	for _, k := range externalAuthAnnotations {
+		delete(annotations, k)
+	}
+	annotations[useRegexAnnotation] = "true"
+
+	name := getSkipAuthResourceName(proxyName)
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   ing.Namespace,
+			Labels:      getSkipAuthLabels(proxyName),
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: networkingv1.SchemeGroupVersion.String(),
+				Kind:       "Ingress",
+				Name:       ing.Name,
+				UID:        ing.UID,
+			}},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ing.Spec.IngressClassName,
+			TLS:              ing.Spec.TLS,
+			Rules:            rules,
+		},
+	}, nil
+}
+
+// getHostBackend returns the backend of the app upstream of the host.
+func getHostBackend(routes []model.IngressRoute, host string) (networkingv1.IngressBackend, bool) {
+	for _, r := range routes {
+		if r.Host != host {
+			continue
+		}
+
+		var port networkingv1.ServiceBackendPort
+		if p, err := strconv.Atoi(r.Upstream.PortOrPortName); err == nil {
+			port.Number = int32(p)
+		} else {
+			port.Name = r.Upstream.PortOrPortName
+		}
+
+		return networkingv1.IngressBackend{
+			Service: &networkingv1.IngressServiceBackend{
+				Name: r.Upstream.Name,
+				Port: port,
+			},
+		}, true
+	}
+
+	return networkingv1.IngressBackend{}, false
+}
+
+// getAuthURL returns the internal URL of the proxy used by nginx to authenticate the requests,
+// the URL is resolved without the cluster domain so it works with custom cluster domains.
+func getAuthURL(ns, proxyName string) string {
+	return fmt.Sprintf("http://%s.%s.svc/oauth2/auth", proxyName, ns)
+}
+
+// getResourceName returns the name of the proxy resources, these are the same
+// used by the oauth2-proxy provisioner.
+func getResourceName(name string) string {
+	return fmt.Sprintf("%s-bilrost-proxy", name)
+}
+
+// getSkipAuthResourceName returns the name of the skip auth ingress.
+func getSkipAuthResourceName(proxyName string) string {
+	return proxyName + "-skip-auth"
+}
+
+func getSkipAuthLabels(proxyName string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/component":  "skip-auth",
+		"app.kubernetes.io/instance":   proxyName,
+	}
+}
+
+func getLabels(name string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/name":       "oauth2-proxy",
+		"app.kubernetes.io/component":  "proxy",
+		"app.kubernetes.io/instance":   name,
+	}
+}
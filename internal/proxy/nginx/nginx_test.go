@@ -0,0 +1,524 @@
+package nginx_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/proxy"
+	"github.com/slok/bilrost/internal/proxy/nginx"
+	"github.com/slok/bilrost/internal/proxy/nginx/nginxmock"
+	"github.com/slok/bilrost/internal/proxy/proxymock"
+)
+
+func getBaseSettings() proxy.OIDCProxySettings {
+	return proxy.OIDCProxySettings{
+		URLs:         []string{"https://my.app.slok.dev"},
+		CallbackPath: "/oauth2/callback",
+		Upstreams:    []proxy.Upstream{{Host: "my.app.slok.dev", URL: "http://my-app.my-ns.svc.cluster.local:8080"}},
+		IssuerURL:    "https://dex.my-cluster.dev",
+		ClientID:     "my-app-bilrost",
+		ClientSecret: "my-secret",
+		App: model.App{
+			ID:            "my-ns/my-app",
+			AuthBackendID: "test-ns-dex-backend",
+			Ingress: model.KubernetesIngress{
+				Namespace: "my-ns",
+				Name:      "my-app",
+				UID:       "my-app-uid",
+				Routes: []model.IngressRoute{
+					{
+						Host: "my.app.slok.dev",
+						Upstream: model.KubernetesService{
+							Name:           "my-app",
+							Namespace:      "my-ns",
+							PortOrPortName: "8080",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getNginxSettings() proxy.OIDCProxySettings {
+	s := getBaseSettings()
+	s.App.ProxySettings.Nginx = &model.NginxProxySettings{
+		Oauth2ProxySettings: model.Oauth2ProxySettings{Replicas: 3},
+	}
+	return s
+}
+
+func getBaseIngress() *networkingv1.Ingress {
+	className := "nginx"
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-app",
+			Namespace:   "my-ns",
+			UID:         "my-app-uid",
+			Annotations: map[string]string{"test": "1"},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: &className,
+			TLS:              []networkingv1.IngressTLS{{Hosts: []string{"my.app.slok.dev"}, SecretName: "my-app-tls"}},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "my.app.slok.dev",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path: "/",
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "my-app",
+											Port: networkingv1.ServiceBackendPort{Number: 8080},
+										},
+									},
+								},
+								{
+									Path: "/api",
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "my-api",
+											Port: networkingv1.ServiceBackendPort{Number: 8080},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getAuthAnnotatedIngress() *networkingv1.Ingress {
+	ing := getBaseIngress()
+	ing.Annotations = map[string]string{
+		"test":                                 "1",
+		"nginx.ingress.kubernetes.io/auth-url": "http://my-app-bilrost-proxy.my-ns.svc/oauth2/auth",
+		"nginx.ingress.kubernetes.io/auth-signin":           "https://$host/oauth2/start?rd=$escaped_request_uri",
+		"nginx.ingress.kubernetes.io/auth-response-headers": "X-Auth-Request-User,X-Auth-Request-Email",
+	}
+	return ing
+}
+
+func getAuthIngress() *networkingv1.Ingress {
+	className := "nginx"
+	pathType := networkingv1.PathTypePrefix
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-app-bilrost-proxy",
+			Namespace: "my-ns",
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "bilrost",
+				"app.kubernetes.io/name":       "oauth2-proxy",
+				"app.kubernetes.io/component":  "proxy",
+				"app.kubernetes.io/instance":   "my-app-bilrost-proxy",
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "networking.k8s.io/v1",
+				Kind:       "Ingress",
+				Name:       "my-app",
+				UID:        "my-app-uid",
+			}},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: &className,
+			TLS:              []networkingv1.IngressTLS{{Hosts: []string{"my.app.slok.dev"}, SecretName: "my-app-tls"}},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "my.app.slok.dev",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/oauth2",
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "my-app-bilrost-proxy",
+											Port: networkingv1.ServiceBackendPort{Name: "http"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestOIDCProvisionerProvision(t *testing.T) {
+	tests := map[string]struct {
+		settings  func() proxy.OIDCProxySettings
+		mock      func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner)
+		expStatus *proxy.OIDCProxyStatus
+		expErr    bool
+	}{
+		"An app without nginx settings should be provisioned by the next provisioner.": {
+			settings: getBaseSettings,
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				mp.On("Provision", mock.Anything, getBaseSettings()).Once().Return(expStatus, nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app without nginx settings that was using the nginx external auth, should clean the external auth.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.OriginalAnnotations = map[string]string{"nginx.ingress.kubernetes.io/auth-response-headers": "X-Original"}
+				return s
+			},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAuthAnnotatedIngress(), nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+
+				expIng := getBaseIngress()
+				expIng.Annotations["nginx.ingress.kubernetes.io/auth-response-headers"] = "X-Original"
+				mk.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app without nginx settings failing on the next provisioner should fail.": {
+			settings: getBaseSettings,
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true},
+			expErr:    true,
+		},
+
+		"An app with nginx settings should set the external auth and provision the proxy in auth only mode.": {
+			settings: getNginxSettings,
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("EnsureIngress", mock.Anything, getAuthIngress()).Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+				mk.On("UpdateIngress", mock.Anything, getAuthAnnotatedIngress()).Once().Return(nil)
+
+				expSettings := getNginxSettings()
+				expSettings.AuthOnly = true
+				expSettings.App.ProxySettings.Oauth2Proxy = &model.Oauth2ProxySettings{Replicas: 3}
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				mp.On("Provision", mock.Anything, expSettings).Once().Return(expStatus, nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with nginx settings and custom response headers should set them on the external auth.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getNginxSettings()
+				s.App.ProxySettings.Nginx.ResponseHeaders = []string{"X-Auth-Request-Groups"}
+				return s
+			},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("EnsureIngress", mock.Anything, getAuthIngress()).Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+				expIng := getAuthAnnotatedIngress()
+				expIng.Annotations["nginx.ingress.kubernetes.io/auth-response-headers"] = "X-Auth-Request-Groups"
+				mk.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with nginx settings and a custom callback path should route the callback path to the proxy.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getNginxSettings()
+				s.CallbackPath = "/auth/callback"
+				return s
+			},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				expAuthIng := getAuthIngress()
+				exactPathType := networkingv1.PathTypeExact
+				callbackPath := networkingv1.HTTPIngressPath{
+					Path:     "/auth/callback",
+					PathType: &exactPathType,
+					Backend:  expAuthIng.Spec.Rules[0].HTTP.Paths[0].Backend,
+				}
+				expAuthIng.Spec.Rules[0].HTTP.Paths = append(expAuthIng.Spec.Rules[0].HTTP.Paths, callbackPath)
+				mk.On("EnsureIngress", mock.Anything, expAuthIng).Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+				mk.On("UpdateIngress", mock.Anything, getAuthAnnotatedIngress()).Once().Return(nil)
+
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with nginx settings already annotated, shouldn't update the ingress.": {
+			settings: getNginxSettings,
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAuthAnnotatedIngress(), nil)
+				mk.On("EnsureIngress", mock.Anything, getAuthIngress()).Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with nginx settings and skip auth routes should route them to the app without the external auth.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getNginxSettings()
+				s.App.ProxySettings.SkipAuthRoutes = []model.SkipAuthRoute{{PathRegex: "^/public/"}, {PathRegex: "/health$"}}
+				return s
+			},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				ing := getBaseIngress()
+				ing.Annotations["nginx.ingress.kubernetes.io/backend-protocol"] = "HTTPS"
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(ing, nil)
+				mk.On("EnsureIngress", mock.Anything, getAuthIngress()).Once().Return(nil)
+
+				className := "nginx"
+				pathType := networkingv1.PathTypeImplementationSpecific
+				backend := networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{
+						Name: "my-app",
+						Port: networkingv1.ServiceBackendPort{Number: 8080},
+					},
+				}
+				expSkipAuthIng := &networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "my-app-bilrost-proxy-skip-auth",
+						Namespace: "my-ns",
+						Labels: map[string]string{
+							"app.kubernetes.io/managed-by": "bilrost",
+							"app.kubernetes.io/component":  "skip-auth",
+							"app.kubernetes.io/instance":   "my-app-bilrost-proxy",
+						},
+						Annotations: map[string]string{
+							"nginx.ingress.kubernetes.io/use-regex":        "true",
+							"nginx.ingress.kubernetes.io/backend-protocol": "HTTPS",
+						},
+						OwnerReferences: []metav1.OwnerReference{{
+							APIVersion: "networking.k8s.io/v1",
+							Kind:       "Ingress",
+							Name:       "my-app",
+							UID:        "my-app-uid",
+						}},
+					},
+					Spec: networkingv1.IngressSpec{
+						IngressClassName: &className,
+						TLS:              []networkingv1.IngressTLS{{Hosts: []string{"my.app.slok.dev"}, SecretName: "my-app-tls"}},
+						Rules: []networkingv1.IngressRule{
+							{
+								Host: "my.app.slok.dev",
+								IngressRuleValue: networkingv1.IngressRuleValue{
+									HTTP: &networkingv1.HTTPIngressRuleValue{
+										Paths: []networkingv1.HTTPIngressPath{
+											{Path: "/public/", PathType: &pathType, Backend: backend},
+											{Path: "/health$", PathType: &pathType, Backend: backend},
+										},
+									},
+								},
+							},
+						},
+					},
+				}
+				mk.On("EnsureIngress", mock.Anything, expSkipAuthIng).Once().Return(nil)
+				mk.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with nginx settings failing ensuring the skip auth ingress should fail.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getNginxSettings()
+				s.App.ProxySettings.SkipAuthRoutes = []model.SkipAuthRoute{{PathRegex: "^/public/"}}
+				return s
+			},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("EnsureIngress", mock.Anything, getAuthIngress()).Once().Return(nil)
+				mk.On("EnsureIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+			expErr:    true,
+		},
+
+		"An app with nginx settings failing ensuring the auth ingress should fail.": {
+			settings: getNginxSettings,
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("EnsureIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+			expErr:    true,
+		},
+
+		"An app with nginx settings failing updating the ingress should fail.": {
+			settings: getNginxSettings,
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("EnsureIngress", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+				mk.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+			expErr:    true,
+		},
+
+		"An app with nginx settings failing on the next provisioner should fail.": {
+			settings: getNginxSettings,
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAuthAnnotatedIngress(), nil)
+				mk.On("EnsureIngress", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", IngressPointed: true},
+			expErr:    true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			// Mocks.
+			mk := &nginxmock.KubernetesRepository{}
+			mp := &proxymock.OIDCProvisioner{}
+			test.mock(mk, mp)
+
+			// Prepare.
+			p := nginx.NewOIDCProvisioner(mk, mp, log.Dummy)
+
+			// Execute.
+			gotStatus, err := p.Provision(context.TODO(), test.settings())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(test.expStatus, gotStatus)
+			mk.AssertExpectations(t)
+			mp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOIDCProvisionerUnprovision(t *testing.T) {
+	notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+
+	tests := map[string]struct {
+		settings proxy.UnprovisionSettings
+		mock     func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner)
+		expErr   bool
+	}{
+		"An app using the external auth should restore the original annotations, delete the auth and skip auth ingresses and unprovision the proxy.": {
+			settings: proxy.UnprovisionSettings{
+				IngressName:         "my-app",
+				IngressNamespace:    "my-ns",
+				OriginalAnnotations: map[string]string{"nginx.ingress.kubernetes.io/auth-url": "https://auth.slok.dev"},
+			},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAuthAnnotatedIngress(), nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+				expIng := getBaseIngress()
+				expIng.Annotations["nginx.ingress.kubernetes.io/auth-url"] = "https://auth.slok.dev"
+				mk.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+				mp.On("Unprovision", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+		},
+
+		"An app not using the external auth should not touch the ingress annotations.": {
+			settings: proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(notFoundErr)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(notFoundErr)
+				mp.On("Unprovision", mock.Anything, proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"}).Once().Return(nil)
+			},
+		},
+
+		"Failing deleting the auth ingress should stop the process.": {
+			settings: proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAuthAnnotatedIngress(), nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"Failing restoring the ingress annotations should stop the process.": {
+			settings: proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAuthAnnotatedIngress(), nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+				mk.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"Failing unprovisioning the proxy should fail.": {
+			settings: proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"},
+			mock: func(mk *nginxmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth").Once().Return(nil)
+				mp.On("Unprovision", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			// Mocks.
+			mk := &nginxmock.KubernetesRepository{}
+			mp := &proxymock.OIDCProvisioner{}
+			test.mock(mk, mp)
+
+			// Prepare.
+			p := nginx.NewOIDCProvisioner(mk, mp, log.Dummy)
+
+			// Execute.
+			err := p.Unprovision(context.TODO(), test.settings)
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			mk.AssertExpectations(t)
+			mp.AssertExpectations(t)
+		})
+	}
+}
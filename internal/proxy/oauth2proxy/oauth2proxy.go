@@ -5,17 +5,21 @@ import (
 	"crypto/md5"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/model"
 	"github.com/slok/bilrost/internal/proxy"
 )
 
@@ -27,8 +31,10 @@ type KubernetesRepository interface {
 	DeleteService(ctx context.Context, ns, name string) error
 	EnsureSecret(ctx context.Context, sec *corev1.Secret) error
 	DeleteSecret(ctx context.Context, ns, name string) error
-	GetIngress(ctx context.Context, ns, name string) (*networkingv1beta1.Ingress, error)
-	UpdateIngress(ctx context.Context, ingress *networkingv1beta1.Ingress) error
+	EnsureConfigMap(ctx context.Context, cm *corev1.ConfigMap) error
+	DeleteConfigMap(ctx context.Context, ns, name string) error
+	GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error)
+	UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error
 }
 
 //go:generate mockery -case underscore -output oauth2proxymock -outpkg oauth2proxymock -name KubernetesRepository
@@ -46,30 +52,61 @@ func NewOIDCProvisioner(kuberepo KubernetesRepository, logger log.Logger) proxy.
 	}
 }
 
-func (p provisioner) Provision(ctx context.Context, settings proxy.OIDCProxySettings) error {
+func (p provisioner) Provision(ctx context.Context, settings proxy.OIDCProxySettings) (*proxy.OIDCProxyStatus, error) {
+	status := &proxy.OIDCProxyStatus{
+		ServiceName: getResourceName(settings.App.Ingress.Name),
+	}
+
+	// oauth2-proxy can't check arbitrary claims.
+	if len(settings.App.ProxySettings.AccessControl.RequiredClaims) > 0 {
+		return status, fmt.Errorf("required claims access control is not supported by oauth2-proxy")
+	}
+
 	// Provision proxy.
 	secret, err := p.provisionSecret(ctx, settings)
 	if err != nil {
-		return fmt.Errorf("could not provision secret on Kubernetes: %w", err)
+		return status, fmt.Errorf("could not provision secret on Kubernetes: %w", err)
+	}
+
+	cm, err := p.provisionConfigMap(ctx, settings)
+	if err != nil {
+		return status, fmt.Errorf("could not provision configmap on Kubernetes: %w", err)
+	}
+
+	err = p.provisionRedis(ctx, settings)
+	if err != nil {
+		return status, fmt.Errorf("could not provision redis on Kubernetes: %w", err)
 	}
 
-	dep, err := p.provisionDeployment(ctx, settings, secret)
+	dep, err := p.provisionDeployment(ctx, settings, secret, cm)
 	if err != nil {
-		return fmt.Errorf("could not provision deployment on Kubernetes: %w", err)
+		return status, fmt.Errorf("could not provision deployment on Kubernetes: %w", err)
 	}
 
 	err = p.provisionDeploymentService(ctx, dep)
 	if err != nil {
-		return fmt.Errorf("could not provision service on Kubernetes: %w", err)
+		return status, fmt.Errorf("could not provision service on Kubernetes: %w", err)
+	}
+	status.Provisioned = true
+
+	// The ingress controller will authenticate the requests using the proxy, so the ingress
+	// needs to point to the app (e.g: an app that was being secured with the proxy in front).
+	if settings.AuthOnly {
+		err = p.setIngressToUpstreams(ctx, settings)
+		if err != nil {
+			return status, fmt.Errorf("could not update ingress on Kubernetes to point to the app upstreams: %w", err)
+		}
+		return status, nil
 	}
 
 	// Point ingress to the secure proxy.
 	err = p.setIngressToProxy(ctx, settings)
 	if err != nil {
-		return fmt.Errorf("could not update ingress in on Kubernetes to eanble oauth2 proxy service: %w", err)
+		return status, fmt.Errorf("could not update ingress in on Kubernetes to eanble oauth2 proxy service: %w", err)
 	}
+	status.IngressPointed = true
 
-	return nil
+	return status, nil
 }
 
 const (
@@ -88,9 +125,10 @@ func (p provisioner) provisionSecret(ctx context.Context, settings proxy.OIDCPro
 
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: settings.App.Ingress.Namespace,
-			Labels:    labels,
+			Name:            name,
+			Namespace:       settings.App.Ingress.Namespace,
+			Labels:          labels,
+			OwnerReferences: getOwnerReferences(settings.App.Ingress),
 		},
 		Type: corev1.SecretTypeOpaque,
 		Data: map[string][]byte{
@@ -108,7 +146,65 @@ func (p provisioner) provisionSecret(ctx context.Context, settings proxy.OIDCPro
 	return secret, nil
 }
 
-func (p provisioner) provisionDeployment(ctx context.Context, settings proxy.OIDCProxySettings, secret *corev1.Secret) (*appsv1.Deployment, error) {
+const (
+	emailsFileKey           = "authenticated-emails"
+	emailsFileMountPath     = "/etc/oauth2-proxy"
+	upstreamCAFileKey       = "upstream-ca.crt"
+	upstreamCAFileMountPath = "/etc/bilrost/upstream-ca"
+)
+
+// provisionConfigMap provisions the configmap with the authenticated emails and the upstream CA files
+// of the proxy, if the app doesn't have any of them, the configmap will be deleted and nil will be returned.
+func (p provisioner) provisionConfigMap(ctx context.Context, settings proxy.OIDCProxySettings) (*corev1.ConfigMap, error) {
+	name := getResourceName(settings.App.Ingress.Name)
+	ns := settings.App.Ingress.Namespace
+
+	data := map[string]string{}
+	emails := settings.App.ProxySettings.AccessControl.AllowedEmails
+	if len(emails) > 0 {
+		data[emailsFileKey] = strings.Join(emails, "\n") + "\n"
+	}
+	if ca := getUpstreamCA(settings); len(ca) > 0 {
+		data[upstreamCAFileKey] = string(ca)
+	}
+
+	if len(data) == 0 {
+		err := p.kuberepo.DeleteConfigMap(ctx, ns, name)
+		if err != nil && !kubeerrors.IsNotFound(err) {
+			return nil, fmt.Errorf("could not delete proxy configmap: %w", err)
+		}
+		return nil, nil
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       ns,
+			Labels:          getLabels(name),
+			OwnerReferences: getOwnerReferences(settings.App.Ingress),
+		},
+		Data: data,
+	}
+
+	err := p.kuberepo.EnsureConfigMap(ctx, cm)
+	if err != nil {
+		return nil, fmt.Errorf("could not ensure proxy configmap: %w", err)
+	}
+
+	return cm, nil
+}
+
+// getUpstreamCA returns the CA bundle used to verify the app certificates, the auth only
+// proxies don't connect to the app, so they don't need it.
+func getUpstreamCA(settings proxy.OIDCProxySettings) []byte {
+	if settings.AuthOnly {
+		return nil
+	}
+
+	return settings.App.ProxySettings.Upstream.CA
+}
+
+func (p provisioner) provisionDeployment(ctx context.Context, settings proxy.OIDCProxySettings, secret *corev1.Secret, cm *corev1.ConfigMap) (*appsv1.Deployment, error) {
 	const proxyInternalPort = 4180
 
 	// For consistency we will create everything with the same names and labels.
@@ -126,15 +222,70 @@ func (p provisioner) provisionDeployment(ctx context.Context, settings proxy.OID
 		return nil, fmt.Errorf("could not get checksum of secret data: %w", err)
 	}
 	checksumLabels["bilrost.slok.dev/secret-checksum-to-force-update"] = checksum
+	if cm != nil {
+		checksum, err := configMapChecksum(cm)
+		if err != nil {
+			return nil, fmt.Errorf("could not get checksum of configmap data: %w", err)
+		}
+		checksumLabels["bilrost.slok.dev/configmap-checksum-to-force-update"] = checksum
+	}
 
 	customSettings := getCustomizableSettings(settings)
 
+	// In auth only mode the proxy doesn't proxy to the app, it only answers the auth
+	// requests of the ingress controller with the user information headers.
+	upstreamArgs := []string{
+		`--upstream=static://202`,
+		`--reverse-proxy=true`,
+		`--set-xauthrequest=true`,
+	}
+	if !settings.AuthOnly {
+		upstreamArgs, err = getUpstreamArgs(settings.Upstreams)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstreams: %w", err)
+		}
+		if settings.App.ProxySettings.Upstream.InsecureSkipVerify {
+			upstreamArgs = append(upstreamArgs, `--ssl-upstream-insecure-skip-verify=true`)
+		}
+	}
+
+	// If we only have one public URL we can set the full redirect URL, otherwise
+	// we set only the path and the proxy will use the request host.
+	redirectURL := settings.CallbackPath
+	if len(settings.URLs) == 1 {
+		redirectURL = settings.URLs[0] + redirectURL
+	}
+
+	args := []string{
+		fmt.Sprintf(`--oidc-issuer-url=%s`, settings.IssuerURL),
+		fmt.Sprintf(`--client-id=$(%s)`, oidcClientIDEnv),
+		// TODO(slok): Create asecret and inject as env var.
+		fmt.Sprintf(`--client-secret=$(%s)`, oidcClientSecretEnv),
+		fmt.Sprintf(`--http-address=0.0.0.0:%d`, proxyInternalPort),
+		fmt.Sprintf(`--redirect-url=%s`, redirectURL),
+	}
+	args = append(args, upstreamArgs...)
+	args = append(args,
+		fmt.Sprintf(`--scope=%s`, strings.Join(customSettings.Scopes, " ")),
+		fmt.Sprintf(`--cookie-secret=$(%s)`, proxyCookieSecretEnv),
+	)
+	args = append(args, getSessionArgs(settings.App.ProxySettings.Session)...)
+	if settings.App.ProxySettings.Session.Store == model.SessionStoreRedis {
+		args = append(args, fmt.Sprintf(`--redis-connection-url=%s`, getRedisURL(settings)))
+	}
+	args = append(args,
+		`--provider=oidc`,
+		`--skip-provider-button`,
+	)
+	args = append(args, getAccessControlArgs(settings.App.ProxySettings.AccessControl)...)
+	args = append(args, getSkipAuthArgs(settings.App.ProxySettings.SkipAuthRoutes)...)
+
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: ns,
-			Labels:    labels,
-			// TODO(slok): Use owner refs or apply our finalizers?.
+			Name:            name,
+			Namespace:       ns,
+			Labels:          labels,
+			OwnerReferences: secret.OwnerReferences,
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &customSettings.Replicas,
@@ -150,21 +301,7 @@ func (p provisioner) provisionDeployment(ctx context.Context, settings proxy.OID
 						{
 							Name:  "app",
 							Image: customSettings.Image,
-							Args: []string{
-								fmt.Sprintf(`--oidc-issuer-url=%s`, settings.IssuerURL),
-								fmt.Sprintf(`--client-id=$(%s)`, oidcClientIDEnv),
-								// TODO(slok): Create asecret and inject as env var.
-								fmt.Sprintf(`--client-secret=$(%s)`, oidcClientSecretEnv),
-								fmt.Sprintf(`--http-address=0.0.0.0:%d`, proxyInternalPort),
-								fmt.Sprintf(`--redirect-url=%s/oauth2/callback`, settings.URL),
-								fmt.Sprintf(`--upstream=%s`, settings.UpstreamURL),
-								fmt.Sprintf(`--scope=%s`, strings.Join(customSettings.Scopes, " ")),
-								fmt.Sprintf(`--cookie-secret=$(%s)`, proxyCookieSecretEnv),
-								`--cookie-secure=false`,
-								`--provider=oidc`,
-								`--skip-provider-button`,
-								`--email-domain=*`,
-							},
+							Args:  args,
 							Ports: []corev1.ContainerPort{
 								{
 									ContainerPort: proxyInternalPort,
@@ -187,6 +324,59 @@ func (p provisioner) provisionDeployment(ctx context.Context, settings proxy.OID
 		},
 	}
 
+	podSpec := &deployment.Spec.Template.Spec
+	if cm != nil && cm.Data[emailsFileKey] != "" {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "authenticated-emails",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cm.Name},
+				},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "authenticated-emails",
+			MountPath: emailsFileMountPath,
+			ReadOnly:  true,
+		})
+	}
+
+	// oauth2-proxy doesn't have a flag for the upstream CAs, so we add the CA file
+	// to the system CA directories loaded by the proxy.
+	if cm != nil && cm.Data[upstreamCAFileKey] != "" {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "upstream-ca",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: cm.Name},
+					Items:                []corev1.KeyToPath{{Key: upstreamCAFileKey, Path: "ca.crt"}},
+				},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      "upstream-ca",
+			MountPath: upstreamCAFileMountPath,
+			ReadOnly:  true,
+		})
+		podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, corev1.EnvVar{Name: "SSL_CERT_DIR", Value: upstreamCAFileMountPath})
+	}
+
+	// The password of an existing Redis is loaded from the user secret by oauth2-proxy from
+	// its env var, this way the password is not on the container args nor on the connection URL
+	// (where it would need to be escaped).
+	redis := settings.App.ProxySettings.Session.Redis
+	if settings.App.ProxySettings.Session.Store == model.SessionStoreRedis && redis.Address != "" && redis.PasswordSecretName != "" {
+		podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, corev1.EnvVar{
+			Name: redisPasswordEnv,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: redis.PasswordSecretName},
+					Key:                  redis.PasswordSecretKey,
+				},
+			},
+		})
+	}
+
 	err = p.kuberepo.EnsureDeployment(ctx, deployment)
 	if err != nil {
 		return nil, fmt.Errorf("could not set up proxy deployment: %w", err)
@@ -195,6 +385,239 @@ func (p provisioner) provisionDeployment(ctx context.Context, settings proxy.OID
 	return deployment, nil
 }
 
+const (
+	redisPort        = 6379
+	redisPasswordEnv = "OAUTH2_PROXY_REDIS_PASSWORD"
+)
+
+// provisionRedis provisions the Redis used as the proxy session store, only if the sessions are stored
+// on Redis and the app doesn't have an existing Redis, otherwise the provisioned Redis will be deleted.
+func (p provisioner) provisionRedis(ctx context.Context, settings proxy.OIDCProxySettings) error {
+	name := getRedisResourceName(settings.App.Ingress.Name)
+	ns := settings.App.Ingress.Namespace
+
+	session := settings.App.ProxySettings.Session
+	if session.Store != model.SessionStoreRedis || session.Redis.Address != "" {
+		return p.deleteRedis(ctx, ns, name)
+	}
+
+	image := session.Redis.Image
+	if image == "" {
+		image = "redis:6-alpine"
+	}
+
+	labels := getRedisLabels(name)
+	replicas := int32(1)
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       ns,
+			Labels:          labels,
+			OwnerReferences: getOwnerReferences(settings.App.Ingress),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "redis",
+							Image: image,
+							// Sessions are ephemeral, we don't need persistence.
+							Args: []string{"--save", "", "--appendonly", "no"},
+							Ports: []corev1.ContainerPort{
+								{
+									ContainerPort: redisPort,
+									Name:          "redis",
+									Protocol:      "TCP",
+								},
+							},
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU:    resource.MustParse("10m"),
+									corev1.ResourceMemory: resource.MustParse("20Mi"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	err := p.kuberepo.EnsureDeployment(ctx, dep)
+	if err != nil {
+		return fmt.Errorf("could not ensure redis deployment: %w", err)
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       ns,
+			Labels:          labels,
+			OwnerReferences: dep.OwnerReferences,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     "ClusterIP",
+			Selector: labels,
+			Ports: []corev1.ServicePort{
+				{
+					Port:       redisPort,
+					Name:       "redis",
+					TargetPort: intstr.FromInt(redisPort),
+				},
+			},
+		},
+	}
+
+	err = p.kuberepo.EnsureService(ctx, svc)
+	if err != nil {
+		return fmt.Errorf("could not ensure redis service: %w", err)
+	}
+
+	return nil
+}
+
+// deleteRedis deletes the provisioned Redis, ignoring the already deleted resources.
+func (p provisioner) deleteRedis(ctx context.Context, ns, name string) error {
+	err := p.kuberepo.DeleteService(ctx, ns, name)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete redis service: %w", err)
+	}
+	err = p.kuberepo.DeleteDeployment(ctx, ns, name)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete redis deployment: %w", err)
+	}
+
+	return nil
+}
+
+// getRedisURL returns the connection URL of the app Redis session store, the existing one or the
+// provisioned one. The URL doesn't have the password, it's set with its own env var.
+func getRedisURL(settings proxy.OIDCProxySettings) string {
+	redis := settings.App.ProxySettings.Session.Redis
+	if redis.Address == "" {
+		return fmt.Sprintf("redis://%s:%d", getRedisResourceName(settings.App.Ingress.Name), redisPort)
+	}
+
+	return fmt.Sprintf("redis://%s", redis.Address)
+}
+
+// getAccessControlArgs returns the flags that restrict the users that can access the app,
+// oauth2-proxy allows the users with an allowed email or an email of an allowed domain. The
+// allowed groups need oauth2-proxy v7 or newer (the default image).
+func getAccessControlArgs(ac model.AccessControl) []string {
+	args := []string{}
+	for _, g := range ac.AllowedGroups {
+		args = append(args, fmt.Sprintf(`--allowed-group=%s`, g))
+	}
+
+	if len(ac.AllowedEmails) > 0 {
+		args = append(args, fmt.Sprintf(`--authenticated-emails-file=%s/%s`, emailsFileMountPath, emailsFileKey))
+	}
+
+	// Without email restrictions, all the emails are allowed.
+	domains := ac.AllowedEmailDomains
+	if len(ac.AllowedEmails) == 0 && len(domains) == 0 {
+		domains = []string{"*"}
+	}
+	for _, d := range domains {
+		args = append(args, fmt.Sprintf(`--email-domain=%s`, d))
+	}
+
+	return args
+}
+
+// getSkipAuthArgs returns the flags of the routes that don't require authentication, the
+// routes restricted to methods need oauth2-proxy v7 or newer (the default image).
+func getSkipAuthArgs(routes []model.SkipAuthRoute) []string {
+	args := []string{}
+	for _, r := range routes {
+		if len(r.Methods) == 0 {
+			args = append(args, fmt.Sprintf(`--skip-auth-regex=%s`, r.PathRegex))
+			continue
+		}
+		for _, m := range r.Methods {
+			args = append(args, fmt.Sprintf(`--skip-auth-route=%s=%s`, m, r.PathRegex))
+		}
+	}
+
+	return args
+}
+
+// getSessionArgs returns the flags of the user session settings, the cookie is secure
+// unless it's disabled explicitly.
+func getSessionArgs(session model.SessionSettings) []string {
+	args := []string{fmt.Sprintf(`--cookie-secure=%t`, !session.Cookie.Insecure)}
+	if session.Cookie.Name != "" {
+		args = append(args, fmt.Sprintf(`--cookie-name=%s`, session.Cookie.Name))
+	}
+	if session.Cookie.Domain != "" {
+		args = append(args, fmt.Sprintf(`--cookie-domain=%s`, session.Cookie.Domain))
+	}
+	if session.Cookie.Expire > 0 {
+		args = append(args, fmt.Sprintf(`--cookie-expire=%s`, session.Cookie.Expire))
+	}
+	if session.Cookie.Refresh > 0 {
+		args = append(args, fmt.Sprintf(`--cookie-refresh=%s`, session.Cookie.Refresh))
+	}
+	if session.Cookie.SameSite != "" {
+		args = append(args, fmt.Sprintf(`--cookie-samesite=%s`, session.Cookie.SameSite))
+	}
+	if session.Store != "" {
+		args = append(args, fmt.Sprintf(`--session-store-type=%s`, session.Store))
+	}
+
+	return args
+}
+
+// getUpstreamArgs returns the upstream flags for each of the upstreams, oauth2-proxy
+// routes the upstreams by path (without host), so the same path can't point to
+// different upstreams.
+func getUpstreamArgs(upstreams []proxy.Upstream) ([]string, error) {
+	args := []string{}
+	pathURLs := map[string]string{}
+	for _, u := range upstreams {
+		// oauth2-proxy uses the path of the upstream URL to route, and it matches
+		// all the requests under the path only if it ends with a slash.
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		if !strings.HasSuffix(path, "/") {
+			path += "/"
+		}
+
+		storedURL, ok := pathURLs[path]
+		if ok {
+			if storedURL != u.URL {
+				return nil, fmt.Errorf("path %q has multiple upstreams (%q and %q)", path, storedURL, u.URL)
+			}
+			continue
+		}
+		pathURLs[path] = u.URL
+
+		// oauth2-proxy only proxies HTTP/1 requests to the upstreams.
+		if strings.HasPrefix(u.URL, "h2c://") {
+			return nil, fmt.Errorf("%q upstream h2c scheme is not supported by oauth2-proxy", u.URL)
+		}
+
+		upstreamURL := u.URL
+		if path != "/" {
+			upstreamURL = strings.TrimSuffix(upstreamURL, "/") + path
+		}
+		args = append(args, fmt.Sprintf(`--upstream=%s`, upstreamURL))
+	}
+
+	return args, nil
+}
+
 type customizableSettings struct {
 	Image     string
 	Scopes    []string
@@ -204,7 +627,7 @@ type customizableSettings struct {
 
 func getCustomizableSettings(settings proxy.OIDCProxySettings) customizableSettings {
 	defaults := customizableSettings{
-		Image:    "quay.io/oauth2-proxy/oauth2-proxy:v5.1.0",
+		Image:    "quay.io/oauth2-proxy/oauth2-proxy:v7.2.1",
 		Scopes:   []string{"openid", "email", "profile", "groups", "offline_access"},
 		Replicas: int32(2),
 		Resources: corev1.ResourceRequirements{
@@ -252,9 +675,10 @@ func (p provisioner) provisionDeploymentService(ctx context.Context, dep *appsv1
 
 	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: ns,
-			Labels:    labels,
+			Name:            name,
+			Namespace:       ns,
+			Labels:          labels,
+			OwnerReferences: dep.OwnerReferences,
 		},
 		Spec: corev1.ServiceSpec{
 			Type:     "ClusterIP",
@@ -278,12 +702,16 @@ func (p provisioner) provisionDeploymentService(ctx context.Context, dep *appsv1
 }
 
 func (p provisioner) setIngressToProxy(ctx context.Context, settings proxy.OIDCProxySettings) error {
-	proxyBackend := networkingv1beta1.IngressBackend{
-		ServiceName: getResourceName(settings.App.Ingress.Name),
-		ServicePort: intstr.FromString(proxySvcName),
+	proxyBackend := networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: getResourceName(settings.App.Ingress.Name),
+			Port: networkingv1.ServiceBackendPort{Name: proxySvcName},
+		},
 	}
 
-	err := p.updateIngressBackend(ctx, settings.App.Ingress.Namespace, settings.App.Ingress.Name, proxyBackend)
+	// All the routes will point to the proxy.
+	getBackend := func(_, _ string) (networkingv1.IngressBackend, bool) { return proxyBackend, true }
+	err := p.updateIngressBackends(ctx, settings.App.Ingress.Namespace, settings.App.Ingress.Name, getBackend)
 	if err != nil {
 		return fmt.Errorf("could not point ingress to secured proxy: %w", err)
 	}
@@ -291,6 +719,16 @@ func (p provisioner) setIngressToProxy(ctx context.Context, settings proxy.OIDCP
 	return nil
 }
 
+func (p provisioner) setIngressToUpstreams(ctx context.Context, settings proxy.OIDCProxySettings) error {
+	getBackend := getRoutesBackend(settings.App.Ingress.Routes)
+	err := p.updateIngressBackends(ctx, settings.App.Ingress.Namespace, settings.App.Ingress.Name, getBackend)
+	if err != nil {
+		return fmt.Errorf("could not point ingress to app upstreams: %w", err)
+	}
+
+	return nil
+}
+
 func (p provisioner) Unprovision(ctx context.Context, settings proxy.UnprovisionSettings) error {
 	name := getResourceName(settings.IngressName)
 	ns := settings.IngressNamespace
@@ -303,37 +741,35 @@ func (p provisioner) Unprovision(ctx context.Context, settings proxy.Unprovision
 		return fmt.Errorf("could not restore ingress previous value: %w", err)
 	}
 
-	// Delete Proxy.
+	// Delete Proxy, ignoring the already deleted resources (e.g: a previous unprovision that
+	// failed halfway, or apps secured without proxy).
 	err = p.kuberepo.DeleteService(ctx, ns, name)
-	if err != nil {
+	if err != nil && !kubeerrors.IsNotFound(err) {
 		return fmt.Errorf("could not unprovision proxy service: %w", err)
 	}
 	err = p.kuberepo.DeleteDeployment(ctx, ns, name)
-	if err != nil {
+	if err != nil && !kubeerrors.IsNotFound(err) {
 		return fmt.Errorf("could not unprovision proxy deployment: %w", err)
 	}
 	err = p.kuberepo.DeleteSecret(ctx, ns, name)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not unprovision proxy secret: %w", err)
+	}
+	err = p.kuberepo.DeleteConfigMap(ctx, ns, name)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not unprovision proxy configmap: %w", err)
+	}
+	err = p.deleteRedis(ctx, ns, getRedisResourceName(settings.IngressName))
 	if err != nil {
-		return fmt.Errorf("could not unprovision proxy deployment: %w", err)
+		return fmt.Errorf("could not unprovision proxy redis: %w", err)
 	}
 
 	return nil
 }
 
 func (p provisioner) restoreIngress(ctx context.Context, settings proxy.UnprovisionSettings) error {
-	var port intstr.IntOrString
-	if p, err := strconv.Atoi(settings.OriginalServicePortOrNamePort); err == nil {
-		port = intstr.FromInt(p)
-	} else {
-		port = intstr.FromString(settings.OriginalServicePortOrNamePort)
-	}
-
-	origBackend := networkingv1beta1.IngressBackend{
-		ServiceName: settings.OriginalServiceName,
-		ServicePort: port,
-	}
-
-	err := p.updateIngressBackend(ctx, settings.IngressNamespace, settings.IngressName, origBackend)
+	getBackend := getRoutesBackend(settings.OriginalRoutes)
+	err := p.updateIngressBackends(ctx, settings.IngressNamespace, settings.IngressName, getBackend)
 	if err != nil {
 		return fmt.Errorf("could not restore original ingress backend: %w", err)
 	}
@@ -341,30 +777,75 @@ func (p provisioner) restoreIngress(ctx context.Context, settings proxy.Unprovis
 	return nil
 }
 
-func (p provisioner) updateIngressBackend(ctx context.Context, ns, name string, newBackend networkingv1beta1.IngressBackend) error {
+// getRoutesBackend returns a getBackend func for updateIngressBackends that returns the
+// upstream of the route that matches the host and path.
+func getRoutesBackend(routes []model.IngressRoute) func(host, path string) (networkingv1.IngressBackend, bool) {
+	return func(host, path string) (networkingv1.IngressBackend, bool) {
+		for _, r := range routes {
+			if r.Host != host || r.Path != path {
+				continue
+			}
+
+			var port networkingv1.ServiceBackendPort
+			if p, err := strconv.Atoi(r.Upstream.PortOrPortName); err == nil {
+				port.Number = int32(p)
+			} else {
+				port.Name = r.Upstream.PortOrPortName
+			}
+
+			return networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: r.Upstream.Name,
+					Port: port,
+				},
+			}, true
+		}
+
+		return networkingv1.IngressBackend{}, false
+	}
+}
+
+// updateIngressBackends will update the ingress paths backends with the backends returned by
+// getBackend func, if getBackend returns false, the path backend will not be updated.
+func (p provisioner) updateIngressBackends(ctx context.Context, ns, name string, getBackend func(host, path string) (networkingv1.IngressBackend, bool)) error {
 	ing, err := p.kuberepo.GetIngress(ctx, ns, name)
 	if err != nil {
 		return err
 	}
 
 	// Pre checks of the ingress.
-	rulesLen := len(ing.Spec.Rules)
-	if rulesLen != 1 {
-		return fmt.Errorf("ingress required rules is 1, got: %d", rulesLen)
+	if len(ing.Spec.Rules) == 0 {
+		return fmt.Errorf("ingress required rules are missing")
 	}
-	pathsLen := len(ing.Spec.Rules[0].HTTP.Paths)
-	if pathsLen != 1 {
-		return fmt.Errorf("ingress required paths is 1, got: %d", pathsLen)
+
+	changed := false
+	for i, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			return fmt.Errorf("ingress required HTTP rule on %q host is missing", rule.Host)
+		}
+
+		for j, path := range rule.HTTP.Paths {
+			newBackend, ok := getBackend(rule.Host, path.Path)
+			if !ok {
+				p.logger.Warningf("ingress %q host %q path without backend to update, ignoring", rule.Host, path.Path)
+				continue
+			}
+
+			// Do we need to update the ingress?
+			if reflect.DeepEqual(path.Backend, newBackend) {
+				continue
+			}
+
+			ing.Spec.Rules[i].HTTP.Paths[j].Backend = newBackend
+			changed = true
+		}
 	}
 
-	// Do we need to update the ingress?
-	currentBackend := ing.Spec.Rules[0].HTTP.Paths[0].Backend
-	if currentBackend == newBackend {
-		p.logger.Debugf("ingress already pointing to %s:%v service, ignoring update", newBackend.ServiceName)
+	if !changed {
+		p.logger.Debugf("ingress already pointing to the required backends, ignoring update")
 		return nil
 	}
 
-	ing.Spec.Rules[0].HTTP.Paths[0].Backend = newBackend
 	err = p.kuberepo.UpdateIngress(ctx, ing)
 	if err != nil {
 		return fmt.Errorf("could not update ingress with backend: %w", err)
@@ -377,6 +858,27 @@ func getResourceName(name string) string {
 	return fmt.Sprintf("%s-bilrost-proxy", name)
 }
 
+// getOwnerReferences returns the owner references to the app ingress, this way if the ingress
+// is deleted without rollbacking the security (e.g: finalizer removed by hand), Kubernetes
+// will garbage collect the proxy resources.
+func getOwnerReferences(ing model.KubernetesIngress) []metav1.OwnerReference {
+	// Apps mapped without the ingress UID can't be referenced.
+	if ing.UID == "" {
+		return nil
+	}
+
+	return []metav1.OwnerReference{{
+		APIVersion: networkingv1.SchemeGroupVersion.String(),
+		Kind:       "Ingress",
+		Name:       ing.Name,
+		UID:        types.UID(ing.UID),
+	}}
+}
+
+func getRedisResourceName(name string) string {
+	return fmt.Sprintf("%s-bilrost-proxy-redis", name)
+}
+
 func getLabels(name string) map[string]string {
 	return map[string]string{
 		"app.kubernetes.io/managed-by": "bilrost",
@@ -386,6 +888,15 @@ func getLabels(name string) map[string]string {
 	}
 }
 
+func getRedisLabels(name string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/name":       "redis",
+		"app.kubernetes.io/component":  "session-store",
+		"app.kubernetes.io/instance":   name,
+	}
+}
+
 func secretChecksum(s *corev1.Secret) (string, error) {
 	d, err := json.Marshal(s.Data)
 	if err != nil {
@@ -395,3 +906,13 @@ func secretChecksum(s *corev1.Secret) (string, error) {
 	checksum := md5.Sum([]byte(d))
 	return fmt.Sprintf("%x", checksum), nil
 }
+
+func configMapChecksum(cm *corev1.ConfigMap) (string, error) {
+	d, err := json.Marshal(cm.Data)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := md5.Sum(d)
+	return fmt.Sprintf("%x", checksum), nil
+}
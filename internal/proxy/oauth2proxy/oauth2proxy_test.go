@@ -4,12 +4,14 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -23,22 +25,28 @@ import (
 
 func getBaseSettings() proxy.OIDCProxySettings {
 	return proxy.OIDCProxySettings{
-		URL:          "https://my-app.my-cluster.dev",
-		UpstreamURL:  "http://my-app.my-ns.svc.cluster.local:8080",
+		URLs:         []string{"https://my-app.my-cluster.dev"},
+		CallbackPath: "/oauth2/callback",
+		Upstreams:    []proxy.Upstream{{URL: "http://my-app.my-ns.svc.cluster.local:8080"}},
 		IssuerURL:    "https://dex.my-cluster.dev",
 		ClientID:     "my-app-bilrost",
 		ClientSecret: "my-secret",
 		App: model.App{
 			ID:            "test-ns/my-app",
 			AuthBackendID: "test-ns-dex-backend",
-			Host:          "my.app.slok.dev",
 			Ingress: model.KubernetesIngress{
 				Namespace: "my-ns",
 				Name:      "my-app",
-				Upstream: model.KubernetesService{
-					Name:           "internal-app",
-					Namespace:      "test-ns",
-					PortOrPortName: "http",
+				UID:       "my-app-uid",
+				Routes: []model.IngressRoute{
+					{
+						Host: "my.app.slok.dev",
+						Upstream: model.KubernetesService{
+							Name:           "internal-app",
+							Namespace:      "test-ns",
+							PortOrPortName: "http",
+						},
+					},
 				},
 			},
 		},
@@ -76,6 +84,15 @@ func getBaseLabels() map[string]string {
 	}
 }
 
+func getBaseOwnerReferences() []metav1.OwnerReference {
+	return []metav1.OwnerReference{{
+		APIVersion: "networking.k8s.io/v1",
+		Kind:       "Ingress",
+		Name:       "my-app",
+		UID:        "my-app-uid",
+	}}
+}
+
 func getBaseDeployment() *appsv1.Deployment {
 	replicas := int32(2)
 	checkSumLabels := getBaseLabels()
@@ -83,9 +100,10 @@ func getBaseDeployment() *appsv1.Deployment {
 
 	return &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "my-app-bilrost-proxy",
-			Namespace: "my-ns",
-			Labels:    getBaseLabels(),
+			Name:            "my-app-bilrost-proxy",
+			Namespace:       "my-ns",
+			Labels:          getBaseLabels(),
+			OwnerReferences: getBaseOwnerReferences(),
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &replicas,
@@ -100,7 +118,7 @@ func getBaseDeployment() *appsv1.Deployment {
 					Containers: []corev1.Container{
 						{
 							Name:  "app",
-							Image: "quay.io/oauth2-proxy/oauth2-proxy:v5.1.0",
+							Image: "quay.io/oauth2-proxy/oauth2-proxy:v7.2.1",
 							Args: []string{
 								"--oidc-issuer-url=https://dex.my-cluster.dev",
 								"--client-id=$(OIDC_CLIENT_ID)",
@@ -110,7 +128,7 @@ func getBaseDeployment() *appsv1.Deployment {
 								"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
 								"--scope=openid email profile groups offline_access",
 								"--cookie-secret=$(PROXY_COOKIE_SECRET)",
-								"--cookie-secure=false",
+								"--cookie-secure=true",
 								"--provider=oidc",
 								"--skip-provider-button",
 								"--email-domain=*",
@@ -167,7 +185,7 @@ func getCustomDeployment() *appsv1.Deployment {
 		"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
 		"--scope=c9 c19 c29",
 		"--cookie-secret=$(PROXY_COOKIE_SECRET)",
-		"--cookie-secure=false",
+		"--cookie-secure=true",
 		"--provider=oidc",
 		"--skip-provider-button",
 		"--email-domain=*",
@@ -179,9 +197,10 @@ func getCustomDeployment() *appsv1.Deployment {
 func getBaseService() *corev1.Service {
 	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "my-app-bilrost-proxy",
-			Namespace: "my-ns",
-			Labels:    getBaseLabels(),
+			Name:            "my-app-bilrost-proxy",
+			Namespace:       "my-ns",
+			Labels:          getBaseLabels(),
+			OwnerReferences: getBaseOwnerReferences(),
 		},
 		Spec: corev1.ServiceSpec{
 			Type:     "ClusterIP",
@@ -200,9 +219,10 @@ func getBaseService() *corev1.Service {
 func getBaseSecret() *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "my-app-bilrost-proxy",
-			Namespace: "my-ns",
-			Labels:    getBaseLabels(),
+			Name:            "my-app-bilrost-proxy",
+			Namespace:       "my-ns",
+			Labels:          getBaseLabels(),
+			OwnerReferences: getBaseOwnerReferences(),
 		},
 		Type: corev1.SecretTypeOpaque,
 		Data: map[string][]byte{
@@ -213,24 +233,26 @@ func getBaseSecret() *corev1.Secret {
 	}
 }
 
-func getBaseIngress() *networkingv1beta1.Ingress {
-	return &networkingv1beta1.Ingress{
+func getBaseIngress() *networkingv1.Ingress {
+	return &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        "my-app",
 			Namespace:   "my-ns",
 			Labels:      map[string]string{"test": "1"},
 			Annotations: map[string]string{"test": "1"},
 		},
-		Spec: networkingv1beta1.IngressSpec{
-			Rules: []networkingv1beta1.IngressRule{
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
 				{
-					IngressRuleValue: networkingv1beta1.IngressRuleValue{
-						HTTP: &networkingv1beta1.HTTPIngressRuleValue{
-							Paths: []networkingv1beta1.HTTPIngressPath{
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
 								{
-									Backend: networkingv1beta1.IngressBackend{
-										ServiceName: "my-app",
-										ServicePort: intstr.FromInt(8080),
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "my-app",
+											Port: networkingv1.ServiceBackendPort{Number: 8080},
+										},
 									},
 								},
 							},
@@ -242,11 +264,65 @@ func getBaseIngress() *networkingv1beta1.Ingress {
 	}
 }
 
+func getMultiRouteIngress() *networkingv1.Ingress {
+	ing := getBaseIngress()
+	ing.Spec.Rules = []networkingv1.IngressRule{
+		{
+			Host: "my-app.my-cluster.dev",
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{
+						{
+							Path: "/",
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: "my-app",
+									Port: networkingv1.ServiceBackendPort{Number: 8080},
+								},
+							},
+						},
+						{
+							Path: "/api",
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: "my-api",
+									Port: networkingv1.ServiceBackendPort{Name: "http"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Host: "my-app2.my-cluster.dev",
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{
+						{
+							Path: "/api",
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: "my-api",
+									Port: networkingv1.ServiceBackendPort{Name: "http"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return ing
+}
+
 func TestOIDCProvisionerProvision(t *testing.T) {
 	tests := map[string]struct {
-		settings func() proxy.OIDCProxySettings
-		mock     func(m *oauth2proxymock.KubernetesRepository)
-		expErr   bool
+		settings  func() proxy.OIDCProxySettings
+		mock      func(m *oauth2proxymock.KubernetesRepository)
+		expStatus *proxy.OIDCProxyStatus
+		expErr    bool
 	}{
 		"A correct proxy provisioning should provision a secret, a deployment, a service, and swap the ingress.": {
 			settings: getBaseSettings,
@@ -256,6 +332,9 @@ func TestOIDCProvisionerProvision(t *testing.T) {
 				expSvc := getBaseService()
 
 				m.On("EnsureSecret", mock.Anything, expSec).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
 				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
 				m.On("EnsureService", mock.Anything, expSvc).Once().Return(nil)
 
@@ -263,12 +342,15 @@ func TestOIDCProvisionerProvision(t *testing.T) {
 				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(storedIngress, nil)
 
 				expIngress := getBaseIngress()
-				expIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1beta1.IngressBackend{
-					ServiceName: "my-app-bilrost-proxy",
-					ServicePort: intstr.FromString("http"),
+				expIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{
+						Name: "my-app-bilrost-proxy",
+						Port: networkingv1.ServiceBackendPort{Name: "http"},
+					},
 				}
 				m.On("UpdateIngress", mock.Anything, expIngress).Once().Return(nil)
 			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
 		},
 
 		"A correct proxy provisioning should provision a secret, a deployment, a service, and swap the ingress (custom settings).": {
@@ -279,6 +361,9 @@ func TestOIDCProvisionerProvision(t *testing.T) {
 				expSvc := getBaseService()
 
 				m.On("EnsureSecret", mock.Anything, expSec).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
 				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
 				m.On("EnsureService", mock.Anything, expSvc).Once().Return(nil)
 
@@ -286,12 +371,117 @@ func TestOIDCProvisionerProvision(t *testing.T) {
 				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(storedIngress, nil)
 
 				expIngress := getBaseIngress()
-				expIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1beta1.IngressBackend{
-					ServiceName: "my-app-bilrost-proxy",
-					ServicePort: intstr.FromString("http"),
+				expIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{
+						Name: "my-app-bilrost-proxy",
+						Port: networkingv1.ServiceBackendPort{Name: "http"},
+					},
 				}
 				m.On("UpdateIngress", mock.Anything, expIngress).Once().Return(nil)
 			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning of an app without ingress UID should not set owner references.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.Ingress.UID = ""
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				expSec := getBaseSecret()
+				expSec.OwnerReferences = nil
+				expDep := getBaseDeployment()
+				expDep.OwnerReferences = nil
+				expSvc := getBaseService()
+				expSvc.OwnerReferences = nil
+
+				m.On("EnsureSecret", mock.Anything, expSec).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, expSvc).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with multiple hosts and paths should configure all the upstreams and swap all the ingress routes.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.URLs = []string{"https://my-app.my-cluster.dev", "https://my-app2.my-cluster.dev"}
+				s.Upstreams = []proxy.Upstream{
+					{Host: "my-app.my-cluster.dev", Path: "/", URL: "http://my-app.my-ns.svc.cluster.local:8080"},
+					{Host: "my-app.my-cluster.dev", Path: "/api", URL: "http://my-api.my-ns.svc.cluster.local:8081"},
+					{Host: "my-app2.my-cluster.dev", Path: "/api", URL: "http://my-api.my-ns.svc.cluster.local:8081"},
+				}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				expSec := getBaseSecret()
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--client-id=$(OIDC_CLIENT_ID)",
+					"--client-secret=$(OIDC_CLIENT_SECRET)",
+					"--http-address=0.0.0.0:4180",
+					"--redirect-url=/oauth2/callback",
+					"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+					"--upstream=http://my-api.my-ns.svc.cluster.local:8081/api/",
+					"--scope=openid email profile groups offline_access",
+					"--cookie-secret=$(PROXY_COOKIE_SECRET)",
+					"--cookie-secure=true",
+					"--provider=oidc",
+					"--skip-provider-button",
+					"--email-domain=*",
+				}
+				expSvc := getBaseService()
+
+				m.On("EnsureSecret", mock.Anything, expSec).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, expSvc).Once().Return(nil)
+
+				storedIngress := getMultiRouteIngress()
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(storedIngress, nil)
+
+				expIngress := getMultiRouteIngress()
+				for _, r := range expIngress.Spec.Rules {
+					for i := range r.HTTP.Paths {
+						r.HTTP.Paths[i].Backend = networkingv1.IngressBackend{
+							Service: &networkingv1.IngressServiceBackend{
+								Name: "my-app-bilrost-proxy",
+								Port: networkingv1.ServiceBackendPort{Name: "http"},
+							},
+						}
+					}
+				}
+				m.On("UpdateIngress", mock.Anything, expIngress).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"Having the same path with different upstreams should fail.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.Upstreams = []proxy.Upstream{
+					{Host: "my-app.my-cluster.dev", Path: "/api", URL: "http://my-api.my-ns.svc.cluster.local:8081"},
+					{Host: "my-app2.my-cluster.dev", Path: "/api", URL: "http://my-api2.my-ns.svc.cluster.local:8081"},
+				}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
 		},
 
 		"If stored ingress already has been swapped, it shouldn't be updated.": {
@@ -302,16 +492,548 @@ func TestOIDCProvisionerProvision(t *testing.T) {
 				expSvc := getBaseService()
 
 				m.On("EnsureSecret", mock.Anything, expSec).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
 				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
 				m.On("EnsureService", mock.Anything, expSvc).Once().Return(nil)
 
 				storedIngress := getBaseIngress()
-				storedIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1beta1.IngressBackend{
-					ServiceName: "my-app-bilrost-proxy",
-					ServicePort: intstr.FromString("http"),
+				storedIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{
+						Name: "my-app-bilrost-proxy",
+						Port: networkingv1.ServiceBackendPort{Name: "http"},
+					},
 				}
 				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(storedIngress, nil)
 			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning in auth only mode should provision the proxy without upstreams and point the ingress to the app.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.AuthOnly = true
+				s.App.Ingress.Routes[0].Host = ""
+				s.App.Ingress.Routes[0].Upstream = model.KubernetesService{Name: "my-app", Namespace: "my-ns", PortOrPortName: "8080"}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				expSec := getBaseSecret()
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--client-id=$(OIDC_CLIENT_ID)",
+					"--client-secret=$(OIDC_CLIENT_SECRET)",
+					"--http-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/oauth2/callback",
+					"--upstream=static://202",
+					"--reverse-proxy=true",
+					"--set-xauthrequest=true",
+					"--scope=openid email profile groups offline_access",
+					"--cookie-secret=$(PROXY_COOKIE_SECRET)",
+					"--cookie-secure=true",
+					"--provider=oidc",
+					"--skip-provider-button",
+					"--email-domain=*",
+				}
+				expSvc := getBaseService()
+
+				m.On("EnsureSecret", mock.Anything, expSec).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, expSvc).Once().Return(nil)
+
+				// The ingress was pointing to the proxy.
+				storedIngress := getBaseIngress()
+				storedIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{
+						Name: "my-app-bilrost-proxy",
+						Port: networkingv1.ServiceBackendPort{Name: "http"},
+					},
+				}
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(storedIngress, nil)
+
+				expIngress := getBaseIngress()
+				m.On("UpdateIngress", mock.Anything, expIngress).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true},
+		},
+
+		"A correct proxy provisioning in auth only mode with the ingress already pointing to the app, it shouldn't be updated.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.AuthOnly = true
+				s.App.Ingress.Routes[0].Host = ""
+				s.App.Ingress.Routes[0].Upstream = model.KubernetesService{Name: "my-app", Namespace: "my-ns", PortOrPortName: "8080"}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true},
+		},
+
+		"A correct proxy provisioning with access control should provision the emails configmap and restrict the proxy users.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.AccessControl = model.AccessControl{
+					AllowedGroups:       []string{"admins", "devs"},
+					AllowedEmails:       []string{"jane@my-company.dev", "john@my-company.dev"},
+					AllowedEmailDomains: []string{"my-company.dev"},
+				}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				expCM := &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            "my-app-bilrost-proxy",
+						Namespace:       "my-ns",
+						Labels:          getBaseLabels(),
+						OwnerReferences: getBaseOwnerReferences(),
+					},
+					Data: map[string]string{
+						"authenticated-emails": "jane@my-company.dev\njohn@my-company.dev\n",
+					},
+				}
+
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Labels["bilrost.slok.dev/configmap-checksum-to-force-update"] = "22fd3ed9dc98008b1f01ba47cd012ec0"
+				// The default image needs to support `--allowed-group` (oauth2-proxy v7).
+				expDep.Spec.Template.Spec.Containers[0].Image = "quay.io/oauth2-proxy/oauth2-proxy:v7.2.1"
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--client-id=$(OIDC_CLIENT_ID)",
+					"--client-secret=$(OIDC_CLIENT_SECRET)",
+					"--http-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/oauth2/callback",
+					"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+					"--scope=openid email profile groups offline_access",
+					"--cookie-secret=$(PROXY_COOKIE_SECRET)",
+					"--cookie-secure=true",
+					"--provider=oidc",
+					"--skip-provider-button",
+					"--allowed-group=admins",
+					"--allowed-group=devs",
+					"--authenticated-emails-file=/etc/oauth2-proxy/authenticated-emails",
+					"--email-domain=my-company.dev",
+				}
+				expDep.Spec.Template.Spec.Volumes = []corev1.Volume{{
+					Name: "authenticated-emails",
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "my-app-bilrost-proxy"},
+						},
+					},
+				}}
+				expDep.Spec.Template.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{
+					Name:      "authenticated-emails",
+					MountPath: "/etc/oauth2-proxy",
+					ReadOnly:  true,
+				}}
+
+				m.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+				m.On("EnsureConfigMap", mock.Anything, expCM).Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, getBaseService()).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with allowed emails only should not allow all the email domains.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.AccessControl.AllowedEmails = []string{"jane@my-company.dev"}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("EnsureConfigMap", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, mock.MatchedBy(func(dep *appsv1.Deployment) bool {
+					args := dep.Spec.Template.Spec.Containers[0].Args
+					return args[len(args)-1] == "--authenticated-emails-file=/etc/oauth2-proxy/authenticated-emails"
+				})).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, mock.Anything, mock.Anything).Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with skip auth routes should configure the default proxy image to not authenticate them.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.SkipAuthRoutes = []model.SkipAuthRoute{
+					{PathRegex: "^/public/"},
+					{PathRegex: "^/api/health$", Methods: []string{"GET", "HEAD"}},
+				}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				expDep := getBaseDeployment()
+				// The default image needs to support `--skip-auth-route` (oauth2-proxy v7).
+				expDep.Spec.Template.Spec.Containers[0].Image = "quay.io/oauth2-proxy/oauth2-proxy:v7.2.1"
+				expDep.Spec.Template.Spec.Containers[0].Args = append(expDep.Spec.Template.Spec.Containers[0].Args,
+					"--skip-auth-regex=^/public/",
+					"--skip-auth-route=GET=^/api/health$",
+					"--skip-auth-route=HEAD=^/api/health$",
+				)
+
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, mock.Anything, mock.Anything).Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with session settings should configure the callback and the session cookie.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.CallbackPath = "/auth/callback"
+				s.App.ProxySettings.Session = model.SessionSettings{
+					CallbackPath: "/auth/callback",
+					Cookie: model.SessionCookie{
+						Name:     "_my_app",
+						Domain:   "my-cluster.dev",
+						Expire:   12 * time.Hour,
+						Refresh:  time.Hour,
+						SameSite: "strict",
+						Insecure: true,
+					},
+					Store: model.SessionStoreCookie,
+				}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--client-id=$(OIDC_CLIENT_ID)",
+					"--client-secret=$(OIDC_CLIENT_SECRET)",
+					"--http-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/auth/callback",
+					"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+					"--scope=openid email profile groups offline_access",
+					"--cookie-secret=$(PROXY_COOKIE_SECRET)",
+					"--cookie-secure=false",
+					"--cookie-name=_my_app",
+					"--cookie-domain=my-cluster.dev",
+					"--cookie-expire=12h0m0s",
+					"--cookie-refresh=1h0m0s",
+					"--cookie-samesite=strict",
+					"--session-store-type=cookie",
+					"--provider=oidc",
+					"--skip-provider-button",
+					"--email-domain=*",
+				}
+
+				m.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, getBaseService()).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with upstream TLS settings should provision the CA configmap and configure the proxy upstream TLS.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.Upstreams = []proxy.Upstream{{URL: "https://my-app.my-ns.svc.cluster.local:8443"}}
+				s.App.ProxySettings.Upstream = model.UpstreamSettings{
+					Scheme:             "https",
+					CA:                 []byte("-----BEGIN CERTIFICATE-----\nMIIBtest\n-----END CERTIFICATE-----\n"),
+					InsecureSkipVerify: true,
+				}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				expCM := &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            "my-app-bilrost-proxy",
+						Namespace:       "my-ns",
+						Labels:          getBaseLabels(),
+						OwnerReferences: getBaseOwnerReferences(),
+					},
+					Data: map[string]string{
+						"upstream-ca.crt": "-----BEGIN CERTIFICATE-----\nMIIBtest\n-----END CERTIFICATE-----\n",
+					},
+				}
+
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Labels["bilrost.slok.dev/configmap-checksum-to-force-update"] = "c02713530c7520eaf13e226e872ac946"
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--client-id=$(OIDC_CLIENT_ID)",
+					"--client-secret=$(OIDC_CLIENT_SECRET)",
+					"--http-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/oauth2/callback",
+					"--upstream=https://my-app.my-ns.svc.cluster.local:8443",
+					"--ssl-upstream-insecure-skip-verify=true",
+					"--scope=openid email profile groups offline_access",
+					"--cookie-secret=$(PROXY_COOKIE_SECRET)",
+					"--cookie-secure=true",
+					"--provider=oidc",
+					"--skip-provider-button",
+					"--email-domain=*",
+				}
+				expDep.Spec.Template.Spec.Volumes = []corev1.Volume{{
+					Name: "upstream-ca",
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "my-app-bilrost-proxy"},
+							Items:                []corev1.KeyToPath{{Key: "upstream-ca.crt", Path: "ca.crt"}},
+						},
+					},
+				}}
+				expDep.Spec.Template.Spec.Containers[0].VolumeMounts = []corev1.VolumeMount{{
+					Name:      "upstream-ca",
+					MountPath: "/etc/bilrost/upstream-ca",
+					ReadOnly:  true,
+				}}
+				expDep.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "SSL_CERT_DIR", Value: "/etc/bilrost/upstream-ca"}}
+
+				m.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+				m.On("EnsureConfigMap", mock.Anything, expCM).Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, getBaseService()).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct auth only proxy provisioning should ignore the upstream TLS settings.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.AuthOnly = true
+				s.App.ProxySettings.Upstream = model.UpstreamSettings{
+					Scheme:             "https",
+					CA:                 []byte("-----BEGIN CERTIFICATE-----\nMIIBtest\n-----END CERTIFICATE-----\n"),
+					InsecureSkipVerify: true,
+				}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, mock.MatchedBy(func(dep *appsv1.Deployment) bool {
+					for _, arg := range dep.Spec.Template.Spec.Containers[0].Args {
+						if arg == "--ssl-upstream-insecure-skip-verify=true" {
+							return false
+						}
+					}
+					return len(dep.Spec.Template.Spec.Volumes) == 0
+				})).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true},
+		},
+
+		"h2c upstreams should fail because oauth2-proxy doesn't support them.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.Upstreams = []proxy.Upstream{{URL: "h2c://my-app.my-ns.svc.cluster.local:8080"}}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+		},
+
+		"A correct proxy provisioning with the redis session store should provision a redis and use it as the session store.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.Session.Store = model.SessionStoreRedis
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				redisLabels := map[string]string{
+					"app.kubernetes.io/managed-by": "bilrost",
+					"app.kubernetes.io/name":       "redis",
+					"app.kubernetes.io/component":  "session-store",
+					"app.kubernetes.io/instance":   "my-app-bilrost-proxy-redis",
+				}
+				replicas := int32(1)
+				expRedisDep := &appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            "my-app-bilrost-proxy-redis",
+						Namespace:       "my-ns",
+						Labels:          redisLabels,
+						OwnerReferences: getBaseOwnerReferences(),
+					},
+					Spec: appsv1.DeploymentSpec{
+						Replicas: &replicas,
+						Selector: &metav1.LabelSelector{MatchLabels: redisLabels},
+						Template: corev1.PodTemplateSpec{
+							ObjectMeta: metav1.ObjectMeta{Labels: redisLabels},
+							Spec: corev1.PodSpec{
+								Containers: []corev1.Container{
+									{
+										Name:  "redis",
+										Image: "redis:6-alpine",
+										Args:  []string{"--save", "", "--appendonly", "no"},
+										Ports: []corev1.ContainerPort{
+											{
+												ContainerPort: 6379,
+												Name:          "redis",
+												Protocol:      "TCP",
+											},
+										},
+										Resources: corev1.ResourceRequirements{
+											Requests: corev1.ResourceList{
+												corev1.ResourceCPU:    resource.MustParse("10m"),
+												corev1.ResourceMemory: resource.MustParse("20Mi"),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				}
+				expRedisSvc := &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            "my-app-bilrost-proxy-redis",
+						Namespace:       "my-ns",
+						Labels:          redisLabels,
+						OwnerReferences: getBaseOwnerReferences(),
+					},
+					Spec: corev1.ServiceSpec{
+						Type:     "ClusterIP",
+						Selector: redisLabels,
+						Ports: []corev1.ServicePort{
+							{
+								Port:       6379,
+								Name:       "redis",
+								TargetPort: intstr.FromInt(6379),
+							},
+						},
+					},
+				}
+
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--client-id=$(OIDC_CLIENT_ID)",
+					"--client-secret=$(OIDC_CLIENT_SECRET)",
+					"--http-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/oauth2/callback",
+					"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+					"--scope=openid email profile groups offline_access",
+					"--cookie-secret=$(PROXY_COOKIE_SECRET)",
+					"--cookie-secure=true",
+					"--session-store-type=redis",
+					"--redis-connection-url=redis://my-app-bilrost-proxy-redis:6379",
+					"--provider=oidc",
+					"--skip-provider-button",
+					"--email-domain=*",
+				}
+
+				m.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expRedisDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, expRedisSvc).Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, getBaseService()).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"A correct proxy provisioning with an existing redis session store should use it with the password from the secret.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.Session.Store = model.SessionStoreRedis
+				s.App.ProxySettings.Session.Redis = model.SessionRedis{
+					Address:            "redis.my-ns.svc:6379",
+					PasswordSecretName: "redis-credentials",
+					PasswordSecretKey:  "password",
+				}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				expDep := getBaseDeployment()
+				expDep.Spec.Template.Spec.Containers[0].Args = []string{
+					"--oidc-issuer-url=https://dex.my-cluster.dev",
+					"--client-id=$(OIDC_CLIENT_ID)",
+					"--client-secret=$(OIDC_CLIENT_SECRET)",
+					"--http-address=0.0.0.0:4180",
+					"--redirect-url=https://my-app.my-cluster.dev/oauth2/callback",
+					"--upstream=http://my-app.my-ns.svc.cluster.local:8080",
+					"--scope=openid email profile groups offline_access",
+					"--cookie-secret=$(PROXY_COOKIE_SECRET)",
+					"--cookie-secure=true",
+					"--session-store-type=redis",
+					"--redis-connection-url=redis://redis.my-ns.svc:6379",
+					"--provider=oidc",
+					"--skip-provider-button",
+					"--email-domain=*",
+				}
+				expDep.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{{
+					Name: "OAUTH2_PROXY_REDIS_PASSWORD",
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "redis-credentials"},
+							Key:                  "password",
+						},
+					},
+				}}
+
+				m.On("EnsureSecret", mock.Anything, getBaseSecret()).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, expDep).Once().Return(nil)
+				m.On("EnsureService", mock.Anything, getBaseService()).Once().Return(nil)
+				m.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"Required claims access control should fail because oauth2-proxy doesn't support it.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.AccessControl.RequiredClaims = map[string]string{"email_verified": "true"}
+				return s
+			},
+			mock:      func(m *oauth2proxymock.KubernetesRepository) {},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
 		},
 
 		"Failing setting up the secret should stop the provision process.": {
@@ -319,49 +1041,95 @@ func TestOIDCProvisionerProvision(t *testing.T) {
 			mock: func(m *oauth2proxymock.KubernetesRepository) {
 				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
 			},
-			expErr: true,
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+		},
+
+		"Failing setting up the emails configmap should stop the provision process.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.AccessControl.AllowedEmails = []string{"jane@my-company.dev"}
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("EnsureConfigMap", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+		},
+
+		"Failing setting up the redis should stop the provision process.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.App.ProxySettings.Session.Store = model.SessionStoreRedis
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
 		},
 
 		"Failing setting up the deployment should stop the provision process.": {
 			settings: getBaseSettings,
 			mock: func(m *oauth2proxymock.KubernetesRepository) {
 				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
 				m.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
 			},
-			expErr: true,
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
 		},
 
 		"Failing setting up the service should stop the provision process.": {
 			settings: getBaseSettings,
 			mock: func(m *oauth2proxymock.KubernetesRepository) {
 				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
 				m.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(nil)
 				m.On("EnsureService", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
 			},
-			expErr: true,
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
 		},
 
 		"Failing getting the original ingress should stop the provision process.": {
 			settings: getBaseSettings,
 			mock: func(m *oauth2proxymock.KubernetesRepository) {
 				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
 				m.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(nil)
 				m.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
 				m.On("GetIngress", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("wanted error"))
 			},
-			expErr: true,
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true},
 		},
 
 		"Failing updating app ingress should stop the provision process.": {
 			settings: getBaseSettings,
 			mock: func(m *oauth2proxymock.KubernetesRepository) {
 				m.On("EnsureSecret", mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", mock.Anything, "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
 				m.On("EnsureDeployment", mock.Anything, mock.Anything).Once().Return(nil)
 				m.On("EnsureService", mock.Anything, mock.Anything).Once().Return(nil)
 				m.On("GetIngress", mock.Anything, mock.Anything, mock.Anything).Once().Return(getBaseIngress(), nil)
 				m.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
 			},
-			expErr: true,
+			expErr:    true,
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true},
 		},
 	}
 
@@ -373,8 +1141,9 @@ func TestOIDCProvisionerProvision(t *testing.T) {
 			test.mock(m)
 
 			prov := oauth2proxy.NewOIDCProvisioner(m, log.Dummy)
-			err := prov.Provision(context.TODO(), test.settings())
+			gotStatus, err := prov.Provision(context.TODO(), test.settings())
 
+			assert.Equal(test.expStatus, gotStatus)
 			if test.expErr {
 				assert.Error(err)
 			} else if assert.NoError(err) {
@@ -386,10 +1155,17 @@ func TestOIDCProvisionerProvision(t *testing.T) {
 
 func getBaseUnprovisionSettings() proxy.UnprovisionSettings {
 	return proxy.UnprovisionSettings{
-		IngressName:                   "test",
-		IngressNamespace:              "test-ns",
-		OriginalServiceName:           "test-orig-svc",
-		OriginalServicePortOrNamePort: "http-orig",
+		IngressName:      "test",
+		IngressNamespace: "test-ns",
+		OriginalRoutes: []model.IngressRoute{
+			{
+				Upstream: model.KubernetesService{
+					Name:           "test-orig-svc",
+					Namespace:      "test-ns",
+					PortOrPortName: "http-orig",
+				},
+			},
+		},
 	}
 }
 
@@ -406,14 +1182,99 @@ func TestOIDCProvisionerUnprovision(t *testing.T) {
 				m.On("GetIngress", context.TODO(), "test-ns", "test").Once().Return(storedIng, nil)
 
 				expIngress := storedIng.DeepCopy()
-				expIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1beta1.IngressBackend{
-					ServiceName: "test-orig-svc",
-					ServicePort: intstr.FromString("http-orig"),
+				expIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{
+						Name: "test-orig-svc",
+						Port: networkingv1.ServiceBackendPort{Name: "http-orig"},
+					},
+				}
+				m.On("UpdateIngress", context.TODO(), expIngress).Once().Return(nil)
+				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteDeployment", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteSecret", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteConfigMap", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", context.TODO(), "test-ns", "test-bilrost-proxy-redis").Once().Return(nil)
+			},
+		},
+
+		"An unprovisioning with the proxy already deleted should not fail.": {
+			settings: getBaseUnprovisionSettings,
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+				storedIng := getBaseIngress()
+				m.On("GetIngress", context.TODO(), "test-ns", "test").Once().Return(storedIng, nil)
+				m.On("UpdateIngress", context.TODO(), mock.Anything).Once().Return(nil)
+				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(notFoundErr)
+				m.On("DeleteDeployment", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(notFoundErr)
+				m.On("DeleteSecret", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(notFoundErr)
+				m.On("DeleteConfigMap", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(notFoundErr)
+				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy-redis").Once().Return(notFoundErr)
+				m.On("DeleteDeployment", context.TODO(), "test-ns", "test-bilrost-proxy-redis").Once().Return(notFoundErr)
+			},
+		},
+
+		"A correct proxy unprovisioning should restore the original ingress with a port number.": {
+			settings: func() proxy.UnprovisionSettings {
+				s := getBaseUnprovisionSettings()
+				s.OriginalRoutes[0].Upstream.PortOrPortName = "8080"
+				return s
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				storedIng := getBaseIngress()
+				m.On("GetIngress", context.TODO(), "test-ns", "test").Once().Return(storedIng, nil)
+
+				expIngress := storedIng.DeepCopy()
+				expIngress.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{
+						Name: "test-orig-svc",
+						Port: networkingv1.ServiceBackendPort{Number: 8080},
+					},
 				}
 				m.On("UpdateIngress", context.TODO(), expIngress).Once().Return(nil)
 				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
 				m.On("DeleteDeployment", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
 				m.On("DeleteSecret", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteConfigMap", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", context.TODO(), "test-ns", "test-bilrost-proxy-redis").Once().Return(nil)
+			},
+		},
+
+		"A correct proxy unprovisioning should restore all the original ingress routes.": {
+			settings: func() proxy.UnprovisionSettings {
+				return proxy.UnprovisionSettings{
+					IngressName:      "my-app",
+					IngressNamespace: "my-ns",
+					OriginalRoutes: []model.IngressRoute{
+						{Host: "my-app.my-cluster.dev", Path: "/", Upstream: model.KubernetesService{Name: "my-app", PortOrPortName: "8080"}},
+						{Host: "my-app.my-cluster.dev", Path: "/api", Upstream: model.KubernetesService{Name: "my-api", PortOrPortName: "http"}},
+						{Host: "my-app2.my-cluster.dev", Path: "/api", Upstream: model.KubernetesService{Name: "my-api", PortOrPortName: "http"}},
+					},
+				}
+			},
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				storedIng := getMultiRouteIngress()
+				for _, r := range storedIng.Spec.Rules {
+					for i := range r.HTTP.Paths {
+						r.HTTP.Paths[i].Backend = networkingv1.IngressBackend{
+							Service: &networkingv1.IngressServiceBackend{
+								Name: "my-app-bilrost-proxy",
+								Port: networkingv1.ServiceBackendPort{Name: "http"},
+							},
+						}
+					}
+				}
+				m.On("GetIngress", context.TODO(), "my-ns", "my-app").Once().Return(storedIng, nil)
+
+				expIngress := getMultiRouteIngress()
+				m.On("UpdateIngress", context.TODO(), expIngress).Once().Return(nil)
+				m.On("DeleteService", context.TODO(), "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteDeployment", context.TODO(), "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteSecret", context.TODO(), "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteConfigMap", context.TODO(), "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", context.TODO(), "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", context.TODO(), "my-ns", "my-app-bilrost-proxy-redis").Once().Return(nil)
 			},
 		},
 
@@ -421,14 +1282,19 @@ func TestOIDCProvisionerUnprovision(t *testing.T) {
 			settings: getBaseUnprovisionSettings,
 			mock: func(m *oauth2proxymock.KubernetesRepository) {
 				storedIng := getBaseIngress()
-				storedIng.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1beta1.IngressBackend{
-					ServiceName: "test-orig-svc",
-					ServicePort: intstr.FromString("http-orig"),
+				storedIng.Spec.Rules[0].HTTP.Paths[0].Backend = networkingv1.IngressBackend{
+					Service: &networkingv1.IngressServiceBackend{
+						Name: "test-orig-svc",
+						Port: networkingv1.ServiceBackendPort{Name: "http-orig"},
+					},
 				}
 				m.On("GetIngress", context.TODO(), "test-ns", "test").Once().Return(storedIng, nil)
 				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
 				m.On("DeleteDeployment", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
 				m.On("DeleteSecret", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteConfigMap", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy-redis").Once().Return(nil)
+				m.On("DeleteDeployment", context.TODO(), "test-ns", "test-bilrost-proxy-redis").Once().Return(nil)
 			},
 		},
 
@@ -481,6 +1347,33 @@ func TestOIDCProvisionerUnprovision(t *testing.T) {
 			},
 			expErr: true,
 		},
+
+		"Failing deleting the proxy redis should stop the process.": {
+			settings: getBaseUnprovisionSettings,
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				m.On("GetIngress", context.TODO(), mock.Anything, mock.Anything).Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", context.TODO(), mock.Anything).Once().Return(nil)
+				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy").Once().Return(nil)
+				m.On("DeleteDeployment", context.TODO(), mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteSecret", context.TODO(), mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", context.TODO(), mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteService", context.TODO(), "test-ns", "test-bilrost-proxy-redis").Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr: true,
+		},
+
+		"Failing deleting the proxy configmap should stop the process.": {
+			settings: getBaseUnprovisionSettings,
+			mock: func(m *oauth2proxymock.KubernetesRepository) {
+				m.On("GetIngress", context.TODO(), mock.Anything, mock.Anything).Once().Return(getBaseIngress(), nil)
+				m.On("UpdateIngress", context.TODO(), mock.Anything).Once().Return(nil)
+				m.On("DeleteService", context.TODO(), mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteDeployment", context.TODO(), mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteSecret", context.TODO(), mock.Anything, mock.Anything).Once().Return(nil)
+				m.On("DeleteConfigMap", context.TODO(), mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+			},
+			expErr: true,
+		},
 	}
 
 	for name, test := range tests {
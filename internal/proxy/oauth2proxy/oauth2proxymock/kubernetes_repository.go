@@ -11,7 +11,7 @@ import (
 
 	v1 "k8s.io/api/apps/v1"
 
-	v1beta1 "k8s.io/api/networking/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 )
 
 // KubernetesRepository is an autogenerated mock type for the KubernetesRepository type
@@ -19,6 +19,20 @@ type KubernetesRepository struct {
 	mock.Mock
 }
 
+// DeleteConfigMap provides a mock function with given fields: ctx, ns, name
+func (_m *KubernetesRepository) DeleteConfigMap(ctx context.Context, ns string, name string) error {
+	ret := _m.Called(ctx, ns, name)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, ns, name)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteDeployment provides a mock function with given fields: ctx, ns, name
 func (_m *KubernetesRepository) DeleteDeployment(ctx context.Context, ns string, name string) error {
 	ret := _m.Called(ctx, ns, name)
@@ -61,6 +75,20 @@ func (_m *KubernetesRepository) DeleteService(ctx context.Context, ns string, na
 	return r0
 }
 
+// EnsureConfigMap provides a mock function with given fields: ctx, cm
+func (_m *KubernetesRepository) EnsureConfigMap(ctx context.Context, cm *corev1.ConfigMap) error {
+	ret := _m.Called(ctx, cm)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *corev1.ConfigMap) error); ok {
+		r0 = rf(ctx, cm)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // EnsureDeployment provides a mock function with given fields: ctx, dep
 func (_m *KubernetesRepository) EnsureDeployment(ctx context.Context, dep *v1.Deployment) error {
 	ret := _m.Called(ctx, dep)
@@ -104,15 +132,15 @@ func (_m *KubernetesRepository) EnsureService(ctx context.Context, svc *corev1.S
 }
 
 // GetIngress provides a mock function with given fields: ctx, ns, name
-func (_m *KubernetesRepository) GetIngress(ctx context.Context, ns string, name string) (*v1beta1.Ingress, error) {
+func (_m *KubernetesRepository) GetIngress(ctx context.Context, ns string, name string) (*networkingv1.Ingress, error) {
 	ret := _m.Called(ctx, ns, name)
 
-	var r0 *v1beta1.Ingress
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) *v1beta1.Ingress); ok {
+	var r0 *networkingv1.Ingress
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *networkingv1.Ingress); ok {
 		r0 = rf(ctx, ns, name)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*v1beta1.Ingress)
+			r0 = ret.Get(0).(*networkingv1.Ingress)
 		}
 	}
 
@@ -127,11 +155,11 @@ func (_m *KubernetesRepository) GetIngress(ctx context.Context, ns string, name
 }
 
 // UpdateIngress provides a mock function with given fields: ctx, ingress
-func (_m *KubernetesRepository) UpdateIngress(ctx context.Context, ingress *v1beta1.Ingress) error {
+func (_m *KubernetesRepository) UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error {
 	ret := _m.Called(ctx, ingress)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, *v1beta1.Ingress) error); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *networkingv1.Ingress) error); ok {
 		r0 = rf(ctx, ingress)
 	} else {
 		r0 = ret.Error(0)
@@ -8,10 +8,13 @@ import (
 
 // OIDCProxySettings are the settings of the proxy.
 type OIDCProxySettings struct {
-	// URL is the Public URL where the app is listening.
-	URL string
-	// UpstreamURL is the internal URL where the app is litening.
-	UpstreamURL string
+	// URLs are the Public URLs where the app is listening, one for each of the app hosts.
+	URLs []string
+	// CallbackPath is the path of the OIDC callback on the app URLs, the callback URLs
+	// registered on the auth backend use the same path.
+	CallbackPath string
+	// Upstreams are the internal URLs where the app is listening, for each of the app routes.
+	Upstreams []Upstream
 	//IssuerURL is the public URL where the auth service is issuing the tokens (e.g Dex public URL).
 	IssuerURL string
 	// ClientID is the id that identifies the app in the auth service.
@@ -20,22 +23,69 @@ type OIDCProxySettings struct {
 	ClientSecret string
 	// Is the main application information.
 	App model.App
+	// OriginalAnnotations are the original values of the app ingress annotations that
+	// could be overridden by the provisioners (IngressAnnotationsToBackup), before being secured.
+	OriginalAnnotations map[string]string
+	// AuthOnly provisions the proxy only as an authentication service for the ingress controller
+	// (e.g: nginx external auth), the app ingress routes will point to the original upstreams.
+	AuthOnly bool
+}
+
+// DefaultCallbackPath is the path of the OIDC callback when the app doesn't set one.
+const DefaultCallbackPath = "/oauth2/callback"
+
+// IngressAnnotationsToBackup are the app ingress annotations that the provisioners could
+// override, their original values are backed up before securing the app so they can be
+// restored on the unprovision.
+var IngressAnnotationsToBackup = []string{
+	"nginx.ingress.kubernetes.io/auth-url",
+	"nginx.ingress.kubernetes.io/auth-signin",
+	"nginx.ingress.kubernetes.io/auth-response-headers",
+	"zalando.org/skipper-filter",
+}
+
+// Upstream is an internal URL of the app for a public route.
+type Upstream struct {
+	// Host is the public host of the route.
+	Host string
+	// Path is the public path of the route.
+	Path string
+	// URL is the internal URL where the app is listening for the route.
+	URL string
 }
 
 // UnprovisionSettings are the settings that the proxy service needs to restore
 // to the previous state.
 type UnprovisionSettings struct {
-	IngressName                   string
-	IngressNamespace              string
-	OriginalServiceName           string
-	OriginalServicePortOrNamePort string
+	IngressName      string
+	IngressNamespace string
+	// OriginalRoutes are the app routes with the original upstreams before
+	// being secured.
+	OriginalRoutes []model.IngressRoute
+	// OriginalAnnotations are the original values of the app ingress annotations that
+	// could be overridden by the provisioners (IngressAnnotationsToBackup), before being secured.
+	OriginalAnnotations map[string]string
+}
+
+// OIDCProxyStatus is the status of a provisioned proxy.
+type OIDCProxyStatus struct {
+	// ServiceName is the name of the Kubernetes service of the proxy.
+	ServiceName string
+	// Provisioned is true when the proxy resources have been provisioned.
+	Provisioned bool
+	// IngressPointed is true when the ingress routes have been pointed to the proxy, or
+	// the ingress has been set to authenticate using the proxy.
+	IngressPointed bool
 }
 
 // OIDCProvisioner knows how to provision an OIDC proxy to be able
 // to connect the proxy with the app as upstream and the
 // auth backend as the authentication service.
+//
+// Provision returns the status of the proxy also when it fails, so the
+// caller knows until which point the proxy has been provisioned.
 type OIDCProvisioner interface {
-	Provision(ctx context.Context, settings OIDCProxySettings) error
+	Provision(ctx context.Context, settings OIDCProxySettings) (*OIDCProxyStatus, error)
 	Unprovision(ctx context.Context, settings UnprovisionSettings) error
 }
 
@@ -15,17 +15,26 @@ type OIDCProvisioner struct {
 }
 
 // Provision provides a mock function with given fields: ctx, settings
-func (_m *OIDCProvisioner) Provision(ctx context.Context, settings proxy.OIDCProxySettings) error {
+func (_m *OIDCProvisioner) Provision(ctx context.Context, settings proxy.OIDCProxySettings) (*proxy.OIDCProxyStatus, error) {
 	ret := _m.Called(ctx, settings)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, proxy.OIDCProxySettings) error); ok {
+	var r0 *proxy.OIDCProxyStatus
+	if rf, ok := ret.Get(0).(func(context.Context, proxy.OIDCProxySettings) *proxy.OIDCProxyStatus); ok {
 		r0 = rf(ctx, settings)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*proxy.OIDCProxyStatus)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, proxy.OIDCProxySettings) error); ok {
+		r1 = rf(ctx, settings)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
 // Unprovision provides a mock function with given fields: ctx, settings
@@ -0,0 +1,495 @@
+package skipper
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/proxy"
+)
+
+// KubernetesRepository is the proxy kubernetes service used to communicate with Kubernetes.
+type KubernetesRepository interface {
+	GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error)
+	UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error
+	EnsureIngress(ctx context.Context, ingress *networkingv1.Ingress) error
+	DeleteIngress(ctx context.Context, ns, name string) error
+	ListIngresses(ctx context.Context, ns string, labelSelector map[string]string) (*networkingv1.IngressList, error)
+	GetService(ctx context.Context, ns, name string) (*corev1.Service, error)
+}
+
+//go:generate mockery -case underscore -output skippermock -outpkg skippermock -name KubernetesRepository
+
+const (
+	filterAnnotation        = "zalando.org/skipper-filter"
+	predicateAnnotation     = "zalando.org/skipper-predicate"
+	ingressClassAnnotation  = "kubernetes.io/ingress.class"
+	skipperAnnotationPrefix = "zalando.org/"
+)
+
+// Our filters are always the first ones, this way we know if the annotation has our filter.
+var filterPrefixes = []string{"oauthOidcAnyClaims(", "oauthOidcUserInfo("}
+
+var (
+	defaultScopes = []string{"openid", "email", "profile"}
+	defaultClaims = []string{"sub"}
+)
+
+type provisioner struct {
+	kuberepo KubernetesRepository
+	next     proxy.OIDCProvisioner
+	logger   log.Logger
+}
+
+// NewOIDCProvisioner returns a new oidc provisioner that secures the apps using the Skipper OIDC
+// filters (`zalando.org/skipper-filter` annotation), for the apps that have Skipper proxy settings,
+// the rest of the apps will be provisioned by the next provisioner.
+//
+// Skipper makes the authentication so no proxy is provisioned, the app ingress routes will point
+// to the app. The OIDC filter is set before the already present filters of the ingress, the original
+// filters are restored on the unprovision.
+//
+// While the app is secured, the filters annotation is owned by the provisioner: it's always set to our
+// filters followed by the original filters of the backup, so the changes made on the ingress annotation
+// are overwritten on the next provision.
+//
+// The routes that skip the authentication are ingresses (one for each route) with the same paths as
+// the app ingress, the original filters and the route predicates. Skipper prefers the routes with
+// more predicates, so these routes take precedence over the app ones.
+func NewOIDCProvisioner(kuberepo KubernetesRepository, next proxy.OIDCProvisioner, logger log.Logger) proxy.OIDCProvisioner {
+	return provisioner{
+		kuberepo: kuberepo,
+		next:     next,
+		logger:   logger.WithKV(log.KV{"service": "proxy.skipper.OIDCProvisioner"}),
+	}
+}
+
+func (p provisioner) Provision(ctx context.Context, settings proxy.OIDCProxySettings) (*proxy.OIDCProxyStatus, error) {
+	ns := settings.App.Ingress.Namespace
+	name := settings.App.Ingress.Name
+
+	// Not using Skipper filters, once the next provisioner has pointed the ingress to its proxy,
+	// we restore the original filters in case the app was using our filter before.
+	if settings.App.ProxySettings.Skipper == nil {
+		status, err := p.next.Provision(ctx, settings)
+		if err != nil {
+			return status, err
+		}
+
+		err = p.restoreFilters(ctx, ns, name, settings.OriginalAnnotations)
+		if err != nil {
+			return status, fmt.Errorf("could not restore original Skipper filters: %w", err)
+		}
+
+		err = p.deleteSkipAuthIngresses(ctx, ns, name, nil)
+		if err != nil {
+			return status, fmt.Errorf("could not delete Skipper skip auth ingresses: %w", err)
+		}
+
+		return status, nil
+	}
+
+	status := &proxy.OIDCProxyStatus{}
+
+	filter, err := getFilter(settings)
+	if err != nil {
+		return status, fmt.Errorf("invalid Skipper settings: %w", err)
+	}
+
+	// The filter and the routes are updated at the same time, this way the app is never exposed
+	// without authentication (e.g: the app was being secured with a proxy in front).
+	ing, err := p.kuberepo.GetIngress(ctx, ns, name)
+	if err != nil {
+		return status, fmt.Errorf("could not get ingress: %w", err)
+	}
+
+	// The original filters come from the backup, the ones on the ingress could be outdated or edited.
+	if originalFilters := settings.OriginalAnnotations[filterAnnotation]; originalFilters != "" {
+		filter = filter + " -> " + originalFilters
+	}
+
+	changed, err := p.setIngressBackends(ing, settings.App.Ingress.Routes)
+	if err != nil {
+		return status, fmt.Errorf("invalid ingress: %w", err)
+	}
+	if ing.Annotations[filterAnnotation] != filter {
+		if ing.Annotations == nil {
+			ing.Annotations = map[string]string{}
+		}
+		ing.Annotations[filterAnnotation] = filter
+		changed = true
+	}
+
+	if changed {
+		err = p.kuberepo.UpdateIngress(ctx, ing)
+		if err != nil {
+			return status, fmt.Errorf("could not update ingress with Skipper filters: %w", err)
+		}
+	}
+	status.IngressPointed = true
+
+	err = p.provisionSkipAuthIngresses(ctx, ing, settings)
+	if err != nil {
+		return status, fmt.Errorf("could not provision Skipper skip auth ingresses: %w", err)
+	}
+	status.Provisioned = true
+
+	// Clean the proxy in case the app was using it before.
+	_, err = p.kuberepo.GetService(ctx, ns, getResourceName(name))
+	if err != nil {
+		if kubeerrors.IsNotFound(err) {
+			return status, nil
+		}
+		return status, fmt.Errorf("could not get proxy service: %w", err)
+	}
+
+	err = p.next.Unprovision(ctx, proxy.UnprovisionSettings{
+		IngressName:         name,
+		IngressNamespace:    ns,
+		OriginalRoutes:      settings.App.Ingress.Routes,
+		OriginalAnnotations: settings.OriginalAnnotations,
+	})
+	if err != nil {
+		return status, fmt.Errorf("could not unprovision previous proxy: %w", err)
+	}
+
+	return status, nil
+}
+
+func (p provisioner) Unprovision(ctx context.Context, settings proxy.UnprovisionSettings) error {
+	err := p.restoreFilters(ctx, settings.IngressNamespace, settings.IngressName, settings.OriginalAnnotations)
+	if err != nil {
+		return fmt.Errorf("could not restore original Skipper filters: %w", err)
+	}
+
+	err = p.deleteSkipAuthIngresses(ctx, settings.IngressNamespace, settings.IngressName, nil)
+	if err != nil {
+		return fmt.Errorf("could not delete Skipper skip auth ingresses: %w", err)
+	}
+
+	return p.next.Unprovision(ctx, settings)
+}
+
+// restoreFilters restores the original filters of the ingress, if the ingress is not using
+// our filter it will not be touched.
+func (p provisioner) restoreFilters(ctx context.Context, ns, name string, originalAnnotations map[string]string) error {
+	ing, err := p.kuberepo.GetIngress(ctx, ns, name)
+	if err != nil {
+		return err
+	}
+
+	filters, ok := ing.Annotations[filterAnnotation]
+	originalFilters, originalOK := originalAnnotations[filterAnnotation]
+	if !ok || (originalOK && filters == originalFilters) || !hasOurFilter(filters) {
+		return nil
+	}
+
+	if originalOK {
+		ing.Annotations[filterAnnotation] = originalFilters
+	} else {
+		delete(ing.Annotations, filterAnnotation)
+	}
+
+	err = p.kuberepo.UpdateIngress(ctx, ing)
+	if err != nil {
+		return fmt.Errorf("could not update ingress: %w", err)
+	}
+
+	return nil
+}
+
+func hasOurFilter(filters string) bool {
+	for _, prefix := range filterPrefixes {
+		if strings.HasPrefix(filters, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getFilter returns the Skipper OIDC filter, the filter checks the ID token claims or the
+// user info fields, depending on the settings.
+func getFilter(settings proxy.OIDCProxySettings) (string, error) {
+	if len(settings.URLs) == 0 {
+		return "", fmt.Errorf("app public URL is missing")
+	}
+
+	// The filter is set on the whole ingress and it only has one callback URL, so the hosts
+	// that are not the callback one would be redirected to another host after the sign in.
+	if len(settings.URLs) > 1 {
+		return "", fmt.Errorf("multiple hosts are not supported by Skipper, the ingress has %d", len(settings.URLs))
+	}
+
+	// The Skipper OIDC cookies are configured globally with the Skipper flags.
+	if settings.App.ProxySettings.Session.Cookie != (model.SessionCookie{}) {
+		return "", fmt.Errorf("session cookie settings are not supported by Skipper")
+	}
+	if settings.App.ProxySettings.Session.Store == model.SessionStoreRedis {
+		return "", fmt.Errorf("redis session store is not supported by Skipper")
+	}
+
+	skipperSettings := settings.App.ProxySettings.Skipper
+	filterName, checks := "oauthOidcAnyClaims", skipperSettings.Claims
+	if len(skipperSettings.UserInfoFields) > 0 {
+		filterName, checks = "oauthOidcUserInfo", skipperSettings.UserInfoFields
+	}
+	if len(checks) == 0 {
+		checks = defaultClaims
+	}
+
+	scopes := settings.App.ProxySettings.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+
+	// Skipper doesn't support secret references on the filters, so the client secret is plain text.
+	args := []string{
+		settings.IssuerURL,
+		settings.ClientID,
+		settings.ClientSecret,
+		settings.URLs[0] + settings.CallbackPath,
+		strings.Join(scopes, " "),
+		strings.Join(checks, " "),
+	}
+	if len(skipperSettings.UpstreamHeaders) > 0 {
+		args = append(args, "", strings.Join(skipperSettings.UpstreamHeaders, " "))
+	}
+
+	quotedArgs := make([]string, 0, len(args))
+	for _, a := range args {
+		quotedArgs = append(quotedArgs, strconv.Quote(a))
+	}
+	filters := []string{fmt.Sprintf("%s(%s)", filterName, strings.Join(quotedArgs, ", "))}
+
+	acFilters, err := getAccessControlFilters(settings.App.ProxySettings.AccessControl)
+	if err != nil {
+		return "", fmt.Errorf("invalid access control: %w", err)
+	}
+	filters = append(filters, acFilters...)
+
+	return strings.Join(filters, " -> "), nil
+}
+
+// getAccessControlFilters returns the `oidcClaimsQuery` filters that check the access control of the
+// authenticated users. The queries (GJSON) of the same filter are ORed and the filters are ANDed, so
+// we use a filter for the groups, another for the emails and one for each of the required claims.
+func getAccessControlFilters(ac model.AccessControl) ([]string, error) {
+	// Skipper splits the queries of a filter by spaces.
+	values := append(append(append([]string{}, ac.AllowedGroups...), ac.AllowedEmails...), ac.AllowedEmailDomains...)
+	for claim, value := range ac.RequiredClaims {
+		values = append(values, claim, value)
+	}
+	for _, v := range values {
+		if strings.ContainsAny(v, " \t") {
+			return nil, fmt.Errorf("%q has spaces, not supported on Skipper claims queries", v)
+		}
+	}
+
+	filters := []string{}
+	if len(ac.AllowedGroups) > 0 {
+		queries := []string{}
+		for _, g := range ac.AllowedGroups {
+			queries = append(queries, fmt.Sprintf(`groups.#[==%s]`, strconv.Quote(g)))
+		}
+		filters = append(filters, getClaimsQueryFilter(queries))
+	}
+
+	if len(ac.AllowedEmails) > 0 || len(ac.AllowedEmailDomains) > 0 {
+		queries := []string{}
+		for _, e := range ac.AllowedEmails {
+			queries = append(queries, fmt.Sprintf(`@_:email==%s`, strconv.Quote(e)))
+		}
+		for _, d := range ac.AllowedEmailDomains {
+			queries = append(queries, fmt.Sprintf(`@_:email%%%s`, strconv.Quote("*@"+d)))
+		}
+		filters = append(filters, getClaimsQueryFilter(queries))
+	}
+
+	// Sorted to have the same filters on every provision.
+	claims := make([]string, 0, len(ac.RequiredClaims))
+	for c := range ac.RequiredClaims {
+		claims = append(claims, c)
+	}
+	sort.Strings(claims)
+	for _, c := range claims {
+		// Booleans are compared as JSON booleans, the rest of the values as strings.
+		value := ac.RequiredClaims[c]
+		if value != "true" && value != "false" {
+			value = strconv.Quote(value)
+		}
+		filters = append(filters, getClaimsQueryFilter([]string{fmt.Sprintf(`@_:%s==%s`, c, value)}))
+	}
+
+	return filters, nil
+}
+
+func getClaimsQueryFilter(queries []string) string {
+	return fmt.Sprintf("oidcClaimsQuery(%s)", strconv.Quote("/:"+strings.Join(queries, " ")))
+}
+
+// setIngressBackends sets the routes upstreams as the ingress paths backends, returns true if
+// the ingress changed.
+func (p provisioner) setIngressBackends(ing *networkingv1.Ingress, routes []model.IngressRoute) (bool, error) {
+	if len(ing.Spec.Rules) == 0 {
+		return false, fmt.Errorf("ingress required rules are missing")
+	}
+
+	changed := false
+	for i, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			return false, fmt.Errorf("ingress required HTTP rule on %q host is missing", rule.Host)
+		}
+
+		for j, path := range rule.HTTP.Paths {
+			backend, ok := getRouteBackend(routes, rule.Host, path.Path)
+			if !ok {
+				p.logger.Warningf("ingress %q host %q path without backend to update, ignoring", rule.Host, path.Path)
+				continue
+			}
+
+			if !reflect.DeepEqual(path.Backend, backend) {
+				ing.Spec.Rules[i].HTTP.Paths[j].Backend = backend
+				changed = true
+			}
+		}
+	}
+
+	return changed, nil
+}
+
+func getRouteBackend(routes []model.IngressRoute, host, path string) (networkingv1.IngressBackend, bool) {
+	for _, r := range routes {
+		if r.Host != host || r.Path != path {
+			continue
+		}
+
+		var port networkingv1.ServiceBackendPort
+		if p, err := strconv.Atoi(r.Upstream.PortOrPortName); err == nil {
+			port.Number = int32(p)
+		} else {
+			port.Name = r.Upstream.PortOrPortName
+		}
+
+		return networkingv1.IngressBackend{
+			Service: &networkingv1.IngressServiceBackend{
+				Name: r.Upstream.Name,
+				Port: port,
+			},
+		}, true
+	}
+
+	return networkingv1.IngressBackend{}, false
+}
+
+// provisionSkipAuthIngresses ensures the ingresses of the routes that skip the authentication, and
+// deletes the ones of the routes that are not present anymore. The app ingress needs to have the
+// app upstreams as the paths backends.
+func (p provisioner) provisionSkipAuthIngresses(ctx context.Context, ing *networkingv1.Ingress, settings proxy.OIDCProxySettings) error {
+	names := map[string]bool{}
+	for i, route := range settings.App.ProxySettings.SkipAuthRoutes {
+		skipAuthIng := getSkipAuthIngress(ing, i, route, settings.OriginalAnnotations[filterAnnotation])
+		err := p.kuberepo.EnsureIngress(ctx, skipAuthIng)
+		if err != nil {
+			return fmt.Errorf("could not ensure %q skip auth ingress: %w", skipAuthIng.Name, err)
+		}
+		names[skipAuthIng.Name] = true
+	}
+
+	return p.deleteSkipAuthIngresses(ctx, ing.Namespace, ing.Name, names)
+}
+
+// deleteSkipAuthIngresses deletes the skip auth ingresses of the app ingress, except the ones to keep.
+func (p provisioner) deleteSkipAuthIngresses(ctx context.Context, ns, name string, keep map[string]bool) error {
+	ings, err := p.kuberepo.ListIngresses(ctx, ns, getSkipAuthLabels(getResourceName(name)))
+	if err != nil {
+		return fmt.Errorf("could not list skip auth ingresses: %w", err)
+	}
+
+	for _, ing := range ings.Items {
+		if keep[ing.Name] {
+			continue
+		}
+
+		err := p.kuberepo.DeleteIngress(ctx, ns, ing.Name)
+		if err != nil && !kubeerrors.IsNotFound(err) {
+			return fmt.Errorf("could not delete %q skip auth ingress: %w", ing.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// getSkipAuthIngress returns the ingress of a route that skips the authentication, the ingress has
+// the same rules as the app ingress (pointing to the app) with the route predicates, and the original
+// filters of the app instead of our ones. The rest of the Skipper annotations of the app ingress are kept.
+func getSkipAuthIngress(ing *networkingv1.Ingress, index int, route model.SkipAuthRoute, originalFilters string) *networkingv1.Ingress {
+	predicates := []string{fmt.Sprintf("PathRegexp(%s)", strconv.Quote(route.PathRegex))}
+	if len(route.Methods) > 0 {
+		methods := make([]string, 0, len(route.Methods))
+		for _, m := range route.Methods {
+			methods = append(methods, strconv.Quote(m))
+		}
+		predicates = append(predicates, fmt.Sprintf("Methods(%s)", strings.Join(methods, ", ")))
+	}
+	if appPredicates := ing.Annotations[predicateAnnotation]; appPredicates != "" {
+		predicates = append(predicates, appPredicates)
+	}
+
+	annotations := map[string]string{}
+	for k, v := range ing.Annotations {
+		if k == ingressClassAnnotation || strings.HasPrefix(k, skipperAnnotationPrefix) {
+			annotations[k] = v
+		}
+	}
+	delete(annotations, filterAnnotation)
+	if originalFilters != "" {
+		annotations[filterAnnotation] = originalFilters
+	}
+	annotations[predicateAnnotation] = strings.Join(predicates, " && ")
+
+	proxyName := getResourceName(ing.Name)
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-skip-auth-%d", proxyName, index),
+			Namespace:   ing.Namespace,
+			Labels:      getSkipAuthLabels(proxyName),
+			Annotations: annotations,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: networkingv1.SchemeGroupVersion.String(),
+				Kind:       "Ingress",
+				Name:       ing.Name,
+				UID:        ing.UID,
+			}},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ing.Spec.IngressClassName,
+			TLS:              ing.Spec.TLS,
+			Rules:            ing.DeepCopy().Spec.Rules,
+		},
+	}
+}
+
+func getSkipAuthLabels(proxyName string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/component":  "skip-auth",
+		"app.kubernetes.io/instance":   proxyName,
+	}
+}
+
+// getResourceName returns the name of the proxy resources, these are the same
+// used by the oauth2-proxy provisioner.
+func getResourceName(name string) string {
+	return fmt.Sprintf("%s-bilrost-proxy", name)
+}
@@ -0,0 +1,565 @@
+package skipper_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/proxy"
+	"github.com/slok/bilrost/internal/proxy/proxymock"
+	"github.com/slok/bilrost/internal/proxy/skipper"
+	"github.com/slok/bilrost/internal/proxy/skipper/skippermock"
+)
+
+const (
+	filterAnnotation = "zalando.org/skipper-filter"
+	ourFilter        = `oauthOidcAnyClaims("https://dex.my-cluster.dev", "my-app-bilrost", "my-secret", "https://my.app.slok.dev/oauth2/callback", "openid email profile", "sub")`
+)
+
+var skipAuthLabels = map[string]string{
+	"app.kubernetes.io/managed-by": "bilrost",
+	"app.kubernetes.io/component":  "skip-auth",
+	"app.kubernetes.io/instance":   "my-app-bilrost-proxy",
+}
+
+func getBaseSettings() proxy.OIDCProxySettings {
+	return proxy.OIDCProxySettings{
+		URLs:         []string{"https://my.app.slok.dev"},
+		CallbackPath: "/oauth2/callback",
+		Upstreams:    []proxy.Upstream{{Host: "my.app.slok.dev", URL: "http://my-app.my-ns.svc.cluster.local:8080"}},
+		IssuerURL:    "https://dex.my-cluster.dev",
+		ClientID:     "my-app-bilrost",
+		ClientSecret: "my-secret",
+		App: model.App{
+			ID:            "my-ns/my-app",
+			AuthBackendID: "test-ns-dex-backend",
+			Ingress: model.KubernetesIngress{
+				Namespace: "my-ns",
+				Name:      "my-app",
+				UID:       "my-app-uid",
+				Routes: []model.IngressRoute{
+					{
+						Host: "my.app.slok.dev",
+						Path: "/",
+						Upstream: model.KubernetesService{
+							Name:           "my-app",
+							Namespace:      "my-ns",
+							PortOrPortName: "8080",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getSkipperSettings() proxy.OIDCProxySettings {
+	s := getBaseSettings()
+	s.App.ProxySettings.Skipper = &model.SkipperProxySettings{}
+	return s
+}
+
+func getIngress(backendName string, backendPort networkingv1.ServiceBackendPort, annotations map[string]string) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "my-app",
+			Namespace:   "my-ns",
+			UID:         "my-app-uid",
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "my.app.slok.dev",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path: "/",
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: backendName,
+											Port: backendPort,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getAppIngress(annotations map[string]string) *networkingv1.Ingress {
+	return getIngress("my-app", networkingv1.ServiceBackendPort{Number: 8080}, annotations)
+}
+
+func getProxiedIngress(annotations map[string]string) *networkingv1.Ingress {
+	return getIngress("my-app-bilrost-proxy", networkingv1.ServiceBackendPort{Name: "http"}, annotations)
+}
+
+func TestOIDCProvisionerProvision(t *testing.T) {
+	notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+
+	tests := map[string]struct {
+		settings  func() proxy.OIDCProxySettings
+		mock      func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner)
+		expStatus *proxy.OIDCProxyStatus
+		expErr    bool
+	}{
+		"An app without Skipper settings should be provisioned by the next provisioner.": {
+			settings: getBaseSettings,
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				mp.On("Provision", mock.Anything, getBaseSettings()).Once().Return(expStatus, nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getProxiedIngress(nil), nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app without Skipper settings that was using our filter, should restore the original filters.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.OriginalAnnotations = map[string]string{filterAnnotation: `ratelimit(20, "1m")`}
+				return s
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getProxiedIngress(map[string]string{filterAnnotation: ourFilter + ` -> ratelimit(20, "1m")`}), nil)
+				mk.On("UpdateIngress", mock.Anything, getProxiedIngress(map[string]string{filterAnnotation: `ratelimit(20, "1m")`})).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app without Skipper settings with its own filters, shouldn't touch them.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getBaseSettings()
+				s.OriginalAnnotations = map[string]string{filterAnnotation: `ratelimit(20, "1m")`}
+				return s
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getProxiedIngress(map[string]string{filterAnnotation: `ratelimit(20, "1m")`}), nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app without Skipper settings failing on the next provisioner should fail.": {
+			settings: getBaseSettings,
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"An app with Skipper settings should set the filter on the ingress.": {
+			settings: getSkipperSettings,
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(nil), nil)
+				mk.On("UpdateIngress", mock.Anything, getAppIngress(map[string]string{filterAnnotation: ourFilter})).Once().Return(nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetService", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil, notFoundErr)
+			},
+			expStatus: &proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Skipper settings and original filters should set our filter before the original ones.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.OriginalAnnotations = map[string]string{filterAnnotation: `ratelimit(20, "1m")`}
+				return s
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(map[string]string{filterAnnotation: `ratelimit(20, "1m")`}), nil)
+				mk.On("UpdateIngress", mock.Anything, getAppIngress(map[string]string{filterAnnotation: ourFilter + ` -> ratelimit(20, "1m")`})).Once().Return(nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetService", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil, notFoundErr)
+			},
+			expStatus: &proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Skipper user info settings should set the user info filter on the ingress.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.App.ProxySettings.Scopes = []string{"openid", "groups"}
+				s.App.ProxySettings.Skipper = &model.SkipperProxySettings{
+					Claims:          []string{"email"},
+					UserInfoFields:  []string{"groups"},
+					UpstreamHeaders: []string{"X-Auth-Email:claims.email", "X-Auth-Groups:claims.groups"},
+				}
+				return s
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(nil), nil)
+				expFilter := `oauthOidcUserInfo("https://dex.my-cluster.dev", "my-app-bilrost", "my-secret", "https://my.app.slok.dev/oauth2/callback", "openid groups", "groups", "", "X-Auth-Email:claims.email X-Auth-Groups:claims.groups")`
+				mk.On("UpdateIngress", mock.Anything, getAppIngress(map[string]string{filterAnnotation: expFilter})).Once().Return(nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetService", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil, notFoundErr)
+			},
+			expStatus: &proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Skipper settings and access control should set the claims query filters after the OIDC filter.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.App.ProxySettings.AccessControl = model.AccessControl{
+					AllowedGroups:       []string{"admins", "devs"},
+					AllowedEmails:       []string{"jane@my-company.dev"},
+					AllowedEmailDomains: []string{"my-company.dev"},
+					RequiredClaims:      map[string]string{"hd": "my-company.dev", "email_verified": "true"},
+				}
+				return s
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(nil), nil)
+				expFilter := ourFilter +
+					` -> oidcClaimsQuery("/:groups.#[==\"admins\"] groups.#[==\"devs\"]")` +
+					` -> oidcClaimsQuery("/:@_:email==\"jane@my-company.dev\" @_:email%\"*@my-company.dev\"")` +
+					` -> oidcClaimsQuery("/:@_:email_verified==true")` +
+					` -> oidcClaimsQuery("/:@_:hd==\"my-company.dev\"")`
+				mk.On("UpdateIngress", mock.Anything, getAppIngress(map[string]string{filterAnnotation: expFilter})).Once().Return(nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetService", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil, notFoundErr)
+			},
+			expStatus: &proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Skipper settings and access control values with spaces should fail.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.App.ProxySettings.AccessControl.AllowedGroups = []string{"my admins"}
+				return s
+			},
+			mock:      func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {},
+			expStatus: &proxy.OIDCProxyStatus{},
+			expErr:    true,
+		},
+
+		"An app with Skipper settings and a custom callback path should set the callback URL on the filter.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.CallbackPath = "/auth/callback"
+				s.App.ProxySettings.Session.CallbackPath = "/auth/callback"
+				return s
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(nil), nil)
+				expFilter := `oauthOidcAnyClaims("https://dex.my-cluster.dev", "my-app-bilrost", "my-secret", "https://my.app.slok.dev/auth/callback", "openid email profile", "sub")`
+				mk.On("UpdateIngress", mock.Anything, getAppIngress(map[string]string{filterAnnotation: expFilter})).Once().Return(nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetService", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil, notFoundErr)
+			},
+			expStatus: &proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Skipper settings and session cookie settings should fail.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.App.ProxySettings.Session.Cookie.Name = "_my_app"
+				return s
+			},
+			mock:      func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {},
+			expStatus: &proxy.OIDCProxyStatus{},
+			expErr:    true,
+		},
+
+		"An app with Skipper settings and the redis session store should fail.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.App.ProxySettings.Session.Store = model.SessionStoreRedis
+				return s
+			},
+			mock:      func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {},
+			expStatus: &proxy.OIDCProxyStatus{},
+			expErr:    true,
+		},
+
+		"An app with Skipper settings and skip auth routes should route them to the app without our filter.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.OriginalAnnotations = map[string]string{filterAnnotation: `ratelimit(20, "1m")`}
+				s.App.ProxySettings.SkipAuthRoutes = []model.SkipAuthRoute{
+					{PathRegex: "^/public/"},
+					{PathRegex: "^/api/status$", Methods: []string{"GET", "HEAD"}},
+				}
+				return s
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				appAnnotations := map[string]string{
+					filterAnnotation:                `ratelimit(20, "1m")`,
+					"zalando.org/skipper-predicate": `Header("X-Test", "1")`,
+					"kubernetes.io/ingress.class":   "skipper",
+					"test":                          "1",
+				}
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(appAnnotations), nil)
+				mk.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+
+				getSkipAuthIngress := func(name, predicate string) *networkingv1.Ingress {
+					ing := getAppIngress(map[string]string{
+						filterAnnotation:                `ratelimit(20, "1m")`,
+						"zalando.org/skipper-predicate": predicate,
+						"kubernetes.io/ingress.class":   "skipper",
+					})
+					ing.ObjectMeta = metav1.ObjectMeta{
+						Name:        name,
+						Namespace:   "my-ns",
+						Labels:      skipAuthLabels,
+						Annotations: ing.Annotations,
+						OwnerReferences: []metav1.OwnerReference{{
+							APIVersion: "networking.k8s.io/v1",
+							Kind:       "Ingress",
+							Name:       "my-app",
+							UID:        "my-app-uid",
+						}},
+					}
+					return ing
+				}
+				mk.On("EnsureIngress", mock.Anything, getSkipAuthIngress("my-app-bilrost-proxy-skip-auth-0", `PathRegexp("^/public/") && Header("X-Test", "1")`)).Once().Return(nil)
+				mk.On("EnsureIngress", mock.Anything, getSkipAuthIngress("my-app-bilrost-proxy-skip-auth-1", `PathRegexp("^/api/status$") && Methods("GET", "HEAD") && Header("X-Test", "1")`)).Once().Return(nil)
+
+				// Stale routes are deleted.
+				ings := &networkingv1.IngressList{Items: []networkingv1.Ingress{
+					{ObjectMeta: metav1.ObjectMeta{Name: "my-app-bilrost-proxy-skip-auth-0"}},
+					{ObjectMeta: metav1.ObjectMeta{Name: "my-app-bilrost-proxy-skip-auth-1"}},
+					{ObjectMeta: metav1.ObjectMeta{Name: "my-app-bilrost-proxy-skip-auth-2"}},
+				}}
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(ings, nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth-2").Once().Return(nil)
+				mk.On("GetService", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil, notFoundErr)
+			},
+			expStatus: &proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Skipper settings failing ensuring the skip auth ingresses should fail.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.App.ProxySettings.SkipAuthRoutes = []model.SkipAuthRoute{{PathRegex: "^/public/"}}
+				return s
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(nil), nil)
+				mk.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("EnsureIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{IngressPointed: true},
+			expErr:    true,
+		},
+
+		"An app with Skipper settings already provisioned shouldn't update the ingress.": {
+			settings: getSkipperSettings,
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(map[string]string{filterAnnotation: ourFilter}), nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetService", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil, notFoundErr)
+			},
+			expStatus: &proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Skipper settings that was using a proxy, should point the ingress to the app and unprovision the proxy.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.OriginalAnnotations = map[string]string{"test": "1"}
+				return s
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getProxiedIngress(nil), nil)
+				mk.On("UpdateIngress", mock.Anything, getAppIngress(map[string]string{filterAnnotation: ourFilter})).Once().Return(nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetService", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(&corev1.Service{}, nil)
+
+				expSettings := proxy.UnprovisionSettings{
+					IngressName:         "my-app",
+					IngressNamespace:    "my-ns",
+					OriginalRoutes:      getBaseSettings().App.Ingress.Routes,
+					OriginalAnnotations: map[string]string{"test": "1"},
+				}
+				mp.On("Unprovision", mock.Anything, expSettings).Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Skipper settings without public URLs should fail.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.URLs = nil
+				return s
+			},
+			mock:      func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {},
+			expStatus: &proxy.OIDCProxyStatus{},
+			expErr:    true,
+		},
+
+		"An app with Skipper settings and multiple hosts should fail.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getSkipperSettings()
+				s.URLs = []string{"https://my.app.slok.dev", "https://my.app2.slok.dev"}
+				return s
+			},
+			mock:      func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {},
+			expStatus: &proxy.OIDCProxyStatus{},
+			expErr:    true,
+		},
+
+		"An app with Skipper settings failing updating the ingress should fail.": {
+			settings: getSkipperSettings,
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(nil), nil)
+				mk.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{},
+			expErr:    true,
+		},
+
+		"An app with Skipper settings failing unprovisioning the previous proxy should fail.": {
+			settings: getSkipperSettings,
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(map[string]string{filterAnnotation: ourFilter}), nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mk.On("GetService", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(&corev1.Service{}, nil)
+				mp.On("Unprovision", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true},
+			expErr:    true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			// Mocks.
+			mk := &skippermock.KubernetesRepository{}
+			mp := &proxymock.OIDCProvisioner{}
+			test.mock(mk, mp)
+
+			// Prepare.
+			p := skipper.NewOIDCProvisioner(mk, mp, log.Dummy)
+
+			// Execute.
+			gotStatus, err := p.Provision(context.TODO(), test.settings())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(test.expStatus, gotStatus)
+			mk.AssertExpectations(t)
+			mp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOIDCProvisionerUnprovision(t *testing.T) {
+	tests := map[string]struct {
+		settings proxy.UnprovisionSettings
+		mock     func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner)
+		expErr   bool
+	}{
+		"An app using our filter should restore the original filters and unprovision the next.": {
+			settings: proxy.UnprovisionSettings{
+				IngressName:         "my-app",
+				IngressNamespace:    "my-ns",
+				OriginalAnnotations: map[string]string{filterAnnotation: `ratelimit(20, "1m")`},
+			},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(map[string]string{filterAnnotation: ourFilter + ` -> ratelimit(20, "1m")`}), nil)
+				mk.On("UpdateIngress", mock.Anything, getAppIngress(map[string]string{filterAnnotation: `ratelimit(20, "1m")`})).Once().Return(nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mp.On("Unprovision", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+		},
+
+		"An app using our filter without original filters should remove the filters annotation.": {
+			settings: proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(map[string]string{"test": "1", filterAnnotation: ourFilter}), nil)
+				mk.On("UpdateIngress", mock.Anything, getAppIngress(map[string]string{"test": "1"})).Once().Return(nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mp.On("Unprovision", mock.Anything, proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"}).Once().Return(nil)
+			},
+		},
+
+		"An app not using our filter should not touch the ingress.": {
+			settings: proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getProxiedIngress(map[string]string{filterAnnotation: `ratelimit(20, "1m")`}), nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mp.On("Unprovision", mock.Anything, mock.Anything).Once().Return(nil)
+			},
+		},
+
+		"Failing restoring the filters should stop the process.": {
+			settings: proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(map[string]string{filterAnnotation: ourFilter}), nil)
+				mk.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"Failing deleting the skip auth ingresses should stop the process.": {
+			settings: proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(nil), nil)
+				ings := &networkingv1.IngressList{Items: []networkingv1.Ingress{{ObjectMeta: metav1.ObjectMeta{Name: "my-app-bilrost-proxy-skip-auth-0"}}}}
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(ings, nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy-skip-auth-0").Once().Return(fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"Failing unprovisioning the next should fail.": {
+			settings: proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"},
+			mock: func(mk *skippermock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getAppIngress(nil), nil)
+				mk.On("ListIngresses", mock.Anything, "my-ns", skipAuthLabels).Once().Return(&networkingv1.IngressList{}, nil)
+				mp.On("Unprovision", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			// Mocks.
+			mk := &skippermock.KubernetesRepository{}
+			mp := &proxymock.OIDCProvisioner{}
+			test.mock(mk, mp)
+
+			// Prepare.
+			p := skipper.NewOIDCProvisioner(mk, mp, log.Dummy)
+
+			// Execute.
+			err := p.Unprovision(context.TODO(), test.settings)
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			mk.AssertExpectations(t)
+			mp.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package traefik
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/proxy"
+)
+
+// KubernetesRepository is the proxy kubernetes service used to communicate with Kubernetes.
+type KubernetesRepository interface {
+	GetIngress(ctx context.Context, ns, name string) (*networkingv1.Ingress, error)
+	UpdateIngress(ctx context.Context, ingress *networkingv1.Ingress) error
+	EnsureIngress(ctx context.Context, ingress *networkingv1.Ingress) error
+	DeleteIngress(ctx context.Context, ns, name string) error
+	EnsureTraefikMiddleware(ctx context.Context, mw *unstructured.Unstructured) error
+	DeleteTraefikMiddleware(ctx context.Context, ns, name string) error
+}
+
+//go:generate mockery -case underscore -output traefikmock -outpkg traefikmock -name KubernetesRepository
+
+const middlewaresAnnotation = "traefik.ingress.kubernetes.io/router.middlewares"
+
+// These annotations select the Traefik routers of the app ingress, the proxy auth ingress needs
+// the same ones.
+var routerAnnotations = []string{
+	"kubernetes.io/ingress.class",
+	"traefik.ingress.kubernetes.io/router.entrypoints",
+	"traefik.ingress.kubernetes.io/router.tls",
+}
+
+var defaultAuthResponseHeaders = []string{"X-Auth-Request-User", "X-Auth-Request-Email"}
+
+type provisioner struct {
+	kuberepo KubernetesRepository
+	next     proxy.OIDCProvisioner
+	logger   log.Logger
+}
+
+// NewOIDCProvisioner returns a new oidc provisioner that secures the apps using a Traefik forward
+// auth middleware, for the apps that have Traefik proxy settings, the rest of the apps will be
+// provisioned by the next provisioner.
+//
+// The authentication is made by an oauth2-proxy provisioned by the next provisioner in auth
+// only mode (one per app), the app ingress routes will point to the app and the middleware will
+// be attached to the ingress routers. An ingress for the proxy `/oauth2` path will be created on
+// the app hosts to handle the sign in flow.
+func NewOIDCProvisioner(kuberepo KubernetesRepository, next proxy.OIDCProvisioner, logger log.Logger) proxy.OIDCProvisioner {
+	return provisioner{
+		kuberepo: kuberepo,
+		next:     next,
+		logger:   logger.WithKV(log.KV{"service": "proxy.traefik.OIDCProvisioner"}),
+	}
+}
+
+func (p provisioner) Provision(ctx context.Context, settings proxy.OIDCProxySettings) (*proxy.OIDCProxyStatus, error) {
+	ns := settings.App.Ingress.Namespace
+	name := settings.App.Ingress.Name
+
+	// Not using Traefik forward auth, once the next provisioner has pointed the ingress to its proxy,
+	// we clean the forward auth in case the app was using it before.
+	if settings.App.ProxySettings.Traefik == nil {
+		status, err := p.next.Provision(ctx, settings)
+		if err != nil {
+			return status, err
+		}
+
+		err = p.unprovisionForwardAuth(ctx, ns, name, false)
+		if err != nil {
+			return status, fmt.Errorf("could not unprovision Traefik forward auth: %w", err)
+		}
+
+		return status, nil
+	}
+
+	// Set the forward auth before the next provisioner points the ingress to the app, this
+	// way the app is never exposed without authentication (e.g: the app was being secured with
+	// the proxy in front).
+	err := p.provisionForwardAuth(ctx, settings)
+	if err != nil {
+		return &proxy.OIDCProxyStatus{ServiceName: getResourceName(name)}, fmt.Errorf("could not provision Traefik forward auth: %w", err)
+	}
+
+	// The proxy only authenticates, it will not be in front of the app.
+	traefikSettings := settings.App.ProxySettings.Traefik.Oauth2ProxySettings
+	settings.App.ProxySettings.Oauth2Proxy = &traefikSettings
+	settings.AuthOnly = true
+
+	status, err := p.next.Provision(ctx, settings)
+	if status == nil {
+		status = &proxy.OIDCProxyStatus{ServiceName: getResourceName(name)}
+	}
+	status.IngressPointed = true
+
+	return status, err
+}
+
+func (p provisioner) provisionForwardAuth(ctx context.Context, settings proxy.OIDCProxySettings) error {
+	ns := settings.App.Ingress.Namespace
+	name := settings.App.Ingress.Name
+	proxyName := getResourceName(name)
+
+	ing, err := p.kuberepo.GetIngress(ctx, ns, name)
+	if err != nil {
+		return err
+	}
+
+	// Sign in flow routes of the proxy.
+	authIng, err := getAuthIngress(ing, proxyName, settings.CallbackPath)
+	if err != nil {
+		return fmt.Errorf("invalid ingress: %w", err)
+	}
+	err = p.kuberepo.EnsureIngress(ctx, authIng)
+	if err != nil {
+		return fmt.Errorf("could not ensure proxy auth ingress: %w", err)
+	}
+
+	// Forward auth.
+	authResponseHeaders := settings.App.ProxySettings.Traefik.AuthResponseHeaders
+	if len(authResponseHeaders) == 0 {
+		authResponseHeaders = defaultAuthResponseHeaders
+	}
+	err = p.kuberepo.EnsureTraefikMiddleware(ctx, getMiddleware(ing, proxyName, authResponseHeaders))
+	if err != nil {
+		return fmt.Errorf("could not ensure forward auth middleware: %w", err)
+	}
+
+	// Attach the middleware to the ingress routers, the authentication needs to be the first one.
+	mwRef := getMiddlewareRef(ns, proxyName)
+	mws := splitMiddlewares(ing.Annotations[middlewaresAnnotation])
+	if len(mws) > 0 && mws[0] == mwRef {
+		p.logger.Debugf("ingress already has the forward auth middleware, ignoring update")
+		return nil
+	}
+
+	newMws := []string{mwRef}
+	for _, mw := range mws {
+		if mw != mwRef {
+			newMws = append(newMws, mw)
+		}
+	}
+	if ing.Annotations == nil {
+		ing.Annotations = map[string]string{}
+	}
+	ing.Annotations[middlewaresAnnotation] = strings.Join(newMws, ",")
+
+	err = p.kuberepo.UpdateIngress(ctx, ing)
+	if err != nil {
+		return fmt.Errorf("could not update ingress with forward auth middleware: %w", err)
+	}
+
+	return nil
+}
+
+func (p provisioner) Unprovision(ctx context.Context, settings proxy.UnprovisionSettings) error {
+	err := p.unprovisionForwardAuth(ctx, settings.IngressNamespace, settings.IngressName, true)
+	if err != nil {
+		return fmt.Errorf("could not unprovision Traefik forward auth: %w", err)
+	}
+
+	return p.next.Unprovision(ctx, settings)
+}
+
+// unprovisionForwardAuth detaches the middleware from the ingress and deletes the middleware and the
+// proxy auth ingress, the middleware is detached first so Traefik doesn't use a missing middleware.
+// If the ingress is not using our middleware it will not be touched.
+//
+// When force is true, the middleware and the auth ingress will be deleted although the app ingress is
+// not using our middleware (e.g: rollbacks where we don't know how the app was secured).
+func (p provisioner) unprovisionForwardAuth(ctx context.Context, ns, name string, force bool) error {
+	proxyName := getResourceName(name)
+	mwRef := getMiddlewareRef(ns, proxyName)
+
+	ing, err := p.kuberepo.GetIngress(ctx, ns, name)
+	if err != nil {
+		return err
+	}
+
+	mws := splitMiddlewares(ing.Annotations[middlewaresAnnotation])
+	newMws := []string{}
+	for _, mw := range mws {
+		if mw != mwRef {
+			newMws = append(newMws, mw)
+		}
+	}
+
+	ours := len(newMws) != len(mws)
+	if !ours && !force {
+		return nil
+	}
+
+	if ours {
+		if len(newMws) == 0 {
+			delete(ing.Annotations, middlewaresAnnotation)
+		} else {
+			ing.Annotations[middlewaresAnnotation] = strings.Join(newMws, ",")
+		}
+
+		err = p.kuberepo.UpdateIngress(ctx, ing)
+		if err != nil {
+			return fmt.Errorf("could not remove forward auth middleware from ingress: %w", err)
+		}
+	}
+
+	err = p.kuberepo.DeleteTraefikMiddleware(ctx, ns, proxyName)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete forward auth middleware: %w", err)
+	}
+
+	err = p.kuberepo.DeleteIngress(ctx, ns, proxyName)
+	if err != nil && !kubeerrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete proxy auth ingress: %w", err)
+	}
+
+	return nil
+}
+
+// getMiddleware returns the forward auth middleware that authenticates the requests against the proxy.
+func getMiddleware(ing *networkingv1.Ingress, proxyName string, authResponseHeaders []string) *unstructured.Unstructured {
+	headers := make([]interface{}, 0, len(authResponseHeaders))
+	for _, h := range authResponseHeaders {
+		headers = append(headers, h)
+	}
+
+	mw := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "traefik.containo.us/v1alpha1",
+		"kind":       "Middleware",
+		"spec": map[string]interface{}{
+			"forwardAuth": map[string]interface{}{
+				// The proxy answers with the sign in redirect or with the static upstream.
+				"address":             fmt.Sprintf("http://%s.%s.svc/", proxyName, ing.Namespace),
+				"trustForwardHeader":  true,
+				"authResponseHeaders": headers,
+			},
+		},
+	}}
+	mw.SetName(proxyName)
+	mw.SetNamespace(ing.Namespace)
+	mw.SetLabels(getLabels(proxyName))
+	mw.SetOwnerReferences(getOwnerReferences(ing))
+
+	return mw
+}
+
+// getAuthIngress returns the ingress that routes the `/oauth2` path and the callback path of the
+// app hosts to the proxy, so the proxy can handle the sign in flow (start, callback...).
+func getAuthIngress(ing *networkingv1.Ingress, proxyName, callbackPath string) (*networkingv1.Ingress, error) {
+	if len(ing.Spec.Rules) == 0 {
+		return nil, fmt.Errorf("ingress required rules are missing")
+	}
+
+	backend := networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: proxyName,
+			Port: networkingv1.ServiceBackendPort{Name: "http"},
+		},
+	}
+	pathType := networkingv1.PathTypePrefix
+	paths := []networkingv1.HTTPIngressPath{{Path: "/oauth2", PathType: &pathType, Backend: backend}}
+	if !strings.HasPrefix(callbackPath, "/oauth2/") {
+		exactPathType := networkingv1.PathTypeExact
+		paths = append(paths, networkingv1.HTTPIngressPath{Path: callbackPath, PathType: &exactPathType, Backend: backend})
+	}
+
+	rules := []networkingv1.IngressRule{}
+	hosts := map[string]bool{}
+	for _, r := range ing.Spec.Rules {
+		if hosts[r.Host] {
+			continue
+		}
+		hosts[r.Host] = true
+
+		rules = append(rules, networkingv1.IngressRule{
+			Host: r.Host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{Paths: paths},
+			},
+		})
+	}
+
+	// Use the same Traefik routers configuration as the app.
+	var annotations map[string]string
+	for _, k := range routerAnnotations {
+		v, ok := ing.Annotations[k]
+		if !ok {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[k] = v
+	}
+
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            proxyName,
+			Namespace:       ing.Namespace,
+			Labels:          getLabels(proxyName),
+			Annotations:     annotations,
+			OwnerReferences: getOwnerReferences(ing),
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ing.Spec.IngressClassName,
+			TLS:              ing.Spec.TLS,
+			Rules:            rules,
+		},
+	}, nil
+}
+
+// getMiddlewareRef returns the reference used by the ingress annotation to attach a middleware.
+func getMiddlewareRef(ns, name string) string {
+	return fmt.Sprintf("%s-%s@kubernetescrd", ns, name)
+}
+
+func splitMiddlewares(s string) []string {
+	res := []string{}
+	for _, mw := range strings.Split(s, ",") {
+		mw = strings.TrimSpace(mw)
+		if mw != "" {
+			res = append(res, mw)
+		}
+	}
+
+	return res
+}
+
+// getOwnerReferences returns the owner references to the app ingress, this way Kubernetes
+// will garbage collect the forward auth resources with the ingress.
+func getOwnerReferences(ing *networkingv1.Ingress) []metav1.OwnerReference {
+	return []metav1.OwnerReference{{
+		APIVersion: networkingv1.SchemeGroupVersion.String(),
+		Kind:       "Ingress",
+		Name:       ing.Name,
+		UID:        ing.UID,
+	}}
+}
+
+// getResourceName returns the name of the proxy resources, these are the same
+// used by the oauth2-proxy provisioner.
+func getResourceName(name string) string {
+	return fmt.Sprintf("%s-bilrost-proxy", name)
+}
+
+func getLabels(name string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/name":       "oauth2-proxy",
+		"app.kubernetes.io/component":  "proxy",
+		"app.kubernetes.io/instance":   name,
+	}
+}
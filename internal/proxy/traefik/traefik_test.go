@@ -0,0 +1,398 @@
+package traefik_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	networkingv1 "k8s.io/api/networking/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/slok/bilrost/internal/log"
+	"github.com/slok/bilrost/internal/model"
+	"github.com/slok/bilrost/internal/proxy"
+	"github.com/slok/bilrost/internal/proxy/proxymock"
+	"github.com/slok/bilrost/internal/proxy/traefik"
+	"github.com/slok/bilrost/internal/proxy/traefik/traefikmock"
+)
+
+func getBaseSettings() proxy.OIDCProxySettings {
+	return proxy.OIDCProxySettings{
+		URLs:         []string{"https://my.app.slok.dev"},
+		CallbackPath: "/oauth2/callback",
+		Upstreams:    []proxy.Upstream{{Host: "my.app.slok.dev", URL: "http://my-app.my-ns.svc.cluster.local:8080"}},
+		IssuerURL:    "https://dex.my-cluster.dev",
+		ClientID:     "my-app-bilrost",
+		ClientSecret: "my-secret",
+		App: model.App{
+			ID:            "my-ns/my-app",
+			AuthBackendID: "test-ns-dex-backend",
+			Ingress: model.KubernetesIngress{
+				Namespace: "my-ns",
+				Name:      "my-app",
+				UID:       "my-app-uid",
+				Routes: []model.IngressRoute{
+					{
+						Host: "my.app.slok.dev",
+						Upstream: model.KubernetesService{
+							Name:           "my-app",
+							Namespace:      "my-ns",
+							PortOrPortName: "8080",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getTraefikSettings() proxy.OIDCProxySettings {
+	s := getBaseSettings()
+	s.App.ProxySettings.Traefik = &model.TraefikProxySettings{
+		Oauth2ProxySettings: model.Oauth2ProxySettings{Replicas: 3},
+	}
+	return s
+}
+
+func getBaseLabels() map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/managed-by": "bilrost",
+		"app.kubernetes.io/name":       "oauth2-proxy",
+		"app.kubernetes.io/component":  "proxy",
+		"app.kubernetes.io/instance":   "my-app-bilrost-proxy",
+	}
+}
+
+func getBaseOwnerReferences() []metav1.OwnerReference {
+	return []metav1.OwnerReference{{
+		APIVersion: "networking.k8s.io/v1",
+		Kind:       "Ingress",
+		Name:       "my-app",
+		UID:        "my-app-uid",
+	}}
+}
+
+func getBaseIngress() *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-app",
+			Namespace: "my-ns",
+			UID:       "my-app-uid",
+			Annotations: map[string]string{
+				"kubernetes.io/ingress.class":                      "traefik",
+				"traefik.ingress.kubernetes.io/router.entrypoints": "websecure",
+				"test": "1",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "my.app.slok.dev",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path: "/",
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "my-app",
+											Port: networkingv1.ServiceBackendPort{Number: 8080},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getMiddlewareIngress(middlewares string) *networkingv1.Ingress {
+	ing := getBaseIngress()
+	ing.Annotations["traefik.ingress.kubernetes.io/router.middlewares"] = middlewares
+	return ing
+}
+
+func getAuthIngress() *networkingv1.Ingress {
+	pathType := networkingv1.PathTypePrefix
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-app-bilrost-proxy",
+			Namespace: "my-ns",
+			Labels:    getBaseLabels(),
+			Annotations: map[string]string{
+				"kubernetes.io/ingress.class":                      "traefik",
+				"traefik.ingress.kubernetes.io/router.entrypoints": "websecure",
+			},
+			OwnerReferences: getBaseOwnerReferences(),
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "my.app.slok.dev",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/oauth2",
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: "my-app-bilrost-proxy",
+											Port: networkingv1.ServiceBackendPort{Name: "http"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func getMiddleware(authResponseHeaders ...interface{}) *unstructured.Unstructured {
+	mw := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "traefik.containo.us/v1alpha1",
+		"kind":       "Middleware",
+		"spec": map[string]interface{}{
+			"forwardAuth": map[string]interface{}{
+				"address":             "http://my-app-bilrost-proxy.my-ns.svc/",
+				"trustForwardHeader":  true,
+				"authResponseHeaders": authResponseHeaders,
+			},
+		},
+	}}
+	mw.SetName("my-app-bilrost-proxy")
+	mw.SetNamespace("my-ns")
+	mw.SetLabels(getBaseLabels())
+	mw.SetOwnerReferences(getBaseOwnerReferences())
+	return mw
+}
+
+func TestOIDCProvisionerProvision(t *testing.T) {
+	tests := map[string]struct {
+		settings  func() proxy.OIDCProxySettings
+		mock      func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner)
+		expStatus *proxy.OIDCProxyStatus
+		expErr    bool
+	}{
+		"An app without Traefik settings should be provisioned by the next provisioner.": {
+			settings: getBaseSettings,
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				mp.On("Provision", mock.Anything, getBaseSettings()).Once().Return(expStatus, nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getMiddlewareIngress("my-ns-other@kubernetescrd"), nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app without Traefik settings that was using the forward auth, should clean the forward auth.": {
+			settings: getBaseSettings,
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getMiddlewareIngress("my-ns-my-app-bilrost-proxy@kubernetescrd"), nil)
+				mk.On("UpdateIngress", mock.Anything, getBaseIngress()).Once().Return(nil)
+				mk.On("DeleteTraefikMiddleware", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app without Traefik settings failing on the next provisioner should fail.": {
+			settings: getBaseSettings,
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"An app with Traefik settings should set the forward auth and provision the proxy in auth only mode.": {
+			settings: getTraefikSettings,
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("EnsureIngress", mock.Anything, getAuthIngress()).Once().Return(nil)
+				mk.On("EnsureTraefikMiddleware", mock.Anything, getMiddleware("X-Auth-Request-User", "X-Auth-Request-Email")).Once().Return(nil)
+				mk.On("UpdateIngress", mock.Anything, getMiddlewareIngress("my-ns-my-app-bilrost-proxy@kubernetescrd")).Once().Return(nil)
+
+				expSettings := getTraefikSettings()
+				expSettings.AuthOnly = true
+				expSettings.App.ProxySettings.Oauth2Proxy = &model.Oauth2ProxySettings{Replicas: 3}
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				mp.On("Provision", mock.Anything, expSettings).Once().Return(expStatus, nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Traefik settings and other middlewares should set the forward auth middleware as the first one.": {
+			settings: func() proxy.OIDCProxySettings {
+				s := getTraefikSettings()
+				s.App.ProxySettings.Traefik.AuthResponseHeaders = []string{"X-Auth-Request-Groups"}
+				return s
+			},
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getMiddlewareIngress("my-ns-other@kubernetescrd, my-ns-my-app-bilrost-proxy@kubernetescrd"), nil)
+				mk.On("EnsureIngress", mock.Anything, getAuthIngress()).Once().Return(nil)
+				mk.On("EnsureTraefikMiddleware", mock.Anything, getMiddleware("X-Auth-Request-Groups")).Once().Return(nil)
+				expIng := getMiddlewareIngress("my-ns-my-app-bilrost-proxy@kubernetescrd,my-ns-other@kubernetescrd")
+				mk.On("UpdateIngress", mock.Anything, expIng).Once().Return(nil)
+
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Traefik settings already with the forward auth middleware, shouldn't update the ingress.": {
+			settings: getTraefikSettings,
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getMiddlewareIngress("my-ns-my-app-bilrost-proxy@kubernetescrd,my-ns-other@kubernetescrd"), nil)
+				mk.On("EnsureIngress", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("EnsureTraefikMiddleware", mock.Anything, mock.Anything).Once().Return(nil)
+
+				expStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(expStatus, nil)
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true},
+		},
+
+		"An app with Traefik settings failing ensuring the middleware should fail.": {
+			settings: getTraefikSettings,
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("EnsureIngress", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("EnsureTraefikMiddleware", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy"},
+			expErr:    true,
+		},
+
+		"An app with Traefik settings failing on the next provisioner should fail.": {
+			settings: getTraefikSettings,
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getMiddlewareIngress("my-ns-my-app-bilrost-proxy@kubernetescrd"), nil)
+				mk.On("EnsureIngress", mock.Anything, mock.Anything).Once().Return(nil)
+				mk.On("EnsureTraefikMiddleware", mock.Anything, mock.Anything).Once().Return(nil)
+				mp.On("Provision", mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("whatever"))
+			},
+			expStatus: &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", IngressPointed: true},
+			expErr:    true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			// Mocks.
+			mk := &traefikmock.KubernetesRepository{}
+			mp := &proxymock.OIDCProvisioner{}
+			test.mock(mk, mp)
+
+			// Prepare.
+			p := traefik.NewOIDCProvisioner(mk, mp, log.Dummy)
+
+			// Execute.
+			gotStatus, err := p.Provision(context.TODO(), test.settings())
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			assert.Equal(test.expStatus, gotStatus)
+			mk.AssertExpectations(t)
+			mp.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOIDCProvisionerUnprovision(t *testing.T) {
+	notFoundErr := &kubeerrors.StatusError{ErrStatus: metav1.Status{Reason: metav1.StatusReasonNotFound}}
+	settings := proxy.UnprovisionSettings{IngressName: "my-app", IngressNamespace: "my-ns"}
+
+	tests := map[string]struct {
+		mock   func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner)
+		expErr bool
+	}{
+		"An app using the forward auth should detach and delete the middleware, delete the auth ingress and unprovision the proxy.": {
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getMiddlewareIngress("my-ns-my-app-bilrost-proxy@kubernetescrd,my-ns-other@kubernetescrd"), nil)
+				mk.On("UpdateIngress", mock.Anything, getMiddlewareIngress("my-ns-other@kubernetescrd")).Once().Return(nil)
+				mk.On("DeleteTraefikMiddleware", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mp.On("Unprovision", mock.Anything, settings).Once().Return(nil)
+			},
+		},
+
+		"An app not using the forward auth should not touch the ingress.": {
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("DeleteTraefikMiddleware", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(notFoundErr)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(notFoundErr)
+				mp.On("Unprovision", mock.Anything, settings).Once().Return(nil)
+			},
+		},
+
+		"Failing detaching the middleware should stop the process.": {
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getMiddlewareIngress("my-ns-my-app-bilrost-proxy@kubernetescrd"), nil)
+				mk.On("UpdateIngress", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"Failing deleting the middleware should stop the process.": {
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("DeleteTraefikMiddleware", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+
+		"Failing unprovisioning the proxy should fail.": {
+			mock: func(mk *traefikmock.KubernetesRepository, mp *proxymock.OIDCProvisioner) {
+				mk.On("GetIngress", mock.Anything, "my-ns", "my-app").Once().Return(getBaseIngress(), nil)
+				mk.On("DeleteTraefikMiddleware", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mk.On("DeleteIngress", mock.Anything, "my-ns", "my-app-bilrost-proxy").Once().Return(nil)
+				mp.On("Unprovision", mock.Anything, settings).Once().Return(fmt.Errorf("whatever"))
+			},
+			expErr: true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			// Mocks.
+			mk := &traefikmock.KubernetesRepository{}
+			mp := &proxymock.OIDCProvisioner{}
+			test.mock(mk, mp)
+
+			// Prepare.
+			p := traefik.NewOIDCProvisioner(mk, mp, log.Dummy)
+
+			// Execute.
+			err := p.Unprovision(context.TODO(), settings)
+
+			// Check.
+			if test.expErr {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+			mk.AssertExpectations(t)
+			mp.AssertExpectations(t)
+		})
+	}
+}
@@ -2,7 +2,11 @@ package security
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
 
 	"github.com/slok/bilrost/internal/authbackend"
 	"github.com/slok/bilrost/internal/backup"
@@ -11,6 +15,10 @@ import (
 	"github.com/slok/bilrost/internal/proxy"
 )
 
+// ErrInvalidSettings is returned (wrapped) when the app security settings are not valid, the app is
+// validated before making any change, so these errors will not be fixed until the settings are fixed.
+var ErrInvalidSettings = errors.New("invalid app security settings")
+
 // AuthBackendRepository knows how to get AuthBackends from a storage.
 type AuthBackendRepository interface {
 	GetAuthBackend(ctx context.Context, id string) (*model.AuthBackend, error)
@@ -20,15 +28,48 @@ type AuthBackendRepository interface {
 
 // KubeServiceTranslator knows how to translate a kubernetes service to a URL.
 type KubeServiceTranslator interface {
-	GetServiceHostAndPort(ctx context.Context, svc model.KubernetesService) (string, int, error)
+	GetServiceEndpoint(ctx context.Context, svc model.KubernetesService) (*ServiceEndpoint, error)
 }
 
 //go:generate mockery -case underscore -output securitymock -outpkg securitymock -name KubeServiceTranslator
 
+// ServiceEndpoint is the internal endpoint of a Kubernetes service port.
+type ServiceEndpoint struct {
+	Host string
+	Port int
+	// Scheme is the scheme of the port based on its app protocol, empty if unknown.
+	Scheme string
+}
+
+// AppSecurityStatus is the status of the security of an app.
+type AppSecurityStatus struct {
+	// BackendRegistered is true when the app has been registered on the auth backend.
+	BackendRegistered bool
+	// ClientID is the ID that identifies the app on the auth backend.
+	ClientID string
+	// BackupStored is true when the original app data has been backed up.
+	BackupStored bool
+	// ProxyProvisioned is true when the auth proxy has been provisioned.
+	ProxyProvisioned bool
+	// ProxyServiceName is the name of the Kubernetes service of the auth proxy.
+	ProxyServiceName string
+	// IngressPointedToProxy is true when the app ingress routes point to the auth proxy.
+	IngressPointedToProxy bool
+	// PreviousAuthBackendID is the auth backend where the app was registered before changing
+	// the auth backend, empty if the auth backend didn't change.
+	PreviousAuthBackendID string
+	// AuthBackendMigrated is true when the app has been unregistered from the previous auth backend.
+	AuthBackendMigrated bool
+}
+
 // Service is the application service where all the security of an application
 // happens.
+//
+// SecureApp returns the security status of the app also when it fails, so the
+// caller knows until which point the app has been secured. If the auth backend of an
+// already secured app changes, SecureApp will migrate the app to the new auth backend.
 type Service interface {
-	SecureApp(ctx context.Context, app model.App) error
+	SecureApp(ctx context.Context, app model.App) (*AppSecurityStatus, error)
 	RollbackAppSecurity(ctx context.Context, app model.App) error
 }
 
@@ -99,46 +140,111 @@ func NewService(cfg ServiceConfig) (Service, error) {
 	}, nil
 }
 
-func (s service) SecureApp(ctx context.Context, app model.App) error {
+func (s service) SecureApp(ctx context.Context, app model.App) (*AppSecurityStatus, error) {
+	status := &AppSecurityStatus{}
+
 	ab, err := s.abRepo.GetAuthBackend(ctx, app.AuthBackendID)
 	if err != nil {
-		return fmt.Errorf("could not retrieve backend information: %w", err)
+		return status, fmt.Errorf("could not retrieve backend information: %w", err)
+	}
+
+	err = validateSecretRotation(*ab, app)
+	if err != nil {
+		return status, fmt.Errorf("%w: invalid secret rotation: %s", ErrInvalidSettings, err)
 	}
 
 	// Get the auth backend to register the app and register.
 	abReg, err := s.abRegFactory.GetAppRegisterer(*ab)
 	if err != nil {
-		return fmt.Errorf("could not get app backend to register the app")
+		return status, fmt.Errorf("could not get app backend to register the app")
+	}
+	// The callback URLs registered on the auth backend and the ones used by the proxy
+	// need to be the same.
+	callbackPath := app.ProxySettings.Session.CallbackPath
+	if callbackPath == "" {
+		callbackPath = proxy.DefaultCallbackPath
+	}
+	err = validateSkipAuthRoutes(app.ProxySettings.SkipAuthRoutes, callbackPath)
+	if err != nil {
+		return status, fmt.Errorf("%w: invalid skip auth routes: %s", ErrInvalidSettings, err)
+	}
+	err = validateIngressControllerSkipAuthRoutes(app)
+	if err != nil {
+		return status, fmt.Errorf("%w: invalid skip auth routes: %s", ErrInvalidSettings, err)
+	}
+	// Validate before registering the app, otherwise we would leave the app registered on the
+	// auth backend.
+	err = validateProxiedRoutes(app)
+	if err != nil {
+		return status, fmt.Errorf("%w: invalid ingress routes: %s", ErrInvalidSettings, err)
+	}
+	hosts := app.Hosts()
+	urls := make([]string, 0, len(hosts))
+	callbackURLs := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		url := fmt.Sprintf("https://%s", host)
+		urls = append(urls, url)
+		callbackURLs = append(callbackURLs, url+callbackPath)
 	}
 	oa := authbackend.OIDCApp{
-		ID:          app.ID,
-		Name:        app.ID,
-		CallBackURL: fmt.Sprintf("https://%s/oauth2/callback", app.Host), // TODO(slok): Configurable based on the proxy.
+		ID:                   app.ID,
+		Name:                 app.ID,
+		CallBackURLs:         callbackURLs,
+		ClientCredentialsKey: app.ClientCredentialsKey,
+		SecretMaxAge:         app.SecretMaxAge,
 	}
 	oaRes, err := abReg.RegisterApp(ctx, oa)
 	if err != nil {
-		return fmt.Errorf("could not register oauth application on backend: %w", err)
+		return status, fmt.Errorf("could not register oauth application on backend: %w", err)
 	}
+	status.BackendRegistered = true
+	status.ClientID = oaRes.ClientID
 
 	// Backup original Ingress service data or load from a previous backup if already there.
 	bkData := &backup.Data{
-		AuthBackendID:         app.AuthBackendID,
-		ServiceName:           app.Ingress.Upstream.Name,
-		ServicePortOrNamePort: app.Ingress.Upstream.PortOrPortName,
+		AuthBackendID: app.AuthBackendID,
+		Routes:        mapRoutesToBackup(app.Ingress.Routes),
+		Annotations:   getAnnotationsToBackup(app.Ingress.Annotations),
 	}
 	bkData, err = s.backupper.BackupOrGet(ctx, app, *bkData)
 	if err != nil {
-		return fmt.Errorf("could not backup or get backup data: %w", err)
+		return status, fmt.Errorf("could not backup or get backup data: %w", err)
 	}
+	status.BackupStored = true
 	if bkData != nil {
-		app.Ingress.Upstream.Name = bkData.ServiceName
-		app.Ingress.Upstream.PortOrPortName = bkData.ServicePortOrNamePort
+		if bkData.AuthBackendID != "" && bkData.AuthBackendID != app.AuthBackendID {
+			status.PreviousAuthBackendID = bkData.AuthBackendID
+		}
+
+		routes, err := restoreRoutesFromBackup(app.Ingress.Routes, bkData.Routes)
+		if err != nil {
+			return status, fmt.Errorf("could not get app routes original upstreams: %w", err)
+		}
+		app.Ingress.Routes = routes
 	}
 
-	// Get Upstream URL.
-	host, port, err := s.svcTranslator.GetServiceHostAndPort(ctx, app.Ingress.Upstream)
-	if err != nil {
-		return fmt.Errorf("could not translate ingress upstream service to host and port: %w", err)
+	// Get Upstream URLs.
+	upstreams := make([]proxy.Upstream, 0, len(app.Ingress.Routes))
+	for _, route := range app.Ingress.Routes {
+		ep, err := s.svcTranslator.GetServiceEndpoint(ctx, route.Upstream)
+		if err != nil {
+			return status, fmt.Errorf("could not translate ingress upstream service to host and port: %w", err)
+		}
+
+		// The app settings have priority over the service port app protocol.
+		scheme := app.ProxySettings.Upstream.Scheme
+		if scheme == "" {
+			scheme = ep.Scheme
+		}
+		if scheme == "" {
+			scheme = "http"
+		}
+
+		upstreams = append(upstreams, proxy.Upstream{
+			Host: route.Host,
+			Path: route.Path,
+			URL:  fmt.Sprintf("%s://%s:%d", scheme, ep.Host, ep.Port),
+		})
 	}
 
 	// Create the proxy.
@@ -146,19 +252,73 @@ func (s service) SecureApp(ctx context.Context, app model.App) error {
 	switch {
 	case ab.Dex != nil:
 		abPublicURL = ab.Dex.PublicURL
+	case ab.OIDCDynamicRegistration != nil:
+		abPublicURL = ab.OIDCDynamicRegistration.IssuerURL
+	case ab.Auth0 != nil:
+		// Auth0 issuer has a trailing slash.
+		abPublicURL = fmt.Sprintf("https://%s/", ab.Auth0.Domain)
+	case ab.Keycloak != nil:
+		abPublicURL = fmt.Sprintf("%s/realms/%s", strings.TrimSuffix(ab.Keycloak.URL, "/"), ab.Keycloak.Realm)
+	case ab.StaticOIDC != nil:
+		abPublicURL = ab.StaticOIDC.IssuerURL
 	}
 	proxySettings := proxy.OIDCProxySettings{
-		URL: fmt.Sprintf("https://%s", app.Host),
-		// TODO(slok): Is always http? https?
-		UpstreamURL:  fmt.Sprintf("http://%s:%d", host, port),
+		URLs:         urls,
+		CallbackPath: callbackPath,
+		Upstreams:    upstreams,
 		IssuerURL:    abPublicURL,
 		ClientID:     oaRes.ClientID,
 		ClientSecret: oaRes.ClientSecret,
 		App:          app,
 	}
-	err = s.proxyProvisioner.Provision(ctx, proxySettings)
+	if bkData != nil {
+		proxySettings.OriginalAnnotations = bkData.Annotations
+	}
+	proxyStatus, err := s.proxyProvisioner.Provision(ctx, proxySettings)
+	if proxyStatus != nil {
+		status.ProxyProvisioned = proxyStatus.Provisioned
+		status.ProxyServiceName = proxyStatus.ServiceName
+		status.IngressPointedToProxy = proxyStatus.IngressPointed
+	}
 	if err != nil {
-		return fmt.Errorf("could not provision OIDC proxy: %w", err)
+		return status, fmt.Errorf("could not provision OIDC proxy: %w", err)
+	}
+
+	// The proxy is already using the new auth backend, now we can unregister the
+	// app from the previous one.
+	if status.PreviousAuthBackendID != "" {
+		err := s.migrateAuthBackend(ctx, app, *bkData)
+		if err != nil {
+			return status, fmt.Errorf("could not migrate app from %q auth backend: %w", status.PreviousAuthBackendID, err)
+		}
+		status.AuthBackendMigrated = true
+	}
+
+	return status, nil
+}
+
+// migrateAuthBackend unregisters the app from the auth backend stored on the backup and sets the
+// current auth backend on the backup, this way a rollback will unregister from the correct one.
+func (s service) migrateAuthBackend(ctx context.Context, app model.App, bkData backup.Data) error {
+	ab, err := s.abRepo.GetAuthBackend(ctx, bkData.AuthBackendID)
+	if err != nil {
+		return fmt.Errorf("could not retrieve previous backend information: %w", err)
+	}
+	abReg, err := s.abRegFactory.GetAppRegisterer(*ab)
+	if err != nil {
+		return fmt.Errorf("could not get previous app backend to unregister the app")
+	}
+	err = abReg.UnregisterApp(ctx, app.ID)
+	if err != nil {
+		return fmt.Errorf("could not unregister oauth application on previous backend: %w", err)
+	}
+
+	s.logger.WithKV(log.KV{"app": app.ID, "from": bkData.AuthBackendID, "to": app.AuthBackendID}).Infof("app migrated between auth backends")
+
+	bkData.AuthBackendID = app.AuthBackendID
+	err = s.backupper.UpdateBackup(ctx, app, bkData)
+	if err != nil {
+		return fmt.Errorf("could not update backup data: %w", err)
 	}
 
 	return nil
@@ -172,10 +332,10 @@ func (s service) RollbackAppSecurity(ctx context.Context, app model.App) error {
 
 	// Uprovision proxy.
 	proxySettings := proxy.UnprovisionSettings{
-		IngressName:                   app.Ingress.Name,
-		IngressNamespace:              app.Ingress.Namespace,
-		OriginalServiceName:           bkData.ServiceName,
-		OriginalServicePortOrNamePort: bkData.ServicePortOrNamePort,
+		IngressName:         app.Ingress.Name,
+		IngressNamespace:    app.Ingress.Namespace,
+		OriginalRoutes:      mapBackupToRoutes(app.Ingress.Namespace, bkData.Routes),
+		OriginalAnnotations: bkData.Annotations,
 	}
 	err = s.proxyProvisioner.Unprovision(ctx, proxySettings)
 	if err != nil {
@@ -204,3 +364,221 @@ func (s service) RollbackAppSecurity(ctx context.Context, app model.App) error {
 
 	return nil
 }
+
+func mapRoutesToBackup(routes []model.IngressRoute) []backup.RouteData {
+	res := make([]backup.RouteData, 0, len(routes))
+	for _, r := range routes {
+		res = append(res, backup.RouteData{
+			Host:                  r.Host,
+			Path:                  r.Path,
+			ServiceName:           r.Upstream.Name,
+			ServicePortOrNamePort: r.Upstream.PortOrPortName,
+		})
+	}
+
+	return res
+}
+
+func mapBackupToRoutes(ns string, routes []backup.RouteData) []model.IngressRoute {
+	res := make([]model.IngressRoute, 0, len(routes))
+	for _, r := range routes {
+		res = append(res, model.IngressRoute{
+			Host: r.Host,
+			Path: r.Path,
+			Upstream: model.KubernetesService{
+				Name:           r.ServiceName,
+				Namespace:      ns,
+				PortOrPortName: r.ServicePortOrNamePort,
+			},
+		})
+	}
+
+	return res
+}
+
+// getAnnotationsToBackup returns the ingress annotations that the proxy provisioners could override.
+func getAnnotationsToBackup(annotations map[string]string) map[string]string {
+	var res map[string]string
+	for _, k := range proxy.IngressAnnotationsToBackup {
+		v, ok := annotations[k]
+		if !ok {
+			continue
+		}
+		if res == nil {
+			res = map[string]string{}
+		}
+		res[k] = v
+	}
+
+	return res
+}
+
+// restoreRoutesFromBackup sets the original upstreams from the backup on the routes, once secured, the
+// routes upstreams will be the proxy so we need the backup to know the original ones.
+//
+// The routes are matched by host and path, we don't allow routes that are not in the backup because
+// these would point to the proxy and would be lost on the rollback.
+func restoreRoutesFromBackup(routes []model.IngressRoute, bkRoutes []backup.RouteData) ([]model.IngressRoute, error) {
+	res := make([]model.IngressRoute, 0, len(routes))
+	for _, r := range routes {
+		found := false
+		for _, bkr := range bkRoutes {
+			if bkr.Host != r.Host || bkr.Path != r.Path {
+				continue
+			}
+
+			found = true
+			r.Upstream.Name = bkr.ServiceName
+			r.Upstream.PortOrPortName = bkr.ServicePortOrNamePort
+			break
+		}
+
+		if !found {
+			return nil, fmt.Errorf("route %q host %q path is not present on the backup, routes can't change once secured, rollback the security first", r.Host, r.Path)
+		}
+
+		res = append(res, r)
+	}
+
+	return res, nil
+}
+
+var skipAuthMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// validateSecretRotation checks the auth backend can rotate the app client secrets, the auth
+// backends that don't generate the client secrets can't rotate them.
+func validateSecretRotation(ab model.AuthBackend, app model.App) error {
+	if ab.SecretMaxAge == 0 && app.SecretMaxAge == 0 {
+		return nil
+	}
+
+	switch {
+	case ab.OIDCDynamicRegistration != nil:
+		return fmt.Errorf("OIDC dynamic registration auth backends can't rotate the client secrets")
+	case ab.StaticOIDC != nil:
+		return fmt.Errorf("static OIDC auth backends can't rotate the client secrets")
+	}
+
+	return nil
+}
+
+// validateSkipAuthRoutes checks the routes that skip the authentication, these can't match the
+// callback path, otherwise the proxy would not handle the sign in flow.
+func validateSkipAuthRoutes(routes []model.SkipAuthRoute, callbackPath string) error {
+	for _, r := range routes {
+		re, err := regexp.Compile(r.PathRegex)
+		if err != nil {
+			return fmt.Errorf("invalid %q path regex: %w", r.PathRegex, err)
+		}
+
+		if re.MatchString(callbackPath) {
+			return fmt.Errorf("%q path regex matches the %q callback path", r.PathRegex, callbackPath)
+		}
+
+		for _, m := range r.Methods {
+			if !skipAuthMethods[m] {
+				return fmt.Errorf("%q path regex has an invalid %q HTTP method", r.PathRegex, m)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateIngressControllerSkipAuthRoutes checks the routes that skip the authentication can be
+// routed by the ingress controller, when the ingress controller authenticates the requests:
+//
+//   - Traefik: the ingress paths don't support regexes, so the routes are not supported.
+//   - nginx: the routes are regex paths of an ingress, so these need to be absolute paths
+//     and can't be restricted to HTTP methods. The route upstream is unknown, so the hosts
+//     need to have a single upstream.
+func validateIngressControllerSkipAuthRoutes(app model.App) error {
+	routes := app.ProxySettings.SkipAuthRoutes
+	if len(routes) == 0 {
+		return nil
+	}
+
+	if app.ProxySettings.Traefik != nil {
+		return fmt.Errorf("not supported by Traefik forward auth, Traefik ingress paths don't support regexes")
+	}
+
+	if app.ProxySettings.Nginx == nil {
+		return nil
+	}
+
+	for _, r := range routes {
+		if !strings.HasPrefix(strings.TrimPrefix(r.PathRegex, "^"), "/") {
+			return fmt.Errorf("%q path regex needs to start with `/` or `^/` on nginx external auth", r.PathRegex)
+		}
+		if len(r.Methods) > 0 {
+			return fmt.Errorf("%q path regex HTTP methods are not supported by nginx external auth", r.PathRegex)
+		}
+	}
+
+	proxyName := fmt.Sprintf("%s-bilrost-proxy", app.Ingress.Name)
+	hostUpstreams := map[string]model.KubernetesService{}
+	for _, r := range app.Ingress.Routes {
+		if r.Upstream.Name == proxyName {
+			continue
+		}
+
+		stored, ok := hostUpstreams[r.Host]
+		if !ok {
+			hostUpstreams[r.Host] = r.Upstream
+			continue
+		}
+		if stored.Name != r.Upstream.Name || stored.PortOrPortName != r.Upstream.PortOrPortName {
+			return fmt.Errorf("host %q has multiple upstreams (%s:%s and %s:%s), not supported by nginx external auth",
+				r.Host, stored.Name, stored.PortOrPortName, r.Upstream.Name, r.Upstream.PortOrPortName)
+		}
+	}
+
+	return nil
+}
+
+// validateProxiedRoutes checks the app routes can be proxied by the proxies in front of the
+// app (oauth2-proxy and Bilrost proxy), these route the requests by path (without host), so
+// the same path can't point to different upstreams on different hosts.
+//
+// The routes already pointing to the proxy are ignored, these have been already validated.
+func validateProxiedRoutes(app model.App) error {
+	ps := app.ProxySettings
+	if ps.Nginx != nil || ps.Traefik != nil || ps.Skipper != nil {
+		return nil
+	}
+
+	proxyName := fmt.Sprintf("%s-bilrost-proxy", app.Ingress.Name)
+	pathUpstreams := map[string]model.KubernetesService{}
+	for _, r := range app.Ingress.Routes {
+		if r.Upstream.Name == proxyName {
+			continue
+		}
+
+		path := r.Path
+		if !strings.HasSuffix(path, "/") {
+			path += "/"
+		}
+
+		stored, ok := pathUpstreams[path]
+		if !ok {
+			pathUpstreams[path] = r.Upstream
+			continue
+		}
+		if stored.Name != r.Upstream.Name || stored.PortOrPortName != r.Upstream.PortOrPortName {
+			return fmt.Errorf("path %q has multiple upstreams (%s:%s and %s:%s), the proxy routes by path without host",
+				path, stored.Name, stored.PortOrPortName, r.Upstream.Name, r.Upstream.PortOrPortName)
+		}
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -29,24 +30,80 @@ type testMocks struct {
 	oidcProxyProv *proxymock.OIDCProvisioner
 }
 
+func getCustomCallbackApp() model.App {
+	app := getMultiRouteApp()
+	app.ProxySettings.Session.CallbackPath = "/auth/callback"
+	return app
+}
+
+func getCustomSchemeApp() model.App {
+	app := getMultiRouteApp()
+	app.ProxySettings.Upstream.Scheme = "h2c"
+	return app
+}
+
+func getMultiRouteApp() model.App {
+	return model.App{
+		ID:            "test-ns/my-app",
+		AuthBackendID: "test-ns-dex-backend",
+		Ingress: model.KubernetesIngress{
+			Name:      "my-app",
+			Namespace: "test-ns",
+			Routes: []model.IngressRoute{
+				{
+					Host:     "my.app.slok.dev",
+					Path:     "/",
+					Upstream: model.KubernetesService{Name: "internal-app", Namespace: "test-ns", PortOrPortName: "http"},
+				},
+				{
+					Host:     "my.app.slok.dev",
+					Path:     "/api",
+					Upstream: model.KubernetesService{Name: "internal-api", Namespace: "test-ns", PortOrPortName: "8080"},
+				},
+				{
+					Host:     "my.app2.slok.dev",
+					Path:     "/",
+					Upstream: model.KubernetesService{Name: "internal-app", Namespace: "test-ns", PortOrPortName: "http"},
+				},
+			},
+		},
+	}
+}
+
+func getSkipAuthApp(routes ...model.SkipAuthRoute) model.App {
+	app := getMultiRouteApp()
+	app.ProxySettings.SkipAuthRoutes = routes
+	return app
+}
+
 func TestSecureApp(t *testing.T) {
 	tests := map[string]struct {
-		app    model.App
-		mock   func(m testMocks)
-		expErr bool
+		app       model.App
+		mock      func(m testMocks)
+		expStatus *security.AppSecurityStatus
+		expErr    bool
+		expErrIs  error
 	}{
 		"A new unsecured app with Dex backend should make all the steps correctly to be secured.": {
 			app: model.App{
 				ID:            "test-ns/my-app",
 				AuthBackendID: "test-ns-dex-backend",
-				Host:          "my.app.slok.dev",
 				Ingress: model.KubernetesIngress{
 					Name:      "my-app",
 					Namespace: "test-ns",
-					Upstream: model.KubernetesService{
-						Name:           "internal-app",
-						Namespace:      "test-ns",
-						PortOrPortName: "http",
+					Annotations: map[string]string{
+						"auth.bilrost.slok.dev/backend":        "test-ns-dex-backend",
+						"nginx.ingress.kubernetes.io/auth-url": "https://auth.my.app.slok.dev",
+					},
+					Routes: []model.IngressRoute{
+						{
+							Host: "my.app.slok.dev",
+							Upstream: model.KubernetesService{
+								Name:           "internal-app",
+								Namespace:      "test-ns",
+								PortOrPortName: "http",
+							},
+						},
 					},
 				},
 			},
@@ -63,9 +120,9 @@ func TestSecureApp(t *testing.T) {
 
 				// The app should be registered.
 				expOIDCApp := authbackend.OIDCApp{
-					ID:          "test-ns/my-app",
-					Name:        "test-ns/my-app",
-					CallBackURL: "https://my.app.slok.dev/oauth2/callback",
+					ID:           "test-ns/my-app",
+					Name:         "test-ns/my-app",
+					CallBackURLs: []string{"https://my.app.slok.dev/oauth2/callback"},
 				}
 				oidcAppReg := &authbackend.OIDCAppRegistryData{
 					ClientID:     "app1",
@@ -75,9 +132,11 @@ func TestSecureApp(t *testing.T) {
 
 				// The original information should be backup up.
 				expData := backup.Data{
-					AuthBackendID:         "test-ns-dex-backend",
-					ServiceName:           "internal-app",
-					ServicePortOrNamePort: "http",
+					AuthBackendID: "test-ns-dex-backend",
+					Routes: []backup.RouteData{
+						{Host: "my.app.slok.dev", ServiceName: "internal-app", ServicePortOrNamePort: "http"},
+					},
+					Annotations: map[string]string{"nginx.ingress.kubernetes.io/auth-url": "https://auth.my.app.slok.dev"},
 				}
 				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, expData).Once().Return(nil, nil)
 
@@ -87,31 +146,49 @@ func TestSecureApp(t *testing.T) {
 					Namespace:      "test-ns",
 					PortOrPortName: "http",
 				}
-				m.svcTranslator.On("GetServiceHostAndPort", mock.Anything, expSvc).Once().Return("internal-app.my-ns.svc.cluster.local", 8080, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, expSvc).Once().Return(&security.ServiceEndpoint{Host: "internal-app.my-ns.svc.cluster.local", Port: 8080}, nil)
 
 				// The proxy should be provisioned.
 				expProxySettings := proxy.OIDCProxySettings{
-					URL:          "https://my.app.slok.dev",
-					UpstreamURL:  "http://internal-app.my-ns.svc.cluster.local:8080",
+					URLs:         []string{"https://my.app.slok.dev"},
+					CallbackPath: "/oauth2/callback",
+					Upstreams:    []proxy.Upstream{{Host: "my.app.slok.dev", URL: "http://internal-app.my-ns.svc.cluster.local:8080"}},
 					IssuerURL:    "https://test-dex.dev",
 					ClientID:     "app1",
 					ClientSecret: "my5cr37",
 					App: model.App{
 						ID:            "test-ns/my-app",
 						AuthBackendID: "test-ns-dex-backend",
-						Host:          "my.app.slok.dev",
 						Ingress: model.KubernetesIngress{
 							Name:      "my-app",
 							Namespace: "test-ns",
-							Upstream: model.KubernetesService{
-								Name:           "internal-app",
-								Namespace:      "test-ns",
-								PortOrPortName: "http",
+							Annotations: map[string]string{
+								"auth.bilrost.slok.dev/backend":        "test-ns-dex-backend",
+								"nginx.ingress.kubernetes.io/auth-url": "https://auth.my.app.slok.dev",
+							},
+							Routes: []model.IngressRoute{
+								{
+									Host: "my.app.slok.dev",
+									Upstream: model.KubernetesService{
+										Name:           "internal-app",
+										Namespace:      "test-ns",
+										PortOrPortName: "http",
+									},
+								},
 							},
 						},
 					},
 				}
-				m.oidcProxyProv.On("Provision", mock.Anything, expProxySettings).Once().Return(nil)
+				proxyStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				m.oidcProxyProv.On("Provision", mock.Anything, expProxySettings).Once().Return(proxyStatus, nil)
+			},
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered:     true,
+				ClientID:              "app1",
+				BackupStored:          true,
+				ProxyProvisioned:      true,
+				ProxyServiceName:      "my-app-bilrost-proxy",
+				IngressPointedToProxy: true,
 			},
 		},
 
@@ -119,14 +196,18 @@ func TestSecureApp(t *testing.T) {
 			app: model.App{
 				ID:            "test-ns/my-app",
 				AuthBackendID: "test-ns-dex-backend",
-				Host:          "my.app.slok.dev",
 				Ingress: model.KubernetesIngress{
 					Name:      "my-app",
 					Namespace: "test-ns",
-					Upstream: model.KubernetesService{
-						Name:           "internal-app-already-secured",
-						Namespace:      "test-ns",
-						PortOrPortName: "80",
+					Routes: []model.IngressRoute{
+						{
+							Host: "my.app.slok.dev",
+							Upstream: model.KubernetesService{
+								Name:           "internal-app-already-secured",
+								Namespace:      "test-ns",
+								PortOrPortName: "80",
+							},
+						},
 					},
 				},
 			},
@@ -143,9 +224,9 @@ func TestSecureApp(t *testing.T) {
 
 				// The app should be registered.
 				expOIDCApp := authbackend.OIDCApp{
-					ID:          "test-ns/my-app",
-					Name:        "test-ns/my-app",
-					CallBackURL: "https://my.app.slok.dev/oauth2/callback",
+					ID:           "test-ns/my-app",
+					Name:         "test-ns/my-app",
+					CallBackURLs: []string{"https://my.app.slok.dev/oauth2/callback"},
 				}
 				oidcAppReg := &authbackend.OIDCAppRegistryData{
 					ClientID:     "app1",
@@ -155,13 +236,15 @@ func TestSecureApp(t *testing.T) {
 
 				// The original information is already there, we return the original upstream.
 				expData := backup.Data{
-					AuthBackendID:         "test-ns-dex-backend",
-					ServiceName:           "internal-app-already-secured",
-					ServicePortOrNamePort: "80",
+					AuthBackendID: "test-ns-dex-backend",
+					Routes: []backup.RouteData{
+						{Host: "my.app.slok.dev", ServiceName: "internal-app-already-secured", ServicePortOrNamePort: "80"},
+					},
 				}
 				storedData := backup.Data{
-					ServiceName:           "internal-app",
-					ServicePortOrNamePort: "http",
+					Routes: []backup.RouteData{
+						{Host: "my.app.slok.dev", ServiceName: "internal-app", ServicePortOrNamePort: "http"},
+					},
 				}
 				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, expData).Once().Return(&storedData, nil)
 
@@ -171,39 +254,411 @@ func TestSecureApp(t *testing.T) {
 					Namespace:      "test-ns",
 					PortOrPortName: "http",
 				}
-				m.svcTranslator.On("GetServiceHostAndPort", mock.Anything, expSvc).Once().Return("internal-app.my-ns.svc.cluster.local", 8080, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, expSvc).Once().Return(&security.ServiceEndpoint{Host: "internal-app.my-ns.svc.cluster.local", Port: 8080}, nil)
 
 				// The proxy should be provisioned.
 				expProxySettings := proxy.OIDCProxySettings{
-					URL:          "https://my.app.slok.dev",
-					UpstreamURL:  "http://internal-app.my-ns.svc.cluster.local:8080",
+					URLs:         []string{"https://my.app.slok.dev"},
+					CallbackPath: "/oauth2/callback",
+					Upstreams:    []proxy.Upstream{{Host: "my.app.slok.dev", URL: "http://internal-app.my-ns.svc.cluster.local:8080"}},
 					IssuerURL:    "https://test-dex.dev",
 					ClientID:     "app1",
 					ClientSecret: "my5cr37",
 					App: model.App{
 						ID:            "test-ns/my-app",
 						AuthBackendID: "test-ns-dex-backend",
-						Host:          "my.app.slok.dev",
 						Ingress: model.KubernetesIngress{
 							Name:      "my-app",
 							Namespace: "test-ns",
-							Upstream: model.KubernetesService{
-								Name:           "internal-app",
-								Namespace:      "test-ns",
-								PortOrPortName: "http",
+							Routes: []model.IngressRoute{
+								{
+									Host: "my.app.slok.dev",
+									Upstream: model.KubernetesService{
+										Name:           "internal-app",
+										Namespace:      "test-ns",
+										PortOrPortName: "http",
+									},
+								},
 							},
 						},
 					},
 				}
-				m.oidcProxyProv.On("Provision", mock.Anything, expProxySettings).Once().Return(nil)
+				proxyStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				m.oidcProxyProv.On("Provision", mock.Anything, expProxySettings).Once().Return(proxyStatus, nil)
+			},
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered:     true,
+				ClientID:              "app1",
+				BackupStored:          true,
+				ProxyProvisioned:      true,
+				ProxyServiceName:      "my-app-bilrost-proxy",
+				IngressPointedToProxy: true,
 			},
 		},
 
+		"An app with multiple hosts and paths should be secured with all the routes.": {
+			app: getMultiRouteApp(),
+			mock: func(m testMocks) {
+				ab := &model.AuthBackend{
+					ID:  "test-dex",
+					Dex: &model.AuthBackendDex{PublicURL: "https://test-dex.dev"},
+				}
+				m.abRepo.On("GetAuthBackend", mock.Anything, "test-ns-dex-backend").Once().Return(ab, nil)
+
+				// The app should be registered with all the hosts.
+				expOIDCApp := authbackend.OIDCApp{
+					ID:   "test-ns/my-app",
+					Name: "test-ns/my-app",
+					CallBackURLs: []string{
+						"https://my.app.slok.dev/oauth2/callback",
+						"https://my.app2.slok.dev/oauth2/callback",
+					},
+				}
+				oidcAppReg := &authbackend.OIDCAppRegistryData{ClientID: "app1", ClientSecret: "my5cr37"}
+				m.abAppReg.On("RegisterApp", mock.Anything, expOIDCApp).Once().Return(oidcAppReg, nil)
+
+				// All the routes should be backup up.
+				expData := backup.Data{
+					AuthBackendID: "test-ns-dex-backend",
+					Routes: []backup.RouteData{
+						{Host: "my.app.slok.dev", Path: "/", ServiceName: "internal-app", ServicePortOrNamePort: "http"},
+						{Host: "my.app.slok.dev", Path: "/api", ServiceName: "internal-api", ServicePortOrNamePort: "8080"},
+						{Host: "my.app2.slok.dev", Path: "/", ServiceName: "internal-app", ServicePortOrNamePort: "http"},
+					},
+				}
+				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, expData).Once().Return(&expData, nil)
+
+				// The services should be translated to URLs.
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, model.KubernetesService{Name: "internal-app", Namespace: "test-ns", PortOrPortName: "http"}).Twice().Return(&security.ServiceEndpoint{Host: "internal-app.test-ns.svc.cluster.local", Port: 80}, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, model.KubernetesService{Name: "internal-api", Namespace: "test-ns", PortOrPortName: "8080"}).Once().Return(&security.ServiceEndpoint{Host: "internal-api.test-ns.svc.cluster.local", Port: 8080}, nil)
+
+				// The proxy should be provisioned with all the routes.
+				expProxySettings := proxy.OIDCProxySettings{
+					URLs:         []string{"https://my.app.slok.dev", "https://my.app2.slok.dev"},
+					CallbackPath: "/oauth2/callback",
+					Upstreams: []proxy.Upstream{
+						{Host: "my.app.slok.dev", Path: "/", URL: "http://internal-app.test-ns.svc.cluster.local:80"},
+						{Host: "my.app.slok.dev", Path: "/api", URL: "http://internal-api.test-ns.svc.cluster.local:8080"},
+						{Host: "my.app2.slok.dev", Path: "/", URL: "http://internal-app.test-ns.svc.cluster.local:80"},
+					},
+					IssuerURL:    "https://test-dex.dev",
+					ClientID:     "app1",
+					ClientSecret: "my5cr37",
+					App:          getMultiRouteApp(),
+				}
+				proxyStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				m.oidcProxyProv.On("Provision", mock.Anything, expProxySettings).Once().Return(proxyStatus, nil)
+			},
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered:     true,
+				ClientID:              "app1",
+				BackupStored:          true,
+				ProxyProvisioned:      true,
+				ProxyServiceName:      "my-app-bilrost-proxy",
+				IngressPointedToProxy: true,
+			},
+		},
+
+		"An app with a custom callback path should be registered and provisioned with the same callback path.": {
+			app: getCustomCallbackApp(),
+			mock: func(m testMocks) {
+				ab := &model.AuthBackend{
+					ID:  "test-dex",
+					Dex: &model.AuthBackendDex{PublicURL: "https://test-dex.dev"},
+				}
+				m.abRepo.On("GetAuthBackend", mock.Anything, "test-ns-dex-backend").Once().Return(ab, nil)
+
+				expOIDCApp := authbackend.OIDCApp{
+					ID:   "test-ns/my-app",
+					Name: "test-ns/my-app",
+					CallBackURLs: []string{
+						"https://my.app.slok.dev/auth/callback",
+						"https://my.app2.slok.dev/auth/callback",
+					},
+				}
+				oidcAppReg := &authbackend.OIDCAppRegistryData{ClientID: "app1", ClientSecret: "my5cr37"}
+				m.abAppReg.On("RegisterApp", mock.Anything, expOIDCApp).Once().Return(oidcAppReg, nil)
+				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, model.KubernetesService{Name: "internal-app", Namespace: "test-ns", PortOrPortName: "http"}).Twice().Return(&security.ServiceEndpoint{Host: "internal-app.test-ns.svc.cluster.local", Port: 80}, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, model.KubernetesService{Name: "internal-api", Namespace: "test-ns", PortOrPortName: "8080"}).Once().Return(&security.ServiceEndpoint{Host: "internal-api.test-ns.svc.cluster.local", Port: 8080}, nil)
+
+				expProxySettings := proxy.OIDCProxySettings{
+					URLs:         []string{"https://my.app.slok.dev", "https://my.app2.slok.dev"},
+					CallbackPath: "/auth/callback",
+					Upstreams: []proxy.Upstream{
+						{Host: "my.app.slok.dev", Path: "/", URL: "http://internal-app.test-ns.svc.cluster.local:80"},
+						{Host: "my.app.slok.dev", Path: "/api", URL: "http://internal-api.test-ns.svc.cluster.local:8080"},
+						{Host: "my.app2.slok.dev", Path: "/", URL: "http://internal-app.test-ns.svc.cluster.local:80"},
+					},
+					IssuerURL:    "https://test-dex.dev",
+					ClientID:     "app1",
+					ClientSecret: "my5cr37",
+					App:          getCustomCallbackApp(),
+				}
+				proxyStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				m.oidcProxyProv.On("Provision", mock.Anything, expProxySettings).Once().Return(proxyStatus, nil)
+			},
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered:     true,
+				ClientID:              "app1",
+				BackupStored:          true,
+				ProxyProvisioned:      true,
+				ProxyServiceName:      "my-app-bilrost-proxy",
+				IngressPointedToProxy: true,
+			},
+		},
+
+		"An app with services with app protocols should use their schemes on the upstreams.": {
+			app: getMultiRouteApp(),
+			mock: func(m testMocks) {
+				ab := &model.AuthBackend{
+					ID:  "test-dex",
+					Dex: &model.AuthBackendDex{PublicURL: "https://test-dex.dev"},
+				}
+				m.abRepo.On("GetAuthBackend", mock.Anything, "test-ns-dex-backend").Once().Return(ab, nil)
+				oidcAppReg := &authbackend.OIDCAppRegistryData{ClientID: "app1", ClientSecret: "my5cr37"}
+				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(oidcAppReg, nil)
+				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, model.KubernetesService{Name: "internal-app", Namespace: "test-ns", PortOrPortName: "http"}).Twice().Return(&security.ServiceEndpoint{Host: "internal-app.test-ns.svc.cluster.local", Port: 443, Scheme: "https"}, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, model.KubernetesService{Name: "internal-api", Namespace: "test-ns", PortOrPortName: "8080"}).Once().Return(&security.ServiceEndpoint{Host: "internal-api.test-ns.svc.cluster.local", Port: 8080}, nil)
+
+				expProxySettings := proxy.OIDCProxySettings{
+					URLs:         []string{"https://my.app.slok.dev", "https://my.app2.slok.dev"},
+					CallbackPath: "/oauth2/callback",
+					Upstreams: []proxy.Upstream{
+						{Host: "my.app.slok.dev", Path: "/", URL: "https://internal-app.test-ns.svc.cluster.local:443"},
+						{Host: "my.app.slok.dev", Path: "/api", URL: "http://internal-api.test-ns.svc.cluster.local:8080"},
+						{Host: "my.app2.slok.dev", Path: "/", URL: "https://internal-app.test-ns.svc.cluster.local:443"},
+					},
+					IssuerURL:    "https://test-dex.dev",
+					ClientID:     "app1",
+					ClientSecret: "my5cr37",
+					App:          getMultiRouteApp(),
+				}
+				proxyStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				m.oidcProxyProv.On("Provision", mock.Anything, expProxySettings).Once().Return(proxyStatus, nil)
+			},
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered:     true,
+				ClientID:              "app1",
+				BackupStored:          true,
+				ProxyProvisioned:      true,
+				ProxyServiceName:      "my-app-bilrost-proxy",
+				IngressPointedToProxy: true,
+			},
+		},
+
+		"An app with an upstream scheme should use it on all the upstreams instead of the service app protocols.": {
+			app: getCustomSchemeApp(),
+			mock: func(m testMocks) {
+				ab := &model.AuthBackend{
+					ID:  "test-dex",
+					Dex: &model.AuthBackendDex{PublicURL: "https://test-dex.dev"},
+				}
+				m.abRepo.On("GetAuthBackend", mock.Anything, "test-ns-dex-backend").Once().Return(ab, nil)
+				oidcAppReg := &authbackend.OIDCAppRegistryData{ClientID: "app1", ClientSecret: "my5cr37"}
+				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(oidcAppReg, nil)
+				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, model.KubernetesService{Name: "internal-app", Namespace: "test-ns", PortOrPortName: "http"}).Twice().Return(&security.ServiceEndpoint{Host: "internal-app.test-ns.svc.cluster.local", Port: 443, Scheme: "https"}, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, model.KubernetesService{Name: "internal-api", Namespace: "test-ns", PortOrPortName: "8080"}).Once().Return(&security.ServiceEndpoint{Host: "internal-api.test-ns.svc.cluster.local", Port: 8080}, nil)
+
+				expProxySettings := proxy.OIDCProxySettings{
+					URLs:         []string{"https://my.app.slok.dev", "https://my.app2.slok.dev"},
+					CallbackPath: "/oauth2/callback",
+					Upstreams: []proxy.Upstream{
+						{Host: "my.app.slok.dev", Path: "/", URL: "h2c://internal-app.test-ns.svc.cluster.local:443"},
+						{Host: "my.app.slok.dev", Path: "/api", URL: "h2c://internal-api.test-ns.svc.cluster.local:8080"},
+						{Host: "my.app2.slok.dev", Path: "/", URL: "h2c://internal-app.test-ns.svc.cluster.local:443"},
+					},
+					IssuerURL:    "https://test-dex.dev",
+					ClientID:     "app1",
+					ClientSecret: "my5cr37",
+					App:          getCustomSchemeApp(),
+				}
+				proxyStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				m.oidcProxyProv.On("Provision", mock.Anything, expProxySettings).Once().Return(proxyStatus, nil)
+			},
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered:     true,
+				ClientID:              "app1",
+				BackupStored:          true,
+				ProxyProvisioned:      true,
+				ProxyServiceName:      "my-app-bilrost-proxy",
+				IngressPointedToProxy: true,
+			},
+		},
+
+		"An already secured app with routes that are not on the backup should fail.": {
+			app: model.App{
+				ID:            "test-ns/my-app",
+				AuthBackendID: "test-ns-dex-backend",
+				Ingress: model.KubernetesIngress{
+					Name:      "my-app",
+					Namespace: "test-ns",
+					Routes: []model.IngressRoute{
+						{Host: "my.app.slok.dev", Path: "/", Upstream: model.KubernetesService{Name: "my-app-bilrost-proxy", PortOrPortName: "http"}},
+						{Host: "my.app.slok.dev", Path: "/new", Upstream: model.KubernetesService{Name: "internal-new", PortOrPortName: "http"}},
+					},
+				},
+			},
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(&authbackend.OIDCAppRegistryData{}, nil)
+				storedData := &backup.Data{
+					Routes: []backup.RouteData{{Host: "my.app.slok.dev", Path: "/", ServiceName: "internal-app", ServicePortOrNamePort: "http"}},
+				}
+				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(storedData, nil)
+			},
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{BackendRegistered: true, BackupStored: true},
+		},
+
+		"An app with the same path pointing to different upstreams on different hosts should fail before registering the app.": {
+			app: func() model.App {
+				app := getMultiRouteApp()
+				app.Ingress.Routes[2].Upstream = model.KubernetesService{Name: "internal-app2", Namespace: "test-ns", PortOrPortName: "http"}
+				return app
+			}(),
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+			},
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
+		"An app with the same path pointing to different upstreams using the ingress controller auth should be secured.": {
+			app: func() model.App {
+				app := getMultiRouteApp()
+				app.Ingress.Routes[2].Upstream = model.KubernetesService{Name: "internal-app2", Namespace: "test-ns", PortOrPortName: "http"}
+				app.ProxySettings.Nginx = &model.NginxProxySettings{}
+				return app
+			}(),
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(&authbackend.OIDCAppRegistryData{}, nil)
+				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, mock.Anything).Times(3).Return(&security.ServiceEndpoint{Host: "internal-app.test-ns.svc.cluster.local", Port: 80}, nil)
+				m.oidcProxyProv.On("Provision", mock.Anything, mock.Anything).Once().Return(&proxy.OIDCProxyStatus{Provisioned: true, IngressPointed: true}, nil)
+			},
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered:     true,
+				BackupStored:          true,
+				ProxyProvisioned:      true,
+				IngressPointedToProxy: true,
+			},
+		},
+
+		"An app with skip auth routes that match the callback path should fail.": {
+			app: getSkipAuthApp(model.SkipAuthRoute{PathRegex: "^/oauth2/"}),
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+			},
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
+		"An app with skip auth routes with invalid path regex should fail.": {
+			app: getSkipAuthApp(model.SkipAuthRoute{PathRegex: "^/public/(.*"}),
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+			},
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
+		"An app with skip auth routes with invalid methods should fail.": {
+			app: getSkipAuthApp(model.SkipAuthRoute{PathRegex: "^/public/", Methods: []string{"GET", "get"}}),
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+			},
+			expErr:    true,
+			expErrIs:  security.ErrInvalidSettings,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
+		"An app with skip auth routes on Traefik forward auth should fail before registering the app.": {
+			app: func() model.App {
+				app := getSkipAuthApp(model.SkipAuthRoute{PathRegex: "^/public/"})
+				app.ProxySettings.Traefik = &model.TraefikProxySettings{}
+				return app
+			}(),
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+			},
+			expErr:    true,
+			expErrIs:  security.ErrInvalidSettings,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
+		"An app with skip auth routes with methods on nginx external auth should fail before registering the app.": {
+			app: func() model.App {
+				app := getSkipAuthApp(model.SkipAuthRoute{PathRegex: "^/public/", Methods: []string{"GET"}})
+				app.Ingress.Routes = app.Ingress.Routes[:1]
+				app.ProxySettings.Nginx = &model.NginxProxySettings{}
+				return app
+			}(),
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+			},
+			expErr:    true,
+			expErrIs:  security.ErrInvalidSettings,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
+		"An app with skip auth routes without absolute paths on nginx external auth should fail before registering the app.": {
+			app: func() model.App {
+				app := getSkipAuthApp(model.SkipAuthRoute{PathRegex: `\.png$`})
+				app.Ingress.Routes = app.Ingress.Routes[:1]
+				app.ProxySettings.Nginx = &model.NginxProxySettings{}
+				return app
+			}(),
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+			},
+			expErr:    true,
+			expErrIs:  security.ErrInvalidSettings,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
+		"An app with skip auth routes on nginx external auth and a host with multiple upstreams should fail before registering the app.": {
+			app: func() model.App {
+				app := getSkipAuthApp(model.SkipAuthRoute{PathRegex: "^/public/"})
+				app.ProxySettings.Nginx = &model.NginxProxySettings{}
+				return app
+			}(),
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
+			},
+			expErr:    true,
+			expErrIs:  security.ErrInvalidSettings,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
+		"An app with a secret rotation policy on an auth backend that can't rotate the secrets should fail before registering the app.": {
+			app: model.App{SecretMaxAge: time.Hour},
+			mock: func(m testMocks) {
+				ab := &model.AuthBackend{StaticOIDC: &model.AuthBackendStaticOIDC{ClientsSecretName: "test"}}
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(ab, nil)
+			},
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
+		"An auth backend with a secret rotation policy that can't rotate the secrets should fail before registering the app.": {
+			mock: func(m testMocks) {
+				ab := &model.AuthBackend{SecretMaxAge: time.Hour, OIDCDynamicRegistration: &model.AuthBackendOIDCDynamicRegistration{IssuerURL: "https://test.dev"}}
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(ab, nil)
+			},
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{},
+		},
+
 		"Failing while getting the auth backend shoult stop the process with failure.": {
 			mock: func(m testMocks) {
 				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("wanted error"))
 			},
-			expErr: true,
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{},
 		},
 
 		"Failing while registering the app on the auth backend should stop the process with failure.": {
@@ -211,7 +666,8 @@ func TestSecureApp(t *testing.T) {
 				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
 				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("wanted error"))
 			},
-			expErr: true,
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{},
 		},
 
 		"Failing while backuping the data should stop the process with failure.": {
@@ -220,28 +676,122 @@ func TestSecureApp(t *testing.T) {
 				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(&authbackend.OIDCAppRegistryData{}, nil)
 				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("wanted error"))
 			},
-			expErr: true,
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{BackendRegistered: true},
 		},
 
 		"Failing while translating the service to a URL should stop the process with failure.": {
+			app: model.App{
+				Ingress: model.KubernetesIngress{Routes: []model.IngressRoute{{}}},
+			},
 			mock: func(m testMocks) {
 				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
 				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(&authbackend.OIDCAppRegistryData{}, nil)
 				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, nil)
-				m.svcTranslator.On("GetServiceHostAndPort", mock.Anything, mock.Anything).Once().Return("", 0, fmt.Errorf("wanted error"))
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, mock.Anything).Once().Return(nil, fmt.Errorf("wanted error"))
 			},
-			expErr: true,
+			expErr:    true,
+			expStatus: &security.AppSecurityStatus{BackendRegistered: true, BackupStored: true},
 		},
 
 		"Failing while provisioning the proxy should stop the process with failure.": {
+			app: model.App{
+				Ingress: model.KubernetesIngress{Routes: []model.IngressRoute{{}}},
+			},
 			mock: func(m testMocks) {
 				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Once().Return(&model.AuthBackend{}, nil)
 				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(&authbackend.OIDCAppRegistryData{}, nil)
 				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(nil, nil)
-				m.svcTranslator.On("GetServiceHostAndPort", mock.Anything, mock.Anything).Once().Return("", 0, nil)
-				m.oidcProxyProv.On("Provision", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, mock.Anything).Once().Return(&security.ServiceEndpoint{}, nil)
+				proxyStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true}
+				m.oidcProxyProv.On("Provision", mock.Anything, mock.Anything).Once().Return(proxyStatus, fmt.Errorf("wanted error"))
+			},
+			expErr: true,
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered: true,
+				BackupStored:      true,
+				ProxyProvisioned:  true,
+				ProxyServiceName:  "my-app-bilrost-proxy",
+			},
+		},
+
+		"An app secured with a different auth backend should be migrated to the new auth backend.": {
+			app: model.App{
+				ID:            "test-ns/my-app",
+				AuthBackendID: "dex-b",
+				Ingress: model.KubernetesIngress{
+					Name:      "my-app",
+					Namespace: "test-ns",
+					Routes: []model.IngressRoute{
+						{Host: "my.app.slok.dev", Upstream: model.KubernetesService{Name: "my-app-bilrost-proxy", Namespace: "test-ns", PortOrPortName: "http"}},
+					},
+				},
+			},
+			mock: func(m testMocks) {
+				// Register on the new backend.
+				abB := &model.AuthBackend{ID: "dex-b", Dex: &model.AuthBackendDex{PublicURL: "https://dex-b.dev"}}
+				m.abRepo.On("GetAuthBackend", mock.Anything, "dex-b").Once().Return(abB, nil)
+				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(&authbackend.OIDCAppRegistryData{ClientID: "app1"}, nil)
+
+				// The backup has the previous backend.
+				storedData := &backup.Data{
+					AuthBackendID: "dex-a",
+					Routes:        []backup.RouteData{{Host: "my.app.slok.dev", ServiceName: "internal-app", ServicePortOrNamePort: "http"}},
+				}
+				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(storedData, nil)
+
+				// The proxy is provisioned with the new backend.
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, mock.Anything).Once().Return(&security.ServiceEndpoint{Host: "internal-app.test-ns.svc.cluster.local", Port: 8080}, nil)
+				proxyStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				m.oidcProxyProv.On("Provision", mock.Anything, mock.MatchedBy(func(s proxy.OIDCProxySettings) bool {
+					return s.IssuerURL == "https://dex-b.dev"
+				})).Once().Return(proxyStatus, nil)
+
+				// Unregister from the previous backend and update the backup.
+				abA := &model.AuthBackend{ID: "dex-a", Dex: &model.AuthBackendDex{PublicURL: "https://dex-a.dev"}}
+				m.abRepo.On("GetAuthBackend", mock.Anything, "dex-a").Once().Return(abA, nil)
+				m.abAppReg.On("UnregisterApp", mock.Anything, "test-ns/my-app").Once().Return(nil)
+				expData := backup.Data{
+					AuthBackendID: "dex-b",
+					Routes:        []backup.RouteData{{Host: "my.app.slok.dev", ServiceName: "internal-app", ServicePortOrNamePort: "http"}},
+				}
+				m.backupper.On("UpdateBackup", mock.Anything, mock.Anything, expData).Once().Return(nil)
+			},
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered:     true,
+				ClientID:              "app1",
+				BackupStored:          true,
+				ProxyProvisioned:      true,
+				ProxyServiceName:      "my-app-bilrost-proxy",
+				IngressPointedToProxy: true,
+				PreviousAuthBackendID: "dex-a",
+				AuthBackendMigrated:   true,
+			},
+		},
+
+		"Failing while unregistering from the previous auth backend should stop the process with failure.": {
+			app: model.App{
+				AuthBackendID: "dex-b",
+				Ingress:       model.KubernetesIngress{Routes: []model.IngressRoute{{}}},
+			},
+			mock: func(m testMocks) {
+				m.abRepo.On("GetAuthBackend", mock.Anything, mock.Anything).Return(&model.AuthBackend{}, nil)
+				m.abAppReg.On("RegisterApp", mock.Anything, mock.Anything).Once().Return(&authbackend.OIDCAppRegistryData{}, nil)
+				m.backupper.On("BackupOrGet", mock.Anything, mock.Anything, mock.Anything).Once().Return(&backup.Data{AuthBackendID: "dex-a", Routes: []backup.RouteData{{}}}, nil)
+				m.svcTranslator.On("GetServiceEndpoint", mock.Anything, mock.Anything).Once().Return(&security.ServiceEndpoint{}, nil)
+				proxyStatus := &proxy.OIDCProxyStatus{ServiceName: "my-app-bilrost-proxy", Provisioned: true, IngressPointed: true}
+				m.oidcProxyProv.On("Provision", mock.Anything, mock.Anything).Once().Return(proxyStatus, nil)
+				m.abAppReg.On("UnregisterApp", mock.Anything, mock.Anything).Once().Return(fmt.Errorf("wanted error"))
 			},
 			expErr: true,
+			expStatus: &security.AppSecurityStatus{
+				BackendRegistered:     true,
+				BackupStored:          true,
+				ProxyProvisioned:      true,
+				ProxyServiceName:      "my-app-bilrost-proxy",
+				IngressPointedToProxy: true,
+				PreviousAuthBackendID: "dex-a",
+			},
 		},
 	}
 
@@ -273,11 +823,15 @@ func TestSecureApp(t *testing.T) {
 			svc, err := security.NewService(cfg)
 			require.NoError(err)
 
-			err = svc.SecureApp(context.TODO(), test.app)
+			gotStatus, err := svc.SecureApp(context.TODO(), test.app)
 
 			// check
+			assert.Equal(test.expStatus, gotStatus)
 			if test.expErr {
 				assert.Error(err)
+				if test.expErrIs != nil {
+					assert.ErrorIs(err, test.expErrIs)
+				}
 			} else if assert.NoError(err) {
 				m.abRepo.AssertExpectations(t)
 				m.abAppReg.AssertExpectations(t)
@@ -300,32 +854,47 @@ func TestRollbackAppSecurity(t *testing.T) {
 			app: model.App{
 				ID:            "test-ns/my-app",
 				AuthBackendID: "",
-				Host:          "my.app.slok.dev",
 				Ingress: model.KubernetesIngress{
 					Name:      "my-app",
 					Namespace: "test-ns",
-					Upstream: model.KubernetesService{
-						Name:           "internal-app",
-						Namespace:      "test-ns",
-						PortOrPortName: "http",
+					Routes: []model.IngressRoute{
+						{
+							Host: "my.app.slok.dev",
+							Upstream: model.KubernetesService{
+								Name:           "internal-app",
+								Namespace:      "test-ns",
+								PortOrPortName: "http",
+							},
+						},
 					},
 				},
 			},
 			mock: func(m testMocks) {
 				// Get original information.
 				expData := &backup.Data{
-					AuthBackendID:         "test-ns-dex-backend",
-					ServiceName:           "internal-orig-app",
-					ServicePortOrNamePort: "http-orig",
+					AuthBackendID: "test-ns-dex-backend",
+					Routes: []backup.RouteData{
+						{Host: "my.app.slok.dev", ServiceName: "internal-orig-app", ServicePortOrNamePort: "http-orig"},
+					},
+					Annotations: map[string]string{"nginx.ingress.kubernetes.io/auth-url": "https://auth.my.app.slok.dev"},
 				}
 				m.backupper.On("GetBackup", mock.Anything, mock.Anything).Once().Return(expData, nil)
 
 				// The proxy should be removed.
 				expProxySettings := proxy.UnprovisionSettings{
-					IngressName:                   "my-app",
-					IngressNamespace:              "test-ns",
-					OriginalServiceName:           "internal-orig-app",
-					OriginalServicePortOrNamePort: "http-orig",
+					IngressName:      "my-app",
+					IngressNamespace: "test-ns",
+					OriginalRoutes: []model.IngressRoute{
+						{
+							Host: "my.app.slok.dev",
+							Upstream: model.KubernetesService{
+								Name:           "internal-orig-app",
+								Namespace:      "test-ns",
+								PortOrPortName: "http-orig",
+							},
+						},
+					},
+					OriginalAnnotations: map[string]string{"nginx.ingress.kubernetes.io/auth-url": "https://auth.my.app.slok.dev"},
 				}
 				m.oidcProxyProv.On("Unprovision", mock.Anything, expProxySettings).Once().Return(nil)
 
@@ -7,6 +7,8 @@ import (
 
 	model "github.com/slok/bilrost/internal/model"
 	mock "github.com/stretchr/testify/mock"
+
+	security "github.com/slok/bilrost/internal/security"
 )
 
 // KubeServiceTranslator is an autogenerated mock type for the KubeServiceTranslator type
@@ -14,30 +16,25 @@ type KubeServiceTranslator struct {
 	mock.Mock
 }
 
-// GetServiceHostAndPort provides a mock function with given fields: ctx, svc
-func (_m *KubeServiceTranslator) GetServiceHostAndPort(ctx context.Context, svc model.KubernetesService) (string, int, error) {
+// GetServiceEndpoint provides a mock function with given fields: ctx, svc
+func (_m *KubeServiceTranslator) GetServiceEndpoint(ctx context.Context, svc model.KubernetesService) (*security.ServiceEndpoint, error) {
 	ret := _m.Called(ctx, svc)
 
-	var r0 string
-	if rf, ok := ret.Get(0).(func(context.Context, model.KubernetesService) string); ok {
+	var r0 *security.ServiceEndpoint
+	if rf, ok := ret.Get(0).(func(context.Context, model.KubernetesService) *security.ServiceEndpoint); ok {
 		r0 = rf(ctx, svc)
 	} else {
-		r0 = ret.Get(0).(string)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*security.ServiceEndpoint)
+		}
 	}
 
-	var r1 int
-	if rf, ok := ret.Get(1).(func(context.Context, model.KubernetesService) int); ok {
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.KubernetesService) error); ok {
 		r1 = rf(ctx, svc)
 	} else {
-		r1 = ret.Get(1).(int)
-	}
-
-	var r2 error
-	if rf, ok := ret.Get(2).(func(context.Context, model.KubernetesService) error); ok {
-		r2 = rf(ctx, svc)
-	} else {
-		r2 = ret.Error(2)
+		r1 = ret.Error(1)
 	}
 
-	return r0, r1, r2
+	return r0, r1
 }
@@ -7,6 +7,8 @@ import (
 
 	model "github.com/slok/bilrost/internal/model"
 	mock "github.com/stretchr/testify/mock"
+
+	security "github.com/slok/bilrost/internal/security"
 )
 
 // Service is an autogenerated mock type for the Service type
@@ -29,15 +31,24 @@ func (_m *Service) RollbackAppSecurity(ctx context.Context, app model.App) error
 }
 
 // SecureApp provides a mock function with given fields: ctx, app
-func (_m *Service) SecureApp(ctx context.Context, app model.App) error {
+func (_m *Service) SecureApp(ctx context.Context, app model.App) (*security.AppSecurityStatus, error) {
 	ret := _m.Called(ctx, app)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.App) error); ok {
+	var r0 *security.AppSecurityStatus
+	if rf, ok := ret.Get(0).(func(context.Context, model.App) *security.AppSecurityStatus); ok {
 		r0 = rf(ctx, app)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*security.AppSecurityStatus)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.App) error); ok {
+		r1 = rf(ctx, app)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
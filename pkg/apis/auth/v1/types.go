@@ -11,6 +11,8 @@ import (
 // +genclient:nonNamespaced
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.conditions[?(@.type=='Ready')].status"
+// +kubebuilder:printcolumn:name="LAST-ERROR",type="string",JSONPath=".status.lastError",priority=1
 // +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
 // +kubebuilder:resource:singular=authbackend,path=authbackends,shortName=ab,scope=Cluster,categories=auth;bilrost
 type AuthBackend struct {
@@ -24,21 +26,144 @@ type AuthBackend struct {
 // AuthBackendSpec is the spec of an auth backend.
 type AuthBackendSpec struct {
 	AuthBackendSource `json:",inline"`
+	// SecretRotation is the client secrets rotation policy of the apps registered on the
+	// auth backend, if missing the secrets will not be rotated. Not supported by the OIDC
+	// dynamic registration and static OIDC auth backends.
+	// +optional
+	SecretRotation *SecretRotation `json:"secretRotation,omitempty"`
 }
 
 // AuthBackendSource has the configuration of the auth backends.
 type AuthBackendSource struct {
-	Dex *AuthBackendDex `json:"dex,omitempty"`
+	Dex                     *AuthBackendDex                     `json:"dex,omitempty"`
+	OIDCDynamicRegistration *AuthBackendOIDCDynamicRegistration `json:"oidcDynamicRegistration,omitempty"`
+	Auth0                   *AuthBackendAuth0                   `json:"auth0,omitempty"`
+	Keycloak                *AuthBackendKeycloak                `json:"keycloak,omitempty"`
+	StaticOIDC              *AuthBackendStaticOIDC              `json:"staticOIDC,omitempty"`
 }
 
 // AuthBackendDex is the spec for a Dex based auth backend.
 type AuthBackendDex struct {
 	PublicURL  string `json:"publicURL"`
 	APIAddress string `json:"apiAddress"`
+	// TLS is the TLS configuration used to connect to the Dex API, if missing the
+	// connection will be insecure (plain text).
+	// +optional
+	TLS *AuthBackendDexTLS `json:"tls,omitempty"`
+}
+
+// AuthBackendDexTLS is the TLS configuration used to connect to the Dex API.
+type AuthBackendDexTLS struct {
+	// CASecretRef is the reference to the secret key that has the CA bundle (PEM) used to
+	// verify the Dex API server certificate, if missing the system CAs will be used.
+	// +optional
+	CASecretRef *SecretKeyRef `json:"caSecretRef,omitempty"`
+	// ClientCertSecretRef is the reference to the secret that has the client certificate
+	// and key on the `tls.crt` and `tls.key` keys (e.g: `kubernetes.io/tls` secret), used for
+	// mutual TLS.
+	// +optional
+	ClientCertSecretRef *SecretRef `json:"clientCertSecretRef,omitempty"`
+	// ServerName is the name used to verify the Dex API server certificate, by default the
+	// host of the API address.
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// AuthBackendOIDCDynamicRegistration is the spec for an OIDC auth backend that supports
+// dynamic client registration (RFC 7591 and RFC 7592).
+type AuthBackendOIDCDynamicRegistration struct {
+	// IssuerURL is the OIDC issuer URL.
+	IssuerURL string `json:"issuerURL"`
+	// RegistrationEndpoint is the client registration endpoint, if missing it will be
+	// discovered using the issuer OIDC discovery.
+	// +optional
+	RegistrationEndpoint string `json:"registrationEndpoint,omitempty"`
+	// InitialAccessTokenSecretRef is the reference to the secret key that has the initial
+	// access token required by the auth backend to register clients (if required).
+	// +optional
+	InitialAccessTokenSecretRef *SecretKeyRef `json:"initialAccessTokenSecretRef,omitempty"`
+}
+
+// AuthBackendAuth0 is the spec for an Auth0 based auth backend.
+type AuthBackendAuth0 struct {
+	// Domain is the Auth0 tenant domain (e.g: my-tenant.eu.auth0.com).
+	Domain string `json:"domain"`
+	// ManagementCredentialsSecretRef is the reference to the secret that has the Auth0
+	// Management API machine to machine application credentials on the `clientID` and
+	// `clientSecret` keys.
+	ManagementCredentialsSecretRef SecretRef `json:"managementCredentialsSecretRef"`
+}
+
+// AuthBackendKeycloak is the spec for a Keycloak based auth backend.
+type AuthBackendKeycloak struct {
+	// URL is the Keycloak base URL (e.g: https://keycloak.my.cluster.slok.dev/auth).
+	URL string `json:"url"`
+	// Realm is the realm where the apps will be registered as clients.
+	Realm string `json:"realm"`
+	// AdminRealm is the realm used to authenticate on the admin REST API, by default the
+	// same as Realm.
+	// +optional
+	AdminRealm string `json:"adminRealm,omitempty"`
+	// AdminCredentialsSecretRef is the reference to the secret that has the admin REST API
+	// credentials, a user on the `username` and `password` keys, or a service account client
+	// on the `clientID` and `clientSecret` keys.
+	AdminCredentialsSecretRef SecretRef `json:"adminCredentialsSecretRef"`
+	// GroupsMapper will add a groups mapper to the clients, so the user groups are on the
+	// `groups` claim.
+	// +optional
+	GroupsMapper bool `json:"groupsMapper,omitempty"`
+}
+
+// AuthBackendStaticOIDC is the spec for an OIDC auth backend where the clients are
+// pre-provisioned (not registered by Bilrost).
+type AuthBackendStaticOIDC struct {
+	// IssuerURL is the OIDC issuer URL.
+	IssuerURL string `json:"issuerURL"`
+	// ClientsSecretRef is the reference to the secret that has the pre-provisioned clients
+	// credentials, for each app the `{key}.clientID`, `{key}.clientSecret` and `{key}.redirectURLs`
+	// (comma separated) keys. By default the key of an app is `{namespace}_{ingress-name}`, it can
+	// be customized with the IngressAuth `clientCredentialsKey` auth setting.
+	ClientsSecretRef SecretRef `json:"clientsSecretRef"`
+}
+
+// SecretRotation is a client secret rotation policy.
+type SecretRotation struct {
+	// MaxAge is the maximum age of a client secret, once expired it will be rotated
+	// (e.g: `2160h` for 90 days).
+	MaxAge metav1.Duration `json:"maxAge"`
+}
+
+// SecretRef is a reference to a Kubernetes secret.
+type SecretRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// SecretKeyRef is a reference to a key of a Kubernetes secret.
+type SecretKeyRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
 }
 
 // AuthBackendStatus is the auth backend status.
-type AuthBackendStatus struct{}
+type AuthBackendStatus struct {
+	// ObservedGeneration is the generation of the auth backend that has been used
+	// on the last app registration.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the conditions of the auth backend, based on the registrations of all its apps.
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastError is the error of the apps that could not be registered on the auth backend.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// AuthBackend status condition types.
+const (
+	// AuthBackendConditionReady is true when the last app registration on the auth backend succeeded.
+	AuthBackendConditionReady = "Ready"
+)
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
@@ -55,6 +180,10 @@ type AuthBackendList struct {
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="READY",type="string",JSONPath=".status.conditions[?(@.type=='Ready')].status"
+// +kubebuilder:printcolumn:name="CLIENT-ID",type="string",JSONPath=".status.clientID"
+// +kubebuilder:printcolumn:name="PROXY-SERVICE",type="string",JSONPath=".status.proxyServiceName"
+// +kubebuilder:printcolumn:name="LAST-ERROR",type="string",JSONPath=".status.lastError",priority=1
 // +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
 // +kubebuilder:resource:singular=ingressauth,path=ingressauths,shortName=ia,scope=Namespaced,categories=auth;bilrost
 type IngressAuth struct {
@@ -67,18 +196,159 @@ type IngressAuth struct {
 
 // IngressAuthSpec is the spec of an auth backend.
 type IngressAuthSpec struct {
-	AuthSettings    AuthSettings `json:"authSettings,omitempty"`
-	AuthProxySource `json:",inline"`
+	AuthSettings AuthSettings `json:"authSettings,omitempty"`
+	// SessionSettings are the settings of the user sessions on the auth proxy.
+	// +optional
+	SessionSettings SessionSettings `json:"sessionSettings,omitempty"`
+	// UpstreamSettings are the settings used by the auth proxy to connect to the app.
+	// +optional
+	UpstreamSettings UpstreamSettings `json:"upstreamSettings,omitempty"`
+	AuthProxySource  `json:",inline"`
 }
 
 // AuthProxySource has the auth proxies configuration.
 type AuthProxySource struct {
 	Oauth2Proxy *Oauth2ProxyAuthProxySource `json:"oauth2Proxy,omitempty"`
+	// BilrostProxy uses the Bilrost OIDC proxy instead of oauth2-proxy.
+	// +optional
+	BilrostProxy *BilrostProxyAuthProxySource `json:"bilrostProxy,omitempty"`
+	// Nginx uses the nginx ingress controller external auth instead of routing the app
+	// traffic through the proxy.
+	// +optional
+	Nginx *NginxAuthProxySource `json:"nginx,omitempty"`
+	// Traefik uses a Traefik forward auth middleware instead of routing the app traffic
+	// through the proxy.
+	// +optional
+	Traefik *TraefikAuthProxySource `json:"traefik,omitempty"`
+	// Skipper uses the Skipper OIDC filters to authenticate, without a proxy.
+	// WARNING: Skipper filters don't support secret references, the OIDC client secret is
+	// set in plain text on the ingress filters annotation, readable by anyone that can read
+	// the ingress.
+	// +optional
+	Skipper *SkipperAuthProxySource `json:"skipper,omitempty"`
 }
 
 // AuthSettings are the Oauth2 and/or OIDC settings.
 type AuthSettings struct {
 	ScopeOrClaims []string `json:"scopeOrClaims,omitempty"`
+	// ClientCredentialsKey is the key used to get the pre-provisioned client credentials of
+	// the app on auth backends that don't register clients (e.g: static OIDC).
+	// +optional
+	ClientCredentialsKey string `json:"clientCredentialsKey,omitempty"`
+	// SecretRotation is the client secret rotation policy of the app, overrides the auth
+	// backend policy. Not supported by the OIDC dynamic registration and static OIDC auth
+	// backends.
+	// +optional
+	SecretRotation *SecretRotation `json:"secretRotation,omitempty"`
+	// AllowedGroups are the groups allowed to access the app, the user needs to be
+	// in any of them.
+	// +optional
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
+	// AllowedEmails are the emails of the users allowed to access the app.
+	// +optional
+	AllowedEmails []string `json:"allowedEmails,omitempty"`
+	// AllowedEmailDomains are the email domains of the users allowed to access the app,
+	// the users with an allowed email or an email of an allowed domain can access.
+	// +optional
+	AllowedEmailDomains []string `json:"allowedEmailDomains,omitempty"`
+	// RequiredClaims are the ID token claims, and their values, that the user needs
+	// to access the app.
+	// +optional
+	RequiredClaims map[string]string `json:"requiredClaims,omitempty"`
+	// SkipAuthRoutes are the app routes that don't require authentication (e.g: health
+	// checks or webhooks), they can't match the callback path.
+	// +optional
+	SkipAuthRoutes []SkipAuthRoute `json:"skipAuthRoutes,omitempty"`
+}
+
+// SkipAuthRoute is an app route that doesn't require authentication.
+type SkipAuthRoute struct {
+	// Path is the regex that matches the request paths (e.g: `^/healthz$`).
+	Path string `json:"path"`
+	// Methods are the HTTP methods of the requests, if missing, any method.
+	// +optional
+	Methods []string `json:"methods,omitempty"`
+}
+
+// SessionSettings are the settings of the user sessions on the auth proxy.
+type SessionSettings struct {
+	// CallbackPath is the path of the OIDC callback on the app hosts, the callback URLs
+	// registered on the auth backend use it (by default `/oauth2/callback`).
+	// +kubebuilder:validation:Pattern=`^/`
+	// +optional
+	CallbackPath string `json:"callbackPath,omitempty"`
+	// Cookie has the settings of the session cookie.
+	// +optional
+	Cookie SessionCookie `json:"cookie,omitempty"`
+	// Store is where the sessions are stored (by default `cookie`).
+	// +kubebuilder:validation:Enum=cookie;redis
+	// +optional
+	Store string `json:"store,omitempty"`
+	// Redis has the settings of the `redis` session store.
+	// +optional
+	Redis *SessionRedis `json:"redis,omitempty"`
+}
+
+// SessionRedis has the settings of the Redis session store.
+type SessionRedis struct {
+	// Address is the address (`host:port`) of an existing Redis, if missing, a Redis
+	// will be deployed with the proxy.
+	// +optional
+	Address string `json:"address,omitempty"`
+	// PasswordSecretRef is the reference to the secret key, on the IngressAuth namespace,
+	// that has the password of the existing Redis.
+	// +optional
+	PasswordSecretRef *LocalSecretKeyRef `json:"passwordSecretRef,omitempty"`
+	// Image is the image of the deployed Redis.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// LocalSecretKeyRef is a reference to a key of a Kubernetes secret on the same namespace.
+type LocalSecretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// SessionCookie has the settings of the session cookie.
+type SessionCookie struct {
+	// Name is the name of the cookie (by default the proxy one).
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Domain is the domain of the cookie (by default the request host).
+	// +optional
+	Domain string `json:"domain,omitempty"`
+	// Expire is the max age of the session, after it the user needs to sign in
+	// again (e.g: `168h`).
+	// +optional
+	Expire *metav1.Duration `json:"expire,omitempty"`
+	// Refresh is the duration after the session tokens are refreshed, if missing, they are
+	// only refreshed when they expire (e.g: `1h`).
+	// +optional
+	Refresh *metav1.Duration `json:"refresh,omitempty"`
+	// SameSite is the SameSite attribute of the cookie.
+	// +kubebuilder:validation:Enum=lax;strict;none
+	// +optional
+	SameSite string `json:"sameSite,omitempty"`
+	// Secure sets the secure flag of the cookie, so it's only sent over HTTPS (by default true).
+	// +optional
+	Secure *bool `json:"secure,omitempty"`
+}
+
+// UpstreamSettings are the settings used by the auth proxy to connect to the app.
+type UpstreamSettings struct {
+	// Scheme is the scheme used to connect to the app Services, by default based on the
+	// Service port `appProtocol` (`http` if missing).
+	// +kubebuilder:validation:Enum=http;https;h2c
+	// +optional
+	Scheme string `json:"scheme,omitempty"`
+	// CABundle is the PEM encoded CA bundle used to verify the app certificates (`https`),
+	// by default the system CAs.
+	// +optional
+	CABundle string `json:"caBundle,omitempty"`
+	// InsecureSkipVerify disables the verification of the app certificates (`https`).
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
 }
 
 // Oauth2ProxyAuthProxySource has the configuration of an oauth2proxy.
@@ -86,6 +356,51 @@ type Oauth2ProxyAuthProxySource struct {
 	CommonProxySettings `json:",inline"`
 }
 
+// BilrostProxyAuthProxySource has the configuration of the Bilrost OIDC proxy.
+type BilrostProxyAuthProxySource struct {
+	CommonProxySettings `json:",inline"`
+	// PassAccessToken passes the OIDC access token to the app on the
+	// `X-Auth-Request-Access-Token` header.
+	// +optional
+	PassAccessToken bool `json:"passAccessToken,omitempty"`
+}
+
+// NginxAuthProxySource has the configuration of the nginx ingress controller external auth,
+// the authentication is made by an oauth2-proxy.
+type NginxAuthProxySource struct {
+	CommonProxySettings `json:",inline"`
+	// ResponseHeaders are the headers of the auth response that will be passed to the app
+	// (by default `X-Auth-Request-User` and `X-Auth-Request-Email`).
+	// +optional
+	ResponseHeaders []string `json:"responseHeaders,omitempty"`
+}
+
+// TraefikAuthProxySource has the configuration of the Traefik forward auth, the authentication
+// is made by an oauth2-proxy.
+type TraefikAuthProxySource struct {
+	CommonProxySettings `json:",inline"`
+	// AuthResponseHeaders are the headers of the auth response that will be passed to the app
+	// (by default `X-Auth-Request-User` and `X-Auth-Request-Email`).
+	// +optional
+	AuthResponseHeaders []string `json:"authResponseHeaders,omitempty"`
+}
+
+// SkipperAuthProxySource has the configuration of the Skipper OIDC filters.
+type SkipperAuthProxySource struct {
+	// Claims are the ID token claims that the user requires, any of them is enough
+	// (by default `sub`, any authenticated user).
+	// +optional
+	Claims []string `json:"claims,omitempty"`
+	// UserInfoFields are the user info fields that the user requires, if set, the user
+	// info will be checked instead of the claims.
+	// +optional
+	UserInfoFields []string `json:"userInfoFields,omitempty"`
+	// UpstreamHeaders are the headers that will be passed to the app, using the Skipper
+	// format (e.g: `X-Auth-Request-Email:claims.email`).
+	// +optional
+	UpstreamHeaders []string `json:"upstreamHeaders,omitempty"`
+}
+
 // CommonProxySettings are settings that all proxies will have.
 type CommonProxySettings struct {
 	Image     string                       `json:"image,omitempty"`
@@ -94,7 +409,32 @@ type CommonProxySettings struct {
 }
 
 // IngressAuthStatus is the ingress auth status.
-type IngressAuthStatus struct{}
+type IngressAuthStatus struct {
+	// ObservedGeneration is the generation of the ingress auth that has been reconciled.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the conditions of the ingress security.
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// ClientID is the ID of the app registered on the auth backend.
+	ClientID string `json:"clientID,omitempty"`
+	// ProxyServiceName is the name of the Kubernetes service of the auth proxy.
+	ProxyServiceName string `json:"proxyServiceName,omitempty"`
+	// LastError is the last error securing the ingress.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// IngressAuth status condition types.
+const (
+	// IngressAuthConditionReady is true when the ingress has been secured.
+	IngressAuthConditionReady = "Ready"
+	// IngressAuthConditionBackendRegistered is true when the app has been registered on the auth backend.
+	IngressAuthConditionBackendRegistered = "BackendRegistered"
+	// IngressAuthConditionProxyProvisioned is true when the auth proxy has been provisioned.
+	IngressAuthConditionProxyProvisioned = "ProxyProvisioned"
+	// IngressAuthConditionIngressPointedToProxy is true when the ingress routes point to the auth proxy.
+	IngressAuthConditionIngressPointedToProxy = "IngressPointedToProxy"
+)
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
@@ -7,6 +7,7 @@ package v1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -16,7 +17,7 @@ func (in *AuthBackend) DeepCopyInto(out *AuthBackend) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -38,9 +39,31 @@ func (in *AuthBackend) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuthBackendAuth0) DeepCopyInto(out *AuthBackendAuth0) {
+	*out = *in
+	out.ManagementCredentialsSecretRef = in.ManagementCredentialsSecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuthBackendAuth0.
+func (in *AuthBackendAuth0) DeepCopy() *AuthBackendAuth0 {
+	if in == nil {
+		return nil
+	}
+	out := new(AuthBackendAuth0)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AuthBackendDex) DeepCopyInto(out *AuthBackendDex) {
 	*out = *in
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(AuthBackendDexTLS)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -54,6 +77,49 @@ func (in *AuthBackendDex) DeepCopy() *AuthBackendDex {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuthBackendDexTLS) DeepCopyInto(out *AuthBackendDexTLS) {
+	*out = *in
+	if in.CASecretRef != nil {
+		in, out := &in.CASecretRef, &out.CASecretRef
+		*out = new(SecretKeyRef)
+		**out = **in
+	}
+	if in.ClientCertSecretRef != nil {
+		in, out := &in.ClientCertSecretRef, &out.ClientCertSecretRef
+		*out = new(SecretRef)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuthBackendDexTLS.
+func (in *AuthBackendDexTLS) DeepCopy() *AuthBackendDexTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(AuthBackendDexTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuthBackendKeycloak) DeepCopyInto(out *AuthBackendKeycloak) {
+	*out = *in
+	out.AdminCredentialsSecretRef = in.AdminCredentialsSecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuthBackendKeycloak.
+func (in *AuthBackendKeycloak) DeepCopy() *AuthBackendKeycloak {
+	if in == nil {
+		return nil
+	}
+	out := new(AuthBackendKeycloak)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AuthBackendList) DeepCopyInto(out *AuthBackendList) {
 	*out = *in
@@ -87,12 +153,53 @@ func (in *AuthBackendList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuthBackendOIDCDynamicRegistration) DeepCopyInto(out *AuthBackendOIDCDynamicRegistration) {
+	*out = *in
+	if in.InitialAccessTokenSecretRef != nil {
+		in, out := &in.InitialAccessTokenSecretRef, &out.InitialAccessTokenSecretRef
+		*out = new(SecretKeyRef)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuthBackendOIDCDynamicRegistration.
+func (in *AuthBackendOIDCDynamicRegistration) DeepCopy() *AuthBackendOIDCDynamicRegistration {
+	if in == nil {
+		return nil
+	}
+	out := new(AuthBackendOIDCDynamicRegistration)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AuthBackendSource) DeepCopyInto(out *AuthBackendSource) {
 	*out = *in
 	if in.Dex != nil {
 		in, out := &in.Dex, &out.Dex
 		*out = new(AuthBackendDex)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OIDCDynamicRegistration != nil {
+		in, out := &in.OIDCDynamicRegistration, &out.OIDCDynamicRegistration
+		*out = new(AuthBackendOIDCDynamicRegistration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Auth0 != nil {
+		in, out := &in.Auth0, &out.Auth0
+		*out = new(AuthBackendAuth0)
+		**out = **in
+	}
+	if in.Keycloak != nil {
+		in, out := &in.Keycloak, &out.Keycloak
+		*out = new(AuthBackendKeycloak)
+		**out = **in
+	}
+	if in.StaticOIDC != nil {
+		in, out := &in.StaticOIDC, &out.StaticOIDC
+		*out = new(AuthBackendStaticOIDC)
 		**out = **in
 	}
 	return
@@ -112,6 +219,11 @@ func (in *AuthBackendSource) DeepCopy() *AuthBackendSource {
 func (in *AuthBackendSpec) DeepCopyInto(out *AuthBackendSpec) {
 	*out = *in
 	in.AuthBackendSource.DeepCopyInto(&out.AuthBackendSource)
+	if in.SecretRotation != nil {
+		in, out := &in.SecretRotation, &out.SecretRotation
+		*out = new(SecretRotation)
+		**out = **in
+	}
 	return
 }
 
@@ -125,9 +237,33 @@ func (in *AuthBackendSpec) DeepCopy() *AuthBackendSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AuthBackendStaticOIDC) DeepCopyInto(out *AuthBackendStaticOIDC) {
+	*out = *in
+	out.ClientsSecretRef = in.ClientsSecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuthBackendStaticOIDC.
+func (in *AuthBackendStaticOIDC) DeepCopy() *AuthBackendStaticOIDC {
+	if in == nil {
+		return nil
+	}
+	out := new(AuthBackendStaticOIDC)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AuthBackendStatus) DeepCopyInto(out *AuthBackendStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -149,6 +285,26 @@ func (in *AuthProxySource) DeepCopyInto(out *AuthProxySource) {
 		*out = new(Oauth2ProxyAuthProxySource)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.BilrostProxy != nil {
+		in, out := &in.BilrostProxy, &out.BilrostProxy
+		*out = new(BilrostProxyAuthProxySource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Nginx != nil {
+		in, out := &in.Nginx, &out.Nginx
+		*out = new(NginxAuthProxySource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Traefik != nil {
+		in, out := &in.Traefik, &out.Traefik
+		*out = new(TraefikAuthProxySource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Skipper != nil {
+		in, out := &in.Skipper, &out.Skipper
+		*out = new(SkipperAuthProxySource)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -170,6 +326,40 @@ func (in *AuthSettings) DeepCopyInto(out *AuthSettings) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.SecretRotation != nil {
+		in, out := &in.SecretRotation, &out.SecretRotation
+		*out = new(SecretRotation)
+		**out = **in
+	}
+	if in.AllowedGroups != nil {
+		in, out := &in.AllowedGroups, &out.AllowedGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedEmails != nil {
+		in, out := &in.AllowedEmails, &out.AllowedEmails
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedEmailDomains != nil {
+		in, out := &in.AllowedEmailDomains, &out.AllowedEmailDomains
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RequiredClaims != nil {
+		in, out := &in.RequiredClaims, &out.RequiredClaims
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SkipAuthRoutes != nil {
+		in, out := &in.SkipAuthRoutes, &out.SkipAuthRoutes
+		*out = make([]SkipAuthRoute, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -183,6 +373,23 @@ func (in *AuthSettings) DeepCopy() *AuthSettings {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BilrostProxyAuthProxySource) DeepCopyInto(out *BilrostProxyAuthProxySource) {
+	*out = *in
+	in.CommonProxySettings.DeepCopyInto(&out.CommonProxySettings)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BilrostProxyAuthProxySource.
+func (in *BilrostProxyAuthProxySource) DeepCopy() *BilrostProxyAuthProxySource {
+	if in == nil {
+		return nil
+	}
+	out := new(BilrostProxyAuthProxySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CommonProxySettings) DeepCopyInto(out *CommonProxySettings) {
 	*out = *in
@@ -210,7 +417,7 @@ func (in *IngressAuth) DeepCopyInto(out *IngressAuth) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -269,6 +476,8 @@ func (in *IngressAuthList) DeepCopyObject() runtime.Object {
 func (in *IngressAuthSpec) DeepCopyInto(out *IngressAuthSpec) {
 	*out = *in
 	in.AuthSettings.DeepCopyInto(&out.AuthSettings)
+	in.SessionSettings.DeepCopyInto(&out.SessionSettings)
+	out.UpstreamSettings = in.UpstreamSettings
 	in.AuthProxySource.DeepCopyInto(&out.AuthProxySource)
 	return
 }
@@ -286,6 +495,13 @@ func (in *IngressAuthSpec) DeepCopy() *IngressAuthSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IngressAuthStatus) DeepCopyInto(out *IngressAuthStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -299,6 +515,44 @@ func (in *IngressAuthStatus) DeepCopy() *IngressAuthStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LocalSecretKeyRef) DeepCopyInto(out *LocalSecretKeyRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LocalSecretKeyRef.
+func (in *LocalSecretKeyRef) DeepCopy() *LocalSecretKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(LocalSecretKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NginxAuthProxySource) DeepCopyInto(out *NginxAuthProxySource) {
+	*out = *in
+	in.CommonProxySettings.DeepCopyInto(&out.CommonProxySettings)
+	if in.ResponseHeaders != nil {
+		in, out := &in.ResponseHeaders, &out.ResponseHeaders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NginxAuthProxySource.
+func (in *NginxAuthProxySource) DeepCopy() *NginxAuthProxySource {
+	if in == nil {
+		return nil
+	}
+	out := new(NginxAuthProxySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Oauth2ProxyAuthProxySource) DeepCopyInto(out *Oauth2ProxyAuthProxySource) {
 	*out = *in
@@ -315,3 +569,216 @@ func (in *Oauth2ProxyAuthProxySource) DeepCopy() *Oauth2ProxyAuthProxySource {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyRef) DeepCopyInto(out *SecretKeyRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyRef.
+func (in *SecretKeyRef) DeepCopy() *SecretKeyRef {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretRef) DeepCopyInto(out *SecretRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretRef.
+func (in *SecretRef) DeepCopy() *SecretRef {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretRotation) DeepCopyInto(out *SecretRotation) {
+	*out = *in
+	out.MaxAge = in.MaxAge
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretRotation.
+func (in *SecretRotation) DeepCopy() *SecretRotation {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretRotation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SessionCookie) DeepCopyInto(out *SessionCookie) {
+	*out = *in
+	if in.Expire != nil {
+		in, out := &in.Expire, &out.Expire
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Refresh != nil {
+		in, out := &in.Refresh, &out.Refresh
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Secure != nil {
+		in, out := &in.Secure, &out.Secure
+		*out = new(bool)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SessionCookie.
+func (in *SessionCookie) DeepCopy() *SessionCookie {
+	if in == nil {
+		return nil
+	}
+	out := new(SessionCookie)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SessionRedis) DeepCopyInto(out *SessionRedis) {
+	*out = *in
+	if in.PasswordSecretRef != nil {
+		in, out := &in.PasswordSecretRef, &out.PasswordSecretRef
+		*out = new(LocalSecretKeyRef)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SessionRedis.
+func (in *SessionRedis) DeepCopy() *SessionRedis {
+	if in == nil {
+		return nil
+	}
+	out := new(SessionRedis)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SessionSettings) DeepCopyInto(out *SessionSettings) {
+	*out = *in
+	in.Cookie.DeepCopyInto(&out.Cookie)
+	if in.Redis != nil {
+		in, out := &in.Redis, &out.Redis
+		*out = new(SessionRedis)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SessionSettings.
+func (in *SessionSettings) DeepCopy() *SessionSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(SessionSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SkipAuthRoute) DeepCopyInto(out *SkipAuthRoute) {
+	*out = *in
+	if in.Methods != nil {
+		in, out := &in.Methods, &out.Methods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SkipAuthRoute.
+func (in *SkipAuthRoute) DeepCopy() *SkipAuthRoute {
+	if in == nil {
+		return nil
+	}
+	out := new(SkipAuthRoute)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SkipperAuthProxySource) DeepCopyInto(out *SkipperAuthProxySource) {
+	*out = *in
+	if in.Claims != nil {
+		in, out := &in.Claims, &out.Claims
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UserInfoFields != nil {
+		in, out := &in.UserInfoFields, &out.UserInfoFields
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UpstreamHeaders != nil {
+		in, out := &in.UpstreamHeaders, &out.UpstreamHeaders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SkipperAuthProxySource.
+func (in *SkipperAuthProxySource) DeepCopy() *SkipperAuthProxySource {
+	if in == nil {
+		return nil
+	}
+	out := new(SkipperAuthProxySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TraefikAuthProxySource) DeepCopyInto(out *TraefikAuthProxySource) {
+	*out = *in
+	in.CommonProxySettings.DeepCopyInto(&out.CommonProxySettings)
+	if in.AuthResponseHeaders != nil {
+		in, out := &in.AuthResponseHeaders, &out.AuthResponseHeaders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TraefikAuthProxySource.
+func (in *TraefikAuthProxySource) DeepCopy() *TraefikAuthProxySource {
+	if in == nil {
+		return nil
+	}
+	out := new(TraefikAuthProxySource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamSettings) DeepCopyInto(out *UpstreamSettings) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamSettings.
+func (in *UpstreamSettings) DeepCopy() *UpstreamSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamSettings)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -3,8 +3,7 @@
 set -o errexit
 set -o nounset
 
-src=./cmd/bilrost
-out=./bin/bilrost
+binaries=(bilrost bilrost-proxy)
 
 ostype=${ostype:-"native"}
 binary_ext=""
@@ -39,9 +38,13 @@ else
     echo "Building native release..."
 fi
 
-final_out=${out}${binary_ext}
 ldf_cmp="-w -extldflags '-static'"
 f_ver="-X main.Version=${VERSION:-dev}"
 
-echo "Building binary at ${final_out}"
-CGO_ENABLED=0 go build -o ${final_out} --ldflags "${ldf_cmp} ${f_ver}"  ${src}
\ No newline at end of file
+for binary in "${binaries[@]}"; do
+    src=./cmd/${binary}
+    final_out=./bin/${binary}${binary_ext}
+
+    echo "Building binary at ${final_out}"
+    CGO_ENABLED=0 go build -o ${final_out} --ldflags "${ldf_cmp} ${f_ver}"  ${src}
+done
\ No newline at end of file